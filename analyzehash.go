@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/peterli110/pcr-hash-table-rename/pkg/rename"
+	"github.com/spf13/cobra"
+)
+
+var hashTemplates = []string{"{name}", "{NAME}", hashedPrefix + "{name}", "{salt}{name}", "{name}{salt}", "{salt}_{name}", "{name}_{salt}", "{salt}:{name}"}
+
+// hashCandidate is a hashScheme and the number of hashes of the mapping it reproduces
+type hashCandidate struct {
+	hashScheme
+	Matches int `json:"matches"`
+	Tables  int `json:"tables"`
+}
+
+func newAnalyzeHashCmd() *cobra.Command {
+	var salts []string
+	var format string
+
+	cmd := &cobra.Command{
+		Use:   "analyze-hash <table mapping or generated database>",
+		Short: "EXPERIMENTAL: Look for the construction of the hashed table names",
+		Long: `EXPERIMENTAL: Test candidate constructions of the hashed table names (MD5 and SHA digests of the
+table name with salts, truncations and encodings) against a mapping found by matching, either the
+JSON of --generateTableMapping or the _table_mapping table of a generated database, and report the
+constructions reproducing some of the hashes, best first.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if format != "text" && format != "json" {
+				fatalf(exitInvalidInput, "Unknown format %s", format)
+			}
+
+			tableMapping := readAnalyzedMapping(cmd.Context(), args[0])
+			candidates := analyzeHash(tableMapping, salts)
+			if format == "json" {
+				jsonData, err := json.MarshalIndent(candidates, "", "  ")
+				if err != nil {
+					fatalf(exitInternalError, "Error encoding candidates: %v", err)
+				}
+				fmt.Println(string(jsonData))
+				return
+			}
+			if len(candidates) == 0 {
+				fmt.Printf("no construction reproduces any of the %d hashes\n", len(tableMapping))
+				return
+			}
+			for _, c := range candidates {
+				fmt.Printf("%d/%d  %s(%s) encoding=%s truncate=%s salt=%q\n", c.Matches, c.Tables, c.Algorithm, c.Template, c.Encoding, c.Truncate, c.Salt)
+			}
+		},
+	}
+	cmd.Flags().StringArrayVar(&salts, "salt", nil, "OPTIONAL: Candidate salt, e.g. a truth version, can be repeated")
+	cmd.Flags().StringVar(&format, "format", "text", "OPTIONAL: Output format, text or json")
+	cmd.Flags().StringVar(&opts.Driver, "driver", sqliteDriver, driverUsage)
+
+	return cmd
+}
+
+// readAnalyzedMapping reads the raw name -> hashed name mapping of a table mapping JSON
+// file or of the _table_mapping table of a generated database
+func readAnalyzedMapping(ctx context.Context, path string) map[string]string {
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		tableMapping, err := readTableMapping(path)
+		if err != nil {
+			fatalf(exitCodeOf(err), "%v", err)
+		}
+		return tableMapping
+	}
+
+	db := openExistingDB(path)
+	defer db.Close()
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT original_name, hashed_name FROM %s", rename.MappingTable))
+	if err != nil {
+		fatalf(exitInvalidInput, "Error reading %s of %s: %v", rename.MappingTable, path, err)
+	}
+	defer rows.Close()
+
+	tableMapping := map[string]string{}
+	for rows.Next() {
+		var name, hashedName string
+		if err = rows.Scan(&name, &hashedName); err != nil {
+			fatalf(exitInvalidInput, "Error reading %s of %s: %v", rename.MappingTable, path, err)
+		}
+		tableMapping[name] = hashedName
+	}
+	if err = rows.Err(); err != nil {
+		fatalf(exitInvalidInput, "Error reading %s of %s: %v", rename.MappingTable, path, err)
+	}
+	return tableMapping
+}
+
+// analyzeHash returns the candidate constructions reproducing at least one hash of tableMapping
+func analyzeHash(tableMapping map[string]string, salts []string) []hashCandidate {
+	salts = append([]string{""}, salts...)
+	digits := 0
+	for _, hashedName := range tableMapping {
+		if n := len(hashedName) - len(hashedPrefix); n > digits {
+			digits = n
+		}
+	}
+	candidates := []hashCandidate{}
+	for _, algorithm := range sortedKeys(hashAlgorithms) {
+		for _, template := range hashTemplates {
+			for _, salt := range salts {
+				// salted templates without a salt repeat the unsalted ones
+				if strings.Contains(template, "{salt}") == (salt == "") {
+					continue
+				}
+				for _, encoding := range []string{"utf8", "utf16le"} {
+					for _, truncate := range []string{"prefix", "suffix"} {
+						// digests as long as the hashes are not truncated
+						if truncate == "suffix" && hashAlgorithms[algorithm]().Size()*2 <= digits {
+							continue
+						}
+						c := hashCandidate{hashScheme: hashScheme{Algorithm: algorithm, Template: template, Salt: salt, Encoding: encoding, Truncate: truncate}, Tables: len(tableMapping)}
+						for name, hashedName := range tableMapping {
+							if c.hashedName(hashedPrefix, name, len(hashedName)-len(hashedPrefix)) == hashedName {
+								c.Matches++
+							}
+						}
+						if c.Matches > 0 {
+							candidates = append(candidates, c)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Matches > candidates[j].Matches
+	})
+	return candidates
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+
+	"github.com/peterli110/pcr-hash-table-rename/pkg/rename"
+	"github.com/spf13/cobra"
+)
+
+func newApplyDeltaCmd() *cobra.Command {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "apply-delta <database> <delta>...",
+		Short: "Apply delta databases of --deltaFrom onto a full generated database",
+		Long: `Apply delta databases generated with --deltaFrom onto a full generated database, in order: every
+table of a delta replaces the table of the same name, with its indexes, the ` + rename.MappingTable + ` rows of the
+delta are merged and its ` + metaTable + ` table replaces the previous one. The renamed tables missing from the
+` + rename.MappingTable + ` of a delta were removed upstream and are dropped. The database is updated in place,
+once every delta is applied, unless --output is set.`,
+		Args: cobra.MinimumNArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			database, deltas := args[0], args[1:]
+			if output == "" {
+				output = database
+			}
+			err := checkDriver()
+			for _, path := range args {
+				if _, statErr := os.Stat(path); err == nil && statErr != nil {
+					err = invalidInputf("error opening %s: %w", path, statErr)
+				}
+			}
+			if err != nil {
+				fatalf(exitInvalidInput, "%v", err)
+			}
+
+			tmp, err := createTempOutput(output)
+			if err == nil {
+				err = copyDB(cmd.Context(), database, tmp)
+			}
+			if err == nil {
+				err = applyDeltas(cmd.Context(), tmp, deltas)
+			}
+			if err == nil {
+				err = commitOutput(tmp, output)
+			}
+			if err != nil {
+				removeDB(tmp)
+				fatalf(exitCodeOf(err), "Error applying deltas: %v", err)
+			}
+			slog.Info("applied deltas", "path", output, "deltas", len(deltas))
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "", "OPTIONAL: Path of the updated database, default to updating the database in place")
+	cmd.Flags().StringVar(&opts.Driver, "driver", sqliteDriver, driverUsage)
+
+	return cmd
+}
+
+// applyDeltas applies the delta databases at deltas onto the database at path, in order
+func applyDeltas(ctx context.Context, path string, deltas []string) error {
+	db, err := openDB(path, "")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// attached databases only exist on the connection that attached them
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for _, delta := range deltas {
+		if _, err = conn.ExecContext(ctx, "ATTACH DATABASE ? AS delta", delta); err != nil {
+			return fmt.Errorf("error attaching %s: %w", delta, err)
+		}
+		tables, dropped, err := applyDelta(ctx, conn, delta)
+		if _, detachErr := conn.ExecContext(ctx, "DETACH DATABASE delta"); err == nil {
+			err = detachErr
+		}
+		if err != nil {
+			return err
+		}
+		slog.Info("applied delta", "path", delta, "tables", tables, "dropped", dropped)
+	}
+	return nil
+}
+
+// applyDelta replaces the tables of the main database with those of the attached delta database
+// and drops the tables removed upstream, in one transaction, and returns the number of tables
+// replaced and dropped
+func applyDelta(ctx context.Context, conn *sql.Conn, delta string) (replaced, dropped int, err error) {
+	statements, err := queryStatements(ctx, conn, "SELECT name, sql FROM delta.sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'")
+	if err != nil {
+		return 0, 0, fmt.Errorf("error reading tables of %s: %w", delta, err)
+	}
+	indexes, err := queryStatements(ctx, conn, "SELECT tbl_name, sql FROM delta.sqlite_master WHERE type = 'index' AND sql IS NOT NULL")
+	if err != nil {
+		return 0, 0, fmt.Errorf("error reading indexes of %s: %w", delta, err)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+	if dropped, err = dropRemovedTables(ctx, tx, statements); err != nil {
+		return 0, 0, fmt.Errorf("error dropping the tables removed by %s: %w", delta, err)
+	}
+	for _, table := range statements {
+		name, quoted := table[0], quoteIdentifier(table[0])
+		if name == rename.MappingTable {
+			// the mapping of the full database also has the tables the delta left out
+			if _, err = tx.ExecContext(ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS main.%s AS SELECT * FROM delta.%s WHERE 0", quoted, quoted)); err == nil {
+				_, err = tx.ExecContext(ctx, fmt.Sprintf("INSERT OR REPLACE INTO main.%s SELECT * FROM delta.%s", quoted, quoted))
+			}
+			if err != nil {
+				return 0, 0, fmt.Errorf("error merging %s: %w", name, err)
+			}
+			continue
+		}
+
+		if _, err = tx.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS main.%s", quoted)); err != nil {
+			return 0, 0, fmt.Errorf("error dropping table %s: %w", name, err)
+		}
+		// unqualified statements create in the main database
+		if _, err = tx.ExecContext(ctx, table[1]); err != nil {
+			return 0, 0, fmt.Errorf("error creating table %s: %w", name, err)
+		}
+		if _, err = tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO main.%s SELECT * FROM delta.%s", quoted, quoted)); err != nil {
+			return 0, 0, fmt.Errorf("error copying table %s of %s: %w", name, delta, err)
+		}
+		for _, index := range indexes {
+			if index[0] != name {
+				continue
+			}
+			if _, err = tx.ExecContext(ctx, index[1]); err != nil {
+				return 0, 0, fmt.Errorf("error creating index of table %s: %w", name, err)
+			}
+		}
+		if name != metaTable {
+			replaced++
+		}
+	}
+	return replaced, dropped, tx.Commit()
+}
+
+// dropRemovedTables drops the tables of the main database in its mapping but not in the mapping
+// of the attached delta database, whose tables are those of the delta, and deletes their mapping
+// rows. Nothing is dropped if either database has no mapping.
+func dropRemovedTables(ctx context.Context, tx *sql.Tx, deltaTables [][2]string) (int, error) {
+	var mainMapping bool
+	if err := tx.QueryRowContext(ctx, "SELECT count(*) > 0 FROM main.sqlite_master WHERE type = 'table' AND name = ?", rename.MappingTable).Scan(&mainMapping); err != nil {
+		return 0, err
+	}
+	deltaMapping := slices.ContainsFunc(deltaTables, func(table [2]string) bool { return table[0] == rename.MappingTable })
+	if !mainMapping || !deltaMapping {
+		return 0, nil
+	}
+
+	quoted := quoteIdentifier(rename.MappingTable)
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT original_name FROM main.%s WHERE original_name NOT IN (SELECT original_name FROM delta.%s)", quoted, quoted))
+	if err != nil {
+		return 0, err
+	}
+	var removed []string
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		removed = append(removed, name)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, name := range removed {
+		if _, err = tx.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS main.%s", quoteIdentifier(name))); err != nil {
+			return 0, err
+		}
+		if _, err = tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM main.%s WHERE original_name = ?", quoted), name); err != nil {
+			return 0, err
+		}
+		slog.Debug("dropped table", "table", name)
+	}
+	return len(removed), nil
+}
+
+// queryStatements returns the pairs of names and statements of a query on sqlite_master
+func queryStatements(ctx context.Context, conn *sql.Conn, query string) ([][2]string, error) {
+	rows, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var statements [][2]string
+	for rows.Next() {
+		var statement [2]string
+		if err = rows.Scan(&statement[0], &statement[1]); err != nil {
+			return nil, err
+		}
+		statements = append(statements, statement)
+	}
+	return statements, rows.Err()
+}
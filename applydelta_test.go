@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// queryColumn returns the first column of the rows of query in the database path
+func queryColumn(t *testing.T, path, query string) []string {
+	t.Helper()
+	db, err := sql.Open(sqliteDriver, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	values := []string{}
+	for rows.Next() {
+		var value string
+		if err = rows.Scan(&value); err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, value)
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return values
+}
+
+func TestApplyDeltas(t *testing.T) {
+	opts.Driver = sqliteDriver
+	full := []string{
+		"CREATE TABLE unit_data (unit_id INTEGER PRIMARY KEY, unit_name TEXT)",
+		"INSERT INTO unit_data VALUES (1, 'a')",
+		"CREATE TABLE skill_data (skill_id INTEGER PRIMARY KEY, name TEXT)",
+		"INSERT INTO skill_data VALUES (10, 'x')",
+		"CREATE TABLE _table_mapping (original_name TEXT PRIMARY KEY, hashed_name TEXT NOT NULL)",
+		"INSERT INTO _table_mapping VALUES ('unit_data', 'v1_aaaa'), ('skill_data', 'v1_bbbb')",
+	}
+	tests := []struct {
+		name   string
+		deltas [][]string
+		// the tables, the mapping, the rows of unit_data and the indexes of the database afterwards
+		tables  []string
+		mapping []string
+		rows    []string
+		indexes []string
+	}{
+		{
+			name: "changed table",
+			deltas: [][]string{{
+				"CREATE TABLE unit_data (unit_id INTEGER PRIMARY KEY, unit_name TEXT)",
+				"CREATE INDEX unit_data_name ON unit_data (unit_name)",
+				"INSERT INTO unit_data VALUES (1, 'b')",
+				"CREATE TABLE _table_mapping (original_name TEXT PRIMARY KEY, hashed_name TEXT NOT NULL)",
+				"INSERT INTO _table_mapping VALUES ('unit_data', 'v1_cccc'), ('skill_data', 'v1_bbbb')",
+			}},
+			tables:  []string{"_table_mapping", "skill_data", "unit_data"},
+			mapping: []string{"skill_data=v1_bbbb", "unit_data=v1_cccc"},
+			rows:    []string{"1=b"},
+			indexes: []string{"unit_data_name"},
+		},
+		{
+			name: "removed table",
+			deltas: [][]string{{
+				"CREATE TABLE _table_mapping (original_name TEXT PRIMARY KEY, hashed_name TEXT NOT NULL)",
+				"INSERT INTO _table_mapping VALUES ('unit_data', 'v1_aaaa')",
+			}},
+			tables:  []string{"_table_mapping", "unit_data"},
+			mapping: []string{"unit_data=v1_aaaa"},
+			rows:    []string{"1=a"},
+			indexes: []string{},
+		},
+		{
+			name: "new table",
+			deltas: [][]string{{
+				"CREATE TABLE quest_data (quest_id INTEGER PRIMARY KEY)",
+				"INSERT INTO quest_data VALUES (5)",
+				"CREATE TABLE _table_mapping (original_name TEXT PRIMARY KEY, hashed_name TEXT NOT NULL)",
+				"INSERT INTO _table_mapping VALUES ('unit_data', 'v1_aaaa'), ('skill_data', 'v1_bbbb'), ('quest_data', 'v1_dddd')",
+			}},
+			tables:  []string{"_table_mapping", "quest_data", "skill_data", "unit_data"},
+			mapping: []string{"quest_data=v1_dddd", "skill_data=v1_bbbb", "unit_data=v1_aaaa"},
+			rows:    []string{"1=a"},
+			indexes: []string{},
+		},
+		{
+			name: "delta without mapping",
+			deltas: [][]string{{
+				"CREATE TABLE unit_data (unit_id INTEGER PRIMARY KEY, unit_name TEXT)",
+				"INSERT INTO unit_data VALUES (1, 'b')",
+			}},
+			tables:  []string{"_table_mapping", "skill_data", "unit_data"},
+			mapping: []string{"skill_data=v1_bbbb", "unit_data=v1_aaaa"},
+			rows:    []string{"1=b"},
+			indexes: []string{},
+		},
+		{
+			name: "deltas in order",
+			deltas: [][]string{
+				{
+					"CREATE TABLE unit_data (unit_id INTEGER PRIMARY KEY, unit_name TEXT)",
+					"INSERT INTO unit_data VALUES (1, 'b')",
+					"CREATE TABLE _table_mapping (original_name TEXT PRIMARY KEY, hashed_name TEXT NOT NULL)",
+					"INSERT INTO _table_mapping VALUES ('unit_data', 'v1_aaaa')",
+				},
+				{
+					"CREATE TABLE unit_data (unit_id INTEGER PRIMARY KEY, unit_name TEXT)",
+					"INSERT INTO unit_data VALUES (1, 'c')",
+					"CREATE TABLE _table_mapping (original_name TEXT PRIMARY KEY, hashed_name TEXT NOT NULL)",
+					"INSERT INTO _table_mapping VALUES ('unit_data', 'v1_eeee')",
+				},
+			},
+			tables:  []string{"_table_mapping", "unit_data"},
+			mapping: []string{"unit_data=v1_eeee"},
+			rows:    []string{"1=c"},
+			indexes: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "full.db")
+			createDB(t, path, full...)
+			var deltas []string
+			for i, stmts := range tt.deltas {
+				delta := filepath.Join(dir, fmt.Sprintf("delta%d.db", i))
+				createDB(t, delta, stmts...)
+				deltas = append(deltas, delta)
+			}
+
+			if err := applyDeltas(context.Background(), path, deltas); err != nil {
+				t.Fatal(err)
+			}
+			if got := queryColumn(t, path, "SELECT name FROM sqlite_master WHERE type = 'table' ORDER BY name"); !reflect.DeepEqual(got, tt.tables) {
+				t.Errorf("tables = %v, want %v", got, tt.tables)
+			}
+			if got := queryColumn(t, path, "SELECT original_name || '=' || hashed_name FROM _table_mapping ORDER BY original_name"); !reflect.DeepEqual(got, tt.mapping) {
+				t.Errorf("mapping = %v, want %v", got, tt.mapping)
+			}
+			if got := queryColumn(t, path, "SELECT unit_id || '=' || unit_name FROM unit_data"); !reflect.DeepEqual(got, tt.rows) {
+				t.Errorf("unit_data rows = %v, want %v", got, tt.rows)
+			}
+			if got := queryColumn(t, path, "SELECT name FROM sqlite_master WHERE type = 'index' AND sql IS NOT NULL"); !reflect.DeepEqual(got, tt.indexes) {
+				t.Errorf("indexes = %v, want %v", got, tt.indexes)
+			}
+		})
+	}
+}
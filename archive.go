@@ -0,0 +1,190 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+)
+
+var zipHeader = []byte("PK\x03\x04")
+
+// archives that may be nested inside an APK/XAPK/zip
+var nestedArchiveExts = []string{".apk", ".obb", ".zip"}
+
+// splitArchivePath splits "game.apk#assets/master.db" into the archive and the entry in it,
+// entry is empty if p is not an archive path
+func splitArchivePath(p string) (string, string) {
+	i := strings.LastIndex(p, "#")
+	if i < 0 {
+		return p, ""
+	}
+	// a real file with a # in its name wins
+	if _, err := os.Stat(p); err == nil {
+		return p, ""
+	}
+	return p[:i], p[i+1:]
+}
+
+// extractArchive extracts the database from an APK/XAPK/zip archive into a temporary file.
+// The entry is given with "archive#entry", or discovered by looking for a SQLite database
+// (or a compressed master database) in the archive and in archives nested inside it.
+// Paths that are not archives are returned as is.
+func extractArchive(p string) (string, func(), error) {
+	archivePath, entry := splitArchivePath(p)
+	if entry == "" {
+		header, err := readHeader(archivePath, len(zipHeader))
+		if err != nil {
+			return "", nil, err
+		}
+		if !bytes.Equal(header, zipHeader) {
+			return p, func() {}, nil
+		}
+	}
+
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return "", nil, invalidInputf("error opening archive %s: %w", archivePath, err)
+	}
+	defer reader.Close()
+
+	var file *zip.File
+	if entry != "" {
+		for _, f := range reader.File {
+			if f.Name == entry {
+				file = f
+				break
+			}
+		}
+		if file == nil {
+			return "", nil, invalidInputf("no %s in archive %s", entry, archivePath)
+		}
+	} else {
+		found, nested, cleanupNested, err := findDatabaseInArchive(&reader.Reader)
+		if err != nil {
+			return "", nil, err
+		}
+		if nested != nil {
+			defer cleanupNested()
+			defer nested.Close()
+		}
+		file = found
+		if file == nil {
+			return "", nil, invalidInputf("no database found in archive %s, use archive#path/in/archive to select it", archivePath)
+		}
+	}
+
+	tmp, err := os.CreateTemp("", "pcr_extracted_*.db")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.Remove(tmp.Name()) }
+	defer tmp.Close()
+
+	if err = copyZipFile(tmp, file); err != nil {
+		cleanup()
+		return "", nil, invalidInputf("error extracting %s from %s: %w", file.Name, archivePath, err)
+	}
+
+	slog.Info("extracted database", "entry", file.Name, "archive", archivePath)
+	return tmp.Name(), cleanup, nil
+}
+
+// findDatabaseInArchive returns the database in the archive, or in one of the archives
+// nested inside it (the APKs inside an XAPK), in which case the opened nested archive and
+// the cleanup of its temporary file are returned as well
+func findDatabaseInArchive(reader *zip.Reader) (*zip.File, *zip.ReadCloser, func(), error) {
+	if f := findDatabaseEntry(reader); f != nil {
+		return f, nil, nil, nil
+	}
+
+	for _, f := range reader.File {
+		if !hasAnySuffix(strings.ToLower(f.Name), nestedArchiveExts...) {
+			continue
+		}
+
+		tmp, err := os.CreateTemp("", "pcr_nested_*.zip")
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		cleanup := func() { os.Remove(tmp.Name()) }
+		err = copyZipFile(tmp, f)
+		tmp.Close()
+		if err != nil {
+			cleanup()
+			continue
+		}
+
+		nested, err := zip.OpenReader(tmp.Name())
+		if err != nil {
+			cleanup()
+			continue
+		}
+		if found := findDatabaseEntry(&nested.Reader); found != nil {
+			slog.Info("found database in nested archive", "archive", f.Name)
+			return found, nested, cleanup, nil
+		}
+		nested.Close()
+		cleanup()
+	}
+
+	return nil, nil, nil, nil
+}
+
+// findDatabaseEntry returns the first SQLite database in the archive,
+// falling back to an entry named like a master database
+func findDatabaseEntry(reader *zip.Reader) *zip.File {
+	var byName *zip.File
+	for _, f := range reader.File {
+		header, err := readZipHeader(f, len(sqliteHeader))
+		if err != nil {
+			continue
+		}
+		if bytes.Equal(header, sqliteHeader) {
+			return f
+		}
+		name := strings.ToLower(path.Base(f.Name))
+		if byName == nil && strings.Contains(name, "master") && hasAnySuffix(name, ".db", ".cdb", ".mdb", ".br") {
+			byName = f
+		}
+	}
+	return byName
+}
+
+func copyZipFile(w io.Writer, f *zip.File) error {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func readZipHeader(f *zip.File, n int) ([]byte, error) {
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	header := make([]byte, n)
+	read, err := io.ReadFull(r, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return header[:read], nil
+}
+
+func hasAnySuffix(s string, suffixes ...string) bool {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(s, suffix) {
+			return true
+		}
+	}
+	return false
+}
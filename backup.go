@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"modernc.org/sqlite"
+)
+
+// backupDB copies db into a new database at dst with the online backup API of SQLite,
+// page by page, with the driver db was opened with
+func backupDB(ctx context.Context, db *sql.DB, dst string) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(dc any) error {
+		// the connections of --traceSql wrap those of the driver
+		if tc, ok := dc.(*traceConn); ok {
+			dc = tc.Conn
+		}
+		if c, ok := dc.(interface {
+			NewBackup(string) (*sqlite.Backup, error)
+		}); ok {
+			backup, err := c.NewBackup(dst)
+			if err != nil {
+				return err
+			}
+			if _, err = backup.Step(-1); err != nil {
+				backup.Finish()
+				return err
+			}
+			return backup.Finish()
+		}
+		if ok, err := backupConn(ctx, dc, dst); ok {
+			return err
+		}
+		return fmt.Errorf("the %s driver has no backup API", opts.Driver)
+	})
+}
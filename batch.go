@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// hashedInputs returns the hashed databases of --hashedDBPath and --batchFile
+func hashedInputs() ([]string, error) {
+	inputs := append([]string{}, opts.HashedDBPaths...)
+	if opts.BatchFile == "" {
+		return inputs, nil
+	}
+
+	file, err := os.Open(opts.BatchFile)
+	if err != nil {
+		return nil, invalidInputf("error opening batch file: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if text := strings.TrimSpace(scanner.Text()); text != "" {
+			inputs = append(inputs, text)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(inputs) == 0 {
+		return nil, invalidInputf("batch file %s lists no databases", opts.BatchFile)
+	}
+	return inputs, nil
+}
+
+// batchName returns the name of the outputs of the hashed database at input,
+// its file name without extensions
+func batchName(input string) string {
+	input, _ = splitArchivePath(input)
+	input, _ = splitDSN(input)
+	name := path.Base(filepath.ToSlash(input))
+	if i := strings.Index(name, "."); i > 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// generateBatch generates one database per hashed database in --outputDir, named after
+// the hashed database, and returns the exit code of the first failed generation, or else
+// exitUnmatchedTables if a database has unmatched tables.
+// The sampled rows of the original database are only read once.
+func generateBatch(ctx context.Context, inputs []string) int {
+	names := map[string]string{}
+	for _, input := range inputs {
+		name := batchName(input)
+		if other, ok := names[name]; ok {
+			return runError(invalidInputf("%s and %s would both be generated as %s, rename one of them", other, input, name))
+		}
+		names[name] = input
+	}
+	if err := os.MkdirAll(opts.OutputDir, 0755); err != nil {
+		return runError(err)
+	}
+
+	batchCode := exitSuccess
+	for _, input := range inputs {
+		name := batchName(input)
+		opts.HashedDBPath = input
+		opts.GeneratedDBPath = filepath.Join(opts.OutputDir, name+"_fixed.db")
+		mappingPath = filepath.Join(opts.OutputDir, name+"_"+mappingFile)
+		indexMappingPath = filepath.Join(opts.OutputDir, name+"_"+indexMappingFile)
+		summaryPath = filepath.Join(opts.OutputDir, name+"_"+summaryFile)
+		hashMappingPath = filepath.Join(opts.OutputDir, name+"_"+hashMappingFile)
+		batchPrefix = filepath.Join(opts.OutputDir, name+"_")
+		summary = newSummary()
+
+		code := generateAndNotify(ctx)
+		if batchCode == exitSuccess || (batchCode == exitUnmatchedTables && code != exitSuccess) {
+			batchCode = code
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return batchCode
+}
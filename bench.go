@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/peterli110/pcr-hash-table-rename/pkg/rename"
+	"github.com/spf13/cobra"
+)
+
+// copyStrategy copies tables of the source database of a benchmark into a new database at dst
+type copyStrategy struct {
+	name        string
+	description string
+	copy        func(ctx context.Context, b *bench, dst string) error
+}
+
+// copyStrategies are the strategies compared by bench, the first one is the one of the tool
+var copyStrategies = []copyStrategy{
+	{"row-by-row", "one INSERT statement with literal values per row, in a transaction per table (used by the tool)", copyRowByRow},
+	{"prepared", "a prepared INSERT statement executed with the values of every row, in a transaction per table", copyPrepared},
+	{"attach", "INSERT INTO ... SELECT from the source database ATTACHed to the new one", copyAttach},
+	{"backup", "the online backup API of SQLite, copying the pages of a database holding only the tables", copyBackup},
+}
+
+// bench holds the tables a benchmark copies
+type bench struct {
+	// source is the database holding only the benchmarked tables, at sourcePath
+	source     *sql.DB
+	sourcePath string
+	tables     []string
+	// createStmts are the CREATE TABLE statements of tables
+	createStmts map[string]string
+	rows        int
+}
+
+// benchResult is the fastest of the runs of a strategy
+type benchResult struct {
+	strategy copyStrategy
+	duration time.Duration
+	err      error
+}
+
+func newBenchCmd() *cobra.Command {
+	var tableCount, runs int
+	var tables []string
+	var workDir string
+
+	cmd := &cobra.Command{
+		Use:   "bench <hashed database>",
+		Short: "Compare the copy strategies on a subset of tables of a database",
+		Long: `Copy a representative subset of the tables of a database, from the smallest to the largest by row count,
+into new databases with each copy strategy and print their timings, to see which one is the fastest on this
+hardware. The best of --runs runs is kept for each strategy. Strategies:
+` + strategiesHelp(),
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if runs < 1 || tableCount < 1 {
+				fatalf(exitInvalidInput, "--runs and --tables must be at least 1")
+			}
+			ctx := cmd.Context()
+			db := openExistingDB(args[0])
+			defer db.Close()
+
+			dir, err := os.MkdirTemp(workDir, "pcr_bench_")
+			if err != nil {
+				fatalf(exitInternalError, "Error creating the benchmark directory: %v", err)
+			}
+			defer os.RemoveAll(dir)
+
+			b, err := newBench(ctx, db, tables, tableCount, filepath.Join(dir, "source.db"))
+			if err != nil {
+				fatalf(exitCodeOf(err), "Error preparing the benchmark: %v", err)
+			}
+			defer b.source.Close()
+			fmt.Printf("%s: %d tables, %d rows, best of %d runs\n", args[0], len(b.tables), b.rows, runs)
+
+			var results []benchResult
+			for _, s := range copyStrategies {
+				result := benchResult{strategy: s}
+				for i := 0; i < runs && result.err == nil; i++ {
+					dst := filepath.Join(dir, fmt.Sprintf("%s_%d.db", s.name, i))
+					start := time.Now()
+					if result.err = s.copy(ctx, b, dst); result.err != nil {
+						break
+					}
+					if d := time.Since(start); result.duration == 0 || d < result.duration {
+						result.duration = d
+					}
+					os.Remove(dst)
+				}
+				if err = ctx.Err(); err != nil {
+					fatalf(exitCodeOf(err), "%v", err)
+				}
+				results = append(results, result)
+			}
+			printBench(results, b.rows)
+		},
+	}
+	cmd.Flags().IntVar(&tableCount, "tables", 20, "OPTIONAL: Number of tables copied, spread from the smallest to the largest")
+	cmd.Flags().StringSliceVar(&tables, "table", nil, "OPTIONAL: Tables to copy instead of a spread of --tables, can be repeated")
+	cmd.Flags().IntVar(&runs, "runs", 3, "OPTIONAL: Number of runs of each strategy, the fastest one is reported")
+	cmd.Flags().StringVar(&workDir, "workDir", "", "OPTIONAL: Directory of the databases written by the benchmark, default to the temporary directory. Use the disk of the real runs for representative timings")
+	cmd.Flags().StringVar(&opts.Driver, "driver", sqliteDriver, driverUsage)
+
+	return cmd
+}
+
+// strategiesHelp lists copyStrategies for the help of bench
+func strategiesHelp() string {
+	var b strings.Builder
+	for _, s := range copyStrategies {
+		fmt.Fprintf(&b, "  %-11s %s\n", s.name, s.description)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// newBench copies the benchmarked tables of db into a source database at sourcePath: the
+// tables named, or else count tables spread evenly between the smallest and the largest
+func newBench(ctx context.Context, db *sql.DB, names []string, count int, sourcePath string) (*bench, error) {
+	all, err := rename.GetTableNames(ctx, db, false)
+	if err != nil {
+		return nil, err
+	}
+	rowCounts := make(map[string]int, len(all))
+	for _, t := range all {
+		if rowCounts[t], err = rename.CountRows(ctx, db, t); err != nil {
+			return nil, err
+		}
+	}
+	if len(names) > 0 {
+		for _, t := range names {
+			if _, ok := rowCounts[t]; !ok {
+				return nil, invalidInputf("no table %s", t)
+			}
+		}
+	} else {
+		sort.Slice(all, func(i, j int) bool {
+			if rowCounts[all[i]] != rowCounts[all[j]] {
+				return rowCounts[all[i]] < rowCounts[all[j]]
+			}
+			return all[i] < all[j]
+		})
+		names = spread(all, count)
+	}
+	if len(names) == 0 {
+		return nil, invalidInputf("the database has no tables")
+	}
+
+	b := &bench{sourcePath: sourcePath, tables: names, createStmts: map[string]string{}}
+	for _, t := range names {
+		var createStmt string
+		if err = db.QueryRowContext(ctx, "SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", t).Scan(&createStmt); err != nil {
+			return nil, fmt.Errorf("error getting CREATE TABLE statement of %s: %w", t, err)
+		}
+		b.createStmts[t] = createStmt
+		b.rows += rowCounts[t]
+	}
+
+	// the source database is written by SQLite itself, so every strategy reads the same pages
+	if err = copyBench(ctx, b.tables, b.createStmts, db, sourcePath); err != nil {
+		return nil, err
+	}
+	if b.source, err = openDB(sourcePath, ""); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// spread returns count names evenly spaced in sorted, the first and last included
+func spread(sorted []string, count int) []string {
+	if count >= len(sorted) {
+		return sorted
+	}
+	if count == 1 {
+		return sorted[len(sorted)-1:]
+	}
+	picked := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		picked = append(picked, sorted[i*(len(sorted)-1)/(count-1)])
+	}
+	return picked
+}
+
+// newBenchDB creates the new database of a strategy with the default pragmas of the tool
+func newBenchDB(ctx context.Context, path string) (*sql.DB, error) {
+	db, err := openDB(path, "")
+	if err != nil {
+		return nil, err
+	}
+	if err = rename.ApplyPragmas(ctx, db, rename.DefaultOptions().NewDBPragmas()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// copyBench copies tables from db into a new database at dst with ATTACH, for the source database
+func copyBench(ctx context.Context, tables []string, createStmts map[string]string, db *sql.DB, dst string) error {
+	newDB, err := newBenchDB(ctx, dst)
+	if err != nil {
+		return err
+	}
+	defer newDB.Close()
+	var sourcePath string
+	if err = db.QueryRowContext(ctx, "SELECT file FROM pragma_database_list WHERE name = 'main'").Scan(&sourcePath); err != nil {
+		return err
+	}
+	return attachCopy(ctx, newDB, sourcePath, tables, createStmts)
+}
+
+// attachCopy creates tables in newDB and copies their rows from the database at sourcePath, ATTACHed to newDB
+func attachCopy(ctx context.Context, newDB *sql.DB, sourcePath string, tables []string, createStmts map[string]string) error {
+	conn, err := newDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err = conn.ExecContext(ctx, "ATTACH DATABASE ? AS source", sourcePath); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.WithoutCancel(ctx), "DETACH DATABASE source")
+	for _, t := range tables {
+		if _, err = conn.ExecContext(ctx, createStmts[t]); err != nil {
+			return fmt.Errorf("error creating table %s: %w", t, err)
+		}
+		if _, err = conn.ExecContext(ctx, fmt.Sprintf("INSERT INTO main.%s SELECT * FROM source.%s", quoteIdentifier(t), quoteIdentifier(t))); err != nil {
+			return fmt.Errorf("error copying table %s: %w", t, err)
+		}
+	}
+	return nil
+}
+
+func copyRowByRow(ctx context.Context, b *bench, dst string) error {
+	newDB, err := newBenchDB(ctx, dst)
+	if err != nil {
+		return err
+	}
+	defer newDB.Close()
+	for _, t := range b.tables {
+		if _, err = rename.CopyDataAs(ctx, b.source, newDB, b.createStmts[t], t, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyPrepared(ctx context.Context, b *bench, dst string) error {
+	newDB, err := newBenchDB(ctx, dst)
+	if err != nil {
+		return err
+	}
+	defer newDB.Close()
+	for _, t := range b.tables {
+		if err = copyTablePrepared(ctx, b.source, newDB, t, b.createStmts[t]); err != nil {
+			return fmt.Errorf("error copying table %s: %w", t, err)
+		}
+	}
+	return nil
+}
+
+// copyTablePrepared copies table from source into newDB with a prepared INSERT statement
+func copyTablePrepared(ctx context.Context, source, newDB *sql.DB, table, createStmt string) error {
+	if _, err := newDB.ExecContext(ctx, createStmt); err != nil {
+		return err
+	}
+	rows, err := source.QueryContext(ctx, fmt.Sprintf("SELECT * FROM %s", quoteIdentifier(table)))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	tx, err := newDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	stmt, err := tx.PrepareContext(ctx, fmt.Sprintf("INSERT INTO %s VALUES (%s)", quoteIdentifier(table), placeholders))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	values := make([]any, len(columns))
+	pointers := make([]any, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err = rows.Scan(pointers...); err != nil {
+			return err
+		}
+		if _, err = stmt.ExecContext(ctx, values...); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func copyAttach(ctx context.Context, b *bench, dst string) error {
+	newDB, err := newBenchDB(ctx, dst)
+	if err != nil {
+		return err
+	}
+	defer newDB.Close()
+	return attachCopy(ctx, newDB, b.sourcePath, b.tables, b.createStmts)
+}
+
+func copyBackup(ctx context.Context, b *bench, dst string) error {
+	return backupDB(ctx, b.source, dst)
+}
+
+// printBench prints the timings of the strategies, relative to the first one
+func printBench(results []benchResult, rows int) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "STRATEGY\tTIME\tROWS/S\tSPEEDUP\t")
+	fastest := -1
+	for i, r := range results {
+		if r.err != nil {
+			fmt.Fprintf(w, "%s\tfailed: %v\t\t\t\n", r.strategy.name, r.err)
+			continue
+		}
+		speedup := "-"
+		if base := results[0]; base.err == nil {
+			speedup = fmt.Sprintf("%.2fx", base.duration.Seconds()/r.duration.Seconds())
+		}
+		fmt.Fprintf(w, "%s\t%s\t%.0f\t%s\t\n", r.strategy.name, r.duration.Round(time.Microsecond), float64(rows)/r.duration.Seconds(), speedup)
+		if fastest < 0 || r.duration < results[fastest].duration {
+			fastest = i
+		}
+	}
+	w.Flush()
+	if fastest >= 0 {
+		fmt.Printf("fastest: %s\n", results[fastest].strategy.name)
+	}
+}
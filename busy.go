@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// isBusy returns whether err is SQLITE_BUSY or SQLITE_LOCKED, which both drivers report
+// as "database is locked" or "database table is locked"
+func isBusy(err error) bool {
+	return err != nil && (strings.Contains(err.Error(), "database is locked") || strings.Contains(err.Error(), "database table is locked"))
+}
+
+// waitUnlocked waits for the database at path to be readable, retrying --busyRetries times
+// with a backoff starting at --busyBackoff and doubling, while another process holds a lock on it
+func waitUnlocked(ctx context.Context, db *sql.DB, path string) error {
+	backoff := opts.BusyBackoff
+	for attempt := 0; ; attempt++ {
+		var count int
+		err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master").Scan(&count)
+		if !isBusy(err) {
+			return err
+		}
+		if attempt >= opts.BusyRetries {
+			return fmt.Errorf("%s is locked by another process, close it or wait for it to finish and run again: %w", path, err)
+		}
+
+		slog.Warn("database is locked by another process, retrying", "path", path, "attempt", attempt+1, "retries", opts.BusyRetries, "wait", backoff)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"log/slog"
+	"math"
+
+	"github.com/peterli110/pcr-hash-table-rename/pkg/rename"
+)
+
+// reportCandidates logs the closest hashed tables for each unmatched table,
+// ranked by schema similarity and partial row overlap
+func reportCandidates(unmatched []string, candidates map[string][]rename.Candidate) {
+	for _, table := range unmatched {
+		if len(candidates[table]) == 0 {
+			slog.Info("no candidates", "table", table)
+			continue
+		}
+
+		for i, c := range candidates[table] {
+			slog.Info("candidate", "table", table, "rank", i+1, "candidate", c.Table,
+				"score", round2(c.Score()), "schemaScore", round2(c.SchemaScore), "rowScore", round2(c.RowScore))
+		}
+	}
+}
+
+func round2(f float64) float64 {
+	return math.Round(f*100) / 100
+}
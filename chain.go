@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+
+	"github.com/peterli110/pcr-hash-table-rename/pkg/rename"
+)
+
+const hashMappingFile = "hash_mapping.json"
+
+// path the previous hashed name -> current hashed name mapping is written to,
+// named after the hashed database in batch mode
+var hashMappingPath = hashMappingFile
+
+// readTableMapping reads a table mapping written by --generateTableMapping, raw name -> hashed name
+func readTableMapping(path string) (map[string]string, error) {
+	jsonData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, invalidInputf("error opening table mapping: %w", err)
+	}
+	entries, err := rename.ParseTableMapping(jsonData)
+	if err != nil {
+		return nil, invalidInputf("invalid table mapping %s: %w", path, err)
+	}
+	tableMapping := make(map[string]string, len(entries))
+	for name, entry := range entries {
+		tableMapping[name] = entry.HashedName
+	}
+	return tableMapping, nil
+}
+
+// writeHashMapping chains the previous table mapping with the current one through the raw
+// names, and writes the previous hashed name -> current hashed name mapping of every table
+// both have, so tools using the previous hashed names can migrate
+func writeHashMapping(previous, current map[string]string) error {
+	hashMapping := map[string]string{}
+	changed := 0
+	for name, previousHash := range previous {
+		currentHash, ok := current[name]
+		if !ok {
+			slog.Warn("table of the previous mapping has no match", "table", name, "previousHashedTable", previousHash)
+			continue
+		}
+		hashMapping[previousHash] = currentHash
+		if currentHash != previousHash {
+			changed++
+		}
+	}
+
+	jsonData, err := json.MarshalIndent(hashMapping, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(hashMappingPath, jsonData, 0644); err != nil {
+		return err
+	}
+	slog.Info("wrote hash mapping", "path", hashMappingPath, "tables", len(hashMapping), "changedHashes", changed)
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// changelogCategory is a section of the changelog, listing the rows of a table that were
+// added or changed between two versions
+type changelogCategory struct {
+	Title string
+	Table string
+	// Status is tableAdded to list new rows, or tableChanged to list changed rows
+	Status string
+	// NameColumn names the rows in the changelog, rows are named by their key if empty
+	NameColumn string
+}
+
+var changelogCategories = []changelogCategory{
+	{Title: "New units", Table: "unit_data", Status: tableAdded, NameColumn: "unit_name"},
+	{Title: "New equipment", Table: "equipment_data", Status: tableAdded, NameColumn: "equipment_name"},
+	{Title: "Changed skills", Table: "skill_data", Status: tableChanged, NameColumn: "name"},
+	{Title: "Changed skill values", Table: "skill_action", Status: tableChanged},
+	{Title: "New quests", Table: "quest_data", Status: tableAdded, NameColumn: "quest_name"},
+}
+
+type changelogSection struct {
+	Title   string           `json:"title"`
+	Table   string           `json:"table"`
+	Entries []changelogEntry `json:"entries"`
+}
+
+type changelogEntry struct {
+	Key     map[string]string    `json:"key"`
+	Name    string               `json:"name,omitempty"`
+	Columns map[string][2]string `json:"columns,omitempty"`
+}
+
+func newChangelogCmd() *cobra.Command {
+	var format string
+
+	cmd := &cobra.Command{
+		Use:   "changelog <previous database> <current database>",
+		Short: "Print a patch changelog between two generated databases",
+		Long: `Print a changelog of new units, equipment and quests and changed skills between the generated
+databases of two game versions, in Markdown for patch-day posts or in JSON.`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			if format != "markdown" && format != "json" {
+				fatalf(exitInvalidInput, "Unknown format %s", format)
+			}
+
+			sections := buildChangelog(cmd.Context(), args[0], args[1])
+			if format == "json" {
+				jsonData, err := json.MarshalIndent(sections, "", "  ")
+				if err != nil {
+					fatalf(exitInternalError, "Error encoding changelog: %v", err)
+				}
+				fmt.Println(string(jsonData))
+				return
+			}
+			printChangelog(sections)
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", "markdown", "OPTIONAL: Output format, markdown or json")
+	cmd.Flags().StringVar(&opts.Driver, "driver", sqliteDriver, driverUsage)
+
+	return cmd
+}
+
+// buildChangelog returns the sections of changelogCategories with at least one entry
+func buildChangelog(ctx context.Context, previousPath, currentPath string) []changelogSection {
+	previousDB := openExistingDB(previousPath)
+	defer previousDB.Close()
+	currentDB := openExistingDB(currentPath)
+	defer currentDB.Close()
+
+	previousTables := tableSet(ctx, previousDB)
+	currentTables := tableSet(ctx, currentDB)
+
+	sections := []changelogSection{}
+	for _, category := range changelogCategories {
+		if _, ok := currentTables[category.Table]; !ok {
+			continue
+		}
+		d := changelogDiff(ctx, previousDB, currentDB, category.Table, previousTables)
+		if d == nil {
+			continue
+		}
+
+		pk := getPrimaryKey(ctx, currentDB, category.Table)
+		section := changelogSection{Title: category.Title, Table: category.Table}
+		if category.Status == tableAdded {
+			for _, row := range d.Added {
+				section.Entries = append(section.Entries, changelogEntry{Key: rowKey(row, pk), Name: row[category.NameColumn]})
+			}
+		} else {
+			names := map[string]string{}
+			if category.NameColumn != "" {
+				names = entryNames(ctx, currentDB, category.Table, category.NameColumn, pk)
+			}
+			for _, change := range d.Changed {
+				section.Entries = append(section.Entries, changelogEntry{Key: change.Key, Name: names[formatRow(change.Key)], Columns: change.Columns})
+			}
+		}
+		if len(section.Entries) > 0 {
+			sections = append(sections, section)
+		}
+	}
+	return sections
+}
+
+// changelogDiff diffs table, which is entirely new if the previous database does not have it
+func changelogDiff(ctx context.Context, previousDB, currentDB *sql.DB, table string, previousTables map[string]struct{}) *tableDiff {
+	if _, ok := previousTables[table]; !ok {
+		return &tableDiff{Table: table, Status: tableAdded, Added: getRowMaps(ctx, currentDB, table)}
+	}
+	return diffTable(ctx, previousDB, currentDB, table)
+}
+
+// entryNames returns the name of every row of table, indexed by the formatted key
+func entryNames(ctx context.Context, db *sql.DB, table, nameColumn string, pk []string) map[string]string {
+	names := map[string]string{}
+	for _, row := range getRowMaps(ctx, db, table) {
+		names[formatRow(rowKey(row, pk))] = row[nameColumn]
+	}
+	return names
+}
+
+func printChangelog(sections []changelogSection) {
+	if len(sections) == 0 {
+		fmt.Println("no changes")
+		return
+	}
+
+	for i, section := range sections {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("## %s\n\n", section.Title)
+		for _, entry := range section.Entries {
+			line := formatKey(entry.Key)
+			if entry.Name != "" {
+				line = fmt.Sprintf("%s (%s)", entry.Name, line)
+			}
+			var columns []string
+			for _, c := range sortedKeys(entry.Columns) {
+				columns = append(columns, fmt.Sprintf("%s %s → %s", c, entry.Columns[c][0], entry.Columns[c][1]))
+			}
+			if len(columns) > 0 {
+				line += ": " + strings.Join(columns, ", ")
+			}
+			fmt.Printf("- %s\n", line)
+		}
+	}
+}
+
+func formatKey(key map[string]string) string {
+	var fields []string
+	for _, c := range sortedKeys(key) {
+		fields = append(fields, c+" "+key[c])
+	}
+	return strings.Join(fields, ", ")
+}
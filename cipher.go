@@ -0,0 +1,33 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// openDB opens the database at path, which may be a DSN, with the driver of --driver
+// and unlocks it with key if it is set, then runs the init statements on every connection.
+// Keys need SQLCipher, which is used when the tool is built with -tags libsqlite3
+// against a SQLCipher build of libsqlite3.
+func openDB(path, key string, init ...string) (*sql.DB, error) {
+	if key == "" {
+		return openSQL(opts.Driver, path, init...)
+	}
+
+	db, err := openKeyedDB(path, key, init...)
+	if err != nil {
+		return nil, err
+	}
+
+	// PRAGMA key is silently ignored by SQLite without SQLCipher
+	var cipherVersion string
+	if err = db.QueryRow("PRAGMA cipher_version;").Scan(&cipherVersion); err != nil {
+		db.Close()
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("%s needs a key but SQLite is not built with SQLCipher, rebuild with -tags libsqlite3 against SQLCipher", path)
+		}
+		return nil, err
+	}
+
+	return db, nil
+}
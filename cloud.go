@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const (
+	s3Scheme = "s3://"
+	gsScheme = "gs://"
+)
+
+// endpoint of the S3 compatible XML API of Google Cloud Storage
+const gcsEndpoint = "storage.googleapis.com"
+
+func isObjectURL(path string) bool {
+	return strings.HasPrefix(path, s3Scheme) || strings.HasPrefix(path, gsScheme)
+}
+
+// parseObjectURL splits s3://bucket/key into the scheme, bucket and key
+func parseObjectURL(url string) (string, string, string, error) {
+	scheme := s3Scheme
+	if strings.HasPrefix(url, gsScheme) {
+		scheme = gsScheme
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(url, scheme), "/")
+	if bucket == "" || key == "" {
+		return "", "", "", invalidInputf("invalid object url %s, expected %sbucket/key", url, scheme)
+	}
+	return scheme, bucket, key, nil
+}
+
+// newObjectClient creates a client for the scheme. S3 credentials are read from the
+// usual AWS environment variables, credentials file or instance role. GCS is accessed
+// through its S3 compatible API with the HMAC key in GCS_ACCESS_KEY_ID and GCS_SECRET_ACCESS_KEY.
+func newObjectClient(scheme string) (*minio.Client, error) {
+	endpoint := opts.S3Endpoint
+	var creds *credentials.Credentials
+	if scheme == gsScheme {
+		endpoint = gcsEndpoint
+		creds = credentials.NewStaticV4(os.Getenv("GCS_ACCESS_KEY_ID"), os.Getenv("GCS_SECRET_ACCESS_KEY"), "")
+	} else {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+		})
+	}
+
+	// plain http is only used when asked for, e.g. for a local MinIO
+	secure := !strings.HasPrefix(endpoint, "http://")
+	endpoint = strings.TrimPrefix(strings.TrimPrefix(endpoint, "http://"), "https://")
+
+	client, err := minio.New(endpoint, &minio.Options{Creds: creds, Secure: secure})
+	if err != nil {
+		return nil, invalidInputf("error creating client for %s: %w", endpoint, err)
+	}
+	return client, nil
+}
+
+// downloadObject downloads an s3:// or gs:// object into a temporary file
+func downloadObject(ctx context.Context, url string) (string, func(), error) {
+	scheme, bucket, key, err := parseObjectURL(url)
+	if err != nil {
+		return "", nil, err
+	}
+	client, err := newObjectClient(scheme)
+	if err != nil {
+		return "", nil, err
+	}
+
+	tmp, err := os.CreateTemp("", "pcr_object_*")
+	if err != nil {
+		return "", nil, err
+	}
+	tmp.Close()
+	cleanup := func() { os.Remove(tmp.Name()) }
+
+	if err = client.FGetObject(ctx, bucket, key, tmp.Name(), minio.GetObjectOptions{}); err != nil {
+		cleanup()
+		return "", nil, invalidInputf("error downloading %s: %w", url, err)
+	}
+
+	slog.Info("downloaded object", "url", url, "path", tmp.Name())
+	return tmp.Name(), cleanup, nil
+}
+
+// uploadObject uploads the file at path to an s3:// or gs:// url
+func uploadObject(ctx context.Context, path, url string) error {
+	scheme, bucket, key, err := parseObjectURL(url)
+	if err != nil {
+		return err
+	}
+	client, err := newObjectClient(scheme)
+	if err != nil {
+		return err
+	}
+
+	if _, err = client.FPutObject(ctx, bucket, key, path, minio.PutObjectOptions{}); err != nil {
+		return fmt.Errorf("error uploading %s to %s: %w", path, url, err)
+	}
+
+	slog.Info("uploaded object", "path", path, "url", url)
+	return nil
+}
+
+// objectExists returns whether the object at an s3:// or gs:// url exists
+func objectExists(ctx context.Context, url string) (bool, error) {
+	scheme, bucket, key, err := parseObjectURL(url)
+	if err != nil {
+		return false, err
+	}
+	client, err := newObjectClient(scheme)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err = client.StatObject(ctx, bucket, key, minio.StatObjectOptions{}); err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return false, nil
+		}
+		return false, fmt.Errorf("error checking %s: %w", url, err)
+	}
+	return true, nil
+}
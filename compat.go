@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+
+	"github.com/peterli110/pcr-hash-table-rename/pkg/rename"
+)
+
+// schemaGeneration is the generation of hashed table names supported by this tool,
+// the number of their hashedPrefix
+const schemaGeneration = 1
+
+// generationRegex matches the hashed table names of any generation, v<generation>_<hex digits>
+var generationRegex = regexp.MustCompile(`^v([0-9]+)_([0-9a-f]+)$`)
+
+// versionString is the version of --version, with the supported hashed table names and the
+// last truth version the matching was validated against
+func versionString() string {
+	return fmt.Sprintf("%s (hashed table names %s with %d hex digits, validated up to truth version %d)", version, hashedPrefix, hashedNameDigits, knownTruthVersion)
+}
+
+// checkCompatibility warns when the hashed database looks newer than the tool: table names of
+// a newer generation or with another number of digits, or a truth version above the validated one
+func checkCompatibility(ctx context.Context, hashedDB *sql.DB) error {
+	tables, err := rename.GetTableNames(ctx, hashedDB, false)
+	if err != nil {
+		return err
+	}
+	newest, otherDigits, supported := 0, 0, 0
+	for _, t := range tables {
+		m := generationRegex.FindStringSubmatch(t)
+		if m == nil {
+			continue
+		}
+		generation, _ := strconv.Atoi(m[1])
+		newest = max(newest, generation)
+		switch {
+		case generation != schemaGeneration:
+		case len(m[2]) != hashedNameDigits:
+			otherDigits++
+		default:
+			supported++
+		}
+	}
+	if newest > schemaGeneration {
+		slog.Warn("the hashed database has hashed table names of a newer generation than supported, update the tool", "generation", fmt.Sprintf("v%d_", newest), "supported", hashedPrefix)
+		addWarning(fmt.Sprintf("the hashed database has v%d_ table names, newer than the supported %s", newest, hashedPrefix))
+	}
+	if otherDigits > 0 {
+		slog.Warn("the hashed database has hashed table names with an unexpected number of digits, update the tool", "tables", otherDigits, "digits", hashedNameDigits)
+		addWarning(fmt.Sprintf("the hashed database has %d %s table names without %d hex digits", otherDigits, hashedPrefix, hashedNameDigits))
+	}
+	if newest == 0 && supported == 0 {
+		slog.Warn("the hashed database has no hashed table names", "prefix", hashedPrefix)
+		addWarning("the hashed database has no hashed table names")
+	}
+	if truthVersion, err := strconv.Atoi(summary.TruthVersion); err == nil && truthVersion > knownTruthVersion {
+		slog.Warn("the hashed database is newer than the tool was validated against, check the matches", "truthVersion", truthVersion, "validatedTruthVersion", knownTruthVersion)
+		addWarning(fmt.Sprintf("truth version %d is newer than the validated truth version %d", truthVersion, knownTruthVersion))
+	}
+	return nil
+}
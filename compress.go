@@ -0,0 +1,73 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// file extension of each supported compression format
+var compressionExts = map[string]string{
+	"gzip": ".gz",
+	"zstd": ".zst",
+	"br":   ".br",
+}
+
+// compressFile replaces the file at path with a compressed one and returns the new path
+func compressFile(path, format string) (string, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	// the compressed file is written next to compressedPath and only renamed to it once complete,
+	// so a failed compression leaves no partial file behind
+	compressedPath := path + compressionExts[format]
+	tmp := compressedPath + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp)
+	defer out.Close()
+
+	var w io.WriteCloser
+	switch format {
+	case "gzip":
+		w, err = gzip.NewWriterLevel(out, gzip.BestCompression)
+	case "zstd":
+		w, err = zstd.NewWriter(out, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+	case "br":
+		w = brotli.NewWriterLevel(out, brotli.BestCompression)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if _, err = io.Copy(w, in); err != nil {
+		return "", fmt.Errorf("error compressing %s: %w", path, err)
+	}
+	if err = w.Close(); err != nil {
+		return "", fmt.Errorf("error compressing %s: %w", path, err)
+	}
+	if err = out.Close(); err != nil {
+		return "", fmt.Errorf("error compressing %s: %w", path, err)
+	}
+	if err = os.Rename(tmp, compressedPath); err != nil {
+		return "", err
+	}
+
+	in.Close()
+	if err = os.Remove(path); err != nil {
+		return "", err
+	}
+
+	slog.Info("compressed file", "path", path, "compressedPath", compressedPath)
+	return compressedPath, nil
+}
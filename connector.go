@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+)
+
+// dsnConnector opens the connections of a driver without a connector of its own
+type dsnConnector struct {
+	driver driver.Driver
+	dsn    string
+}
+
+func (c *dsnConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c *dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// initConnector runs statements on every connection it opens, before the pool uses it
+type initConnector struct {
+	driver.Connector
+	statements []string
+}
+
+func (c *initConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, statement := range c.statements {
+		if err = execConn(ctx, conn, statement); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s: %w", statement, err)
+		}
+	}
+	return conn, nil
+}
+
+// execConn runs statement on conn with the interfaces the driver implements
+func execConn(ctx context.Context, conn driver.Conn, statement string) error {
+	if e, ok := conn.(driver.ExecerContext); ok {
+		_, err := e.ExecContext(ctx, statement, nil)
+		if err != driver.ErrSkip {
+			return err
+		}
+	}
+	stmt, err := conn.Prepare(statement)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	if se, ok := stmt.(driver.StmtExecContext); ok {
+		_, err = se.ExecContext(ctx, nil)
+		return err
+	}
+	_, err = stmt.Exec(nil)
+	return err
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+)
+
+// curatedViewFiles are the queries of the views of --curatedViews, one SELECT per file,
+// named after the file
+//
+//go:embed views/*.sql
+var curatedViewFiles embed.FS
+
+// createCuratedViews creates the curated views of views/ in db and returns the number of views
+// created. Views over tables or columns db does not have, e.g. of a region with another schema
+// or of tables left out by --filter, are skipped.
+func createCuratedViews(ctx context.Context, db *sql.DB) (int, error) {
+	entries, err := curatedViewFiles.ReadDir("views")
+	if err != nil {
+		return 0, err
+	}
+	created := 0
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		query, err := curatedViewFiles.ReadFile(path.Join("views", entry.Name()))
+		if err != nil {
+			return created, err
+		}
+
+		var exists bool
+		if err = db.QueryRowContext(ctx, "SELECT count(*) > 0 FROM sqlite_master WHERE name = ? COLLATE NOCASE", name).Scan(&exists); err != nil {
+			return created, err
+		}
+		if exists {
+			slog.Warn("not creating curated view, the new database has a table of the same name", "view", name)
+			addWarning("no curated view " + name + ", the new database has a table of the same name")
+			continue
+		}
+		if err = createCuratedView(ctx, db, name, string(query)); err != nil {
+			slog.Debug("not creating curated view", "view", name, "error", err)
+			continue
+		}
+		slog.Debug("created curated view", "view", name)
+		created++
+	}
+	return created, nil
+}
+
+// createCuratedView creates the view name of query in db, unless a table or column of query is
+// missing from db, which is only reported when the view is queried
+func createCuratedView(ctx context.Context, db *sql.DB, name, query string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, fmt.Sprintf("CREATE VIEW %s AS %s", quoteIdentifier(name), query)); err != nil {
+		return err
+	}
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT * FROM %s LIMIT 0", quoteIdentifier(name)))
+	if err != nil {
+		return err
+	}
+	if err = rows.Close(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
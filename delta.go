@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/peterli110/pcr-hash-table-rename/pkg/rename"
+)
+
+// readPreviousChecksums returns the table checksums of the previous run of --deltaFrom by table name,
+// read from the run summary of a run with --tableChecksums or computed from the generated database itself
+func readPreviousChecksums(ctx context.Context, path string) (map[string]string, error) {
+	checksums := map[string]string{}
+	if strings.HasSuffix(path, ".json") {
+		jsonData, err := os.ReadFile(path)
+		if err != nil {
+			return nil, invalidInputf("error opening run summary: %w", err)
+		}
+		var previous runSummary
+		if err = json.Unmarshal(jsonData, &previous); err != nil {
+			return nil, invalidInputf("invalid run summary %s: %w", path, err)
+		}
+		for _, t := range previous.Tables {
+			if t.SHA256 != "" {
+				checksums[t.Name] = t.SHA256
+			}
+		}
+		if len(checksums) == 0 {
+			return nil, invalidInputf("run summary %s has no table checksums, it must be of a run with --tableChecksums or --deltaFrom", path)
+		}
+		return checksums, nil
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return nil, invalidInputf("error opening previous generated database: %w", err)
+	}
+	db, err := openDB(path, "")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	names, err := rename.GetTableNames(ctx, db, false)
+	if err != nil {
+		return nil, invalidInputf("previous generated database %s: %w", path, err)
+	}
+	if !slices.Contains(names, rename.MappingTable) {
+		return nil, invalidInputf("previous generated database %s has no %s table, it must be generated without --skipMappingTable", path, rename.MappingTable)
+	}
+	// only the tables generated from a hashed table, a delta does not hold the unchanged ones
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT original_name FROM %s", rename.MappingTable))
+	if err != nil {
+		return nil, invalidInputf("error reading %s of %s: %w", rename.MappingTable, path, err)
+	}
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if slices.Contains(names, name) {
+			tables = append(tables, name)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, table := range tables {
+		if checksums[table], err = rename.TableChecksum(ctx, db, table); err != nil {
+			return nil, invalidInputf("previous generated database %s: %w", path, err)
+		}
+	}
+	return checksums, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/peterli110/pcr-hash-table-rename/pkg/rename"
+)
+
+// createDB creates the database path holding the tables created by stmts
+func createDB(t *testing.T, path string, stmts ...string) {
+	t.Helper()
+	db, err := sql.Open(sqliteDriver, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, stmt := range stmts {
+		if _, err = db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+}
+
+// checksumOf returns the TableChecksum of the table created by stmts
+func checksumOf(t *testing.T, table string, stmts ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "checksum.db")
+	createDB(t, path, stmts...)
+	db, err := sql.Open(sqliteDriver, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	checksum, err := rename.TableChecksum(context.Background(), db, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return checksum
+}
+
+func TestReadPreviousChecksums(t *testing.T) {
+	opts.Driver = sqliteDriver
+	unitData := checksumOf(t, "v1_aaaa",
+		"CREATE TABLE v1_aaaa (c_1 INTEGER PRIMARY KEY, c_2 TEXT)",
+		"INSERT INTO v1_aaaa VALUES (2, 'b'), (1, 'a')")
+	tests := []struct {
+		name string
+		// file is the previous generated database, or the run summary if it ends in .json
+		file  string
+		stmts []string
+		json  string
+		want  map[string]string
+		code  int
+	}{
+		{
+			name: "generated database",
+			file: "previous.db",
+			stmts: []string{
+				"CREATE TABLE unit_data (unit_id INTEGER PRIMARY KEY, unit_name TEXT)",
+				"INSERT INTO unit_data VALUES (1, 'a'), (2, 'b')",
+				"CREATE TABLE extra (id INTEGER PRIMARY KEY)",
+				"CREATE TABLE _table_mapping (original_name TEXT PRIMARY KEY, hashed_name TEXT NOT NULL)",
+				// a delta lists the unchanged tables it does not hold
+				"INSERT INTO _table_mapping VALUES ('unit_data', 'v1_aaaa'), ('skill_data', 'v1_bbbb')",
+			},
+			want: map[string]string{"unit_data": unitData},
+		},
+		{
+			name: "hashed database",
+			file: "hashed.db",
+			stmts: []string{
+				"CREATE TABLE v1_aaaa (c_1 INTEGER PRIMARY KEY, c_2 TEXT)",
+			},
+			code: exitInvalidInput,
+		},
+		{
+			name: "run summary",
+			file: "run_summary.json",
+			json: `{"tables": [{"name": "unit_data", "hashedName": "v1_aaaa", "sha256": "abc"}, {"name": "skill_data", "hashedName": "v1_bbbb"}]}`,
+			want: map[string]string{"unit_data": "abc"},
+		},
+		{
+			name: "run summary without checksums",
+			file: "run_summary.json",
+			json: `{"tables": [{"name": "unit_data", "hashedName": "v1_aaaa"}]}`,
+			code: exitInvalidInput,
+		},
+		{
+			name: "missing database",
+			file: "missing.db",
+			code: exitInvalidInput,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if tt.json != "" {
+				if err := os.WriteFile(path, []byte(tt.json), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			} else if tt.stmts != nil {
+				createDB(t, path, tt.stmts...)
+			}
+
+			got, err := readPreviousChecksums(context.Background(), path)
+			if tt.code != 0 {
+				if code := exitCodeOf(err); code != tt.code {
+					t.Errorf("readPreviousChecksums error = %v, exit code %d, want %d", err, code, tt.code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readPreviousChecksums = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/peterli110/pcr-hash-table-rename/pkg/rename"
+	"github.com/spf13/cobra"
+)
+
+type tableDiff struct {
+	Table   string              `json:"table"`
+	Status  string              `json:"status"`
+	Added   []map[string]string `json:"added,omitempty"`
+	Removed []map[string]string `json:"removed,omitempty"`
+	Changed []rowChange         `json:"changed,omitempty"`
+}
+
+type rowChange struct {
+	Key     map[string]string    `json:"key"`
+	Columns map[string][2]string `json:"columns"`
+}
+
+const (
+	tableAdded   = "added"
+	tableRemoved = "removed"
+	tableChanged = "changed"
+)
+
+func newDiffCmd() *cobra.Command {
+	var format string
+
+	cmd := &cobra.Command{
+		Use:   "diff <old database> <new database>",
+		Short: "Print added, removed and changed rows between two generated databases",
+		Long: `Print added, removed and changed rows per table between two generated databases.
+Rows are matched by primary key, tables without a primary key only report added and removed rows,
+duplicated rows included. The _meta and _table_mapping tables describing the runs are left out.`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			if format != "text" && format != "json" {
+				fatalf(exitInvalidInput, "Unknown format %s", format)
+			}
+
+			diffs := diffDatabases(cmd.Context(), args[0], args[1])
+			if format == "json" {
+				jsonData, err := json.MarshalIndent(diffs, "", "  ")
+				if err != nil {
+					fatalf(exitInternalError, "Error encoding diff: %v", err)
+				}
+				fmt.Println(string(jsonData))
+				return
+			}
+			printDiff(diffs)
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", "text", "OPTIONAL: Output format, text or json")
+	cmd.Flags().StringVar(&opts.Driver, "driver", sqliteDriver, driverUsage)
+
+	return cmd
+}
+
+func diffDatabases(ctx context.Context, oldPath, newPath string) []tableDiff {
+	oldDB := openExistingDB(oldPath)
+	defer oldDB.Close()
+	newDB := openExistingDB(newPath)
+	defer newDB.Close()
+
+	oldTables := tableSet(ctx, oldDB)
+	newTables := tableSet(ctx, newDB)
+
+	var names []string
+	for t := range oldTables {
+		names = append(names, t)
+	}
+	for t := range newTables {
+		if _, ok := oldTables[t]; !ok {
+			names = append(names, t)
+		}
+	}
+	sort.Strings(names)
+
+	diffs := []tableDiff{}
+	for _, t := range names {
+		_, inOld := oldTables[t]
+		_, inNew := newTables[t]
+		switch {
+		case !inOld:
+			diffs = append(diffs, tableDiff{Table: t, Status: tableAdded, Added: getRowMaps(ctx, newDB, t)})
+		case !inNew:
+			diffs = append(diffs, tableDiff{Table: t, Status: tableRemoved, Removed: getRowMaps(ctx, oldDB, t)})
+		default:
+			if d := diffTable(ctx, oldDB, newDB, t); d != nil {
+				diffs = append(diffs, *d)
+			}
+		}
+	}
+
+	return diffs
+}
+
+// tableSet returns the tables of a generated database, without the tables describing the run that generated it
+func tableSet(ctx context.Context, db *sql.DB) map[string]struct{} {
+	tables, err := rename.GetTableNames(ctx, db, false)
+	if err != nil {
+		fatalf(exitInvalidInput, "Error reading tables: %v", err)
+	}
+	set := map[string]struct{}{}
+	for _, t := range tables {
+		if t == metaTable || t == rename.MappingTable {
+			continue
+		}
+		set[t] = struct{}{}
+	}
+	return set
+}
+
+// openExistingDB opens the database at path, which may be a DSN, with the driver of --driver
+func openExistingDB(path string) *sql.DB {
+	if err := checkDriver(); err != nil {
+		fatalf(exitInvalidInput, "%v", err)
+	}
+	file, _ := splitDSN(path)
+	if _, err := os.Stat(file); err != nil {
+		fatalf(exitInvalidInput, "Error opening %s: %v", path, err)
+	}
+
+	db, err := openDB(path, "")
+	if err != nil {
+		fatalf(exitInvalidInput, "Error opening %s: %v", path, err)
+	}
+	return db
+}
+
+// diffTable compares a table present in both databases, it returns nil if nothing changed
+func diffTable(ctx context.Context, oldDB, newDB *sql.DB, table string) *tableDiff {
+	pk := getPrimaryKey(ctx, newDB, table)
+	d := tableDiff{Table: table, Status: tableChanged}
+	if len(pk) == 0 {
+		d.Added, d.Removed = diffRowCounts(getRowMaps(ctx, oldDB, table), getRowMaps(ctx, newDB, table))
+		if len(d.Added) == 0 && len(d.Removed) == 0 {
+			return nil
+		}
+		return &d
+	}
+
+	oldRows := keyRows(getRowMaps(ctx, oldDB, table), pk)
+	newRows := keyRows(getRowMaps(ctx, newDB, table), pk)
+	for _, key := range sortedKeys(oldRows) {
+		oldRow := oldRows[key]
+		newRow, ok := newRows[key]
+		if !ok {
+			d.Removed = append(d.Removed, oldRow)
+			continue
+		}
+		if columns := diffRow(oldRow, newRow); len(columns) > 0 {
+			d.Changed = append(d.Changed, rowChange{Key: rowKey(newRow, pk), Columns: columns})
+		}
+	}
+	for _, key := range sortedKeys(newRows) {
+		if _, ok := oldRows[key]; !ok {
+			d.Added = append(d.Added, newRows[key])
+		}
+	}
+
+	if len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0 {
+		return nil
+	}
+	return &d
+}
+
+func getPrimaryKey(ctx context.Context, db *sql.DB, tableName string) []string {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT name FROM pragma_table_info('%s') WHERE pk > 0 ORDER BY pk", tableName))
+	if err != nil {
+		fatalf(exitInvalidInput, "Error getting primary key of table %s: %v", tableName, err)
+	}
+	defer rows.Close()
+
+	var pk []string
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			fatalf(exitInvalidInput, "Error getting primary key of table %s: %v", tableName, err)
+		}
+		pk = append(pk, name)
+	}
+	if err = rows.Err(); err != nil {
+		fatalf(exitInvalidInput, "Error getting primary key of table %s: %v", tableName, err)
+	}
+	return pk
+}
+
+func getRowMaps(ctx context.Context, db *sql.DB, tableName string) []map[string]string {
+	columns, err := rename.GetColumnNames(ctx, db, tableName)
+	if err != nil {
+		fatalf(exitInvalidInput, "Error getting columns of table %s: %v", tableName, err)
+	}
+	data, err := rename.GetAllData(ctx, db, tableName)
+	if err != nil {
+		fatalf(exitInvalidInput, "Error fetching data from table %s: %v", tableName, err)
+	}
+
+	rows := make([]map[string]string, 0, len(data))
+	for _, values := range data {
+		row := make(map[string]string, len(columns))
+		for i, c := range columns {
+			row[c] = values[i]
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// keyRows indexes rows by their primary key
+func keyRows(rows []map[string]string, pk []string) map[string]map[string]string {
+	keyed := make(map[string]map[string]string, len(rows))
+	for _, row := range rows {
+		keyed[joinValues(row, pk)] = row
+	}
+	return keyed
+}
+
+// diffRowCounts compares the rows of a table without a primary key as multisets: a row is added or
+// removed as many times as the number of its copies changed
+func diffRowCounts(oldRows, newRows []map[string]string) (added, removed []map[string]string) {
+	counts := map[string]int{}
+	rows := map[string]map[string]string{}
+	for _, row := range oldRows {
+		key := joinValues(row, sortedKeys(row))
+		counts[key]--
+		rows[key] = row
+	}
+	for _, row := range newRows {
+		key := joinValues(row, sortedKeys(row))
+		counts[key]++
+		rows[key] = row
+	}
+	for _, key := range sortedKeys(counts) {
+		for i := counts[key]; i > 0; i-- {
+			added = append(added, rows[key])
+		}
+		for i := counts[key]; i < 0; i++ {
+			removed = append(removed, rows[key])
+		}
+	}
+	return added, removed
+}
+
+// joinValues returns the values of the columns of row, joined into a key
+func joinValues(row map[string]string, columns []string) string {
+	values := make([]string, len(columns))
+	for i, c := range columns {
+		values[i] = row[c]
+	}
+	return strings.Join(values, "\x1f")
+}
+
+func rowKey(row map[string]string, pk []string) map[string]string {
+	key := map[string]string{}
+	for _, c := range pk {
+		key[c] = row[c]
+	}
+	return key
+}
+
+func diffRow(oldRow, newRow map[string]string) map[string][2]string {
+	columns := map[string][2]string{}
+	for c, v := range oldRow {
+		if nv, ok := newRow[c]; !ok || nv != v {
+			columns[c] = [2]string{v, nv}
+		}
+	}
+	for c, v := range newRow {
+		if _, ok := oldRow[c]; !ok {
+			columns[c] = [2]string{"", v}
+		}
+	}
+	return columns
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func printDiff(diffs []tableDiff) {
+	if len(diffs) == 0 {
+		fmt.Println("no differences")
+		return
+	}
+
+	for _, d := range diffs {
+		fmt.Printf("%s (%s): +%d -%d ~%d\n", d.Table, d.Status, len(d.Added), len(d.Removed), len(d.Changed))
+		for _, row := range d.Added {
+			fmt.Printf("  + %s\n", formatRow(row))
+		}
+		for _, row := range d.Removed {
+			fmt.Printf("  - %s\n", formatRow(row))
+		}
+		for _, change := range d.Changed {
+			var columns []string
+			for _, c := range sortedKeys(change.Columns) {
+				columns = append(columns, fmt.Sprintf("%s: %s -> %s", c, change.Columns[c][0], change.Columns[c][1]))
+			}
+			fmt.Printf("  ~ %s  %s\n", formatRow(change.Key), strings.Join(columns, ", "))
+		}
+	}
+}
+
+func formatRow(row map[string]string) string {
+	var fields []string
+	for _, c := range sortedKeys(row) {
+		fields = append(fields, c+"="+row[c])
+	}
+	return "{" + strings.Join(fields, ", ") + "}"
+}
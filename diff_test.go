@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDiffRowCounts(t *testing.T) {
+	a := map[string]string{"id": "1", "name": "a"}
+	b := map[string]string{"id": "2", "name": "b"}
+	tests := []struct {
+		name             string
+		oldRows, newRows []map[string]string
+		added, removed   []map[string]string
+	}{
+		{name: "same rows in another order", oldRows: []map[string]string{a, b}, newRows: []map[string]string{b, a}},
+		{name: "added row", oldRows: []map[string]string{a}, newRows: []map[string]string{a, b}, added: []map[string]string{b}},
+		{name: "removed row", oldRows: []map[string]string{a, b}, newRows: []map[string]string{b}, removed: []map[string]string{a}},
+		{name: "removed copy", oldRows: []map[string]string{a, a, a}, newRows: []map[string]string{a}, removed: []map[string]string{a, a}},
+		{name: "added copy", oldRows: []map[string]string{a}, newRows: []map[string]string{a, a, b}, added: []map[string]string{a, b}},
+		{name: "new table", newRows: []map[string]string{a}, added: []map[string]string{a}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			added, removed := diffRowCounts(tt.oldRows, tt.newRows)
+			if !reflect.DeepEqual(added, tt.added) {
+				t.Errorf("added = %v, want %v", added, tt.added)
+			}
+			if !reflect.DeepEqual(removed, tt.removed) {
+				t.Errorf("removed = %v, want %v", removed, tt.removed)
+			}
+		})
+	}
+}
+
+func TestDiffTable(t *testing.T) {
+	opts.Driver = sqliteDriver
+	tests := []struct {
+		name               string
+		oldStmts, newStmts []string
+		// want is nil if the table did not change
+		want *tableDiff
+	}{
+		{
+			name:     "unchanged",
+			oldStmts: []string{"CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT)", "INSERT INTO t VALUES (1, 'a'), (2, 'b')"},
+			newStmts: []string{"CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT)", "INSERT INTO t VALUES (2, 'b'), (1, 'a')"},
+		},
+		{
+			name:     "primary key",
+			oldStmts: []string{"CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT)", "INSERT INTO t VALUES (1, 'a'), (2, 'b')"},
+			newStmts: []string{"CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT)", "INSERT INTO t VALUES (1, 'x'), (3, 'c')"},
+			want: &tableDiff{
+				Table:   "t",
+				Status:  tableChanged,
+				Added:   []map[string]string{{"id": "3", "name": "c"}},
+				Removed: []map[string]string{{"id": "2", "name": "b"}},
+				Changed: []rowChange{{Key: map[string]string{"id": "1"}, Columns: map[string][2]string{"name": {"a", "x"}}}},
+			},
+		},
+		{
+			name:     "composite primary key",
+			oldStmts: []string{"CREATE TABLE t (a INTEGER, b INTEGER, v TEXT, PRIMARY KEY (a, b))", "INSERT INTO t VALUES (1, 1, 'x'), (1, 2, 'y')"},
+			newStmts: []string{"CREATE TABLE t (a INTEGER, b INTEGER, v TEXT, PRIMARY KEY (a, b))", "INSERT INTO t VALUES (1, 1, 'x'), (1, 2, 'z')"},
+			want: &tableDiff{
+				Table:   "t",
+				Status:  tableChanged,
+				Changed: []rowChange{{Key: map[string]string{"a": "1", "b": "2"}, Columns: map[string][2]string{"v": {"y", "z"}}}},
+			},
+		},
+		{
+			name:     "no primary key, duplicated rows",
+			oldStmts: []string{"CREATE TABLE t (id INTEGER, name TEXT)", "INSERT INTO t VALUES (1, 'a'), (1, 'a'), (2, 'b')"},
+			newStmts: []string{"CREATE TABLE t (id INTEGER, name TEXT)", "INSERT INTO t VALUES (1, 'a'), (2, 'b'), (2, 'b')"},
+			want: &tableDiff{
+				Table:   "t",
+				Status:  tableChanged,
+				Added:   []map[string]string{{"id": "2", "name": "b"}},
+				Removed: []map[string]string{{"id": "1", "name": "a"}},
+			},
+		},
+		{
+			name:     "no primary key, same rows",
+			oldStmts: []string{"CREATE TABLE t (id INTEGER, name TEXT)", "INSERT INTO t VALUES (1, 'a'), (1, 'a')"},
+			newStmts: []string{"CREATE TABLE t (id INTEGER, name TEXT)", "INSERT INTO t VALUES (1, 'a'), (1, 'a')"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			oldDB := openTestDB(t, filepath.Join(dir, "old.db"), tt.oldStmts...)
+			newDB := openTestDB(t, filepath.Join(dir, "new.db"), tt.newStmts...)
+
+			got := diffTable(context.Background(), oldDB, newDB, "t")
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffTable = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// openTestDB creates the database path holding the tables created by stmts and opens it
+func openTestDB(t *testing.T, path string, stmts ...string) *sql.DB {
+	t.Helper()
+	createDB(t, path, stmts...)
+	db, err := sql.Open(sqliteDriver, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func isURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// downloadURL downloads url into the temporary directory and returns the path of the file.
+// The download goes to a partial file named after the url, so an interrupted download is
+// resumed by the next run if the server supports range requests and the file did not change
+// in the meantime. The complete file is moved to a path of its own, removed by the returned
+// cleanup function, so runs downloading the same url at the same time never share a file.
+func downloadURL(ctx context.Context, url string) (string, func(), error) {
+	sum := sha256.Sum256([]byte(url))
+	partial := filepath.Join(os.TempDir(), "pcr_download_"+hex.EncodeToString(sum[:8]))
+
+	// a run already downloading url keeps its partial file, this one downloads url on its own
+	unlock, err := lockOutput(partial)
+	if err != nil {
+		slog.Debug("not resuming download", "url", url, "error", err)
+		file, err := os.CreateTemp("", "pcr_download_*")
+		if err != nil {
+			return "", nil, err
+		}
+		file.Close()
+		path := file.Name()
+		if err = download(ctx, url, path, false); err != nil {
+			os.Remove(path)
+			return "", nil, err
+		}
+		return path, func() { os.Remove(path) }, nil
+	}
+	defer unlock()
+
+	if err = download(ctx, url, partial, true); err != nil {
+		// the partial file is kept so the next run can resume
+		return "", nil, err
+	}
+	file, err := os.CreateTemp("", "pcr_download_*")
+	if err != nil {
+		return "", nil, err
+	}
+	file.Close()
+	path := file.Name()
+	if err = os.Rename(partial, path); err != nil {
+		os.Remove(path)
+		return "", nil, err
+	}
+	os.Remove(partial + validatorExt)
+	return path, func() { os.Remove(path) }, nil
+}
+
+// validatorExt is the extension of the file next to a partial download holding the ETag
+// or Last-Modified of the response it comes from
+const validatorExt = ".validator"
+
+// errRestartDownload is returned by downloadRange when the partial file cannot be resumed
+var errRestartDownload = errors.New("partial download cannot be resumed")
+
+// download downloads url into path, resuming the partial file at path if resume is set
+func download(ctx context.Context, url, path string, resume bool) error {
+	var offset int64
+	var validator string
+	if resume {
+		if data, err := os.ReadFile(path + validatorExt); err == nil {
+			validator = string(data)
+		}
+		// without a validator, the partial file may belong to another version of the file
+		if info, err := os.Stat(path); err == nil && validator != "" {
+			offset = info.Size()
+		}
+	}
+
+	err := downloadRange(ctx, url, path, offset, validator, resume)
+	if errors.Is(err, errRestartDownload) {
+		slog.Info("restarting download", "url", url)
+		err = downloadRange(ctx, url, path, 0, "", resume)
+	}
+	return err
+}
+
+// downloadRange downloads url into path from offset, which must be the size of the partial
+// file at path, downloaded from the version of the file of validator
+func downloadRange(ctx context.Context, url, path string, offset int64, validator string, resume bool) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return invalidInputf("invalid url %s: %w", url, err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		// the server sends the whole file instead if it changed since the partial download
+		req.Header.Set("If-Range", validator)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error downloading %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	// size is the size of the complete file, -1 if unknown
+	size := int64(-1)
+	flags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusOK:
+		offset = 0
+		size = resp.ContentLength
+		flags |= os.O_TRUNC
+	case http.StatusPartialContent:
+		start, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != offset {
+			os.Remove(path)
+			return errRestartDownload
+		}
+		slog.Info("resuming download", "url", url, "offset", offset)
+		size = total
+		flags |= os.O_APPEND
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the previous download was already complete if the file is as long as the partial file
+		if _, total, ok := parseContentRange(resp.Header.Get("Content-Range")); offset > 0 && ok && total == offset {
+			return nil
+		}
+		os.Remove(path)
+		if offset == 0 {
+			return invalidInputf("error downloading %s: %s", url, resp.Status)
+		}
+		return errRestartDownload
+	default:
+		return invalidInputf("error downloading %s: %s", url, resp.Status)
+	}
+
+	if resume {
+		validator = resp.Header.Get("ETag")
+		if validator == "" {
+			validator = resp.Header.Get("Last-Modified")
+		}
+		// a strong validator is required to resume, the partial file is downloaded again otherwise
+		if strings.HasPrefix(validator, "W/") {
+			validator = ""
+		}
+		if err = os.WriteFile(path+validatorExt, []byte(validator), 0644); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	n, err := io.Copy(file, resp.Body)
+	if err != nil {
+		return fmt.Errorf("error downloading %s after %d bytes: %w", url, offset+n, err)
+	}
+	if size >= 0 && offset+n != size {
+		return fmt.Errorf("error downloading %s: got %d bytes of %d", url, offset+n, size)
+	}
+	slog.Info("downloaded file", "url", url, "path", path)
+	return nil
+}
+
+// parseContentRange returns the first byte and the size of the complete file of a
+// Content-Range header, bytes start-end/size or bytes */size, ok is false if either is missing
+func parseContentRange(header string) (start, size int64, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes ")
+	if !found {
+		return 0, 0, false
+	}
+	rangeSpec, sizeSpec, found := strings.Cut(spec, "/")
+	if !found {
+		return 0, 0, false
+	}
+	size, err := strconv.ParseInt(sizeSpec, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if rangeSpec == "*" {
+		return 0, size, true
+	}
+	startSpec, _, _ := strings.Cut(rangeSpec, "-")
+	start, err = strconv.ParseInt(startSpec, 10, 64)
+	return start, size, err == nil
+}
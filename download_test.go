@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header      string
+		start, size int64
+		ok          bool
+	}{
+		{header: "bytes 100-199/200", start: 100, size: 200, ok: true},
+		{header: "bytes 0-0/1", start: 0, size: 1, ok: true},
+		{header: "bytes */200", start: 0, size: 200, ok: true},
+		{header: "bytes 100-199/*"},
+		{header: "bytes 100-199"},
+		{header: "items 100-199/200"},
+		{header: "bytes x-199/200", size: 200},
+		{header: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			start, size, ok := parseContentRange(tt.header)
+			if start != tt.start || size != tt.size || ok != tt.ok {
+				t.Errorf("parseContentRange(%q) = %d, %d, %v, want %d, %d, %v", tt.header, start, size, ok, tt.start, tt.size, tt.ok)
+			}
+		})
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	content := []byte("0123456789abcdefghij")
+	etag := `"v2"`
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name string
+		// partial is the partial file left by a previous run, validator the file next to it, none if empty
+		partial   string
+		validator string
+		// rangeHeader is the Range header of the request
+		rangeHeader string
+	}{
+		{name: "no partial file"},
+		{name: "resumed", partial: "0123456789", validator: etag, rangeHeader: "bytes=10-"},
+		{name: "file changed", partial: "abcdefghij", validator: `"v1"`, rangeHeader: "bytes=10-"},
+		{name: "partial file without validator", partial: "abcdefghij"},
+		{name: "already complete", partial: string(content), validator: etag, rangeHeader: "bytes=20-"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ranges = nil
+			path := filepath.Join(t.TempDir(), "download")
+			if tt.partial != "" {
+				if err := os.WriteFile(path, []byte(tt.partial), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.validator != "" {
+				if err := os.WriteFile(path+validatorExt, []byte(tt.validator), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := download(context.Background(), srv.URL, path, true); err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("downloaded %q, want %q", got, content)
+			}
+			if len(ranges) != 1 || ranges[0] != tt.rangeHeader {
+				t.Errorf("Range headers = %q, want [%q]", ranges, tt.rangeHeader)
+			}
+		})
+	}
+}
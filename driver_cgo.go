@@ -0,0 +1,90 @@
+//go:build cgo && !purego
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+	_ "modernc.org/sqlite"
+)
+
+// sqliteDriver is mattn/go-sqlite3, which needs CGO. The pure-Go sqlite driver
+// can be picked with --driver, build with -tags purego or CGO_ENABLED=0 to drop CGO entirely.
+const sqliteDriver = "sqlite3"
+
+// keyedDriver is the name keyDriver is registered with
+const keyedDriver = "sqlite3_keyed"
+
+func init() {
+	sql.Register(keyedDriver, &keyDriver{})
+}
+
+// keyDriver is mattn/go-sqlite3 running PRAGMA key on every connection with the key of the keyParam
+// parameter of its DSN, as every connection of the pool has to be unlocked
+type keyDriver struct {
+	sqlite3.SQLiteDriver
+}
+
+func (d *keyDriver) Open(dsn string) (driver.Conn, error) {
+	dsn, key := cutKeyParam(dsn)
+	conn, err := d.SQLiteDriver.Open(dsn)
+	if err != nil || key == "" {
+		return conn, err
+	}
+	pragma := fmt.Sprintf("PRAGMA key = '%s';", strings.ReplaceAll(key, "'", "''"))
+	if _, err = conn.(*sqlite3.SQLiteConn).Exec(pragma, nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// openKeyedDB opens the database at path with the keyed driver, running PRAGMA key on every connection
+// before the init statements of openSQL
+func openKeyedDB(path, key string, init ...string) (*sql.DB, error) {
+	if opts.Driver != sqliteDriver {
+		return nil, fmt.Errorf("%s needs a key, which is only supported by the %s driver", path, sqliteDriver)
+	}
+
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return openSQL(keyedDriver, path+sep+keyParam+"="+url.QueryEscape(key), init...)
+}
+
+// backupConn copies the database of the connection src into the database at dst with the online
+// backup API of mattn/go-sqlite3, it returns false if src is not one of its connections
+func backupConn(ctx context.Context, src any, dst string) (bool, error) {
+	srcConn, ok := src.(*sqlite3.SQLiteConn)
+	if !ok {
+		return false, nil
+	}
+	db, err := sql.Open(sqliteDriver, dst)
+	if err != nil {
+		return true, err
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return true, err
+	}
+	defer conn.Close()
+	return true, conn.Raw(func(dc any) error {
+		backup, err := dc.(*sqlite3.SQLiteConn).Backup("main", srcConn, "main")
+		if err != nil {
+			return err
+		}
+		if _, err = backup.Step(-1); err != nil {
+			backup.Finish()
+			return err
+		}
+		return backup.Finish()
+	})
+}
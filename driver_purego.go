@@ -0,0 +1,24 @@
+//go:build purego || !cgo
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteDriver is modernc.org/sqlite, a CGO-free SQLite used when built with -tags purego or without CGO
+const sqliteDriver = "sqlite"
+
+// openKeyedDB always fails, SQLCipher is only available through the CGO driver
+func openKeyedDB(path, key string, init ...string) (*sql.DB, error) {
+	return nil, fmt.Errorf("%s needs a key but the tool is built without CGO, which has no SQLCipher support", path)
+}
+
+// backupConn always returns false, the mattn/go-sqlite3 connections it handles are only in the CGO build
+func backupConn(ctx context.Context, src any, dst string) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"database/sql"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// keyParam is the DSN parameter passing the SQLCipher key of a database to the keyed driver of openKeyedDB
+const keyParam = "_key"
+
+// driverUsage is the help of the --driver flag of the commands opening databases
+var driverUsage = "OPTIONAL: database/sql driver opening the databases, one of " + strings.Join(sql.Drivers(), ", ")
+
+// checkDriver returns an invalid input error if opts.Driver is not built into the tool
+func checkDriver() error {
+	if !slices.Contains(sql.Drivers(), opts.Driver) {
+		return invalidInputf("unknown driver %s, available drivers are %s", opts.Driver, strings.Join(sql.Drivers(), ", "))
+	}
+	return nil
+}
+
+// splitDSN splits a SQLite URI filename such as file:jp.db?mode=ro&vfs=unix-none into
+// the path of the database and its parameters. Anything else is returned as the path.
+func splitDSN(dsn string) (path, params string) {
+	if !strings.HasPrefix(dsn, "file:") {
+		return dsn, ""
+	}
+	path, params, _ = strings.Cut(strings.TrimPrefix(dsn, "file:"), "?")
+	return path, params
+}
+
+// joinDSN returns the DSN opening the database at path with the parameters of splitDSN
+func joinDSN(path, params string) string {
+	if params == "" {
+		return path
+	}
+	return "file:" + path + "?" + params
+}
+
+// cutKeyParam returns dsn without its keyParam parameter, and the key it held
+func cutKeyParam(dsn string) (string, string) {
+	path, query, ok := strings.Cut(dsn, "?")
+	if !ok {
+		return dsn, ""
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil || !values.Has(keyParam) {
+		return dsn, ""
+	}
+	key := values.Get(keyParam)
+	values.Del(keyParam)
+	if len(values) == 0 {
+		return path, key
+	}
+	return path + "?" + values.Encode(), key
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/peterli110/pcr-hash-table-rename/pkg/rename"
+)
+
+const (
+	exitSuccess            = 0
+	exitInternalError      = 1
+	exitInvalidInput       = 2
+	exitUnmatchedTables    = 3
+	exitVerificationFailed = 4
+	exitTablesFailed       = 5
+	exitStrictUnmatched    = 6
+	// 128 + SIGINT, like shells report a process killed by Ctrl-C
+	exitInterrupted = 130
+)
+
+const exitCodesHelp = `Exit codes:
+  0  success
+  1  internal error
+  2  invalid input (bad flags or unreadable input files)
+  3  success, but some tables have no match
+  4  the generated database failed verification
+  5  some tables could not be copied, the others were
+  6  some tables have no match in strict mode, nothing is written
+  130  interrupted by SIGINT or SIGTERM, or --review or --interactive was quit, nothing is written`
+
+// fatalf logs the message, writes the profiles, the SQL trace and the spans and exits with the given exit code
+func fatalf(code int, format string, v ...interface{}) {
+	slog.Error(fmt.Sprintf(format, v...), "exitCode", code)
+	stopProfiling()
+	stopSQLTrace()
+	stopTracing()
+	os.Exit(code)
+}
+
+// exitError is an error that ends the process with a specific exit code
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// invalidInputf returns an error that exits with exitInvalidInput
+func invalidInputf(format string, v ...interface{}) error {
+	return &exitError{code: exitInvalidInput, err: fmt.Errorf(format, v...)}
+}
+
+// exitCodeOf returns the exit code of a run that failed with err
+func exitCodeOf(err error) int {
+	var e *exitError
+	switch {
+	case errors.As(err, &e):
+		return e.code
+	case errors.Is(err, rename.ErrNoMatch):
+		return exitUnmatchedTables
+	case errors.Is(err, context.Canceled), errors.Is(err, errReviewAborted):
+		return exitInterrupted
+	default:
+		return exitInternalError
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/peterli110/pcr-hash-table-rename/pkg/rename"
+)
+
+// kinds of --extraOutput
+const (
+	extraOutputDB      = "db"
+	extraOutputSQL     = "sql"
+	extraOutputMapping = "mapping"
+)
+
+// extraOutput is an artifact of --extraOutput, written from the new database once the run is
+// complete so the matching and reading are only done once
+type extraOutput struct {
+	Kind, Path string
+	// Filter is the filter file of a db output, with the syntax of --filter
+	Filter string
+}
+
+// extraOutputs are the artifacts of --extraOutput of the current run
+var extraOutputs []extraOutput
+
+// parseExtraOutput parses a --extraOutput value, kind=<db|sql|mapping>,path=<path>[,filter=<file>]
+func parseExtraOutput(value string) (extraOutput, error) {
+	var output extraOutput
+	for _, field := range strings.Split(value, ",") {
+		key, v, ok := strings.Cut(field, "=")
+		if !ok {
+			return output, invalidInputf("invalid --extraOutput %s, expected kind=<kind>,path=<path>", value)
+		}
+		switch key {
+		case "kind":
+			output.Kind = v
+		case "path":
+			output.Path = v
+		case "filter":
+			output.Filter = v
+		default:
+			return output, invalidInputf("invalid --extraOutput %s: unknown key %s", value, key)
+		}
+	}
+	switch {
+	case output.Kind != extraOutputDB && output.Kind != extraOutputSQL && output.Kind != extraOutputMapping:
+		return output, invalidInputf("invalid --extraOutput %s: kind must be one of %s, %s, %s", value, extraOutputDB, extraOutputSQL, extraOutputMapping)
+	case output.Path == "":
+		return output, invalidInputf("invalid --extraOutput %s: missing path", value)
+	case isURL(output.Path) || isObjectURL(output.Path):
+		return output, invalidInputf("invalid --extraOutput %s: the path must be local", value)
+	case output.Filter != "" && output.Kind != extraOutputDB:
+		return output, invalidInputf("invalid --extraOutput %s: only db outputs take a filter", value)
+	}
+	if batchPrefix != "" {
+		output.Path = batchPrefix + filepath.Base(output.Path)
+	}
+	return output, nil
+}
+
+// readExtraOutputs parses the values of --extraOutput
+func readExtraOutputs(values []string) ([]extraOutput, error) {
+	outputs := make([]extraOutput, 0, len(values))
+	for _, value := range values {
+		output, err := parseExtraOutput(value)
+		if err != nil {
+			return nil, err
+		}
+		if output.Filter != "" {
+			if _, err = readFilterFile(output.Filter); err != nil {
+				return nil, err
+			}
+		}
+		outputs = append(outputs, output)
+	}
+	return outputs, nil
+}
+
+// writeExtraOutputs writes every output of outputs from db, the complete new database, each
+// renamed into place once written
+func writeExtraOutputs(ctx context.Context, db *sql.DB, outputs []extraOutput, tableMapping map[string]string) error {
+	for _, output := range outputs {
+		tmp, err := createTempOutput(output.Path)
+		if err != nil {
+			return err
+		}
+		switch output.Kind {
+		case extraOutputDB:
+			err = writeExtraDB(ctx, db, tmp, output.Filter)
+		case extraOutputSQL:
+			err = writeSQLDump(ctx, db, tmp)
+		case extraOutputMapping:
+			err = writeTableMapping(tmp, tableMapping)
+		}
+		if err == nil {
+			err = commitOutput(tmp, output.Path)
+		}
+		if err != nil {
+			removeDB(tmp)
+			return fmt.Errorf("--extraOutput %s: %w", output.Path, err)
+		}
+		slog.Info("wrote extra output", "kind", output.Kind, "path", output.Path)
+	}
+	return nil
+}
+
+// writeExtraDB copies db into the empty file at path, keeping only the tables, rows and columns
+// of the filter file if it is set
+func writeExtraDB(ctx context.Context, db *sql.DB, path, filter string) error {
+	if _, err := db.ExecContext(ctx, "VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("error copying new database: %w", err)
+	}
+	if filter == "" {
+		return nil
+	}
+
+	tables, err := readFilterFile(filter)
+	if err != nil {
+		return err
+	}
+	rowFilters, columns, err := readTableFilters(filter)
+	if err != nil {
+		return err
+	}
+	extraDB, err := openDB(path, opts.GeneratedKey)
+	if err != nil {
+		return err
+	}
+	defer extraDB.Close()
+
+	// full-text search tables are dropped with their shadow tables, before the tables they index
+	var names []string
+	rows, err := extraDB.QueryContext(ctx, "SELECT name FROM pragma_table_list WHERE schema = 'main' AND type IN ('table', 'virtual') ORDER BY type = 'table', name")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	hasMappingTable := slices.Contains(names, rename.MappingTable)
+	for _, table := range names {
+		if _, ok := tables[table]; ok || table == rename.MappingTable || table == metaTable || strings.HasPrefix(table, "sqlite_") {
+			continue
+		}
+		if _, err = extraDB.ExecContext(ctx, fmt.Sprintf("DROP TABLE %s", quoteIdentifier(table))); err != nil {
+			return fmt.Errorf("error dropping table %s: %w", table, err)
+		}
+		if hasMappingTable {
+			if _, err = extraDB.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE original_name = ?", rename.MappingTable), table); err != nil {
+				return err
+			}
+		}
+	}
+	for _, table := range sortedKeys(rowFilters) {
+		if _, err = rename.FilterRows(ctx, extraDB, table, rowFilters[table]); err != nil {
+			return err
+		}
+	}
+	for _, table := range sortedKeys(columns) {
+		if err = rename.KeepColumns(ctx, extraDB, table, columns[table]); err != nil {
+			return err
+		}
+	}
+	// the dropped tables leave free pages
+	_, err = extraDB.ExecContext(ctx, "VACUUM")
+	return err
+}
+
+// writeSQLDump writes the SQL statements recreating db to the file at path, like the .dump
+// command of the sqlite3 shell: tables with their rows, then virtual tables, indexes, views
+// and triggers. The shadow tables of virtual tables are left out.
+func writeSQLDump(ctx context.Context, db *sql.DB, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	if err = dumpDB(ctx, db, w); err != nil {
+		return err
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func dumpDB(ctx context.Context, db *sql.DB, w io.Writer) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	types := map[string]string{}
+	tableTypes, err := queryStatements(ctx, conn, "SELECT name, type FROM pragma_table_list WHERE schema = 'main'")
+	if err != nil {
+		return err
+	}
+	for _, t := range tableTypes {
+		types[t[0]] = t[1]
+	}
+	// sqlite_sequence and sqlite_stat1 are written by SQLite itself
+	schema, err := queryStatements(ctx, conn, `SELECT name, sql FROM sqlite_master WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%'
+		ORDER BY CASE type WHEN 'table' THEN 0 WHEN 'index' THEN 2 WHEN 'view' THEN 3 ELSE 4 END, sql LIKE 'CREATE VIRTUAL%', rowid`)
+	if err != nil {
+		return err
+	}
+
+	if _, err = fmt.Fprint(w, "PRAGMA foreign_keys=OFF;\nBEGIN TRANSACTION;\n"); err != nil {
+		return err
+	}
+	for _, s := range schema {
+		name, statement := s[0], s[1]
+		if types[name] == "shadow" {
+			continue
+		}
+		if _, err = fmt.Fprintf(w, "%s;\n", statement); err != nil {
+			return err
+		}
+		switch types[name] {
+		case "table":
+			err = dumpRows(ctx, conn, name, w)
+		case "virtual":
+			// the full-text search tables of --fts index the rows of another table
+			if strings.Contains(strings.ToLower(statement), "content=") {
+				_, err = fmt.Fprintf(w, "INSERT INTO %[1]s(%[1]s) VALUES('rebuild');\n", quoteIdentifier(name))
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprint(w, "COMMIT;\n")
+	return err
+}
+
+// dumpRows writes an INSERT statement per row of table, with the values quoted by SQLite
+func dumpRows(ctx context.Context, conn *sql.Conn, table string, w io.Writer) error {
+	rows, err := conn.QueryContext(ctx, "SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	var values []string
+	for rows.Next() {
+		var column string
+		if err = rows.Scan(&column); err != nil {
+			rows.Close()
+			return err
+		}
+		values = append(values, "quote("+quoteIdentifier(column)+")")
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	prefix := "INSERT INTO " + quoteIdentifier(table) + " VALUES("
+	rows, err = conn.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s", strings.Join(values, " || ',' || "), quoteIdentifier(table)))
+	if err != nil {
+		return fmt.Errorf("error reading table %s: %w", table, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var row string
+		if err = rows.Scan(&row); err != nil {
+			return err
+		}
+		if _, err = fmt.Fprintf(w, "%s%s);\n", prefix, row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
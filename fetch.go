@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// last truth version known to this tool, used as the starting point of --fetchLatest
+const knownTruthVersion = 10059000
+
+// truth versions are increased by this step, --fetchLatest gives up
+// after this many consecutive versions without a manifest
+const (
+	truthVersionStep      = 10
+	truthVersionMaxMisses = 20
+)
+
+const masterDataPrefix = "a/masterdata_master"
+
+// downloadHashedDB downloads the master database selected by --truthVersion
+// and --fetchLatest into the temporary directory and returns its path
+func downloadHashedDB(ctx context.Context) (string, error) {
+	version := opts.TruthVersion
+	if opts.FetchLatest {
+		start := knownTruthVersion
+		if opts.TruthVersion != "" {
+			v, err := strconv.Atoi(opts.TruthVersion)
+			if err != nil {
+				return "", invalidInputf("invalid truth version %s", opts.TruthVersion)
+			}
+			start = v
+		}
+		var err error
+		if version, err = findLatestTruthVersion(ctx, start); err != nil {
+			return "", err
+		}
+	}
+
+	summary.TruthVersion = version
+	return fetchHashedDB(ctx, version)
+}
+
+// fetchHashedDB downloads the master database of the given truth version
+// into the temporary directory and returns its path
+func fetchHashedDB(ctx context.Context, truthVersion string) (string, error) {
+	manifest, ok, err := httpGet(ctx, manifestURL(truthVersion))
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", invalidInputf("no manifest found for truth version %s", truthVersion)
+	}
+
+	hash := ""
+	scanner := bufio.NewScanner(strings.NewReader(string(manifest)))
+	for scanner.Scan() {
+		// each line is path,md5,category,size,...
+		fields := strings.Split(scanner.Text(), ",")
+		if len(fields) >= 2 && strings.HasPrefix(fields[0], masterDataPrefix) {
+			slog.Info("found master database in manifest", "file", fields[0], "truthVersion", truthVersion)
+			hash = fields[1]
+			break
+		}
+	}
+	if len(hash) < 2 {
+		return "", fmt.Errorf("no master database in manifest of truth version %s", truthVersion)
+	}
+
+	path, _, err := downloadURL(ctx, poolURL(hash))
+	return path, err
+}
+
+// findLatestTruthVersion probes the CDN for manifests of newer truth versions,
+// starting from the given one
+func findLatestTruthVersion(ctx context.Context, start int) (string, error) {
+	latest := 0
+	_, ok, err := httpGet(ctx, manifestURL(strconv.Itoa(start)))
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		latest = start
+	}
+
+	misses := 0
+	for v := start + truthVersionStep; misses < truthVersionMaxMisses; v += truthVersionStep {
+		_, ok, err := httpGet(ctx, manifestURL(strconv.Itoa(v)))
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			latest = v
+			misses = 0
+		} else {
+			misses++
+		}
+	}
+
+	if latest == 0 {
+		return "", invalidInputf("no truth version found from %d, use --truthVersion to start from a newer version", start)
+	}
+	slog.Info("found latest truth version", "truthVersion", latest)
+	return strconv.Itoa(latest), nil
+}
+
+func manifestURL(truthVersion string) string {
+	return fmt.Sprintf("%s/dl/Resources/%s/%s/AssetBundles/Windows/manifest/masterdata_assetmanifest", opts.CDNHost, truthVersion, opts.Locale)
+}
+
+func poolURL(hash string) string {
+	return fmt.Sprintf("%s/dl/pool/AssetBundles/%s/%s", opts.CDNHost, hash[:2], hash)
+}
+
+// httpGet returns the body of url, ok is false if the server responded with a non 200 status
+func httpGet(ctx context.Context, url string) ([]byte, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, false, invalidInputf("invalid url %s: %w", url, err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, false, fmt.Errorf("error requesting %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, fmt.Errorf("error reading %s: %w", url, err)
+	}
+	return body, true, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// fromHashed is the value of --pageSize and --encoding copying the format of the hashed database
+const fromHashed = "hashed"
+
+// encodings are the text encodings of SQLite, by lower case name
+var encodings = map[string]string{
+	"utf-8":    "UTF-8",
+	"utf-16le": "UTF-16le",
+	"utf-16be": "UTF-16be",
+}
+
+// checkFormat returns an invalid input error if --pageSize or --encoding is invalid
+func checkFormat() error {
+	if opts.PageSizeFlag != fromHashed {
+		size, err := strconv.Atoi(opts.PageSizeFlag)
+		if err != nil || size < 512 || size > 65536 || size&(size-1) != 0 {
+			return invalidInputf("invalid --pageSize %s, expected a power of two between 512 and 65536 or %s", opts.PageSizeFlag, fromHashed)
+		}
+	}
+	if _, ok := encodings[strings.ToLower(opts.EncodingFlag)]; !ok && opts.EncodingFlag != fromHashed {
+		return invalidInputf("invalid --encoding %s, expected UTF-8, UTF-16le, UTF-16be or %s", opts.EncodingFlag, fromHashed)
+	}
+	return nil
+}
+
+// outputFormat returns the page size and text encoding of the new database, those of hashedDB
+// for --pageSize=hashed and --encoding=hashed
+func outputFormat(ctx context.Context, hashedDB *sql.DB) (pageSize int, encoding string, err error) {
+	if opts.PageSizeFlag == fromHashed {
+		if err = hashedDB.QueryRowContext(ctx, "PRAGMA page_size").Scan(&pageSize); err != nil {
+			return 0, "", err
+		}
+	} else {
+		pageSize, _ = strconv.Atoi(opts.PageSizeFlag)
+	}
+	if opts.EncodingFlag == fromHashed {
+		if err = hashedDB.QueryRowContext(ctx, "PRAGMA encoding").Scan(&encoding); err != nil {
+			return 0, "", err
+		}
+	} else {
+		encoding = encodings[strings.ToLower(opts.EncodingFlag)]
+	}
+	return pageSize, encoding, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/peterli110/pcr-hash-table-rename/pkg/rename"
+)
+
+// ftsSuffix names the FTS5 table of a table with --fts
+const ftsSuffix = "_fts"
+
+// checkFTS returns an invalid input error if --fts is set and the SQLite of --driver is built
+// without FTS5, probed on an in-memory database so the run fails before any work is done
+func checkFTS() error {
+	if len(opts.FTS) == 0 {
+		return nil
+	}
+	if err := checkDriver(); err != nil {
+		return err
+	}
+	db, err := sql.Open(opts.Driver, ":memory:")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err = db.Exec("CREATE VIRTUAL TABLE fts_probe USING fts5(text)"); err != nil {
+		return invalidInputf("--fts needs SQLite with FTS5, build with -tags sqlite_fts5 or use --driver sqlite: %w", err)
+	}
+	return nil
+}
+
+// createFTSTables creates an FTS5 table over the text columns of every table of --fts in db,
+// indexing their rows without a copy of the text, and returns the number of tables created
+func createFTSTables(ctx context.Context, db *sql.DB, tables []string) (int, error) {
+	created := 0
+	for _, table := range tables {
+		columns, err := rename.GetColumnNames(ctx, db, table)
+		if err != nil {
+			return created, err
+		}
+		if len(columns) == 0 {
+			slog.Warn("not creating full-text search table of a table missing from the new database", "table", table)
+			addWarning("no full-text search table for " + table + ", which is missing from the new database")
+			continue
+		}
+		types, err := rename.GetColumnTypes(ctx, db, table)
+		if err != nil {
+			return created, err
+		}
+		var textColumns []string
+		for i, column := range columns {
+			if strings.Contains(types[i], "TEXT") || strings.Contains(types[i], "CHAR") || strings.Contains(types[i], "CLOB") {
+				textColumns = append(textColumns, quoteIdentifier(column))
+			}
+		}
+		if len(textColumns) == 0 {
+			return created, invalidInputf("--fts table %s has no text column", table)
+		}
+
+		fts := quoteIdentifier(table + ftsSuffix)
+		// an external content table only indexes the rows of table by rowid
+		createStmt := fmt.Sprintf("CREATE VIRTUAL TABLE %s USING fts5(%s, content=%s, content_rowid='rowid')",
+			fts, strings.Join(textColumns, ", "), quoteIdentifier(table))
+		if _, err = db.ExecContext(ctx, createStmt); err != nil {
+			return created, fmt.Errorf("error creating full-text search table of %s: %w", table, err)
+		}
+		if _, err = db.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s(%s) VALUES ('rebuild')", fts, fts)); err != nil {
+			return created, fmt.Errorf("error indexing %s: %w", table, err)
+		}
+		slog.Debug("created full-text search table", "table", table, "fts", table+ftsSuffix, "columns", len(textColumns))
+		created++
+	}
+	return created, nil
+}
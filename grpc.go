@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/peterli110/pcr-hash-table-rename/pkg/rename"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// grpcServicePath is the path prefix of the methods of the RenameService of proto/rename/v1/rename.proto
+const grpcServicePath = "/pcr.rename.v1.RenameService/"
+
+// time between two checks of the output and status of a job by StreamProgress
+const progressPollInterval = 500 * time.Millisecond
+
+// gRPC status codes
+const (
+	grpcOK                 = 0
+	grpcCanceled           = 1
+	grpcInvalidArgument    = 3
+	grpcNotFound           = 5
+	grpcResourceExhausted  = 8
+	grpcFailedPrecondition = 9
+	grpcUnimplemented      = 12
+	grpcInternal           = 13
+)
+
+// grpcError is an error ending a gRPC call with a status code
+type grpcError struct {
+	code int
+	msg  string
+}
+
+func (e *grpcError) Error() string {
+	return e.msg
+}
+
+func grpcErrorf(code int, format string, v ...interface{}) error {
+	return &grpcError{code: code, msg: fmt.Sprintf(format, v...)}
+}
+
+// handler serves the REST API, and the gRPC API on HTTP/2 requests, also without TLS (h2c)
+func (s *jobServer) handler() http.Handler {
+	mux := s.routes()
+	return h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			s.serveGRPC(w, r)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}), &http2.Server{})
+}
+
+// serveGRPC runs a gRPC call and ends it with its status in the trailers
+func (s *jobServer) serveGRPC(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/grpc+proto")
+	code, msg := grpcOK, ""
+	if err := s.callGRPC(w, r); err != nil {
+		code, msg = grpcInternal, err.Error()
+		var grpcErr *grpcError
+		if errors.As(err, &grpcErr) {
+			code = grpcErr.code
+		}
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", grpcPercentEncode(msg))
+	}
+}
+
+func (s *jobServer) callGRPC(w http.ResponseWriter, r *http.Request) error {
+	method, ok := strings.CutPrefix(r.URL.Path, grpcServicePath)
+	if !ok {
+		return grpcErrorf(grpcUnimplemented, "unknown service of %s", r.URL.Path)
+	}
+	// like the uploads of POST /jobs, SubmitJob carries both databases
+	msg, err := readGRPCMessage(http.MaxBytesReader(w, r.Body, s.maxUpload), s.maxUpload)
+	if err != nil {
+		return err
+	}
+
+	switch method {
+	case "SubmitJob":
+		var req submitJobRequest
+		if err = req.unmarshal(msg); err != nil {
+			return grpcErrorf(grpcInvalidArgument, "%v", err)
+		}
+		return s.grpcSubmitJob(w, req)
+	case "GetStatus", "StreamProgress", "FetchMapping":
+		var req jobRequest
+		if err = req.unmarshal(msg); err != nil {
+			return grpcErrorf(grpcInvalidArgument, "%v", err)
+		}
+		j, release, ok := s.acquire(req.id)
+		if !ok {
+			return grpcErrorf(grpcNotFound, "no such job")
+		}
+		defer release()
+		switch method {
+		case "GetStatus":
+			return writeGRPCMessage(w, marshalJob(j))
+		case "StreamProgress":
+			return s.grpcStreamProgress(w, r, j.ID)
+		default:
+			return grpcFetchMapping(w, j)
+		}
+	default:
+		return grpcErrorf(grpcUnimplemented, "unknown method %s", method)
+	}
+}
+
+func (s *jobServer) grpcSubmitJob(w http.ResponseWriter, req submitJobRequest) error {
+	if (len(req.hashed) > 0) == (req.truthVersion != "") {
+		return grpcErrorf(grpcInvalidArgument, "exactly one of hashed or truth_version is required")
+	}
+	j, err := s.submit(req.truthVersion, func(field, path string) error {
+		data := req.original
+		if field == "hashed" {
+			data = req.hashed
+		}
+		if len(data) == 0 {
+			return errors.New("empty database")
+		}
+		return os.WriteFile(path, data, 0644)
+	})
+	var uploadErr *uploadError
+	if errors.As(err, &uploadErr) {
+		return grpcErrorf(grpcInvalidArgument, "%v", err)
+	}
+	if err != nil {
+		return err
+	}
+	return writeGRPCMessage(w, marshalJob(j))
+}
+
+// grpcStreamProgress sends the job with the new lines of its output whenever either changes,
+// until the job is finished or the client cancels the call
+func (s *jobServer) grpcStreamProgress(w http.ResponseWriter, r *http.Request, id string) error {
+	// the stream lasts as long as the job, past the write timeout of the server
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	var output *bufio.Reader
+	var partial string
+	var previous job
+	ticker := time.NewTicker(progressPollInterval)
+	defer ticker.Stop()
+	for first := true; ; first = false {
+		// the job is read before its output, so its output is complete once it is finished
+		j, _ := s.get(id)
+		if output == nil {
+			// the output is created once the job starts
+			if f, err := os.Open(filepath.Join(j.dir, jobLogFile)); err == nil {
+				defer f.Close()
+				output = bufio.NewReader(f)
+			}
+		}
+		var lines []string
+		for output != nil {
+			line, err := output.ReadString('\n')
+			if err != nil {
+				// the rest of the line is not written yet
+				partial += line
+				break
+			}
+			lines = append(lines, strings.TrimRight(partial+line, "\r\n"))
+			partial = ""
+		}
+		finished := j.Status == jobDone || j.Status == jobFailed
+		if finished && partial != "" {
+			lines, partial = append(lines, partial), ""
+		}
+		if first || len(lines) > 0 || j.Status != previous.Status {
+			if err := writeGRPCMessage(w, marshalProgress(j, lines)); err != nil {
+				return err
+			}
+		}
+		if finished {
+			return nil
+		}
+		previous = j
+
+		select {
+		case <-r.Context().Done():
+			return grpcErrorf(grpcCanceled, "%v", r.Context().Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+func grpcFetchMapping(w http.ResponseWriter, j job) error {
+	if j.Status != jobDone {
+		return grpcErrorf(grpcFailedPrecondition, "job is %s", j.Status)
+	}
+	data, err := os.ReadFile(filepath.Join(j.dir, mappingFile))
+	if os.IsNotExist(err) {
+		return grpcErrorf(grpcNotFound, "%s was not generated", mappingFile)
+	}
+	if err != nil {
+		return err
+	}
+	entries, err := rename.ParseTableMapping(data)
+	if err != nil {
+		return err
+	}
+	tables := make(map[string]string, len(entries))
+	for name, entry := range entries {
+		tables[name] = entry.HashedName
+	}
+	return writeGRPCMessage(w, marshalMapping(tables))
+}
+
+// readGRPCMessage reads the request message of a call, the only one of the methods of RenameService,
+// of at most limit bytes. The message is read as it arrives rather than allocated from its announced length.
+func readGRPCMessage(r io.Reader, limit int64) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, readGRPCError(err)
+	}
+	if prefix[0] != 0 {
+		return nil, grpcErrorf(grpcUnimplemented, "compressed messages are not supported")
+	}
+	length := int64(binary.BigEndian.Uint32(prefix[1:]))
+	if length > limit {
+		return nil, grpcErrorf(grpcResourceExhausted, "request of %d bytes is larger than the maximum of %d", length, limit)
+	}
+	var msg bytes.Buffer
+	n, err := msg.ReadFrom(io.LimitReader(r, length))
+	if err != nil {
+		return nil, readGRPCError(err)
+	}
+	if n < length {
+		return nil, grpcErrorf(grpcInvalidArgument, "error reading request: %v", io.ErrUnexpectedEOF)
+	}
+	return msg.Bytes(), nil
+}
+
+// readGRPCError returns the status of an error reading a request
+func readGRPCError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return grpcErrorf(grpcResourceExhausted, "request larger than the maximum of %d bytes", tooLarge.Limit)
+	}
+	return grpcErrorf(grpcInvalidArgument, "error reading request: %v", err)
+}
+
+// writeGRPCMessage sends a response message, uncompressed, right away
+func writeGRPCMessage(w http.ResponseWriter, msg []byte) error {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	if _, err := w.Write(append(frame, msg...)); err != nil {
+		return err
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// grpcPercentEncode encodes the grpc-message trailer, which only holds printable ASCII
+func grpcPercentEncode(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		if c := msg[i]; c >= ' ' && c <= '~' && c != '%' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"log/slog"
+	"os"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/peterli110/pcr-hash-table-rename/pkg/rename"
+)
+
+// hashedPrefix starts every hashed table name, set by --region
+var hashedPrefix = rename.DefaultHashedPrefix
+
+const (
+	// hashedColumnPrefix starts every hashed column name
+	hashedColumnPrefix = "c_"
+	// hashedNameDigits and hashedColumnDigits are the number of hex digits after the prefixes
+	hashedNameDigits   = 40
+	hashedColumnDigits = 12
+)
+
+var hashAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// hashScheme is a construction of the hashed table names from the readable ones
+type hashScheme struct {
+	Algorithm string `json:"algorithm"`
+	// Template is hashed after replacing {name} with the table name, {NAME} with
+	// the upper-case table name and {salt} with Salt
+	Template string `json:"template"`
+	Salt     string `json:"salt,omitempty"`
+	// Encoding of the hashed string, utf8 or utf16le
+	Encoding string `json:"encoding"`
+	// Truncate keeps the prefix or the suffix of the hex digest
+	Truncate string `json:"truncate"`
+}
+
+// hashedName returns prefix and the hex digest of name truncated to digits,
+// or "" if the digest is shorter
+func (s hashScheme) hashedName(prefix, name string, digits int) string {
+	input := strings.NewReplacer("{name}", name, "{NAME}", strings.ToUpper(name), "{salt}", s.Salt).Replace(s.Template)
+	h := hashAlgorithms[s.Algorithm]()
+	if s.Encoding == "utf16le" {
+		for _, u := range utf16.Encode([]rune(input)) {
+			h.Write(binary.LittleEndian.AppendUint16(nil, u))
+		}
+	} else {
+		h.Write([]byte(input))
+	}
+
+	digest := hex.EncodeToString(h.Sum(nil))
+	if digits > len(digest) {
+		return ""
+	}
+	if s.Truncate == "suffix" {
+		return prefix + digest[len(digest)-digits:]
+	}
+	return prefix + digest[:digits]
+}
+
+// flagHashScheme returns the hashScheme of --hashScheme, --salt and --hashTemplate
+func flagHashScheme() (hashScheme, error) {
+	if _, ok := hashAlgorithms[opts.HashScheme]; !ok {
+		return hashScheme{}, invalidInputf("unknown hash scheme %s, available schemes are %s", opts.HashScheme, strings.Join(sortedKeys(hashAlgorithms), ", "))
+	}
+	template := opts.HashTemplate
+	if template == "" {
+		template = "{name}"
+		if opts.Salt != "" {
+			template = "{salt}{name}"
+		}
+	}
+	return hashScheme{Algorithm: opts.HashScheme, Template: template, Salt: opts.Salt, Encoding: "utf8", Truncate: "prefix"}, nil
+}
+
+// computeMapping computes the hashed name of every table of --filter with the hash scheme
+// and checks the hashed database has it, writing the table mapping and the run summary.
+// Without the original database no new database can be generated, its schema is unknown.
+func computeMapping(ctx context.Context) (int, error) {
+	scheme, err := flagHashScheme()
+	if err != nil {
+		return 0, err
+	}
+	tables, err := readFilterFile(opts.Filter)
+	if err != nil {
+		return 0, err
+	}
+
+	if opts.HashedDBPath == "" {
+		path, err := downloadHashedDB(ctx)
+		if err != nil {
+			return 0, err
+		}
+		opts.HashedDBPath = path
+		defer os.Remove(opts.HashedDBPath)
+	}
+	hashedPath, hashedParams := splitDSN(opts.HashedDBPath)
+	hashed, hashedInfo, cleanupHashed, err := prepareInput(ctx, hashedPath, opts.HashedDBChecksum, opts.HashedKey != "")
+	if err != nil {
+		return 0, fmt.Errorf("hashed database: %w", err)
+	}
+	defer cleanupHashed()
+	summary.HashedDB = hashedInfo
+	summary.GeneratedDB = ""
+
+	hashedDB, err := openDB(joinDSN(hashed, hashedParams), opts.HashedKey, mmapPragmas(joinDSN(hashed, hashedParams))...)
+	if err != nil {
+		return 0, err
+	}
+	defer hashedDB.Close()
+	if err = checkInputDB(ctx, hashedDB, hashed); err != nil {
+		return 0, fmt.Errorf("hashed database: %w", err)
+	}
+	hashedTables, err := rename.GetTableNames(ctx, hashedDB, false)
+	if err != nil {
+		return 0, err
+	}
+	exists := map[string]struct{}{}
+	for _, t := range hashedTables {
+		exists[t] = struct{}{}
+	}
+
+	tableMapping := map[string]string{}
+	for _, t := range sortedKeys(tables) {
+		hashedName := scheme.hashedName(hashedPrefix, t, hashedNameDigits)
+		if _, ok := exists[hashedName]; !ok {
+			slog.Warn("no matching table", "table", t, "hashedTable", hashedName)
+			addWarning("no matching table for " + t)
+			summary.Unmatched = append(summary.Unmatched, t)
+			continue
+		}
+		slog.Debug("computed table", "table", t, "hashedTable", hashedName)
+		tableMapping[t] = hashedName
+		summary.Tables = append(summary.Tables, tableSummary{Name: t, HashedName: hashedName, Confidence: 1})
+	}
+
+	if err = writeJson(tableMapping); err != nil {
+		return 0, err
+	}
+	slog.Info("done", "mapping", mappingPath, "tables", len(tableMapping))
+	if len(summary.Unmatched) > 0 {
+		return exitUnmatchedTables, nil
+	}
+	return exitSuccess, nil
+}
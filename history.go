@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// historyEntry is the mapping of one run, stored as <history dir>/<region>/<truth version>.json
+type historyEntry struct {
+	TruthVersion string            `json:"truthVersion"`
+	Region       string            `json:"region"`
+	RecordedAt   time.Time         `json:"recordedAt"`
+	HashedSHA256 string            `json:"hashedSHA256"`
+	Tables       map[string]string `json:"tables"`
+}
+
+// historyDir returns the directory of the mapping history, --historyDir or
+// pcr-hash-table-rename/history in the user config directory
+func historyDir() (string, error) {
+	if opts.HistoryDir != "" {
+		return opts.HistoryDir, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pcr-hash-table-rename", "history"), nil
+}
+
+// recordHistory stores the table mapping of the run in the mapping history, replacing
+// a previous run of the same truth version. Runs without a known truth version are not stored.
+func recordHistory(tableMapping map[string]string) error {
+	if opts.NoHistory {
+		return nil
+	}
+	if summary.TruthVersion == "" {
+		slog.Debug("not recording the mapping history, the truth version is unknown, set it with --truthVersion or --gameVersion")
+		return nil
+	}
+	path, err := writeHistory(historyEntry{
+		TruthVersion: summary.TruthVersion,
+		Region:       opts.Region,
+		RecordedAt:   time.Now().UTC(),
+		HashedSHA256: summary.HashedDB.SHA256,
+		Tables:       tableMapping,
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("recorded mapping history", "path", path)
+	return nil
+}
+
+// writeHistory stores entry in the mapping history and returns its path
+func writeHistory(entry historyEntry) (string, error) {
+	dir, err := historyDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, entry.Region)
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	jsonData, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, entry.TruthVersion+".json")
+	return path, os.WriteFile(path, jsonData, 0644)
+}
+
+// readHistory returns the mapping history of the region, oldest truth version first
+func readHistory(region string) ([]historyEntry, error) {
+	dir, err := historyDir()
+	if err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, region, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []historyEntry
+	for _, path := range paths {
+		jsonData, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var entry historyEntry
+		if err = json.Unmarshal(jsonData, &entry); err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", path, err)
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		vi, erri := strconv.Atoi(entries[i].TruthVersion)
+		vj, errj := strconv.Atoi(entries[j].TruthVersion)
+		if erri != nil || errj != nil {
+			return entries[i].TruthVersion < entries[j].TruthVersion
+		}
+		return vi < vj
+	})
+	return entries, nil
+}
+
+func newMappingCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "mapping",
+		Short: "Manage the table mappings of past runs",
+	}
+	cmd.AddCommand(newMappingHistoryCmd())
+	cmd.AddCommand(newMappingFetchCmd())
+	cmd.AddCommand(newMappingPublishCmd())
+	cmd.AddCommand(newMappingKeygenCmd())
+	cmd.AddCommand(newMappingValidateCmd())
+	cmd.AddCommand(newMappingSchemaCmd())
+	return cmd
+}
+
+func newMappingHistoryCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "history [table]",
+		Short: "Print when the hashed names changed across truth versions",
+		Long: `Print the hashed name of a table in every truth version of the mapping history and when it
+last changed, or without a table, the number of hashed names that changed in each truth version.
+Every run with a known truth version (--truthVersion, --fetchLatest or --gameVersion) is recorded.`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			entries, err := readHistory(opts.Region)
+			if err != nil {
+				fatalf(exitInternalError, "Error reading mapping history: %v", err)
+			}
+			if len(entries) == 0 {
+				fatalf(exitInvalidInput, "No mapping history for region %s", opts.Region)
+			}
+			if len(args) == 1 {
+				printTableHistory(entries, args[0])
+				return
+			}
+			printHistory(entries)
+		},
+	}
+	cmd.Flags().StringVar(&opts.Region, "region", "jp", "OPTIONAL: Region of the mapping history")
+	cmd.Flags().StringVar(&opts.HistoryDir, "historyDir", "", "OPTIONAL: Directory of the mapping history, default to pcr-hash-table-rename/history in the user config directory")
+
+	return cmd
+}
+
+func printTableHistory(entries []historyEntry, table string) {
+	previous, lastChange := "", ""
+	for _, e := range entries {
+		hashedName, ok := e.Tables[table]
+		if !ok {
+			fmt.Printf("%s  (no match)\n", e.TruthVersion)
+			continue
+		}
+		changed := ""
+		if previous != "" && hashedName != previous {
+			changed = "  changed"
+			lastChange = e.TruthVersion
+		}
+		fmt.Printf("%s  %s%s\n", e.TruthVersion, hashedName, changed)
+		previous = hashedName
+	}
+	if previous == "" {
+		fmt.Printf("%s has no match in the mapping history\n", table)
+	} else if lastChange == "" {
+		fmt.Printf("%s has not changed since %s\n", table, entries[0].TruthVersion)
+	} else {
+		fmt.Printf("%s last changed in %s\n", table, lastChange)
+	}
+}
+
+func printHistory(entries []historyEntry) {
+	for i, e := range entries {
+		if i == 0 {
+			fmt.Printf("%s  %d tables\n", e.TruthVersion, len(e.Tables))
+			continue
+		}
+		changed := 0
+		for table, hashedName := range e.Tables {
+			if previous, ok := entries[i-1].Tables[table]; ok && previous != hashedName {
+				changed++
+			}
+		}
+		fmt.Printf("%s  %d tables, %d changed\n", e.TruthVersion, len(e.Tables), changed)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// catalog translates the English messages of the tool into a language
+type catalog struct {
+	// Messages are the translations of log messages and usage headings, by English message
+	Messages map[string]string `json:"messages"`
+	// Commands are the translations of the short descriptions of the commands, by command
+	// path without the name of the tool, empty for the tool itself
+	Commands map[string]string `json:"commands"`
+	// Long are the translations of the long descriptions of the commands, by command path
+	Long map[string]string `json:"long"`
+	// Flags are the translations of the flag usages, by --flag for the flags of the tool and
+	// by command path and --flag for those of its subcommands, e.g. "stats --driver"
+	Flags map[string]string `json:"flags"`
+}
+
+//go:embed locales/*.json
+var catalogFiles embed.FS
+
+// langs are the languages of --lang, English is built in
+var langs = []string{"en", "ja", "zh"}
+
+// lang is the language of the messages, set by --lang
+var lang = "en"
+
+// catalogs are the catalogs of the languages other than English, read once at startup
+// since the loggers of every goroutine translate with them
+var catalogs = readCatalogs()
+
+// readCatalogs reads the embedded catalogs of the languages other than English
+func readCatalogs() map[string]*catalog {
+	catalogs := make(map[string]*catalog, len(langs)-1)
+	for _, l := range langs {
+		if l == "en" {
+			continue
+		}
+		c := &catalog{}
+		data, err := catalogFiles.ReadFile("locales/" + l + ".json")
+		if err == nil {
+			err = json.Unmarshal(data, c)
+		}
+		if err != nil {
+			// the catalogs are embedded, only a broken build gets here
+			panic(fmt.Sprintf("invalid message catalog %s: %v", l, err))
+		}
+		catalogs[l] = c
+	}
+	return catalogs
+}
+
+// currentCatalog returns the catalog of lang, nil for English
+func currentCatalog() *catalog {
+	return catalogs[lang]
+}
+
+// tr returns the translation of msg into lang, or msg itself if it has none
+func tr(msg string) string {
+	if c := currentCatalog(); c != nil {
+		if translated, ok := c.Messages[msg]; ok {
+			return translated
+		}
+	}
+	return msg
+}
+
+// envLang returns the language of the locale of the environment (LC_ALL, LC_MESSAGES
+// or LANG, e.g. ja_JP.UTF-8), or en if it is not one of langs
+func envLang() string {
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		locale := os.Getenv(name)
+		if locale == "" {
+			continue
+		}
+		code := strings.ToLower(strings.SplitN(strings.SplitN(locale, "_", 2)[0], ".", 2)[0])
+		if slices.Contains(langs, code) {
+			return code
+		}
+		return "en"
+	}
+	return "en"
+}
+
+// checkLang returns an error if --lang is not one of langs
+func checkLang() error {
+	if slices.Contains(langs, lang) {
+		return nil
+	}
+	return invalidInputf("unknown language %s, expected one of %s", lang, strings.Join(langs, ", "))
+}
+
+// usageHeadings are the headings of the usage template of cobra
+var usageHeadings = []string{
+	"Usage:", "Aliases:", "Examples:", "Available Commands:", "Additional Commands:",
+	"Global Flags:", "Flags:", "Additional help topics:",
+	`Use "{{.CommandPath}} [command] --help" for more information about a command.`,
+}
+
+// localizeCommand translates the usage headings, descriptions and flag usages of
+// root and its subcommands into lang, before the help is printed
+func localizeCommand(root *cobra.Command) {
+	c := currentCatalog()
+	if c == nil {
+		return
+	}
+	template := root.UsageTemplate()
+	for _, heading := range usageHeadings {
+		template = strings.ReplaceAll(template, heading, tr(heading))
+	}
+	root.SetUsageTemplate(template)
+
+	var localize func(cmd *cobra.Command)
+	localize = func(cmd *cobra.Command) {
+		path := strings.TrimPrefix(strings.TrimPrefix(cmd.CommandPath(), root.Name()), " ")
+		if short, ok := c.Commands[path]; ok {
+			cmd.Short = short
+		}
+		if long, ok := c.Long[path]; ok {
+			cmd.Long = long
+		}
+		prefix := ""
+		if path != "" {
+			prefix = path + " "
+		}
+		// the inherited flags are the flags of root
+		cmd.LocalFlags().VisitAll(func(f *pflag.Flag) {
+			if usage, ok := c.Flags[prefix+"--"+f.Name]; ok {
+				f.Usage = usage
+			}
+		})
+		for _, sub := range cmd.Commands() {
+			localize(sub)
+		}
+	}
+	localize(root)
+}
+
+// translateHandler translates the messages of the records of its Handler into lang
+type translateHandler struct {
+	slog.Handler
+}
+
+func (h translateHandler) Handle(ctx context.Context, r slog.Record) error {
+	r.Message = tr(r.Message)
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h translateHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return translateHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h translateHandler) WithGroup(name string) slog.Handler {
+	return translateHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCatalogs(t *testing.T) {
+	// every catalog translates the same messages, commands and flags
+	ja := catalogs["ja"]
+	for _, l := range langs {
+		c, ok := catalogs[l]
+		if l == "en" {
+			if ok {
+				t.Errorf("en has a catalog, English is built in")
+			}
+			continue
+		}
+		if !ok {
+			t.Fatalf("no catalog for %s", l)
+		}
+		for name, keys := range map[string][2]map[string]string{
+			"messages": {c.Messages, ja.Messages},
+			"commands": {c.Commands, ja.Commands},
+			"long":     {c.Long, ja.Long},
+			"flags":    {c.Flags, ja.Flags},
+		} {
+			got, want := sortedKeys(keys[0]), sortedKeys(keys[1])
+			if !slices.Equal(got, want) {
+				t.Errorf("%s of %s = %v, want those of ja %v", name, l, got, want)
+			}
+		}
+	}
+}
+
+func TestTr(t *testing.T) {
+	defer func(previous string) { lang = previous }(lang)
+	tests := []struct {
+		lang, msg, want string
+	}{
+		{"en", "done", "done"},
+		{"ja", "done", "完了"},
+		{"zh", "done", "完成"},
+		{"ja", "not a message", "not a message"},
+	}
+	for _, tt := range tests {
+		lang = tt.lang
+		if got := tr(tt.msg); got != tt.want {
+			t.Errorf("tr(%q) in %s = %q, want %q", tt.msg, tt.lang, got, tt.want)
+		}
+	}
+}
+
+func TestEnvLang(t *testing.T) {
+	tests := []struct {
+		lcAll, lcMessages, lang string
+		want                    string
+	}{
+		{"", "", "ja_JP.UTF-8", "ja"},
+		{"", "zh_CN.UTF-8", "ja_JP.UTF-8", "zh"},
+		{"C", "", "ja_JP.UTF-8", "en"},
+		{"", "", "fr_FR.UTF-8", "en"},
+		{"", "", "", "en"},
+	}
+	for _, tt := range tests {
+		t.Setenv("LC_ALL", tt.lcAll)
+		t.Setenv("LC_MESSAGES", tt.lcMessages)
+		t.Setenv("LANG", tt.lang)
+		if got := envLang(); got != tt.want {
+			t.Errorf("envLang() with LC_ALL=%q LC_MESSAGES=%q LANG=%q = %s, want %s", tt.lcAll, tt.lcMessages, tt.lang, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/peterli110/pcr-hash-table-rename/pkg/rename"
+)
+
+// checksumsSuffix is appended to the path of the new database for the file recording the
+// checksums of the hashed tables its tables were copied from, read by the next --incremental run
+const checksumsSuffix = ".checksums.json"
+
+// reusable are the tables of the previous new database --incremental may reuse, by table name
+var reusable map[string]rename.ReusableTable
+
+// copiedTables are the tables the run wrote into the new database, with the checksum of their hashed table
+var copiedTables []rename.ReusableTable
+
+// tableChecksums is the file of checksumsSuffix
+type tableChecksums struct {
+	Version string `json:"version"`
+	// Inputs is the digest of incrementalInputs of the run that wrote the new database
+	Inputs string                 `json:"inputs"`
+	Tables []rename.ReusableTable `json:"tables"`
+}
+
+// checkIncremental returns an invalid input error if --incremental cannot be used with the other flags
+func checkIncremental() error {
+	if !opts.Incremental {
+		return nil
+	}
+	if len(opts.OriginalDBPaths) == 0 {
+		return invalidInputf("--incremental needs --originalDBPath")
+	}
+	if isObjectURL(opts.GeneratedDBPath) || opts.Compress != "" {
+		return invalidInputf("--incremental needs an uncompressed local new database")
+	}
+	return nil
+}
+
+// incrementalInputs returns a digest of what the tables of the new database depend on besides the rows
+// of their hashed table: the tool version, the original databases and the flags changing the copied rows
+func incrementalInputs() (string, error) {
+	h := sha256.New()
+	fmt.Fprintln(h, version, summary.OriginalDB.SHA256)
+	for _, f := range summary.OlderOriginalDBs {
+		fmt.Fprintln(h, f.SHA256)
+	}
+	fmt.Fprintln(h, opts.LimitRows, opts.OrderByPK, opts.SkipIndexes, opts.PageSizeFlag, opts.EncodingFlag, opts.GeneratedKey != "")
+	if opts.Filter != "" {
+		content, err := os.ReadFile(opts.Filter)
+		if err != nil {
+			return "", invalidInputf("error opening table list: %w", err)
+		}
+		h.Write(content)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// readReusableTables returns the tables of the new database at path recorded by the previous run
+// with --incremental, or nil if there is none or it was generated from other inputs
+func readReusableTables(path, inputs string) map[string]rename.ReusableTable {
+	jsonData, err := os.ReadFile(path + checksumsSuffix)
+	if os.IsNotExist(err) {
+		slog.Info("no table checksums of a previous run, copying every table", "path", path+checksumsSuffix)
+		return nil
+	}
+	var previous tableChecksums
+	if err == nil {
+		err = json.Unmarshal(jsonData, &previous)
+	}
+	if err != nil {
+		slog.Warn("invalid table checksums of the previous run, copying every table", "path", path+checksumsSuffix, "error", err)
+		return nil
+	}
+	if previous.Inputs != inputs {
+		slog.Info("the original databases or the flags changed since the previous run, copying every table")
+		return nil
+	}
+	if _, err = os.Stat(path); err != nil {
+		slog.Info("no new database of a previous run, copying every table", "path", path)
+		return nil
+	}
+	tables := make(map[string]rename.ReusableTable, len(previous.Tables))
+	for _, t := range previous.Tables {
+		tables[t.Name] = t
+	}
+	return tables
+}
+
+// writeTableChecksums records copiedTables next to the new database at path, for the next --incremental run
+func writeTableChecksums(path, inputs string) error {
+	jsonData, err := json.MarshalIndent(tableChecksums{Version: version, Inputs: inputs, Tables: copiedTables}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path+checksumsSuffix, jsonData, 0644)
+}
+
+// dropUnreused drops every view and table of db, a copy of the previous new database, but the reused
+// tables, so the other tables are copied again and what is built from them, e.g. the mapping table,
+// the FTS tables and the curated views, is built again
+func dropUnreused(ctx context.Context, db *sql.DB, reused []string) error {
+	keep := make(map[string]struct{}, len(reused))
+	for _, t := range reused {
+		keep[t] = struct{}{}
+	}
+	steps := []struct{ kind, query string }{
+		{"VIEW", "SELECT name FROM sqlite_master WHERE type = 'view'"},
+		// virtual tables drop their shadow tables, which cannot be dropped on their own
+		{"TABLE", "SELECT name FROM sqlite_master WHERE type = 'table' AND sql LIKE 'CREATE VIRTUAL TABLE%'"},
+		{"TABLE", "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'"},
+	}
+	dropped := 0
+	for _, step := range steps {
+		names, err := queryStrings(ctx, db, step.query)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if _, ok := keep[name]; ok {
+				continue
+			}
+			if _, err = db.ExecContext(ctx, fmt.Sprintf("DROP %s IF EXISTS \"%s\"", step.kind, name)); err != nil {
+				return fmt.Errorf("error dropping %s: %w", name, err)
+			}
+			dropped++
+		}
+	}
+	slog.Info("reusing unchanged tables", "reused", len(reused), "dropped", dropped)
+	return nil
+}
+
+// queryStrings returns the first column of the rows of query
+func queryStrings(ctx context.Context, db *sql.DB, query string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var values []string
+	for rows.Next() {
+		var value string
+		if err = rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/peterli110/pcr-hash-table-rename/pkg/rename"
+)
+
+var sqliteHeader = []byte("SQLite format 3\x00")
+var gzipHeader = []byte{0x1f, 0x8b}
+
+// prepareInput returns the path of a SQLite database for the input at path, downloading it,
+// extracting it from an archive and decompressing it as needed. The input is verified against
+// checksum (a hex SHA-256) if it is set. Encrypted inputs have no SQLite header and are never
+// decompressed. The returned cleanup function removes the temporary files, it is a no-op
+// when an error is returned.
+func prepareInput(ctx context.Context, path, checksum string, encrypted bool) (string, inputFile, func(), error) {
+	local := path
+	cleanupDownloaded := func() {}
+	if isURL(path) || isObjectURL(path) {
+		url, entry := splitArchivePath(path)
+		var err error
+		if isURL(url) {
+			local, cleanupDownloaded, err = downloadURL(ctx, url)
+		} else {
+			local, cleanupDownloaded, err = downloadObject(ctx, url)
+		}
+		if err != nil {
+			return "", inputFile{}, func() {}, err
+		}
+		if entry != "" {
+			local += "#" + entry
+		}
+	}
+
+	info, err := newInputFile(local)
+	if err != nil {
+		cleanupDownloaded()
+		return "", inputFile{}, func() {}, err
+	}
+	info.Path = path
+	if checksum != "" && !strings.EqualFold(checksum, info.SHA256) {
+		cleanupDownloaded()
+		return "", inputFile{}, func() {}, invalidInputf("checksum mismatch for %s: expected %s, got %s", path, checksum, info.SHA256)
+	}
+
+	extracted, cleanupExtracted, err := extractArchive(local)
+	if err != nil {
+		cleanupDownloaded()
+		return "", inputFile{}, func() {}, err
+	}
+	if encrypted {
+		return extracted, info, func() {
+			cleanupExtracted()
+			cleanupDownloaded()
+		}, nil
+	}
+	decompressed, cleanupDecompressed, err := decompressInput(extracted)
+	if err != nil {
+		cleanupExtracted()
+		cleanupDownloaded()
+		return "", inputFile{}, func() {}, err
+	}
+	return decompressed, info, func() {
+		cleanupDecompressed()
+		cleanupExtracted()
+		cleanupDownloaded()
+	}, nil
+}
+
+// decompressInput returns the path of a SQLite database for the input at path.
+// Inputs that are not SQLite databases are decompressed (gzip is detected by its
+// magic bytes, anything else is tried as brotli, which has none) into a temporary
+// file that is deleted by the returned cleanup function.
+func decompressInput(path string) (string, func(), error) {
+	header, err := readHeader(path, len(sqliteHeader))
+	if err != nil {
+		return "", nil, err
+	}
+	if len(header) == 0 {
+		return "", nil, invalidInputf("%s is empty", path)
+	}
+	if bytes.Equal(header, sqliteHeader) {
+		return path, func() {}, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", nil, invalidInputf("error opening %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var reader io.Reader
+	format := "brotli"
+	if bytes.HasPrefix(header, gzipHeader) {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return "", nil, invalidInputf("error reading gzip header of %s: %w", path, err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+		format = "gzip"
+	} else {
+		reader = brotli.NewReader(file)
+	}
+
+	tmp, err := os.CreateTemp("", "pcr_decompressed_*.db")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.Remove(tmp.Name()) }
+
+	_, err = io.Copy(tmp, reader)
+	tmp.Close()
+	if err == nil {
+		header, err = readHeader(tmp.Name(), len(sqliteHeader))
+	}
+	if err != nil || !bytes.Equal(header, sqliteHeader) {
+		cleanup()
+		return "", nil, invalidInputf("%s is neither a SQLite database nor a compressed one", path)
+	}
+
+	slog.Info("decompressed input", "path", path, "format", format, "decompressedPath", tmp.Name())
+	return tmp.Name(), cleanup, nil
+}
+
+func readHeader(path string, n int) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, invalidInputf("error opening %s: %w", path, err)
+	}
+	defer file.Close()
+
+	header := make([]byte, n)
+	read, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, invalidInputf("error reading %s: %w", path, err)
+	}
+	return header[:read], nil
+}
+
+// checkInputDB waits for the database at path to be unlocked, and returns an invalid input
+// error if it cannot be read or has no tables
+func checkInputDB(ctx context.Context, db *sql.DB, path string) error {
+	if err := waitUnlocked(ctx, db, path); err != nil {
+		if isBusy(err) || ctx.Err() != nil {
+			return err
+		}
+		return invalidInputf("%s is not a readable SQLite database, it may be encrypted and need its key, or be corrupted: %w", path, err)
+	}
+	tables, err := rename.GetTableNames(ctx, db, false)
+	if err != nil {
+		return err
+	}
+	if len(tables) == 0 {
+		return invalidInputf("%s has no tables", path)
+	}
+	return nil
+}
+
+// areSwapped returns whether the original database has mostly hashed v1_ table names
+// and the hashed one mostly readable names, i.e. they were passed the other way around
+func areSwapped(ctx context.Context, originalDB, hashedDB *sql.DB) (bool, error) {
+	originalRatio, err := hashedTableRatio(ctx, originalDB)
+	if err != nil {
+		return false, err
+	}
+	hashedRatio, err := hashedTableRatio(ctx, hashedDB)
+	if err != nil {
+		return false, err
+	}
+	return originalRatio > 0.5 && hashedRatio < 0.5, nil
+}
+
+// hashedTableRatio returns the share of the tables of db with a hashed name
+func hashedTableRatio(ctx context.Context, db *sql.DB) (float64, error) {
+	tables, err := rename.GetTableNames(ctx, db, false)
+	if err != nil || len(tables) == 0 {
+		return 0, err
+	}
+	hashed := 0
+	for _, t := range tables {
+		if strings.HasPrefix(t, hashedPrefix) {
+			hashed++
+		}
+	}
+	return float64(hashed) / float64(len(tables)), nil
+}
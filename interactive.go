@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/peterli110/pcr-hash-table-rename/pkg/rename"
+)
+
+// promptMatches asks the user to choose the hashed table of every table matched with a
+// confidence below 1 or without a match among its candidates, reading the answers from in
+// and writing to out, for --interactive. It returns the mapping with the chosen matches
+// flagged as manual, or errReviewAborted if the input ended.
+func promptMatches(ctx context.Context, in io.Reader, out io.Writer, originalDBs []*sql.DB, hashedDB *sql.DB, mapping rename.Mapping, report rename.Report, sampleRows int, newKnown bool) (rename.Mapping, error) {
+	r, err := newReview(ctx, in, out, originalDBs, hashedDB, mapping, report, newKnown)
+	if err != nil {
+		return mapping, err
+	}
+	var hashedTables rename.Tables
+	for i := range r.entries {
+		e := &r.entries[i]
+		var candidates []string
+		switch {
+		case e.HashedName == "":
+			for _, c := range r.candidates[e.Name] {
+				candidates = append(candidates, c.Table)
+			}
+			if len(candidates) == 0 {
+				fmt.Fprintf(r.out, "%s has no match and no candidate, skipping it\n", e.Name)
+				continue
+			}
+			fmt.Fprintf(r.out, "%s has no match, its closest hashed tables are:\n", e.Name)
+		case e.Confidence < 1:
+			// the hashed tables are only read if a match is ambiguous
+			if hashedTables == nil {
+				if hashedTables, err = rename.ReadTables(ctx, hashedDB, false, sampleRows); err != nil {
+					return mapping, err
+				}
+			}
+			if candidates, err = sameFirstRows(ctx, originalDBs[e.Original], e.Name, hashedTables, sampleRows); err != nil {
+				return mapping, err
+			}
+			fmt.Fprintf(r.out, "%s was matched with %s, but %d hashed tables have the same first rows:\n", e.Name, e.HashedName, len(candidates))
+		default:
+			continue
+		}
+		if err = r.prompt(ctx, e, candidates); err != nil {
+			return mapping, err
+		}
+	}
+	return r.result(), nil
+}
+
+// sameFirstRows returns the sorted names of the hashed tables with the same first rows as the original table
+func sameFirstRows(ctx context.Context, originalDB *sql.DB, table string, hashedTables rename.Tables, sampleRows int) ([]string, error) {
+	rows, err := rename.GetFirstNRows(ctx, originalDB, table, sampleRows)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for name, hashedRows := range hashedTables {
+		if rename.CompareData(rows, hashedRows) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// prompt lists the candidates of e and previews its match or else its first candidate, then
+// reads the choice of the user until one is made: a candidate, which becomes the manual match
+// of e, s to skip the table, or an empty line to keep the match of e, if any
+func (r *review) prompt(ctx context.Context, e *reviewEntry, candidates []string) error {
+	for i, c := range candidates {
+		fmt.Fprintf(r.out, "  %d  %s\n", i+1, c)
+	}
+	if e.HashedName != "" {
+		r.preview(ctx, e, e.HashedName)
+	} else {
+		r.preview(ctx, e, candidates[0])
+	}
+	keep := "skip the table"
+	if e.HashedName != "" {
+		keep = "keep " + e.HashedName
+	}
+	for {
+		fmt.Fprintf(r.out, "number to choose, p <number> to preview, s to skip the table, Enter to %s: ", keep)
+		if !r.in.Scan() {
+			if err := r.in.Err(); err != nil {
+				return err
+			}
+			return errReviewAborted
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		fields := strings.Fields(r.in.Text())
+		switch {
+		case len(fields) == 0:
+			return nil
+		case fields[0] == "s":
+			e.HashedName, e.Confidence, e.status = "", 0, reviewRejected
+			return nil
+		case fields[0] == "p" && len(fields) == 2:
+			if i, err := strconv.Atoi(fields[1]); err == nil && i >= 1 && i <= len(candidates) {
+				r.preview(ctx, e, candidates[i-1])
+				continue
+			}
+		case len(fields) == 1:
+			if i, err := strconv.Atoi(fields[0]); err == nil && i >= 1 && i <= len(candidates) {
+				if r.override(e, candidates[i-1]) {
+					return nil
+				}
+				continue
+			}
+		}
+		fmt.Fprintf(r.out, "no candidate %s\n", strings.Join(fields, " "))
+	}
+}
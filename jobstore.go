@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// jobStateFile is the file of a job directory recording the job, to serve it again once serve restarts
+const jobStateFile = "job.json"
+
+// longest time between two checks for expired jobs
+const expireInterval = time.Minute
+
+// jobState is the content of jobStateFile
+type jobState struct {
+	job
+	Args []string `json:"args"`
+}
+
+// saveJob writes the state of j to its directory, the caller holds s.mu
+func (s *jobServer) saveJob(j *job) {
+	data, err := json.MarshalIndent(jobState{job: *j, Args: j.args}, "", "  ")
+	if err == nil {
+		// written aside and renamed, so a crash never leaves a truncated state
+		path := filepath.Join(j.dir, jobStateFile)
+		if err = os.WriteFile(path+".tmp", data, 0644); err == nil {
+			err = os.Rename(path+".tmp", path)
+		}
+	}
+	if err != nil {
+		slog.Warn("error saving job", "job", j.ID, "error", err)
+	}
+}
+
+// load adds the jobs of the previous runs of the server in its data directory. The jobs they left
+// queued or running, interrupted by a shutdown or a crash, are queued again in submission order.
+func (s *jobServer) load() error {
+	entries, err := os.ReadDir(s.dataDir)
+	if err != nil {
+		return err
+	}
+	var queue []*job
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(s.dataDir, entry.Name())
+		data, err := os.ReadFile(filepath.Join(dir, jobStateFile))
+		if os.IsNotExist(err) {
+			continue
+		}
+		var state jobState
+		if err == nil {
+			err = json.Unmarshal(data, &state)
+		}
+		if err != nil || state.ID != entry.Name() {
+			slog.Warn("skipping invalid job", "dir", dir, "error", err)
+			continue
+		}
+		j := state.job
+		j.dir, j.args = dir, state.Args
+		if j.Status == jobQueued || j.Status == jobRunning {
+			j.Status, j.ExitCode, j.Error = jobQueued, 0, ""
+			queue = append(queue, &j)
+		}
+		s.jobs[j.ID] = &j
+	}
+	sort.Slice(queue, func(i, k int) bool {
+		return queue[i].CreatedAt.Before(queue[k].CreatedAt)
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, j := range queue {
+		s.enqueue(j)
+	}
+	if len(s.jobs) > 0 {
+		slog.Info("loaded jobs", "jobs", len(s.jobs), "queued", len(queue))
+	}
+	return nil
+}
+
+// expireJobs deletes the jobs finished for longer than the TTL, and their outputs, until the server shuts down
+func (s *jobServer) expireJobs() {
+	if s.ttl <= 0 {
+		return
+	}
+	ticker := time.NewTicker(min(s.ttl, expireInterval))
+	defer ticker.Stop()
+	for {
+		s.expire(time.Now())
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// expire deletes the jobs finished for longer than the TTL at now. The jobs whose files are being
+// served are left to a later check.
+func (s *jobServer) expire(now time.Time) {
+	var expired []*job
+	s.mu.Lock()
+	for id, j := range s.jobs {
+		if j.FinishedAt != nil && now.Sub(*j.FinishedAt) > s.ttl && j.serving == 0 {
+			delete(s.jobs, id)
+			expired = append(expired, j)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, j := range expired {
+		if err := os.RemoveAll(j.dir); err != nil {
+			slog.Warn("error deleting expired job", "job", j.ID, "error", err)
+		}
+	}
+	if len(expired) > 0 {
+		slog.Info("deleted expired jobs", "jobs", len(expired))
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// errLineInterrupted is returned by lineReader.readLine when Ctrl-C is typed
+var errLineInterrupted = errors.New("interrupted")
+
+// lineReader reads the lines typed on a terminal with Tab completion, or the lines of any
+// other input as they are
+type lineReader struct {
+	in  *bufio.Reader
+	out io.Writer
+	// terminal is the terminal lines are read from in raw mode, nil for other inputs
+	terminal *os.File
+	// complete returns the words completing the start of a word
+	complete func(prefix string) []string
+}
+
+// newLineReader returns a lineReader reading from in, with completion if it is a terminal
+func newLineReader(in *os.File, out io.Writer, complete func(prefix string) []string) *lineReader {
+	r := &lineReader{in: bufio.NewReader(in), out: out, complete: complete}
+	if isTerminal(in) {
+		r.terminal = in
+	}
+	return r
+}
+
+// readLine prints prompt and returns the next line, without its line break.
+// It returns io.EOF at the end of the input or on Ctrl-D on an empty line.
+func (r *lineReader) readLine(prompt string) (string, error) {
+	if r.terminal != nil {
+		if restore, err := makeRaw(r.terminal); err == nil {
+			defer restore()
+			return r.editLine(prompt)
+		}
+		fmt.Fprint(r.out, prompt)
+	}
+	line, err := r.in.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// editLine reads a line typed in raw mode, echoing it and completing its last word on Tab
+func (r *lineReader) editLine(prompt string) (string, error) {
+	fmt.Fprint(r.out, prompt)
+	var line []byte
+	for {
+		b, err := r.in.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case b == '\r' || b == '\n':
+			fmt.Fprint(r.out, "\r\n")
+			return string(line), nil
+		case b == 3: // Ctrl-C
+			fmt.Fprint(r.out, "^C\r\n")
+			return "", errLineInterrupted
+		case b == 4: // Ctrl-D
+			if len(line) == 0 {
+				fmt.Fprint(r.out, "\r\n")
+				return "", io.EOF
+			}
+		case b == 127 || b == 8: // Backspace
+			if len(line) > 0 {
+				_, size := utf8.DecodeLastRune(line)
+				line = line[:len(line)-size]
+				fmt.Fprint(r.out, "\b \b")
+			}
+		case b == '\t':
+			line = r.completeLine(prompt, line)
+		case b == 27: // escape sequences of arrow and function keys are ignored
+			if next, err := r.in.ReadByte(); err == nil && next == '[' {
+				for {
+					if b, err = r.in.ReadByte(); err != nil || (b >= 0x40 && b <= 0x7e) {
+						break
+					}
+				}
+			}
+		case b >= 32:
+			line = append(line, b)
+			r.out.Write([]byte{b})
+		}
+	}
+}
+
+// completeLine completes the last word of line with the common start of its completions,
+// or lists them if there is nothing to add, and returns the completed line
+func (r *lineReader) completeLine(prompt string, line []byte) []byte {
+	start := strings.LastIndexAny(string(line), " \t(),=.;") + 1
+	word := string(line[start:])
+	// a meta command is completed with the dot it starts with
+	if start == 1 && line[0] == '.' {
+		start, word = 0, string(line)
+	}
+	if word == "" {
+		return line
+	}
+	completions := r.complete(word)
+	if len(completions) == 0 {
+		return line
+	}
+	common := completions[0]
+	for _, c := range completions[1:] {
+		for !strings.HasPrefix(strings.ToLower(c), strings.ToLower(common)) {
+			common = common[:len(common)-1]
+		}
+	}
+	if len(common) > len(word) {
+		added := common[len(word):]
+		fmt.Fprint(r.out, added)
+		return append(line, added...)
+	}
+	if len(completions) > 1 {
+		fmt.Fprintf(r.out, "\r\n%s\r\n%s%s", strings.Join(completions, "  "), prompt, line)
+	}
+	return line
+}
+
+// prefixCompletions returns the sorted words starting with prefix, ignoring case
+func prefixCompletions(words []string, prefix string) []string {
+	var completions []string
+	for _, w := range words {
+		if len(w) >= len(prefix) && strings.EqualFold(w[:len(prefix)], prefix) {
+			completions = append(completions, w)
+		}
+	}
+	sort.Strings(completions)
+	return completions
+}
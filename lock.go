@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// lockOutput acquires the lockfile of the new database at path, so two runs never write
+// the same database. A lockfile left by a run that is not running anymore is taken over.
+// The returned function releases the lock.
+func lockOutput(path string) (func(), error) {
+	lockPath := path + ".lock"
+	host, _ := os.Hostname()
+	for {
+		file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			_, err = fmt.Fprintf(file, "%d\n%s\n", os.Getpid(), host)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(lockPath)
+				return nil, err
+			}
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("error creating lockfile %s: %w", lockPath, err)
+		}
+
+		content, err := os.ReadFile(lockPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// released in the meantime
+				continue
+			}
+			return nil, fmt.Errorf("error reading lockfile %s: %w", lockPath, err)
+		}
+		pid, lockHost := parseLockfile(string(content))
+		// the process of another host cannot be checked
+		if pid > 0 && (lockHost != host || processAlive(pid)) {
+			return nil, invalidInputf("%s is being generated by another run (pid %d on %s), remove %s if it is not running", path, pid, lockHost, lockPath)
+		}
+
+		slog.Warn("removing stale lockfile", "path", lockPath, "pid", pid)
+		if err = os.Remove(lockPath); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("error removing stale lockfile %s: %w", lockPath, err)
+		}
+	}
+}
+
+// parseLockfile returns the pid and host written by lockOutput, pid is 0 if the lockfile is invalid
+func parseLockfile(content string) (int, string) {
+	lines := strings.Split(content, "\n")
+	pid, err := strconv.Atoi(strings.TrimSpace(lines[0]))
+	if err != nil {
+		return 0, ""
+	}
+	host := ""
+	if len(lines) > 1 {
+		host = strings.TrimSpace(lines[1])
+	}
+	return pid, host
+}
+
+// processAlive returns whether a process with pid is running on this host
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	// on Windows FindProcess opens the process, which fails if it does not exist
+	if runtime.GOOS == "windows" {
+		process.Release()
+		return true
+	}
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/peterli110/pcr-hash-table-rename/pkg/rename"
+)
+
+// logLevels are the levels of --logLevel
+var logLevels = map[string]slog.Level{
+	"trace": rename.LevelTrace,
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// logging flags, shared by every command
+var logLevelFlag, logFormat string
+var verbose int
+var noColor bool
+
+// ANSI colors of the log lines on a terminal
+const (
+	colorRed    = "\x1b[31m"
+	colorYellow = "\x1b[33m"
+	colorGreen  = "\x1b[32m"
+	colorReset  = "\x1b[0m"
+)
+
+// colorWriter colors the text log records written to w by their level, errors in red and
+// warnings in yellow, and the summary of a run in green, so they stand out among the others
+type colorWriter struct {
+	w io.Writer
+}
+
+// Write writes a record, the text handler writes one record per call
+func (c colorWriter) Write(p []byte) (int, error) {
+	color := ""
+	switch {
+	case bytes.Contains(p, []byte(" level=ERROR ")):
+		color = colorRed
+	case bytes.Contains(p, []byte(" level=WARN ")):
+		color = colorYellow
+	case bytes.Contains(p, []byte(" msg="+tr("done")+" ")):
+		color = colorGreen
+	}
+	if color == "" {
+		return c.w.Write(p)
+	}
+	line := bytes.TrimSuffix(p, []byte("\n"))
+	if _, err := fmt.Fprintf(c.w, "%s%s%s\n", color, line, colorReset); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// useColor returns whether the logs written to f are colored: text logs on a terminal,
+// unless NO_COLOR is set (https://no-color.org), TERM is dumb or --noColor is passed
+func useColor(f *os.File) bool {
+	if noColor || logFormat != "text" || os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return isTerminal(f)
+}
+
+// isTerminal returns whether f is a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// newLogger returns a logger writing text or JSON records of level and above to w.
+// Runs log their summary at info level, the details of every table at debug level and
+// the statements run on the new database at trace level.
+func newLogger(w io.Writer, format string, level slog.Level) *slog.Logger {
+	handlerOpts := &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.LevelKey && len(groups) == 0 && a.Value.Any() == rename.LevelTrace {
+				a.Value = slog.StringValue("TRACE")
+			}
+			return a
+		},
+	}
+	if format == "json" {
+		return slog.New(slog.NewJSONHandler(w, handlerOpts))
+	}
+	return slog.New(slog.NewTextHandler(w, handlerOpts))
+}
+
+// setupLogging sends the logs to stderr with --logFormat, at the level of --logLevel lowered
+// by a level per -v, colored on a terminal. The messages of text logs are in --lang, those of
+// JSON logs stay in English for automation.
+func setupLogging() error {
+	level, ok := logLevels[strings.ToLower(logLevelFlag)]
+	if !ok {
+		return invalidInputf("unknown log level %s, expected one of %s", logLevelFlag, strings.Join(sortedKeys(logLevels), ", "))
+	}
+	if logFormat != "text" && logFormat != "json" {
+		return invalidInputf("unknown log format %s, expected text or json", logFormat)
+	}
+	var w io.Writer = os.Stderr
+	if useColor(os.Stderr) {
+		w = colorWriter{w: os.Stderr}
+	}
+	logger := newLogger(w, logFormat, level-slog.Level(4*verbose))
+	if logFormat == "text" && lang != "en" {
+		logger = slog.New(translateHandler{logger.Handler()})
+	}
+	slog.SetDefault(logger)
+	return nil
+}
@@ -2,374 +2,1126 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/spf13/cobra"
-	"log"
+	"log/slog"
 	"os"
-	"reflect"
+	"os/signal"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/peterli110/pcr-hash-table-rename/pkg/rename"
 )
 
-var originalDBPath, hashedDBPath, generatedDBPath, filter string
-var generateHashJson bool
+// set at build time with -ldflags "-X main.version=..."
+var version = "dev"
 
-var originalDBMap = map[string][][]string{}
-var hashedDBMap = map[string][][]string{}
-var tableMapping = map[string]string{}
-var filterTables = map[string]struct{}{}
+// options of a generation, set from the flags
+type options struct {
+	rename.Options
 
-var numericRegex = regexp.MustCompile(`^\d+(\.\d+)?$`)
+	OriginalDBPath, HashedDBPath, GeneratedDBPath, Filter string
+	BatchFile, OutputDir, Translate, Blacklist            string
+	PreviousMapping, HashScheme, Salt, HashTemplate       string
+	UseMapping, HistoryDir, GameVersion, DeltaFrom        string
+	RenameRules, Partition                                string
+	OriginalDBPaths, HashedDBPaths                        []string
+	TruthVersion, CDNHost, S3Endpoint, Compress, Driver   string
+	Region, Locale, PageSizeFlag, EncodingFlag            string
+	MaxMemoryFlag, Staging, MmapSizeFlag, NiceRate        string
+	OriginalDBChecksum, HashedDBChecksum                  string
+	OriginalKey, HashedKey, GeneratedKey                  string
+	Webhooks, DiscordWebhooks, FTS                        []string
+	OriginalPreSQL, HashedPreSQL, ExtraOutputs            []string
+	GenerateTableMapping, SkipVerify, Strict, FetchLatest bool
+	Force, SkipMappingTable, SkipMetaTable, NoHistory     bool
+	ViewsInPlace, MetaTimestamp, Vacuum, Analyze          bool
+	Optimize, CuratedViews, Review, Interactive           bool
+	Incremental, IfChanged, Nice                          bool
+	QueryTimeout, BusyBackoff                             time.Duration
+	BusyRetries                                           int
+}
+
+var opts = options{Options: rename.DefaultOptions(), Locale: regions["jp"].Locale}
+
+const (
+	mappingFile      = "table_mapping.json"
+	indexMappingFile = "index_mapping.json"
+)
+
+// paths the table and index mappings are written to, named after the hashed database in batch mode
+var (
+	mappingPath      = mappingFile
+	indexMappingPath = indexMappingFile
+)
+
+// sampled rows of the original database, read by the first run of a batch
+var originalTables rename.Tables
+
+var exitCode = exitSuccess
 
 func main() {
 	var rootCmd = &cobra.Command{
-		Use:   "pcr-hash-table-rename",
-		Short: "PCR Hash Table Rename",
+		Use:     "pcr-hash-table-rename",
+		Short:   "PCR Hash Table Rename",
+		Version: versionString(),
 		Long: `Generate a new database with human-readable table names from a hashed database in Princess Connect Re:Dive.
-                Complete documentation is available at https://github.com/peterli110/pcr-hash-table-rename`,
+                Complete documentation is available at https://github.com/peterli110/pcr-hash-table-rename
+
+` + exitCodesHelp,
 		Run: func(cmd *cobra.Command, args []string) {
-			run(originalDBPath, hashedDBPath, generatedDBPath, generateHashJson)
+			recordFlags(cmd)
+			recordManifestFlags(cmd)
+			if opts.Optimize {
+				opts.Vacuum, opts.Analyze = true, true
+			}
+			inputs, err := hashedInputs()
+			if err == nil {
+				err = applyRegion(cmd)
+			}
+			if err == nil && len(inputs) > 1 && cmd.Flags().Changed("generatedDBPath") {
+				err = invalidInputf("--generatedDBPath cannot be used with several hashed databases, use --outputDir")
+			}
+			if err == nil && len(opts.OriginalDBPaths) == 0 && opts.UseMapping == "" && opts.Filter == "" {
+				err = invalidInputf("--hashScheme without --originalDBPath needs --filter listing the table names")
+			}
+			if err == nil && len(opts.OriginalDBPaths) == 0 && opts.UseMapping != "" && (opts.Resume || opts.CreateMissing || opts.Append) {
+				err = invalidInputf("--resume, --createMissing and --append need --originalDBPath")
+			}
+			if err == nil {
+				err = checkFormat()
+			}
+			if err == nil {
+				err = applyMaxMemory()
+			}
+			if err == nil {
+				err = checkStaging()
+			}
+			if err == nil {
+				err = checkMmapSize()
+			}
+			if err == nil {
+				err = applyNice()
+			}
+			if err == nil {
+				err = checkIncremental()
+			}
+			if err == nil {
+				err = checkFTS()
+			}
+			if err == nil && opts.Truncate && !opts.Append {
+				err = invalidInputf("--truncate needs --append")
+			}
+			if err == nil && opts.HashScheme != "" {
+				_, err = flagHashScheme()
+			}
+			if err == nil && (opts.Review || opts.Interactive) && len(opts.OriginalDBPaths) == 0 {
+				err = invalidInputf("--review and --interactive need --originalDBPath")
+			}
+			if err == nil && (opts.Review || opts.Interactive) && !isTerminal(os.Stdin) {
+				err = invalidInputf("--review and --interactive need a terminal")
+			}
+			if err != nil {
+				exitCode = runError(err)
+				notify(exitCode)
+				return
+			}
+
+			if len(opts.OriginalDBPaths) > 0 {
+				opts.OriginalDBPath = opts.OriginalDBPaths[0]
+			}
+			if len(inputs) > 1 {
+				exitCode = generateBatch(cmd.Context(), inputs)
+				return
+			}
+			if len(inputs) == 1 {
+				opts.HashedDBPath = inputs[0]
+			}
+			exitCode = generateAndNotify(cmd.Context())
 		},
 	}
 
-	rootCmd.PersistentFlags().StringVarP(&originalDBPath, "originalDBPath", "r", "", "REQUIRED: Path to the original (human-readable one) database")
-	rootCmd.PersistentFlags().StringVarP(&hashedDBPath, "hashedDBPath", "n", "", "REQUIRED: Path to the hashed (latest) database")
-	rootCmd.PersistentFlags().StringVarP(&generatedDBPath, "generatedDBPath", "g", "jp_fixed.db", "OPTIONAL: Path to the new database, default to jp_fixed.db")
-	rootCmd.PersistentFlags().BoolVarP(&generateHashJson, "generateTableMapping", "t", false, "OPTIONAL: Generate a mapping of raw table name -> hash table name in JSON")
-	rootCmd.PersistentFlags().StringVarP(&filter, "filter", "f", "", "OPTIONAL: Use a file to generate a new database with only the tables in the file")
-	_ = rootCmd.MarkPersistentFlagRequired("originalDBPath")
-	_ = rootCmd.MarkPersistentFlagRequired("hashedDBPath")
-
-	err := rootCmd.Execute()
-	if err != nil {
-		log.Fatal(err)
+	rootCmd.Flags().StringArrayVarP(&opts.OriginalDBPaths, "originalDBPath", "r", nil, "REQUIRED: Path, SQLite URI (file:path?params), HTTP(S) URL or s3:// / gs:// object of the original (human-readable one) database, unless --hashScheme is used. Can be repeated, newest first, to match the tables the newest one does not have or that have no match there from older ones")
+	rootCmd.Flags().StringArrayVarP(&opts.HashedDBPaths, "hashedDBPath", "n", nil, "REQUIRED: Path, SQLite URI (file:path?params), HTTP(S) URL or s3:// / gs:// object of the hashed (latest) database, may be brotli or gzip compressed or inside an APK/XAPK/zip (archive#path/in/archive), unless --truthVersion, --fetchLatest or --batchFile is used. Can be repeated to generate one database per hashed database in --outputDir")
+	rootCmd.Flags().StringVar(&opts.BatchFile, "batchFile", "", "OPTIONAL: File listing hashed databases, one per line, to generate one database per hashed database in --outputDir")
+	rootCmd.Flags().StringVar(&opts.OutputDir, "outputDir", ".", "OPTIONAL: Directory of the databases generated from several hashed databases, named <hashed database>_fixed.db")
+	rootCmd.Flags().StringVarP(&opts.GeneratedDBPath, "generatedDBPath", "g", "jp_fixed.db", "OPTIONAL: Path, SQLite URI (file:path?params) or s3:// / gs:// object of the new database, default to <region>_fixed.db")
+	rootCmd.Flags().StringVar(&opts.Translate, "translate", "", "OPTIONAL: CSV file (table,column,id,text) or SQLite database of translations overwriting the text columns of the new database, keyed by ID")
+	rootCmd.Flags().BoolVarP(&opts.GenerateTableMapping, "generateTableMapping", "t", false, "OPTIONAL: Generate a mapping of raw table name -> hash table name in JSON, and of raw index name -> hashed index name in "+indexMappingFile)
+	rootCmd.Flags().StringVar(&opts.PreviousMapping, "previousMapping", "", "OPTIONAL: Table mapping of a previous run, to write the previous hashed name -> current hashed name mapping to "+hashMappingFile)
+	rootCmd.Flags().StringVar(&opts.UseMapping, "useMapping", "", "OPTIONAL: Trusted table mapping (of --generateTableMapping or precompute) to rename the tables with instead of matching by data. Without --originalDBPath, the tables keep their hashed schema, with the columns of a precompute lookup table renamed")
+	rootCmd.Flags().StringVar(&opts.HashScheme, "hashScheme", "", "OPTIONAL: Digest of the hashed table names, one of "+strings.Join(sortedKeys(hashAlgorithms), ", ")+", to compute the hashed name of every table instead of matching by data. Without --originalDBPath, only the table mapping of the tables of --filter is written")
+	rootCmd.Flags().StringVar(&opts.Salt, "salt", "", "OPTIONAL: Salt of --hashScheme, prepended to the table name unless --hashTemplate is set")
+	rootCmd.Flags().StringVar(&opts.HashTemplate, "hashTemplate", "", "OPTIONAL: String hashed by --hashScheme, where {name}, {NAME} and {salt} are the table name, upper-case table name and salt, as reported by analyze-hash")
+	rootCmd.Flags().StringVarP(&opts.Filter, "filter", "f", "", "OPTIONAL: Use a file to generate a new database with only the tables in the file, one per line, optionally followed by the columns to copy in parentheses and by WHERE and the condition of the rows to copy, or by AS and a SELECT replacing its rows")
+	rootCmd.Flags().StringVar(&opts.Blacklist, "blacklist", "", "OPTIONAL: File listing known-dead tables to skip, one per line, replacing the built-in blacklist of --region (empty to skip none)")
+	rootCmd.Flags().StringVar(&opts.OriginalDBChecksum, "originalDBSHA256", "", "OPTIONAL: Expected SHA-256 of the original database")
+	rootCmd.Flags().StringVar(&opts.HashedDBChecksum, "hashedDBSHA256", "", "OPTIONAL: Expected SHA-256 of the hashed database")
+	rootCmd.Flags().StringVar(&opts.OriginalKey, "originalKey", "", "OPTIONAL: SQLCipher key of the original database")
+	rootCmd.Flags().StringVar(&opts.HashedKey, "hashedKey", "", "OPTIONAL: SQLCipher key of the hashed database")
+	rootCmd.Flags().StringArrayVar(&opts.OriginalPreSQL, "originalPreSQL", nil, "OPTIONAL: SQL run on a copy of the original database before matching, e.g. to delete rows that break the matching, can be repeated")
+	rootCmd.Flags().StringArrayVar(&opts.HashedPreSQL, "hashedPreSQL", nil, "OPTIONAL: SQL run on a copy of the hashed database before matching, e.g. to delete rows that break the matching, can be repeated")
+	rootCmd.Flags().StringVar(&opts.GeneratedKey, "generatedKey", "", "OPTIONAL: SQLCipher key to encrypt the new database with")
+	rootCmd.Flags().StringVar(&opts.TruthVersion, "truthVersion", "", "OPTIONAL: Download the hashed database of this truth version from the game CDN")
+	rootCmd.Flags().BoolVar(&opts.FetchLatest, "fetchLatest", false, "OPTIONAL: Download the hashed database of the latest truth version from the game CDN, searching from --truthVersion if set")
+	rootCmd.Flags().StringVar(&opts.GameVersion, "gameVersion", "", "OPTIONAL: Truth version of the hashed database, to record its mapping in the mapping history, set by --truthVersion and --fetchLatest")
+	rootCmd.Flags().StringVar(&opts.HistoryDir, "historyDir", "", "OPTIONAL: Directory of the mapping history, default to pcr-hash-table-rename/history in the user config directory")
+	rootCmd.Flags().BoolVar(&opts.NoHistory, "noHistory", false, "OPTIONAL: Do not record the mapping in the mapping history")
+	rootCmd.Flags().StringVar(&opts.Region, "region", "jp", regionUsage)
+	rootCmd.Flags().StringVar(&opts.CDNHost, "cdnHost", regions["jp"].CDNHost, "OPTIONAL: Game CDN used by --truthVersion and --fetchLatest, default to the CDN of --region")
+	rootCmd.Flags().StringVar(&opts.S3Endpoint, "s3Endpoint", "s3.amazonaws.com", "OPTIONAL: Endpoint used for s3:// paths, for S3 compatible storage")
+	rootCmd.Flags().StringVar(&opts.Compress, "compress", "", "OPTIONAL: Compress the new database and the table mapping with gzip, zstd or br")
+	rootCmd.Flags().StringArrayVar(&opts.Webhooks, "webhook", nil, "OPTIONAL: URL to POST the run summary to when the run is done, can be repeated")
+	rootCmd.Flags().StringArrayVar(&opts.DiscordWebhooks, "discordWebhook", nil, "OPTIONAL: Discord webhook URL to post the run summary to when the run is done, can be repeated")
+	rootCmd.Flags().IntVar(&opts.SampleRows, "sampleRows", opts.SampleRows, "OPTIONAL: Number of first rows that have to be the same for a hashed table to match")
+	rootCmd.Flags().IntVar(&opts.Workers, "workers", opts.Workers, "OPTIONAL: Number of tables matched at the same time")
+	rootCmd.Flags().IntVar(&opts.ReadWorkers, "readWorkers", opts.ReadWorkers, "OPTIONAL: Number of tables of each database whose first rows are read at the same time before matching, each on a connection of its own. Above 1, the original and hashed databases are also read at the same time")
+	rootCmd.Flags().StringVar(&opts.PageSizeFlag, "pageSize", strconv.Itoa(opts.PageSize), "OPTIONAL: Page size of the new database, or "+fromHashed+" to copy the page size of the hashed database")
+	rootCmd.Flags().StringVar(&opts.EncodingFlag, "encoding", opts.Encoding, "OPTIONAL: Text encoding of the new database, UTF-8, UTF-16le or UTF-16be, or "+fromHashed+" to copy the encoding of the hashed database")
+	rootCmd.Flags().StringArrayVar(&opts.Pragmas, "pragma", opts.Pragmas, "OPTIONAL: PRAGMA run on the new database before copying, e.g. \"synchronous = OFF\", can be repeated and replaces the default")
+	rootCmd.Flags().IntVar(&opts.BusyRetries, "busyRetries", 5, "OPTIONAL: Number of times to retry opening a database locked by another process")
+	rootCmd.Flags().DurationVar(&opts.BusyBackoff, "busyBackoff", time.Second, "OPTIONAL: Wait before the first retry of a locked database, doubled after every retry")
+	rootCmd.Flags().DurationVar(&opts.QueryTimeout, "queryTimeout", 0, "OPTIONAL: Cancel the run if a single query takes longer than this, e.g. 30s")
+	rootCmd.Flags().StringVar(&opts.Driver, "driver", sqliteDriver, driverUsage)
+	rootCmd.Flags().BoolVar(&opts.Force, "force", false, "OPTIONAL: Replace the new database if it already exists")
+	rootCmd.Flags().BoolVar(&opts.IfChanged, "ifChanged", false, "OPTIONAL: Skip the run if the inputs and flags are the same as the run that wrote the existing new database, recorded in <generatedDBPath>"+manifestSuffix+", and replace it otherwise, e.g. to run from cron. --force runs anyway")
+	rootCmd.Flags().BoolVar(&opts.Resume, "resume", false, "OPTIONAL: Keep the new database of an interrupted or failed run and skip its copied tables when run again with --resume")
+	rootCmd.Flags().BoolVar(&opts.CreateMissing, "createMissing", false, "OPTIONAL: Create the original tables without a match in the new database too, with no rows")
+	rootCmd.Flags().BoolVar(&opts.FailFast, "failFast", false, "OPTIONAL: Stop at the first table that could not be copied instead of copying the remaining tables")
+	rootCmd.Flags().BoolVar(&opts.Strict, "strict", false, "OPTIONAL: Exit with an error (exit code 6) without writing the new database if any table has no match")
+	rootCmd.Flags().BoolVar(&opts.ViewsInPlace, "viewsInPlace", false, "OPTIONAL: Instead of copying the tables, write a copy of the hashed database with a readable view on every hashed table, which is much faster and smaller")
+	rootCmd.Flags().BoolVar(&opts.Append, "append", false, "OPTIONAL: Insert the rows into the tables the existing new database already has, e.g. from a previous run or a migration tool, creating only the missing ones")
+	rootCmd.Flags().BoolVar(&opts.Truncate, "truncate", false, "OPTIONAL: With --append, delete the rows of the existing tables before inserting the new ones")
+	rootCmd.Flags().StringVar(&opts.MaxMemoryFlag, "maxMemory", "", "OPTIONAL: Approximate cap of the memory used, e.g. 256MiB, for low-RAM machines: large tables are copied in chunks and table checksums are computed while reading")
+	rootCmd.Flags().BoolVar(&opts.Nice, "nice", false, "OPTIONAL: Run in the background without starving the other processes of the host: lower the CPU and I/O priority of the process and limit the rows read and written to --niceRate")
+	rootCmd.Flags().StringVar(&opts.NiceRate, "niceRate", "16MiB", "OPTIONAL: Bytes of rows read and written per second with --nice, e.g. 4MiB, or 0 to only lower the priority")
+	rootCmd.Flags().StringVar(&opts.MmapSizeFlag, "mmapSize", mmapAuto, "OPTIONAL: PRAGMA mmap_size of the original and hashed databases, e.g. 1GiB, to speed up reading large tables, or 0 to disable it. auto maps up to 256MiB of the databases opened read-only or immutable, e.g. file:jp.db?immutable=1")
+	rootCmd.Flags().StringVar(&opts.Staging, "staging", stagingDisk, "OPTIONAL: Where the new database is built, disk or memory to build it in memory and write it to disk once at the end, trading RAM for far fewer disk syncs on slow disks")
+	rootCmd.Flags().IntVar(&opts.LimitRows, "limitRows", 0, "OPTIONAL: Copy at most this many rows per table, for a small but schema-complete new database to test downstream tools with")
+	rootCmd.Flags().BoolVar(&opts.OrderByPK, "orderByPK", false, "OPTIONAL: Insert the rows in primary key order instead of the rowid order of the hashed database, for stable diffs between versions of the new database")
+	rootCmd.Flags().StringVar(&opts.DeltaFrom, "deltaFrom", "", "OPTIONAL: Previous generated database, or run summary of a run with --tableChecksums, to only write the tables whose content changed since into the new database")
+	rootCmd.Flags().BoolVar(&opts.Checksums, "tableChecksums", false, "OPTIONAL: Record the checksum of the content of every table in the run summary, for a later --deltaFrom")
+	rootCmd.Flags().BoolVar(&opts.Incremental, "incremental", false, "OPTIONAL: Keep the tables of the existing new database whose hashed table did not change since the run that wrote it, recorded in <generatedDBPath>"+checksumsSuffix+", and only match and copy the other ones")
+	rootCmd.Flags().BoolVar(&opts.SchemaOnly, "schemaOnly", false, "OPTIONAL: Only create the renamed tables and their indexes in the new database, without any row")
+	rootCmd.Flags().BoolVar(&opts.SkipIndexes, "skipIndexes", false, "OPTIONAL: Do not copy the indexes of the hashed tables into the new database")
+	rootCmd.Flags().BoolVar(&opts.SkipMappingTable, "skipMappingTable", false, "OPTIONAL: Do not write the "+rename.MappingTable+" table of the renamed tables into the new database")
+	rootCmd.Flags().StringArrayVar(&opts.ExtraOutputs, "extraOutput", nil, "OPTIONAL: Another artifact written from the new database in the same run, kind=db,path=<path>[,filter=<filter file>], kind=sql,path=<path> for an SQL dump or kind=mapping,path=<path> for the table mapping, can be repeated")
+	rootCmd.Flags().StringVar(&opts.Partition, "partition", "", "OPTIONAL: YAML file of groups of tables, each moved from the new database into a database of its own, e.g. to ship the stats without the story text")
+	rootCmd.Flags().BoolVar(&opts.CuratedViews, "curatedViews", false, "OPTIONAL: Create query-ready views joining related tables, e.g. unit_skills, from the queries in views/ of the repository")
+	rootCmd.Flags().StringVar(&opts.RenameRules, "renameRules", "", "OPTIONAL: File of rules renaming the tables and columns of the new database, e.g. table strip_prefix m_ or column snake_case, one per line")
+	rootCmd.Flags().StringSliceVar(&opts.FTS, "fts", nil, "OPTIONAL: Comma separated tables to build an FTS5 full-text search table <table>"+ftsSuffix+" over the text columns of, e.g. skill_data,story_detail")
+	rootCmd.Flags().BoolVar(&opts.Vacuum, "vacuum", false, "OPTIONAL: Compact the new database once every table is copied")
+	rootCmd.Flags().BoolVar(&opts.Analyze, "analyze", false, "OPTIONAL: Generate the query planner statistics of the new database once every table is copied")
+	rootCmd.Flags().BoolVar(&opts.Optimize, "optimize", false, "OPTIONAL: Same as --vacuum --analyze")
+	rootCmd.Flags().BoolVar(&opts.MetaTimestamp, "metaTimestamp", false, "OPTIONAL: Record the time the run started in the "+metaTable+" table, which makes the new database differ between identical runs")
+	rootCmd.Flags().BoolVar(&opts.SkipMetaTable, "skipMetaTable", false, "OPTIONAL: Do not write the "+metaTable+" table recording how the new database was produced into it")
+	rootCmd.Flags().BoolVar(&opts.Review, "review", false, "OPTIONAL: Review the matches on the terminal before generating the new database, showing their first rows side by side and accepting, rejecting or overriding them")
+	rootCmd.Flags().BoolVar(&opts.Interactive, "interactive", false, "OPTIONAL: Prompt for the hashed table of the tables matched with a confidence below 1 or without a match, among their candidates, recording the choices as manual matches")
+	rootCmd.Flags().BoolVar(&opts.SkipVerify, "skipVerify", false, "OPTIONAL: Skip the integrity, foreign key and orphaned reference checks on the generated database")
+	rootCmd.MarkFlagsOneRequired("originalDBPath", "hashScheme", "useMapping")
+	rootCmd.MarkFlagsMutuallyExclusive("useMapping", "hashScheme")
+	// the views are on the hashed tables of the copy, as they are
+	for _, flag := range []string{"resume", "createMissing", "translate", "hashedKey", "generatedKey"} {
+		rootCmd.MarkFlagsMutuallyExclusive("viewsInPlace", flag)
 	}
-}
-
-func run(originalDBPath string, hashedDBPath string, generatedDBPath string, generateHashJson bool) {
-	if filter != "" {
-		readFilterFile()
+	rootCmd.MarkFlagsMutuallyExclusive("viewsInPlace", "schemaOnly")
+	rootCmd.MarkFlagsMutuallyExclusive("viewsInPlace", "deltaFrom")
+	rootCmd.MarkFlagsMutuallyExclusive("schemaOnly", "deltaFrom")
+	rootCmd.MarkFlagsMutuallyExclusive("viewsInPlace", "limitRows")
+	rootCmd.MarkFlagsMutuallyExclusive("viewsInPlace", "renameRules")
+	rootCmd.MarkFlagsMutuallyExclusive("append", "renameRules")
+	for _, flag := range []string{"viewsInPlace", "append", "fts", "curatedViews"} {
+		rootCmd.MarkFlagsMutuallyExclusive("partition", flag)
 	}
-	originalDB, err := sql.Open("sqlite3", originalDBPath)
-	if err != nil {
-		log.Fatal(err)
+	rootCmd.MarkFlagsMutuallyExclusive("viewsInPlace", "extraOutput")
+	// the tables of the previous new database are reused as they were copied
+	for _, flag := range []string{"append", "resume", "viewsInPlace", "schemaOnly", "deltaFrom", "renameRules", "partition"} {
+		rootCmd.MarkFlagsMutuallyExclusive("incremental", flag)
 	}
-	defer originalDB.Close()
-
-	hashedDB, err := sql.Open("sqlite3", hashedDBPath)
-	if err != nil {
-		log.Fatal(err)
+	rootCmd.MarkFlagsMutuallyExclusive("schemaOnly", "limitRows")
+	for _, flag := range []string{"resume", "createMissing", "schemaOnly", "viewsInPlace", "force"} {
+		rootCmd.MarkFlagsMutuallyExclusive("append", flag)
 	}
-	defer hashedDB.Close()
+	rootCmd.MarkFlagsOneRequired("hashedDBPath", "truthVersion", "fetchLatest", "batchFile")
+	rootCmd.MarkFlagsMutuallyExclusive("hashedDBPath", "truthVersion")
+	rootCmd.MarkFlagsMutuallyExclusive("hashedDBPath", "fetchLatest")
+	rootCmd.MarkFlagsMutuallyExclusive("batchFile", "truthVersion")
+	rootCmd.MarkFlagsMutuallyExclusive("batchFile", "fetchLatest")
+	rootCmd.MarkFlagsMutuallyExclusive("review", "batchFile")
+	rootCmd.MarkFlagsMutuallyExclusive("interactive", "batchFile")
+	rootCmd.MarkFlagsMutuallyExclusive("interactive", "review")
 
-	readFromDB(originalDB, originalDBMap, true)
-	readFromDB(hashedDB, hashedDBMap, false)
+	rootCmd.AddCommand(newStatsCmd())
+	rootCmd.AddCommand(newDiffCmd())
+	rootCmd.AddCommand(newMergeCmd())
+	rootCmd.AddCommand(newApplyDeltaCmd())
+	rootCmd.AddCommand(newChangelogCmd())
+	rootCmd.AddCommand(newAnalyzeHashCmd())
+	rootCmd.AddCommand(newPrecomputeCmd())
+	rootCmd.AddCommand(newMappingCmd())
+	rootCmd.AddCommand(newServeCmd())
+	rootCmd.AddCommand(newWatchCmd())
+	rootCmd.AddCommand(newReplCmd())
+	rootCmd.AddCommand(newQueryCmd())
+	rootCmd.AddCommand(newBenchCmd())
 
-	newDB, err := sql.Open("sqlite3", generatedDBPath)
-	if err != nil {
-		log.Fatal(err)
+	rootCmd.PersistentFlags().StringVar(&logLevelFlag, "logLevel", "info", "OPTIONAL: Level of the logs, one of trace, debug, info, warn, error. info only logs the summary of the run and its warnings")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "logFormat", "text", "OPTIONAL: Format of the logs, text or json")
+	rootCmd.PersistentFlags().CountVarP(&verbose, "verbose", "v", "OPTIONAL: Lower --logLevel by a level, -v logs the details of every table and -vv every statement run on the new database")
+	rootCmd.PersistentFlags().BoolVar(&noColor, "noColor", false, "OPTIONAL: Do not color the logs on a terminal, like setting NO_COLOR")
+	rootCmd.PersistentFlags().StringVar(&lang, "lang", envLang(), "OPTIONAL: Language of the help and of the text logs, one of "+strings.Join(langs, ", ")+", default to the language of the locale")
+	rootCmd.PersistentFlags().StringVar(&cpuProfilePath, "cpuProfile", "", "OPTIONAL: Write a CPU profile of the run to this file, to diagnose slow runs with go tool pprof")
+	rootCmd.PersistentFlags().StringVar(&memProfilePath, "memProfile", "", "OPTIONAL: Write a heap profile to this file at the end of the run")
+	rootCmd.PersistentFlags().StringVar(&traceSQLPath, "traceSql", "", "OPTIONAL: Record every statement run on the databases to this file, one JSON line with its duration and the rows it affected or returned, to find slow tables and statements")
+	rootCmd.PersistentFlags().StringVar(&otlpEndpoint, "otlpEndpoint", envOTLPEndpoint(), "OPTIONAL: Export the spans of matching, of the copy of every table and of verification to this OpenTelemetry collector with OTLP/HTTP, e.g. http://localhost:4318, default to OTEL_EXPORTER_OTLP_ENDPOINT")
+	rootCmd.PersistentFlags().StringVar(&pprofAddr, "pprofAddr", "", "OPTIONAL: Serve live pprof profiles on this address during the run, e.g. localhost:6060")
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if err := checkLang(); err != nil {
+			return err
+		}
+		if err := setupLogging(); err != nil {
+			return err
+		}
+		if err := startSQLTrace(); err != nil {
+			return err
+		}
+		if err := startTracing(); err != nil {
+			return err
+		}
+		return startProfiling()
 	}
-	defer newDB.Close()
+	// the help is translated once --lang is parsed
+	help, usage := rootCmd.HelpFunc(), rootCmd.UsageFunc()
+	rootCmd.SetHelpFunc(func(cmd *cobra.Command, args []string) {
+		if checkLang() == nil {
+			localizeCommand(rootCmd)
+		}
+		help(cmd, args)
+	})
+	rootCmd.SetUsageFunc(func(cmd *cobra.Command) error {
+		if checkLang() == nil {
+			localizeCommand(rootCmd)
+		}
+		return usage(cmd)
+	})
 
-	// using WAL mode to speed up insertions
-	_, err = newDB.Exec("PRAGMA journal_mode = WAL;")
+	// the log package is also sent to this logger
+	slog.SetDefault(newLogger(os.Stderr, "text", slog.LevelInfo))
+
+	// interrupting cancels the running queries and downloads, so the open transaction is
+	// rolled back and deferred cleanups still run, a second interrupt exits immediately
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		signal.Stop(signals)
+		slog.Warn("interrupted, cleaning up, interrupt again to exit immediately", "signal", sig)
+		cancel()
+	}()
+	err := rootCmd.ExecuteContext(ctx)
+	cancel()
 	if err != nil {
-		log.Fatal(err)
+		fatalf(exitInvalidInput, "%v", err)
 	}
+	stopProfiling()
+	stopSQLTrace()
+	stopTracing()
+	os.Exit(exitCode)
+}
 
-	for t, v := range originalDBMap {
-		if filter != "" {
-			if _, ok := filterTables[t]; !ok {
-				continue
-			}
+// generateAndNotify runs generate, logs its error, writes the run summary and notifies the webhooks,
+// it returns the exit code
+func generateAndNotify(ctx context.Context) (code int) {
+	summary.Version = version
+	summary.SchemaGeneration = hashedPrefix
+	summary.ValidatedTruthVersion = knownTruthVersion
+	summary.StartedAt = time.Now()
+	summary.GeneratedDB = opts.GeneratedDBPath
+	ctx, span := startSpan(withTracing(ctx), "generate", slog.String("hashedDB", opts.HashedDBPath), slog.String("generatedDB", opts.GeneratedDBPath))
+	var err error
+	// the summary of every run is written, including the failed and interrupted ones
+	defer func() {
+		if errors.Is(err, errUpToDate) {
+			// nothing happened, so there is nothing to write or notify
+			return
 		}
-		if hashedTable, ok := findMatchingTable(v, hashedDB, t); ok {
-			tableMapping[t] = hashedTable
-			copyData(originalDB, hashedDB, newDB, t, hashedTable)
-		} else {
-			log.Println("no matching table for", t)
+		if err != nil {
+			summary.Error = err.Error()
 		}
-	}
+		summary.ExitCode = code
+		if err := writeSummary(); err != nil {
+			slog.Error("error writing run summary", "path", summaryPath, "error", err)
+			if code == exitSuccess {
+				code = exitInternalError
+			}
+		}
+		notify(code)
+	}()
 
-	if generateHashJson {
-		writeJson()
+	code, err = generate(ctx)
+	if errors.Is(err, errUpToDate) {
+		span.End(nil, slog.Bool("upToDate", true))
+		return exitSuccess
 	}
-
-	log.Println("Done!")
+	span.End(err, slog.Int("exitCode", code))
+	if err != nil {
+		code = runError(err)
+	}
+	return code
 }
 
-func readFromDB(db *sql.DB, dbMap map[string][][]string, filterV1Table bool) {
-	tables := getTableNames(db, filterV1Table)
+// generate prepares the inputs, runs the generation and compresses and uploads
+// its outputs, it returns the exit code of a finished run
+func generate(ctx context.Context) (int, error) {
+	if _, ok := compressionExts[opts.Compress]; opts.Compress != "" && !ok {
+		return 0, invalidInputf("unknown compression %s", opts.Compress)
+	}
+	if err := checkDriver(); err != nil {
+		return 0, err
+	}
+	summary.TruthVersion = opts.GameVersion
+	if opts.OriginalDBPath == "" && opts.UseMapping == "" {
+		return computeMapping(ctx)
+	}
+	// URI parameters are kept aside while the inputs are prepared and passed to the driver
+	generatedPath, generatedParams := splitDSN(opts.GeneratedDBPath)
+	// objects are generated in a temporary directory of their own
+	if !isObjectURL(generatedPath) {
+		unlock, err := lockOutput(generatedPath)
+		if err != nil {
+			return 0, err
+		}
+		defer unlock()
+	}
+	if opts.Append && isObjectURL(generatedPath) {
+		return 0, invalidInputf("--append needs a local new database")
+	}
+	if opts.IfChanged && isObjectURL(generatedPath) {
+		return 0, invalidInputf("--ifChanged needs a local new database")
+	}
+	partitions = nil
+	if opts.Partition != "" {
+		var err error
+		if partitions, err = readPartitions(opts.Partition); err != nil {
+			return 0, err
+		}
+	}
+	extraOutputs = nil
+	if len(opts.ExtraOutputs) > 0 {
+		var err error
+		if extraOutputs, err = readExtraOutputs(opts.ExtraOutputs); err != nil {
+			return 0, err
+		}
+	}
+	outputs := []string{generatedPath}
+	for _, p := range partitions {
+		outputs = append(outputs, p.Path)
+	}
+	for _, o := range extraOutputs {
+		outputs = append(outputs, o.Path)
+	}
+	// --incremental and --ifChanged replace the new database they reuse or find out of date
+	if !opts.Force && !opts.Append && !opts.Incremental && !opts.IfChanged {
+		for _, output := range outputs {
+			if err := checkOutput(ctx, output); err != nil {
+				return 0, err
+			}
+			if opts.Compress != "" {
+				if err := checkOutput(ctx, output+compressionExts[opts.Compress]); err != nil {
+					return 0, err
+				}
+			}
+		}
+	}
 
-	for _, table := range tables {
-		dbMap[table] = getFirstNRows(db, table, 1)
+	if opts.HashedDBPath == "" {
+		path, err := downloadHashedDB(ctx)
+		if err != nil {
+			return 0, err
+		}
+		opts.HashedDBPath = path
+		defer os.Remove(opts.HashedDBPath)
 	}
-}
+	originalPath, originalParams := splitDSN(opts.OriginalDBPath)
+	hashedPath, hashedParams := splitDSN(opts.HashedDBPath)
 
-func getTableNames(db *sql.DB, filterV1Tables bool) []string {
-	tables := make([]string, 0)
-	query := "SELECT name FROM sqlite_master WHERE type='table';"
-	rows, err := db.Query(query)
+	// with --useMapping, the original database is optional
+	var original string
+	if opts.OriginalDBPath != "" {
+		var originalInfo inputFile
+		var cleanupOriginal func()
+		var err error
+		if original, originalInfo, cleanupOriginal, err = prepareInput(ctx, originalPath, opts.OriginalDBChecksum, opts.OriginalKey != ""); err != nil {
+			return 0, fmt.Errorf("original database: %w", err)
+		}
+		defer cleanupOriginal()
+		summary.OriginalDB = originalInfo
+		if len(opts.OriginalPreSQL) > 0 {
+			var cleanupPreSQL func()
+			if original, cleanupPreSQL, err = preSQLInput(ctx, original, opts.OriginalKey, "originalPreSQL", opts.OriginalPreSQL); err != nil {
+				return 0, fmt.Errorf("original database: %w", err)
+			}
+			defer cleanupPreSQL()
+		}
+	}
+	// checksums and keys only apply to the newest original database
+	var olderOriginals []string
+	for _, path := range opts.OriginalDBPaths[min(1, len(opts.OriginalDBPaths)):] {
+		olderPath, olderParams := splitDSN(path)
+		older, olderInfo, cleanupOlder, err := prepareInput(ctx, olderPath, "", false)
+		if err != nil {
+			return 0, fmt.Errorf("original database %s: %w", path, err)
+		}
+		defer cleanupOlder()
+		olderOriginals = append(olderOriginals, joinDSN(older, olderParams))
+		summary.OlderOriginalDBs = append(summary.OlderOriginalDBs, olderInfo)
+	}
+	hashed, hashedInfo, cleanupHashed, err := prepareInput(ctx, hashedPath, opts.HashedDBChecksum, opts.HashedKey != "")
 	if err != nil {
-		log.Fatalf("Error querying database: %v", err)
+		return 0, fmt.Errorf("hashed database: %w", err)
+	}
+	defer cleanupHashed()
+	summary.HashedDB = hashedInfo
+	if len(opts.HashedPreSQL) > 0 {
+		var cleanupPreSQL func()
+		if hashed, cleanupPreSQL, err = preSQLInput(ctx, hashed, opts.HashedKey, "hashedPreSQL", opts.HashedPreSQL); err != nil {
+			return 0, fmt.Errorf("hashed database: %w", err)
+		}
+		defer cleanupPreSQL()
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var name string
-		if err = rows.Scan(&name); err != nil {
-			log.Fatalf("Error scanning row: %v", err)
+	var manifest runManifest
+	if opts.IfChanged {
+		if manifest, err = newRunManifest(); err != nil {
+			return 0, err
 		}
+		if !opts.Force && upToDate(generatedPath, outputs, manifest) {
+			slog.Info("the inputs and flags did not change since the previous run, skipping", "path", generatedPath)
+			return exitSuccess, errUpToDate
+		}
+	}
 
-		// ignore the sqlite_stat1 table because row data is also hashed
-		if name == "sqlite_stat1" {
-			continue
+	// objects are generated locally and uploaded once the run is done
+	output := generatedPath
+	if isObjectURL(generatedPath) {
+		if _, _, _, err = parseObjectURL(generatedPath); err != nil {
+			return 0, err
 		}
-		// ignore the new hashed v1_ tables
-		if strings.HasPrefix(name, "v1_") {
-			if !filterV1Tables {
-				tables = append(tables, name)
-			}
-		} else {
-			tables = append(tables, name)
+		tmpDir, err := os.MkdirTemp("", "pcr_output_*")
+		if err != nil {
+			return 0, err
 		}
+		defer os.RemoveAll(tmpDir)
+		output = filepath.Join(tmpDir, filepath.Base(generatedPath))
 	}
 
-	return tables
-}
+	runCtx := ctx
+	if opts.QueryTimeout > 0 {
+		runCtx = rename.WithQueryTimeout(ctx, opts.QueryTimeout)
+	}
 
-func findMatchingTable(values [][]string, hashedDB *sql.DB, table string) (string, bool) {
-	if len(values) == 0 {
-		return "", false
+	// the new database is renamed into place once the run is complete,
+	// so output never holds a half-written database
+	tmpOutput := partialOutput(output)
+	if opts.Resume {
+		if _, err = os.Stat(tmpOutput); err == nil {
+			slog.Info("resuming", "path", tmpOutput)
+		}
+	} else {
+		if tmpOutput, err = createTempOutput(output); err != nil {
+			return 0, err
+		}
+		defer removeDB(tmpOutput)
+		// the existing database is only replaced once the run is complete too
+		if opts.Append {
+			if err = copyDB(ctx, joinDSN(output, generatedParams), tmpOutput); err != nil {
+				return 0, err
+			}
+		}
 	}
-	for t, v := range hashedDBMap {
-		if len(v) == 0 {
-			continue
+	// the unchanged tables are reused from a copy of the previous new database
+	reusable = nil
+	var inputsDigest string
+	if opts.Incremental {
+		if inputsDigest, err = incrementalInputs(); err != nil {
+			return 0, err
 		}
-		if compareData(values, v) {
-			// these 2 tables have the same data but different number of rows
-			// looks like unit_unique_equip is deprecated and there are only 183 rows
-			if table == "unit_unique_equipment" || table == "unit_unique_equip" {
-				rowsCount := countRowsInTable(hashedDB, t)
-				if (table == "unit_unique_equipment" && rowsCount < 200) || (table == "unit_unique_equip" && rowsCount > 200) {
-					continue
-				}
+		if reusable = readReusableTables(output, inputsDigest); reusable != nil {
+			if err = copyDB(ctx, joinDSN(output, generatedParams), tmpOutput); err != nil {
+				return 0, err
 			}
-			return t, true
 		}
 	}
-
-	return "", false
-}
-
-func getFirstNRows(db *sql.DB, tableName string, n int) [][]string {
-	query := fmt.Sprintf("SELECT * FROM %s LIMIT %d", tableName, n)
-	rows, err := db.Query(query)
-	if err != nil {
-		log.Fatalf("Error querying database in table %s: %v", tableName, err)
+	for i := range partitions {
+		if partitions[i].tmp, err = createTempOutput(partitions[i].Path); err != nil {
+			return 0, err
+		}
+		defer removeDB(partitions[i].tmp)
 	}
-	defer rows.Close()
 
-	cols, err := rows.Columns()
-	if err != nil {
-		log.Fatalf("Error getting columns in table %s: %v", tableName, err)
+	code, err := run(runCtx, joinDSN(original, originalParams), olderOriginals, joinDSN(hashed, hashedParams), joinDSN(tmpOutput, generatedParams))
+	if err != nil || (code != exitSuccess && code != exitUnmatchedTables && code != exitTablesFailed) {
+		return code, err
 	}
-
-	var tableData [][]string
-	for rows.Next() {
-		columns := make([]interface{}, len(cols))
-		columnPointers := make([]interface{}, len(cols))
-		for i := range columns {
-			columnPointers[i] = &columns[i]
+	if err = commitOutput(tmpOutput, output); err != nil {
+		return 0, err
+	}
+	// a run without --resume starts over, the database it could have resumed is obsolete
+	removeDB(partialOutput(output))
+	if opts.Incremental {
+		if err = writeTableChecksums(output, inputsDigest); err != nil {
+			return 0, err
 		}
-
-		if err = rows.Scan(columnPointers...); err != nil {
-			log.Fatalf("Error scanning row in table %s: %v", tableName, err)
+	}
+	for _, p := range partitions {
+		if err = commitOutput(p.tmp, p.Path); err != nil {
+			return 0, err
 		}
+	}
 
-		var rowValues []string
-		for _, col := range columns {
-			rowValues = append(rowValues, fmt.Sprintf("%v", col))
+	if opts.Compress != "" {
+		if output, err = compressFile(output, opts.Compress); err != nil {
+			return 0, err
+		}
+		for _, p := range partitions {
+			if _, err = compressFile(p.Path, opts.Compress); err != nil {
+				return 0, err
+			}
+		}
+		if opts.GenerateTableMapping {
+			if _, err = compressFile(mappingPath, opts.Compress); err != nil {
+				return 0, err
+			}
+			if _, err = compressFile(indexMappingPath, opts.Compress); err != nil {
+				return 0, err
+			}
+		}
+		if opts.PreviousMapping != "" {
+			if _, err = compressFile(hashMappingPath, opts.Compress); err != nil {
+				return 0, err
+			}
 		}
-		tableData = append(tableData, rowValues)
 	}
-
-	return tableData
+	if isObjectURL(generatedPath) {
+		if err = uploadObject(ctx, output, generatedPath+compressionExts[opts.Compress]); err != nil {
+			return 0, err
+		}
+	}
+	if opts.IfChanged {
+		if err = writeManifest(generatedPath, manifest); err != nil {
+			return 0, err
+		}
+	}
+	return code, nil
 }
 
-func compareData(data1, data2 [][]string) bool {
-	if len(data1) != len(data2) {
-		return false
+func run(ctx context.Context, original string, olderOriginals []string, hashed, output string) (int, error) {
+	slog.Debug("starting", "version", version, "schemaGeneration", hashedPrefix, "validatedTruthVersion", knownTruthVersion)
+	renameOpts := opts.Options
+	copiedTables = nil
+	var transforms []tableTransform
+	if opts.Filter != "" {
+		tables, err := readFilterFile(opts.Filter)
+		if err != nil {
+			return 0, err
+		}
+		renameOpts.Tables = tables
+		if renameOpts.RowFilters, renameOpts.Columns, err = readTableFilters(opts.Filter); err != nil {
+			return 0, err
+		}
+		if transforms, err = readTransforms(opts.Filter); err != nil {
+			return 0, err
+		}
+		if len(transforms) > 0 && opts.ViewsInPlace {
+			return 0, invalidInputf("--viewsInPlace cannot transform the tables of %s", opts.Filter)
+		}
 	}
-
-	for i := range data1 {
-		if !reflect.DeepEqual(data1[i], data2[i]) {
-			return false
+	if opts.HashScheme != "" {
+		scheme, err := flagHashScheme()
+		if err != nil {
+			return 0, err
+		}
+		renameOpts.HashedName = func(table string) string {
+			return scheme.hashedName(hashedPrefix, table, hashedNameDigits)
+		}
+	}
+	var usedTables, usedColumns map[string]string
+	if opts.UseMapping != "" {
+		var err error
+		if usedTables, usedColumns, err = readUseMapping(opts.UseMapping); err != nil {
+			return 0, err
+		}
+		renameOpts.HashedName = func(table string) string {
+			return usedTables[table]
+		}
+	}
+	if opts.Incremental {
+		// the checksums of the copied tables are recorded for the next run
+		renameOpts.Checksums = true
+		renameOpts.Reuse = map[string]rename.ReusableTable{}
+		for name, t := range reusable {
+			renameOpts.Reuse[name] = t
+		}
+		// the rows of transformed tables are not the ones of their hashed table
+		for _, t := range transforms {
+			delete(renameOpts.Reuse, t.Table)
+		}
+	}
+	if opts.DeltaFrom != "" {
+		var err error
+		if renameOpts.PreviousChecksums, err = readPreviousChecksums(ctx, opts.DeltaFrom); err != nil {
+			return 0, err
+		}
+	}
+	var rules renameRules
+	if opts.RenameRules != "" {
+		var err error
+		if rules, err = readRenameRules(opts.RenameRules); err != nil {
+			return 0, err
+		}
+	}
+	var previousMapping map[string]string
+	if opts.PreviousMapping != "" {
+		var err error
+		if previousMapping, err = readTableMapping(opts.PreviousMapping); err != nil {
+			return 0, err
+		}
+	}
+	var translations []translation
+	if opts.Translate != "" {
+		var err error
+		if translations, err = readTranslations(ctx, opts.Translate); err != nil {
+			return 0, err
 		}
 	}
-	return true
-}
 
-func copyData(originalDB, hashedDB, newDB *sql.DB, origTable, hashedTable string) {
-	// get the CREATE TABLE statement for the original table
-	createStmt, err := getCreateTableStatement(originalDB, origTable)
-	if err != nil {
-		log.Fatalf("Error getting CREATE TABLE statement for table %s: %v", origTable, err)
+	// without the original database, --useMapping renames the tables of the hashed database alone
+	var originalDB *sql.DB
+	if original != "" {
+		var err error
+		if originalDB, err = openDB(original, opts.OriginalKey, mmapPragmas(original)...); err != nil {
+			return 0, err
+		}
+		defer originalDB.Close()
+		if err = checkInputDB(ctx, originalDB, original); err != nil {
+			return 0, fmt.Errorf("original database: %w", err)
+		}
 	}
-	log.Println(createStmt)
 
-	// create the new table in the new database
-	_, err = newDB.Exec(createStmt)
+	hashedDB, err := openDB(hashed, opts.HashedKey, mmapPragmas(hashed)...)
 	if err != nil {
-		log.Fatalf("Error creating table %s in new database: %v", origTable, err)
+		return 0, err
+	}
+	defer hashedDB.Close()
+	// the hashed database may still be written by the emulator or the extraction tool
+	if err = checkInputDB(ctx, hashedDB, hashed); err != nil {
+		return 0, fmt.Errorf("hashed database: %w", err)
 	}
 
-	// fetch data from the hashed table
-	hashedData, err := getAllData(hashedDB, hashedTable)
-	if err != nil {
-		log.Fatalf("Error fetching data from hashed table %s: %v", hashedTable, err)
+	// passing the databases the other way around is the most common mistake
+	swapped := false
+	if originalDB != nil {
+		if swapped, err = areSwapped(ctx, originalDB, hashedDB); err != nil {
+			return 0, err
+		}
+	}
+	if swapped {
+		slog.Warn("the original database has hashed table names and the hashed database readable ones, swapping them", "original", opts.HashedDBPath, "hashed", opts.OriginalDBPath)
+		addWarning("the original and hashed databases were swapped")
+		originalDB, hashedDB = hashedDB, originalDB
+		summary.OriginalDB, summary.HashedDB = summary.HashedDB, summary.OriginalDB
+	} else {
+		renameOpts.OriginalTables = originalTables
+	}
+	if err = checkCompatibility(ctx, hashedDB); err != nil {
+		return 0, fmt.Errorf("hashed database: %w", err)
+	}
+	if renameOpts.PageSize, renameOpts.Encoding, err = outputFormat(ctx, hashedDB); err != nil {
+		return 0, fmt.Errorf("hashed database: %w", err)
 	}
 
-	// copy data row by row to the new table
-	tx, err := newDB.Begin()
+	var runner tableRunner
+	var originalDBs []*sql.DB
+	if originalDB == nil {
+		runner = &mappedRunner{hashedDB: hashedDB, opts: renameOpts, tables: usedTables, columns: usedColumns}
+	} else {
+		originalRunner := rename.NewRunner(originalDB, hashedDB, renameOpts)
+		for _, older := range olderOriginals {
+			olderDB, err := openDB(older, "", mmapPragmas(older)...)
+			if err != nil {
+				return 0, err
+			}
+			defer olderDB.Close()
+			if err = checkInputDB(ctx, olderDB, older); err != nil {
+				return 0, fmt.Errorf("original database: %w", err)
+			}
+			originalRunner.AddOriginal(olderDB)
+			originalDBs = append(originalDBs, olderDB)
+		}
+		runner = originalRunner
+		originalDBs = append([]*sql.DB{originalDB}, originalDBs...)
+	}
+	if opts.ViewsInPlace {
+		outputPath, _ := splitDSN(output)
+		runner = &viewRunner{tableRunner: runner, hashedDB: hashedDB, originalDBs: originalDBs, columns: usedColumns, opts: renameOpts, output: outputPath}
+	}
+	mapping, report, err := runner.Match(ctx)
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
+	}
+	if !swapped {
+		originalTables = report.OriginalTables
 	}
-	for _, row := range hashedData {
-		insertStmt := createInsertStatement(origTable, row)
-		log.Println(insertStmt)
-		_, err = tx.Exec(insertStmt)
+	if opts.Review || opts.Interactive {
+		setter, ok := runner.(mappingSetter)
+		if !ok {
+			return 0, invalidInputf("--review and --interactive need --originalDBPath")
+		}
+		if opts.Review {
+			mapping, err = reviewMatches(ctx, os.Stdin, os.Stdout, originalDBs, hashedDB, mapping, report, len(renameOpts.Tables) == 0)
+		} else {
+			mapping, err = promptMatches(ctx, os.Stdin, os.Stdout, originalDBs, hashedDB, mapping, report, max(renameOpts.SampleRows, 1), len(renameOpts.Tables) == 0)
+		}
 		if err != nil {
-			tx.Rollback()
-			log.Fatalf("Error inserting data into new table:", err)
+			return 0, err
 		}
+		if err = setter.SetMapping(ctx, mapping); err != nil {
+			return 0, err
+		}
+	}
+	unmatched := mapping.Unmatched
+	for _, t := range unmatched {
+		slog.Warn("no matching table", "table", t)
+		addWarning("no matching table for " + t)
 	}
+	reportCandidates(unmatched, report.Candidates)
+	summary.Unmatched = unmatched
+	summary.NewTables = mapping.New
+	summary.Skipped = report.Skipped
+	summary.Durations.Read = report.ReadDuration.Seconds()
+	summary.Durations.Match = report.MatchDuration.Seconds()
 
-	if err = tx.Commit(); err != nil {
-		log.Fatal(err)
+	// in strict mode, bail out before the new database is created
+	if opts.Strict && len(unmatched) > 0 {
+		return 0, &exitError{code: exitStrictUnmatched, err: fmt.Errorf("strict mode: %w", mapping.Err())}
 	}
-}
 
-func getAllData(db *sql.DB, tableName string) ([][]string, error) {
-	query := fmt.Sprintf("SELECT * FROM %s", tableName)
-	rows, err := db.Query(query)
+	newDB, err := openNewDB(output)
 	if err != nil {
-		return nil, err
+		return 0, err
+	}
+	defer newDB.Close()
+	if reusable != nil {
+		if err = dropUnreused(ctx, newDB, report.Reused); err != nil {
+			return 0, err
+		}
 	}
-	defer rows.Close()
 
-	cols, err := rows.Columns()
+	phaseStart := time.Now()
+	copies, err := runner.Copy(ctx, newDB)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
+	var failed []rename.TableCopy
+	var renamed []rename.TableMatch
+	for _, c := range copies {
+		if c.Err != nil {
+			slog.Error("copy failed", "table", c.Name, "hashedTable", c.HashedName, "error", c.Err)
+			failed = append(failed, c)
+			summary.Failed = append(summary.Failed, failedTable{Name: c.Name, HashedName: c.HashedName, Error: c.Err.Error()})
+			continue
+		}
+		rowsPerSecond := 0.0
+		if c.Duration > 0 {
+			rowsPerSecond = float64(c.Rows) / c.Duration.Seconds()
+		}
+		// the mapping of a delta also has the tables it left out, so apply-delta drops the others
+		if !c.Missing {
+			renamed = append(renamed, c.TableMatch)
+		}
+		if !c.Missing && !c.Unchanged && c.Checksum != "" {
+			copiedTables = append(copiedTables, rename.ReusableTable{TableMatch: c.TableMatch, Checksum: c.Checksum})
+		}
+		if c.Missing {
+			slog.Debug("created table without a match", "table", c.Name)
+		} else if c.Reused {
+			slog.Debug("reused table", "table", c.Name, "hashedTable", c.HashedName, "rows", c.Rows)
+		} else if c.Unchanged {
+			slog.Debug("unchanged table", "table", c.Name, "hashedTable", c.HashedName)
+		} else if opts.SchemaOnly {
+			slog.Debug("created table", "table", c.Name, "hashedTable", c.HashedName)
+		} else if opts.ViewsInPlace {
+			slog.Debug("created view", "table", c.Name, "hashedTable", c.HashedName, "rows", c.Rows)
+		} else if c.Resumed {
+			slog.Debug("resumed table", "table", c.Name, "hashedTable", c.HashedName, "rows", c.Rows)
+		} else {
+			slog.Debug("copied table", "table", c.Name, "hashedTable", c.HashedName, "matchDuration", report.MatchDurations[c.Name], "rows", c.Rows, "copyDuration", c.Duration, "rowsPerSecond", int(rowsPerSecond))
+		}
 
-	var tableData [][]string
-	for rows.Next() {
-		columns := make([]interface{}, len(cols))
-		columnPointers := make([]interface{}, len(cols))
-		for i := range columns {
-			columnPointers[i] = &columns[i]
+		olderOriginal := ""
+		if c.Original > 0 {
+			olderOriginal = opts.OriginalDBPaths[c.Original]
 		}
+		summary.Tables = append(summary.Tables, tableSummary{
+			Name:          c.Name,
+			HashedName:    c.HashedName,
+			Confidence:    c.Confidence,
+			Rows:          c.Rows,
+			MatchSeconds:  report.MatchDurations[c.Name].Seconds(),
+			CopySeconds:   c.Duration.Seconds(),
+			RowsPerSecond: rowsPerSecond,
+			Resumed:       c.Resumed,
+			Missing:       c.Missing,
+			OlderOriginal: olderOriginal,
+			SHA256:        c.Checksum,
+			Unchanged:     c.Unchanged,
+			Reused:        c.Reused,
+			Manual:        c.Manual,
+		})
+		summary.RowsCopied += c.Rows
+	}
+	summary.Durations.Copy = time.Since(phaseStart).Seconds()
 
-		if err := rows.Scan(columnPointers...); err != nil {
-			return nil, err
+	if !opts.SkipMappingTable {
+		if err = rename.WriteMappingTable(ctx, newDB, renamed); err != nil {
+			return 0, err
 		}
+	}
 
-		var rowValues []string
-		for _, col := range columns {
-			rowValues = append(rowValues, fmt.Sprintf("%v", col))
+	if !opts.SkipMetaTable {
+		if err = writeMetaTable(ctx, newDB, renameOpts.SampleRows); err != nil {
+			return 0, err
 		}
-		tableData = append(tableData, rowValues)
 	}
 
-	return tableData, nil
-}
+	if len(translations) > 0 {
+		if summary.Translated, err = applyTranslations(ctx, newDB, translations); err != nil {
+			return 0, err
+		}
+		slog.Info("translated", "path", opts.Translate, "values", summary.Translated)
+	}
 
-func countRowsInTable(db *sql.DB, tableName string) int {
-	var count int
+	if len(transforms) > 0 {
+		if err = transformTables(ctx, newDB, transforms); err != nil {
+			return 0, err
+		}
+	}
 
-	err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", tableName)).Scan(&count)
-	if err != nil {
-		log.Fatalf("Error counting rows in table %s: %v", tableName, err)
+	if opts.CuratedViews {
+		created, err := createCuratedViews(ctx, newDB)
+		if err != nil {
+			return 0, err
+		}
+		slog.Info("created curated views", "views", created)
 	}
 
-	return count
-}
+	if len(rules) > 0 {
+		tables, columns, err := rules.apply(ctx, newDB)
+		if err != nil {
+			return 0, err
+		}
+		slog.Info("applied rename rules", "path", opts.RenameRules, "tables", tables, "columns", columns)
+	}
 
-func getCreateTableStatement(db *sql.DB, tableName string) (string, error) {
-	query := "SELECT sql FROM sqlite_master WHERE type='table' AND name=?"
-	var createStmt string
-	row := db.QueryRow(query, tableName)
-	err := row.Scan(&createStmt)
-	if err != nil {
-		return "", err
+	if len(opts.FTS) > 0 {
+		ftsTables := make([]string, len(opts.FTS))
+		for i, table := range opts.FTS {
+			ftsTables[i] = rules.TableName(table)
+		}
+		if _, err = createFTSTables(ctx, newDB, ftsTables); err != nil {
+			return 0, err
+		}
 	}
-	return createStmt, nil
-}
 
-func createInsertStatement(tableName string, rowData []string) string {
-	var formattedValues []string
+	if len(partitions) > 0 {
+		if err = partitionDB(ctx, newDB, partitions); err != nil {
+			return 0, err
+		}
+	}
 
-	for _, value := range rowData {
-		formattedValues = append(formattedValues, formatValueByType(value))
+	if err = optimizeDB(ctx, newDB); err != nil {
+		return 0, err
+	}
+	if err = writeStaged(ctx, newDB, output); err != nil {
+		return 0, err
 	}
 
-	values := strings.Join(formattedValues, ", ")
-	return fmt.Sprintf("INSERT INTO %s VALUES (%s)", tableName, values)
-}
+	if opts.GenerateTableMapping {
+		if err = writeJson(mapping.Map()); err != nil {
+			return 0, err
+		}
+		if err = writeIndexMapping(mapping.IndexMap()); err != nil {
+			return 0, err
+		}
+	}
+	if err = recordHistory(mapping.Map()); err != nil {
+		return 0, err
+	}
+	if previousMapping != nil {
+		if err = writeHashMapping(previousMapping, mapping.Map()); err != nil {
+			return 0, err
+		}
+	}
+
+	var problems []string
+	if !opts.SkipVerify {
+		phaseStart = time.Now()
+		if problems, err = rename.VerifyDB(ctx, newDB); err != nil {
+			return 0, err
+		}
+		for _, p := range problems {
+			slog.Error("verification problem", "problem", p)
+			addWarning(p)
+		}
+		// orphaned rows are reported as warnings but do not fail verification
+		orphans, err := rename.AuditOrphans(ctx, newDB)
+		if err != nil {
+			return 0, err
+		}
+		for _, p := range orphans {
+			slog.Warn("orphaned rows", "problem", p)
+			addWarning(p)
+		}
+		summary.Durations.Verify = time.Since(phaseStart).Seconds()
+	}
+
+	// a database that failed verification is not fanned out
+	if len(extraOutputs) > 0 && len(problems) == 0 {
+		if err = writeExtraOutputs(ctx, newDB, extraOutputs, mapping.Map()); err != nil {
+			return 0, err
+		}
+	}
 
-func formatValueByType(value string) string {
-	if isNumeric(value) {
-		return value
+	if len(problems) > 0 {
+		slog.Error("verification failed", "path", summary.GeneratedDB, "problems", len(problems))
+		return exitVerificationFailed, nil
 	}
 
-	escapedValue := strings.ReplaceAll(value, "'", "''")
-	return fmt.Sprintf("'%s'", escapedValue)
+	// the failures are repeated at the end, so they are not lost in the logs of the other tables
+	if len(failed) > 0 {
+		for _, c := range failed {
+			slog.Error("table failed", "table", c.Name, "error", c.Err)
+		}
+		slog.Error("some tables could not be copied", "failed", len(failed), "copied", len(copies)-len(failed), "rows", summary.RowsCopied)
+		return exitTablesFailed, nil
+	}
+
+	slog.Info("done", "tables", len(copies), "rows", summary.RowsCopied)
+	if len(unmatched) > 0 {
+		return exitUnmatchedTables, nil
+	}
+	return exitSuccess, nil
 }
 
-func isNumeric(s string) bool {
-	return numericRegex.MatchString(s)
+func writeJson(tableMapping map[string]string) error {
+	return writeTableMapping(mappingPath, tableMapping)
 }
 
-func writeJson() {
+// writeTableMapping writes the mapping of original table name -> hashed table name to path
+func writeTableMapping(path string, tableMapping map[string]string) error {
 	jsonData, err := json.MarshalIndent(tableMapping, "", "  ")
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	if _, err = rename.ParseTableMapping(jsonData); err != nil {
+		return fmt.Errorf("invalid table mapping: %w", err)
 	}
 
-	file, err := os.Create("table_mapping.json")
+	return os.WriteFile(path, jsonData, 0644)
+}
+
+// writeIndexMapping writes the mapping of original index name -> hashed index name next to the table mapping
+func writeIndexMapping(indexMapping map[string]string) error {
+	jsonData, err := json.MarshalIndent(indexMapping, "", "  ")
 	if err != nil {
-		fmt.Println(err)
-		return
+		return err
 	}
-	defer file.Close()
+	return os.WriteFile(indexMappingPath, jsonData, 0644)
+}
 
-	_, err = file.Write(jsonData)
-	if err != nil {
-		log.Fatal(err)
+// runError logs the error a run stopped with and returns its exit code
+func runError(err error) int {
+	code := exitCodeOf(err)
+	if errors.Is(err, context.Canceled) {
+		slog.Error("interrupted", "error", err, "exitCode", code)
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		slog.Error("cancelled", "error", err)
+	} else if isBusy(err) {
+		slog.Error("database is locked by another process", "error", err, "exitCode", code)
+	} else {
+		slog.Error("run failed", "error", err, "exitCode", code)
 	}
+	return code
 }
 
-func readFilterFile() {
-	file, err := os.Open(filter)
+// filterLineRegex matches a line of a filter file, <table>[(<column>, ...)] [WHERE <condition>]
+var filterLineRegex = regexp.MustCompile(`(?i)^([^\s(]+)\s*(?:\(([^)]*)\))?\s*(?:WHERE\s+(.+))?$`)
+
+// readFilterFile returns the table names listed in the file at path, one per line, each
+// optionally followed by the columns to copy in parentheses and by WHERE and the condition
+// of the rows to copy, see readTableFilters, or by AS and the query of its rows, see readTransforms
+func readFilterFile(path string) (map[string]struct{}, error) {
+	file, err := os.Open(path)
 	if err != nil {
-		log.Fatal(err)
+		return nil, invalidInputf("error opening table list: %w", err)
 	}
 	defer file.Close()
 
+	tables := map[string]struct{}{}
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		text := scanner.Text()
+		if m := transformLineRegex.FindStringSubmatch(strings.TrimSpace(text)); m != nil {
+			text = m[1]
+		} else if m = filterLineRegex.FindStringSubmatch(strings.TrimSpace(text)); m != nil {
+			text = m[1]
+		}
 		if text != "" {
-			filterTables[text] = struct{}{}
+			tables[text] = struct{}{}
 		}
 	}
 
 	if err = scanner.Err(); err != nil {
-		log.Fatalf("Error reading filter file: %v", err)
+		return nil, fmt.Errorf("error reading filter file: %w", err)
+	}
+	if len(tables) == 0 {
+		return nil, invalidInputf("filter file %s lists no tables", path)
+	}
+	return tables, nil
+}
+
+// readTableFilters returns the conditions of the rows to copy and the columns to copy of the
+// tables of the filter file at path that have them, by table name
+func readTableFilters(path string) (rowFilters map[string]string, columns map[string][]string, err error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, invalidInputf("error opening table list: %w", err)
+	}
+	rowFilters, columns = map[string]string{}, map[string][]string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		m := filterLineRegex.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		if m[2] != "" {
+			for _, column := range strings.Split(m[2], ",") {
+				if column = strings.TrimSpace(column); column != "" {
+					columns[m[1]] = append(columns[m[1]], column)
+				}
+			}
+		}
+		if m[3] != "" {
+			rowFilters[m[1]] = m[3]
+		}
 	}
+	return rowFilters, columns, nil
 }
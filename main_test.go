@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadFilterFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		// the tables of readFilterFile, and the row conditions and columns of readTableFilters
+		tables     []string
+		rowFilters map[string]string
+		columns    map[string][]string
+		code       int
+	}{
+		{
+			name:       "tables",
+			content:    "unit_data\n\n  skill_data  \n",
+			tables:     []string{"skill_data", "unit_data"},
+			rowFilters: map[string]string{},
+			columns:    map[string][]string{},
+		},
+		{
+			name:       "row conditions",
+			content:    "quest_data WHERE quest_id > 100\nunit_data where rarity IN (1, 2)\n",
+			tables:     []string{"quest_data", "unit_data"},
+			rowFilters: map[string]string{"quest_data": "quest_id > 100", "unit_data": "rarity IN (1, 2)"},
+			columns:    map[string][]string{},
+		},
+		{
+			name:       "columns",
+			content:    "skill_data (skill_id, name)\nequipment_data(equipment_id,  equipment_name ,) WHERE promotion_level >= 3\n",
+			tables:     []string{"equipment_data", "skill_data"},
+			rowFilters: map[string]string{"equipment_data": "promotion_level >= 3"},
+			columns:    map[string][]string{"skill_data": {"skill_id", "name"}, "equipment_data": {"equipment_id", "equipment_name"}},
+		},
+		{
+			name:       "transformed table",
+			content:    "unit_data\nstory_detail AS SELECT * FROM story_detail WHERE story_id < 10\n",
+			tables:     []string{"story_detail", "unit_data"},
+			rowFilters: map[string]string{},
+			columns:    map[string][]string{},
+		},
+		{name: "no table", content: "\n\n", code: exitInvalidInput},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "filter.txt")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			tables, err := readFilterFile(path)
+			if tt.code != 0 {
+				if code := exitCodeOf(err); code != tt.code {
+					t.Errorf("readFilterFile error = %v, exit code %d, want %d", err, code, tt.code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := sortedKeys(tables); !reflect.DeepEqual(got, tt.tables) {
+				t.Errorf("tables = %v, want %v", got, tt.tables)
+			}
+
+			rowFilters, columns, err := readTableFilters(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(rowFilters, tt.rowFilters) {
+				t.Errorf("row conditions = %v, want %v", rowFilters, tt.rowFilters)
+			}
+			if !reflect.DeepEqual(columns, tt.columns) {
+				t.Errorf("columns = %v, want %v", columns, tt.columns)
+			}
+		})
+	}
+}
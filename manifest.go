@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// manifestSuffix is appended to the path of the new database for the manifest of the run that wrote it
+const manifestSuffix = ".manifest.json"
+
+// errUpToDate is returned by generate when --ifChanged skips a run whose inputs and flags did not change
+var errUpToDate = errors.New("the new database is up to date")
+
+// ignoredManifestFlags change how a run goes but not what it writes, so they are left out of the manifest
+var ignoredManifestFlags = map[string]struct{}{
+	"force": {}, "ifChanged": {}, "webhook": {}, "discordWebhook": {},
+	"logLevel": {}, "logFormat": {}, "verbose": {}, "noColor": {}, "lang": {},
+	"cpuProfile": {}, "memProfile": {}, "pprofAddr": {}, "traceSql": {},
+	"workers": {}, "readWorkers": {}, "maxMemory": {}, "staging": {},
+	"busyRetries": {}, "busyBackoff": {}, "queryTimeout": {}, "nice": {}, "niceRate": {},
+}
+
+// manifestFlags are the flags of the manifest, set by recordManifestFlags
+var manifestFlags []string
+
+// runManifest records what a run wrote its outputs from, next to the new database
+type runManifest struct {
+	Version string `json:"version"`
+	// OriginalDBs are the SHA-256 of the original databases, newest first
+	OriginalDBs []string `json:"originalDBs"`
+	HashedDB    string   `json:"hashedDB"`
+	// Files are the SHA-256 of the files of the flags, e.g. --filter, by path
+	Files map[string]string `json:"files,omitempty"`
+	// Flags are the flags set on the command line, with the SHA-256 of the secrets
+	Flags []string `json:"flags"`
+}
+
+// recordManifestFlags sets manifestFlags to the flags set on the command line that change the outputs
+func recordManifestFlags(cmd *cobra.Command) {
+	manifestFlags = nil
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		if _, ok := ignoredManifestFlags[f.Name]; ok {
+			return
+		}
+		values := []string{f.Value.String()}
+		if slice, ok := f.Value.(pflag.SliceValue); ok {
+			values = slice.GetSlice()
+		}
+		for _, v := range values {
+			if _, ok := secretFlags[f.Name]; ok {
+				sum := sha256.Sum256([]byte(v))
+				v = "sha256:" + hex.EncodeToString(sum[:])
+			}
+			manifestFlags = append(manifestFlags, "--"+f.Name+"="+strconv.Quote(v))
+		}
+	})
+	sort.Strings(manifestFlags)
+}
+
+// newRunManifest returns the manifest of the run, once its inputs are prepared
+func newRunManifest() (runManifest, error) {
+	m := runManifest{
+		Version:     version,
+		OriginalDBs: []string{summary.OriginalDB.SHA256},
+		HashedDB:    summary.HashedDB.SHA256,
+		Files:       map[string]string{},
+		Flags:       manifestFlags,
+	}
+	for _, f := range summary.OlderOriginalDBs {
+		m.OriginalDBs = append(m.OriginalDBs, f.SHA256)
+	}
+	for _, path := range []string{opts.Filter, opts.Translate, opts.Blacklist, opts.RenameRules, opts.Partition, opts.UseMapping, opts.PreviousMapping, opts.DeltaFrom} {
+		if path == "" {
+			continue
+		}
+		info, err := newInputFile(path)
+		if err != nil {
+			return m, err
+		}
+		m.Files[path] = info.SHA256
+	}
+	return m, nil
+}
+
+// upToDate returns whether every output exists and the manifest of the new database at path
+// is m, so running again would write the same outputs
+func upToDate(path string, outputs []string, m runManifest) bool {
+	for _, output := range outputs {
+		if _, err := os.Stat(output + compressionExts[opts.Compress]); err != nil {
+			return false
+		}
+	}
+	previous, err := os.ReadFile(path + manifestSuffix)
+	if err != nil {
+		return false
+	}
+	current, err := json.MarshalIndent(m, "", "  ")
+	return err == nil && bytes.Equal(bytes.TrimSpace(previous), current)
+}
+
+// writeManifest writes m next to the new database at path, for the next run with --ifChanged
+func writeManifest(path string, m runManifest) error {
+	jsonData, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(path+manifestSuffix, jsonData, 0644); err != nil {
+		return err
+	}
+	slog.Debug("wrote manifest", "path", path+manifestSuffix)
+	return nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/peterli110/pcr-hash-table-rename/pkg/rename"
+)
+
+// tableRunner matches the tables of the hashed database and copies them into the new one
+type tableRunner interface {
+	Match(ctx context.Context) (rename.Mapping, rename.Report, error)
+	Copy(ctx context.Context, newDB *sql.DB) ([]rename.TableCopy, error)
+}
+
+// readUseMapping reads the trusted mapping of --useMapping, either a table mapping of
+// --generateTableMapping or a lookup table of precompute, which also names the columns
+func readUseMapping(path string) (tables, columns map[string]string, err error) {
+	jsonData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, invalidInputf("error opening mapping: %w", err)
+	}
+	var lookup struct {
+		Scheme  *hashScheme       `json:"scheme"`
+		Tables  json.RawMessage   `json:"tables"`
+		Columns map[string]string `json:"columns"`
+	}
+	if json.Unmarshal(jsonData, &lookup) == nil && lookup.Scheme != nil {
+		jsonData = lookup.Tables
+		columns = lookup.Columns
+	}
+
+	entries, err := rename.ParseTableMapping(jsonData)
+	if err != nil {
+		if lookup.Scheme != nil {
+			return nil, nil, invalidInputf("invalid lookup table %s: tables: %w", path, err)
+		}
+		return nil, nil, invalidInputf("invalid table mapping %s: %w", path, err)
+	}
+	if columns == nil {
+		columns = map[string]string{}
+	}
+	tables = make(map[string]string, len(entries))
+	for name, entry := range entries {
+		tables[name] = entry.HashedName
+		for column, hashedColumn := range entry.Columns {
+			columns[column] = hashedColumn
+		}
+	}
+	return tables, columns, nil
+}
+
+// mappedRunner renames the tables of the hashed database with a trusted mapping, without
+// the original database. The tables keep the schema of the hashed database, with the
+// columns of the mapping renamed.
+type mappedRunner struct {
+	hashedDB *sql.DB
+	opts     rename.Options
+	// tables and columns map readable names to hashed names
+	tables, columns map[string]string
+
+	mapping rename.Mapping
+}
+
+func (r *mappedRunner) Match(ctx context.Context) (rename.Mapping, rename.Report, error) {
+	mapping := rename.Mapping{Tables: []rename.TableMatch{}, Unmatched: []string{}, New: []string{}}
+	report := rename.Report{Skipped: []string{}, Candidates: map[string][]rename.Candidate{}, MatchDurations: map[string]time.Duration{}}
+
+	start := time.Now()
+	hashedTables, err := rename.GetTableNames(ctx, r.hashedDB, false)
+	if err != nil {
+		return mapping, report, err
+	}
+	exists := map[string]struct{}{}
+	for _, t := range hashedTables {
+		exists[t] = struct{}{}
+	}
+	report.ReadDuration = time.Since(start)
+
+	start = time.Now()
+	matchedHashed := map[string]struct{}{}
+	for _, t := range sortedKeys(r.tables) {
+		_, included := r.opts.Tables[t]
+		_, excluded := r.opts.Exclude[t]
+		if (len(r.opts.Tables) > 0 && !included) || excluded {
+			report.Skipped = append(report.Skipped, t)
+			continue
+		}
+		if _, ok := exists[r.tables[t]]; !ok {
+			mapping.Unmatched = append(mapping.Unmatched, t)
+			continue
+		}
+		mapping.Tables = append(mapping.Tables, rename.TableMatch{Name: t, HashedName: r.tables[t], Confidence: 1})
+		matchedHashed[r.tables[t]] = struct{}{}
+	}
+	if len(r.opts.Tables) == 0 {
+		for _, t := range hashedTables {
+			if _, ok := matchedHashed[t]; !ok {
+				mapping.New = append(mapping.New, t)
+			}
+		}
+	}
+	report.MatchDuration = time.Since(start)
+
+	r.mapping = mapping
+	return mapping, report, nil
+}
+
+func (r *mappedRunner) Copy(ctx context.Context, newDB *sql.DB) ([]rename.TableCopy, error) {
+	if r.opts.OrderByPK {
+		ctx = rename.WithPrimaryKeyOrder(ctx)
+	}
+	if r.opts.LimitRows > 0 {
+		ctx = rename.WithRowLimit(ctx, r.opts.LimitRows)
+	}
+	if err := rename.ApplyPragmas(ctx, newDB, r.opts.NewDBPragmas()); err != nil {
+		return nil, err
+	}
+
+	copies := make([]rename.TableCopy, 0, len(r.mapping.Tables))
+	for _, match := range r.mapping.Tables {
+		start := time.Now()
+		var checksum string
+		if r.opts.Checksums || r.opts.PreviousChecksums != nil {
+			var err error
+			if checksum, err = rename.TableChecksum(ctx, r.hashedDB, match.HashedName); err != nil {
+				return copies, err
+			}
+			if previous, ok := r.opts.PreviousChecksums[match.Name]; ok && previous == checksum {
+				copies = append(copies, rename.TableCopy{TableMatch: match, Checksum: checksum, Unchanged: true})
+				continue
+			}
+		}
+
+		// the rows and columns are filtered as they are read from the hashed table
+		tableCtx := ctx
+		where, filtered := r.opts.RowFilters[match.Name]
+		if columns, restricted := r.opts.Columns[match.Name]; filtered || restricted {
+			tableCtx = rename.WithSelection(ctx, rename.Selection{Where: where, Columns: columns})
+		}
+		createStmt, err := r.createStatement(ctx, match)
+		var rows int
+		if err == nil && r.opts.SchemaOnly {
+			err = rename.CreateTableAs(tableCtx, newDB, createStmt, match.Name)
+		} else if err == nil {
+			rows, err = rename.CopyDataAs(tableCtx, r.hashedDB, newDB, createStmt, match.Name, match.HashedName)
+		}
+		// without the original database, the indexes keep their hashed names
+		if err == nil && !r.opts.SkipIndexes {
+			var columns []string
+			if columns, err = r.columnNames(ctx, match); err == nil {
+				err = rename.CopyIndexesAs(tableCtx, r.hashedDB, newDB, match, columns)
+			}
+		}
+		if err != nil && (r.opts.FailFast || ctx.Err() != nil) {
+			return copies, err
+		}
+		copies = append(copies, rename.TableCopy{TableMatch: match, Rows: rows, Duration: time.Since(start), Err: err, Checksum: checksum})
+	}
+	return copies, nil
+}
+
+// columnNames returns the names of the columns of the hashed table of match in the new database,
+// with the columns of the mapping renamed
+func (r *mappedRunner) columnNames(ctx context.Context, match rename.TableMatch) ([]string, error) {
+	columns, err := rename.GetColumnNames(ctx, r.hashedDB, match.HashedName)
+	if err != nil {
+		return nil, &rename.ErrCopyFailed{Table: match.Name, Err: err}
+	}
+	readable := make(map[string]string, len(r.columns))
+	for column, hashedColumn := range r.columns {
+		readable[hashedColumn] = column
+	}
+	for i, column := range columns {
+		if name, ok := readable[column]; ok {
+			columns[i] = name
+		}
+	}
+	return columns, nil
+}
+
+// createStatement returns the CREATE TABLE statement of the hashed table of match,
+// renamed to its readable name and with the columns of the mapping renamed
+func (r *mappedRunner) createStatement(ctx context.Context, match rename.TableMatch) (string, error) {
+	var createStmt string
+	err := r.hashedDB.QueryRowContext(ctx, "SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", match.HashedName).Scan(&createStmt)
+	if err != nil {
+		return "", &rename.ErrCopyFailed{Table: match.Name, Err: fmt.Errorf("error getting CREATE TABLE statement: %w", err)}
+	}
+
+	createStmt = tableNameRegex.ReplaceAllLiteralString(createStmt, "CREATE TABLE "+quoteIdentifier(match.Name))
+	for column, hashedColumn := range r.columns {
+		columnRegex := regexp.MustCompile("[\"'`\\[]?\\b" + regexp.QuoteMeta(hashedColumn) + "\\b[\"'`\\]]?")
+		createStmt = columnRegex.ReplaceAllLiteralString(createStmt, quoteIdentifier(column))
+	}
+	return createStmt, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// signatureExt is appended to the URL of a published mapping to get its signature
+const signatureExt = ".sig"
+
+func newMappingFetchCmd() *cobra.Command {
+	var url, publicKey, output, version string
+	var skipSignature bool
+
+	cmd := &cobra.Command{
+		Use:   "fetch",
+		Short: "Download a community-published table mapping",
+		Long: `Download the table mapping of a truth version published at --url, where {region} and {version}
+are replaced, e.g. https://raw.githubusercontent.com/<user>/<repo>/main/{region}/{version}.json.
+The mapping is verified against its ed25519 signature at the same URL followed by .sig with
+--publicKey (see mapping publish and mapping keygen), saved to use with --useMapping, and recorded in the mapping history.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if publicKey == "" && !skipSignature {
+				fatalf(exitInvalidInput, "--publicKey is required to verify the mapping, unless --skipSignature is set")
+			}
+			if output == "" {
+				output = version + "_" + mappingFile
+			}
+			mappingURL := strings.NewReplacer("{region}", opts.Region, "{version}", version).Replace(url)
+
+			jsonData, err := fetchMapping(cmd.Context(), mappingURL, publicKey, skipSignature)
+			if err != nil {
+				fatalf(exitCodeOf(err), "Error fetching mapping: %v", err)
+			}
+			if err = os.WriteFile(output, jsonData, 0644); err != nil {
+				fatalf(exitInternalError, "Error writing %s: %v", output, err)
+			}
+			tables, _, err := readUseMapping(output)
+			if err != nil {
+				os.Remove(output)
+				fatalf(exitInvalidInput, "Error reading fetched mapping: %v", err)
+			}
+			slog.Info("fetched mapping", "url", mappingURL, "path", output, "tables", len(tables))
+
+			if !opts.NoHistory {
+				path, err := writeHistory(historyEntry{TruthVersion: version, Region: opts.Region, RecordedAt: time.Now().UTC(), Tables: tables})
+				if err != nil {
+					fatalf(exitInternalError, "Error recording mapping history: %v", err)
+				}
+				slog.Info("recorded mapping history", "path", path)
+			}
+		},
+	}
+	cmd.Flags().StringVar(&version, "version", "", "REQUIRED: Truth version of the mapping")
+	cmd.Flags().StringVar(&url, "url", "", "REQUIRED: URL of the published mappings, where {region} and {version} are replaced")
+	cmd.Flags().StringVar(&publicKey, "publicKey", "", "OPTIONAL: Base64 ed25519 public key of the publisher, required unless --skipSignature is set")
+	cmd.Flags().BoolVar(&skipSignature, "skipSignature", false, "OPTIONAL: Do not verify the signature of the mapping")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "OPTIONAL: Path of the downloaded mapping, default to <version>_"+mappingFile)
+	cmd.Flags().StringVar(&opts.Region, "region", "jp", "OPTIONAL: Region of the mapping")
+	cmd.Flags().StringVar(&opts.HistoryDir, "historyDir", "", "OPTIONAL: Directory of the mapping history, default to pcr-hash-table-rename/history in the user config directory")
+	cmd.Flags().BoolVar(&opts.NoHistory, "noHistory", false, "OPTIONAL: Do not record the mapping in the mapping history")
+	_ = cmd.MarkFlagRequired("version")
+	_ = cmd.MarkFlagRequired("url")
+
+	return cmd
+}
+
+// fetchMapping downloads the mapping at url and verifies its signature with publicKey
+func fetchMapping(ctx context.Context, url, publicKey string, skipSignature bool) ([]byte, error) {
+	jsonData, ok, err := httpGet(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, invalidInputf("no mapping found at %s", url)
+	}
+	if skipSignature {
+		slog.Warn("not verifying the signature of the mapping", "url", url)
+		return jsonData, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, invalidInputf("invalid public key, expected %d base64 encoded bytes", ed25519.PublicKeySize)
+	}
+	encodedSignature, ok, err := httpGet(ctx, url+signatureExt)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, invalidInputf("no signature found at %s", url+signatureExt)
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encodedSignature)))
+	if err != nil || !ed25519.Verify(key, jsonData, signature) {
+		return nil, invalidInputf("invalid signature of %s", url)
+	}
+	return jsonData, nil
+}
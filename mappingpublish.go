@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func newMappingPublishCmd() *cobra.Command {
+	var remote, branch, version, privateKeyFile, author string
+
+	cmd := &cobra.Command{
+		Use:   "publish <table mapping>",
+		Short: "Commit and push a table mapping to a git repository",
+		Long: `Commit a table mapping as {region}/{version}.json to a git repository and push it, so a nightly
+job generating mappings can also distribute them. With --privateKeyFile, the mapping is signed into
+{region}/{version}.json.sig, to be verified by mapping fetch with the public key of mapping keygen.
+The repository is cloned from --remote into a temporary directory, git must be installed.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			tables, _, err := readUseMapping(args[0])
+			if err != nil {
+				fatalf(exitInvalidInput, "%v", err)
+			}
+			var privateKey ed25519.PrivateKey
+			if privateKeyFile != "" {
+				if privateKey, err = readPrivateKey(privateKeyFile); err != nil {
+					fatalf(exitInvalidInput, "%v", err)
+				}
+			}
+			if err = publishMapping(cmd.Context(), args[0], remote, branch, version, author, privateKey); err != nil {
+				fatalf(exitCodeOf(err), "Error publishing mapping: %v", err)
+			}
+			slog.Info("published mapping", "remote", remote, "branch", branch, "region", opts.Region, "version", version, "tables", len(tables))
+		},
+	}
+	cmd.Flags().StringVar(&remote, "remote", "", "REQUIRED: Git remote to publish to")
+	cmd.Flags().StringVar(&version, "version", "", "REQUIRED: Truth version of the mapping")
+	cmd.Flags().StringVar(&branch, "branch", "main", "OPTIONAL: Branch to publish to")
+	cmd.Flags().StringVar(&privateKeyFile, "privateKeyFile", "", "OPTIONAL: File holding the base64 ed25519 private key of mapping keygen, to sign the mapping")
+	cmd.Flags().StringVar(&author, "author", "", "OPTIONAL: Author of the commit, \"Name <email>\", default to the git configuration")
+	cmd.Flags().StringVar(&opts.Region, "region", "jp", "OPTIONAL: Region of the mapping")
+	_ = cmd.MarkFlagRequired("remote")
+	_ = cmd.MarkFlagRequired("version")
+
+	return cmd
+}
+
+func newMappingKeygenCmd() *cobra.Command {
+	var privateKeyFile string
+
+	cmd := &cobra.Command{
+		Use:   "keygen",
+		Short: "Generate a key pair to sign published mappings",
+		Long: `Generate an ed25519 key pair, write the private key to --privateKeyFile for mapping publish and
+print the public key to give to mapping fetch.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			publicKey, privateKey, err := ed25519.GenerateKey(nil)
+			if err != nil {
+				fatalf(exitInternalError, "Error generating key: %v", err)
+			}
+			// O_EXCL so an existing key is never lost
+			file, err := os.OpenFile(privateKeyFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+			if err != nil {
+				fatalf(exitInvalidInput, "Error creating %s: %v", privateKeyFile, err)
+			}
+			_, err = file.WriteString(base64.StdEncoding.EncodeToString(privateKey) + "\n")
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				fatalf(exitInternalError, "Error writing %s: %v", privateKeyFile, err)
+			}
+			fmt.Println(base64.StdEncoding.EncodeToString(publicKey))
+		},
+	}
+	cmd.Flags().StringVar(&privateKeyFile, "privateKeyFile", "mapping_key", "OPTIONAL: File to write the private key to")
+
+	return cmd
+}
+
+func readPrivateKey(path string) (ed25519.PrivateKey, error) {
+	encoded, err := os.ReadFile(path)
+	if err != nil {
+		return nil, invalidInputf("error opening private key: %w", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil || len(key) != ed25519.PrivateKeySize {
+		return nil, invalidInputf("invalid private key in %s, expected %d base64 encoded bytes", path, ed25519.PrivateKeySize)
+	}
+	return key, nil
+}
+
+// publishMapping commits the mapping at path and its signature, if privateKey is set,
+// to branch of remote and pushes it
+func publishMapping(ctx context.Context, path, remote, branch, version, author string, privateKey ed25519.PrivateKey) error {
+	jsonData, err := os.ReadFile(path)
+	if err != nil {
+		return invalidInputf("error opening mapping: %w", err)
+	}
+	// never publish a mapping that mapping fetch would reject
+	if _, _, err = readUseMapping(path); err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp("", "pcr_publish_*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	if err = git(ctx, "", "clone", "--depth", "1", "--branch", branch, remote, dir); err != nil {
+		return err
+	}
+	name := filepath.Join(opts.Region, version+".json")
+	if err = os.MkdirAll(filepath.Join(dir, opts.Region), 0755); err != nil {
+		return err
+	}
+	if err = os.WriteFile(filepath.Join(dir, name), jsonData, 0644); err != nil {
+		return err
+	}
+	files := []string{name}
+	if privateKey != nil {
+		signature := base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, jsonData))
+		if err = os.WriteFile(filepath.Join(dir, name+signatureExt), []byte(signature+"\n"), 0644); err != nil {
+			return err
+		}
+		files = append(files, name+signatureExt)
+	}
+
+	if err = git(ctx, dir, append([]string{"add", "--"}, files...)...); err != nil {
+		return err
+	}
+	commit := []string{"commit", "-m", fmt.Sprintf("Add %s table mapping of truth version %s", opts.Region, version)}
+	if author != "" {
+		commit = append(commit, "--author", author)
+	}
+	if err = git(ctx, dir, commit...); err != nil {
+		return err
+	}
+	return git(ctx, dir, "push", "origin", branch)
+}
+
+// git runs a git command in dir, returning its output in the error if it fails
+func git(ctx context.Context, dir string, args ...string) error {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
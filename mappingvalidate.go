@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/peterli110/pcr-hash-table-rename/pkg/rename"
+	"github.com/spf13/cobra"
+)
+
+func newMappingValidateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "validate <mapping>...",
+		Short: "Validate table mappings against the mapping schema",
+		Long: `Validate table mappings or lookup tables against the JSON Schema of the table mapping, printing
+the location of the first error of every invalid file. Exits with 2 if a file is invalid.`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			invalid := 0
+			for _, path := range args {
+				tables, _, err := readUseMapping(path)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					invalid++
+					continue
+				}
+				fmt.Printf("%s: valid, %d tables\n", path, len(tables))
+			}
+			if invalid > 0 {
+				fatalf(exitInvalidInput, "%d of %d mappings are invalid", invalid, len(args))
+			}
+		},
+	}
+
+	return cmd
+}
+
+func newMappingSchemaCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "schema",
+		Short: "Print the JSON Schema of the table mapping",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Print(string(rename.TableMappingSchema))
+		},
+	}
+
+	return cmd
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// byteUnits are the units of --maxMemory, by lower case suffix
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30},
+	{"kb", 1000}, {"mb", 1000 * 1000}, {"gb", 1000 * 1000 * 1000},
+	{"k", 1 << 10}, {"m", 1 << 20}, {"g", 1 << 30},
+	{"b", 1},
+}
+
+// parseByteSize parses a size like 512MiB, 1G or 1048576
+func parseByteSize(s string) (int64, error) {
+	lower := strings.ToLower(strings.TrimSpace(s))
+	unit := int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(lower, u.suffix) {
+			lower, unit = strings.TrimSpace(strings.TrimSuffix(lower, u.suffix)), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(lower, 64)
+	if err != nil || n < 0 {
+		return 0, invalidInputf("invalid size %s, expected e.g. 512MiB or 1G", s)
+	}
+	return int64(n * float64(unit)), nil
+}
+
+// applyMaxMemory sets the memory budget of --maxMemory: the Go runtime collects garbage harder
+// as it is approached, and tables are copied in chunks of a quarter of it, as their rows take
+// about twice their size in memory once read, and as much again as INSERT statements
+func applyMaxMemory() error {
+	if opts.MaxMemoryFlag == "" {
+		return nil
+	}
+	budget, err := parseByteSize(opts.MaxMemoryFlag)
+	if err != nil {
+		return err
+	}
+	if budget < 1<<20 {
+		return invalidInputf("--maxMemory %s is below the minimum of 1MiB", opts.MaxMemoryFlag)
+	}
+	debug.SetMemoryLimit(budget)
+	opts.MaxMemory = budget / 4
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		size string
+		want int64
+		code int
+	}{
+		{size: "1048576", want: 1 << 20},
+		{size: "512MiB", want: 512 << 20},
+		{size: "512mib", want: 512 << 20},
+		{size: "1G", want: 1 << 30},
+		{size: "1GB", want: 1000 * 1000 * 1000},
+		{size: "1.5KiB", want: 1536},
+		{size: " 2 MB ", want: 2 * 1000 * 1000},
+		{size: "10b", want: 10},
+		{size: "0", want: 0},
+		{size: "", code: exitInvalidInput},
+		{size: "MiB", code: exitInvalidInput},
+		{size: "-1G", code: exitInvalidInput},
+		{size: "1TiB", code: exitInvalidInput},
+	}
+	for _, tt := range tests {
+		t.Run(tt.size, func(t *testing.T) {
+			got, err := parseByteSize(tt.size)
+			if tt.code != 0 {
+				if code := exitCodeOf(err); code != tt.code {
+					t.Errorf("parseByteSize(%q) error = %v, exit code %d, want %d", tt.size, err, code, tt.code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("parseByteSize(%q) = %d, want %d", tt.size, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// tableNameRegex matches the table name of a CREATE TABLE statement, quoted or not
+var tableNameRegex = regexp.MustCompile("(?i)^CREATE TABLE\\s+(\"(?:[^\"]|\"\")*\"|'(?:[^']|'')*'|\\[[^\\]]*\\]|`[^`]*`|[^\\s(]+)")
+
+// virtualTableRegex matches the table name and the module of a CREATE VIRTUAL TABLE statement
+var virtualTableRegex = regexp.MustCompile("(?i)^CREATE VIRTUAL TABLE\\s+(\"(?:[^\"]|\"\")*\"|'(?:[^']|'')*'|\\[[^\\]]*\\]|`[^`]*`|[^\\s(]+)\\s+USING\\s+(\\w+)")
+
+// ftsContentRegex matches the content option of an FTS5 table, the table it indexes
+var ftsContentRegex = regexp.MustCompile(`(?i)\bcontent\s*=\s*('(?:[^']|'')*'|"(?:[^"]|"")*"|[^\s,)]+)`)
+
+// ftsShadowTables are the suffixes of the shadow tables an FTS5 table keeps its index in,
+// created along with the table
+var ftsShadowTables = []string{"_data", "_idx", "_content", "_docsize", "_config"}
+
+type mergeSource struct {
+	suffix string
+	path   string
+}
+
+func newMergeCmd() *cobra.Command {
+	var output string
+	var force bool
+
+	cmd := &cobra.Command{
+		Use:   "merge <suffix>=<database>...",
+		Short: "Merge generated databases of several regions into one database",
+		Long: `Merge generated databases, e.g. of several regions, into one database in which every table
+is suffixed with the name given to its database, so they can be compared in a single query.
+jp=jp_fixed.db cn=cn_fixed.db gives unit_data_jp and unit_data_cn. The FTS5 tables of --fts are
+indexed again over the suffixed tables, other virtual tables cannot be merged.`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			sources, err := parseMergeSources(args)
+			if err == nil {
+				err = checkDriver()
+			}
+			if err == nil && !force {
+				err = checkOutput(cmd.Context(), output)
+			}
+			if err != nil {
+				fatalf(exitInvalidInput, "%v", err)
+			}
+
+			// like generated databases, the merged one is renamed into place once complete
+			tmp, err := createTempOutput(output)
+			if err == nil {
+				err = mergeDatabases(cmd.Context(), tmp, sources)
+			}
+			if err == nil {
+				err = commitOutput(tmp, output)
+			}
+			if err != nil {
+				removeDB(tmp)
+				fatalf(exitCodeOf(err), "Error merging databases: %v", err)
+			}
+			slog.Info("merged databases", "path", output, "databases", len(sources))
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "merged.db", "OPTIONAL: Path of the merged database")
+	cmd.Flags().BoolVar(&force, "force", false, "OPTIONAL: Replace the merged database if it already exists")
+	cmd.Flags().StringVar(&opts.Driver, "driver", sqliteDriver, driverUsage)
+
+	return cmd
+}
+
+func parseMergeSources(args []string) ([]mergeSource, error) {
+	var sources []mergeSource
+	suffixes := map[string]struct{}{}
+	for _, arg := range args {
+		suffix, path, ok := strings.Cut(arg, "=")
+		if !ok || suffix == "" || path == "" {
+			return nil, invalidInputf("invalid database %s, expected <suffix>=<database>", arg)
+		}
+		if _, ok = suffixes[suffix]; ok {
+			return nil, invalidInputf("suffix %s is used twice", suffix)
+		}
+		if _, err := os.Stat(path); err != nil {
+			return nil, invalidInputf("error opening %s: %w", path, err)
+		}
+		suffixes[suffix] = struct{}{}
+		sources = append(sources, mergeSource{suffix: suffix, path: path})
+	}
+	return sources, nil
+}
+
+// mergeDatabases copies every table of the sources into the database at path, suffixed
+func mergeDatabases(ctx context.Context, path string, sources []mergeSource) error {
+	db, err := openDB(path, "")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// attached databases only exist on the connection that attached them
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for _, source := range sources {
+		if _, err = conn.ExecContext(ctx, "ATTACH DATABASE ? AS source", source.path); err != nil {
+			return fmt.Errorf("error attaching %s: %w", source.path, err)
+		}
+		tables, err := mergeSourceTables(ctx, conn, source)
+		if _, detachErr := conn.ExecContext(ctx, "DETACH DATABASE source"); err == nil {
+			err = detachErr
+		}
+		if err != nil {
+			return err
+		}
+		slog.Info("merged database", "path", source.path, "suffix", source.suffix, "tables", tables)
+	}
+	return nil
+}
+
+// mergeSourceTables copies the tables of the attached source database and returns their number
+func mergeSourceTables(ctx context.Context, conn *sql.Conn, source mergeSource) (int, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT name, sql FROM source.sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'")
+	if err != nil {
+		return 0, fmt.Errorf("error reading tables of %s: %w", source.path, err)
+	}
+	var names, statements []string
+	for rows.Next() {
+		var name, statement string
+		if err = rows.Scan(&name, &statement); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		names = append(names, name)
+		statements = append(statements, statement)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// the shadow tables of the FTS5 tables are created with their merged FTS5 table, not copied
+	shadow := map[string]struct{}{}
+	for i, name := range names {
+		if virtualTableRegex.MatchString(statements[i]) {
+			for _, suffix := range ftsShadowTables {
+				shadow[name+suffix] = struct{}{}
+			}
+		}
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	merged := 0
+	var rebuilt []string
+	for i, name := range names {
+		if _, ok := shadow[name]; ok {
+			continue
+		}
+		table := quoteIdentifier(name + "_" + source.suffix)
+		create, external, err := mergedCreateStatement(statements[i], table, source.suffix)
+		if err != nil {
+			return 0, fmt.Errorf("table %s of %s: %w", name, source.path, err)
+		}
+		if _, err = tx.ExecContext(ctx, create); err != nil {
+			if strings.Contains(err.Error(), "no such module") {
+				return 0, fmt.Errorf("merging the full-text search table %s needs SQLite with FTS5, build with -tags sqlite_fts5 or use --driver sqlite: %w", name, err)
+			}
+			return 0, fmt.Errorf("error creating table %s: %w", table, err)
+		}
+		merged++
+		// an external content FTS5 table holds no rows, its index is rebuilt from the merged content table
+		if external {
+			rebuilt = append(rebuilt, table)
+			continue
+		}
+		if _, err = tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO main.%s SELECT * FROM source.%s", table, quoteIdentifier(name))); err != nil {
+			return 0, fmt.Errorf("error copying table %s of %s: %w", name, source.path, err)
+		}
+	}
+	for _, table := range rebuilt {
+		if _, err = tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO main.%s(%s) VALUES ('rebuild')", table, table)); err != nil {
+			return 0, fmt.Errorf("error indexing %s: %w", table, err)
+		}
+	}
+	return merged, tx.Commit()
+}
+
+// mergedCreateStatement returns the CREATE statement of the table of createStmt renamed to table, a quoted
+// identifier, and whether it is an FTS5 table with external content, whose content table, in the same database,
+// is renamed with the same suffix. Virtual tables of other modules and contentless FTS5 tables cannot be merged.
+func mergedCreateStatement(createStmt, table, suffix string) (string, bool, error) {
+	match := virtualTableRegex.FindStringSubmatchIndex(createStmt)
+	if match == nil {
+		return tableNameRegex.ReplaceAllLiteralString(createStmt, "CREATE TABLE "+table), false, nil
+	}
+	if module := createStmt[match[4]:match[5]]; !strings.EqualFold(module, "fts5") {
+		return "", false, fmt.Errorf("cannot merge a virtual table of module %s, only FTS5 tables can be merged", module)
+	}
+	create := "CREATE VIRTUAL TABLE " + table + createStmt[match[3]:]
+	content := ftsContentRegex.FindStringSubmatchIndex(create)
+	if content == nil {
+		return create, false, nil
+	}
+	contentTable := unquoteIdentifier(create[content[2]:content[3]])
+	if contentTable == "" {
+		return "", false, errors.New("cannot merge a contentless FTS5 table, its text is not stored")
+	}
+	return create[:content[2]] + quoteIdentifier(contentTable+"_"+suffix) + create[content[3]:], true, nil
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// unquoteIdentifier returns the name of a quoted or bare SQL identifier or string
+func unquoteIdentifier(name string) string {
+	if len(name) >= 2 {
+		switch q := name[0]; {
+		case q == '"' || q == '\'' || q == '`':
+			if name[len(name)-1] == q {
+				return strings.ReplaceAll(name[1:len(name)-1], string(q)+string(q), string(q))
+			}
+		case q == '[' && name[len(name)-1] == ']':
+			return name[1 : len(name)-1]
+		}
+	}
+	return name
+}
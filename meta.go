@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// metaTable records how the new database was produced
+const metaTable = "_meta"
+
+// secretFlags are left out of the flags recorded in metaTable
+var secretFlags = map[string]struct{}{
+	"originalKey":    {},
+	"hashedKey":      {},
+	"generatedKey":   {},
+	"webhook":        {},
+	"discordWebhook": {},
+}
+
+// runFlags are the flags the run was started with, set by recordFlags
+var runFlags string
+
+// recordFlags sets runFlags to the flags set on the command line, secrets excluded
+func recordFlags(cmd *cobra.Command) {
+	var flags []string
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		if _, ok := secretFlags[f.Name]; ok {
+			flags = append(flags, "--"+f.Name+"=<redacted>")
+			return
+		}
+		if values, ok := f.Value.(pflag.SliceValue); ok {
+			for _, v := range values.GetSlice() {
+				flags = append(flags, "--"+f.Name+"="+strconv.Quote(v))
+			}
+			return
+		}
+		flags = append(flags, "--"+f.Name+"="+strconv.Quote(f.Value.String()))
+	})
+	runFlags = strings.Join(flags, " ")
+}
+
+// writeMetaTable writes the tool version, run timestamp if set, input checksums, sample depth and
+// flags into a metaTable table of db, so anyone receiving it can verify how it was produced
+func writeMetaTable(ctx context.Context, db *sql.DB, sampleRows int) error {
+	var olderChecksums []string
+	for _, f := range summary.OlderOriginalDBs {
+		olderChecksums = append(olderChecksums, f.SHA256)
+	}
+	meta := [][2]string{
+		{"version", version},
+	}
+	// the timestamp would make every new database different
+	if opts.MetaTimestamp {
+		meta = append(meta, [2]string{"started_at", summary.StartedAt.UTC().Format(time.RFC3339)})
+	}
+	meta = append(meta, [][2]string{
+		{"original_sha256", summary.OriginalDB.SHA256},
+		{"older_original_sha256", strings.Join(olderChecksums, ",")},
+		{"hashed_sha256", summary.HashedDB.SHA256},
+		{"sample_rows", strconv.Itoa(sampleRows)},
+		{"flags", runFlags},
+	}...)
+
+	if _, err := db.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s;", metaTable)); err != nil {
+		return fmt.Errorf("error dropping %s table: %w", metaTable, err)
+	}
+	if _, err := db.ExecContext(ctx, fmt.Sprintf("CREATE TABLE %s (key TEXT PRIMARY KEY, value TEXT NOT NULL);", metaTable)); err != nil {
+		return fmt.Errorf("error creating %s table: %w", metaTable, err)
+	}
+	for _, m := range meta {
+		if _, err := db.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s VALUES (?, ?)", metaTable), m[0], m[1]); err != nil {
+			return fmt.Errorf("error writing %s table: %w", metaTable, err)
+		}
+	}
+	return nil
+}
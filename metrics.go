@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// upper bounds of the buckets of the duration histograms, in seconds
+var (
+	tableCopyBuckets = []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60}
+	jobBuckets       = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800}
+)
+
+// histogram counts observations in cumulative buckets, like a Prometheus histogram
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// serveMetrics are the metrics of the jobs of serve, written by GET /metrics in the Prometheus text format
+type serveMetrics struct {
+	mu sync.Mutex
+	// jobs are the finished jobs by status
+	jobs map[string]uint64
+	// tables are the tables of the finished jobs by result: matched, unmatched or failed
+	tables       map[string]uint64
+	rowsCopied   uint64
+	bytesWritten uint64
+	tableCopy    *histogram
+	jobDuration  *histogram
+}
+
+func newServeMetrics() *serveMetrics {
+	return &serveMetrics{
+		jobs:        map[string]uint64{},
+		tables:      map[string]uint64{},
+		tableCopy:   newHistogram(tableCopyBuckets),
+		jobDuration: newHistogram(jobBuckets),
+	}
+}
+
+// observeJob adds the finished job j, read from its run summary and outputs
+func (m *serveMetrics) observeJob(j job) {
+	var summary runSummary
+	hasSummary := len(j.Summary) > 0 && json.Unmarshal(j.Summary, &summary) == nil
+	var written int64
+	for _, name := range []string{jobGeneratedFile, mappingFile} {
+		if info, err := os.Stat(filepath.Join(j.dir, name)); err == nil {
+			written += info.Size()
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[j.Status]++
+	m.bytesWritten += uint64(written)
+	if j.FinishedAt != nil {
+		m.jobDuration.observe(j.FinishedAt.Sub(j.CreatedAt).Seconds())
+	}
+	if !hasSummary {
+		return
+	}
+	m.tables["matched"] += uint64(len(summary.Tables))
+	m.tables["unmatched"] += uint64(len(summary.Unmatched))
+	m.tables["failed"] += uint64(len(summary.Failed))
+	m.rowsCopied += uint64(summary.RowsCopied)
+	for _, t := range summary.Tables {
+		// the tables kept from a previous run were not copied
+		if !t.Resumed && !t.Reused && !t.Missing {
+			m.tableCopy.observe(t.CopySeconds)
+		}
+	}
+}
+
+// writeTo writes the metrics in the Prometheus text format, with the number of jobs queued or running
+func (m *serveMetrics) writeTo(w io.Writer, active int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP pcr_jobs_active Jobs queued or running.")
+	fmt.Fprintln(w, "# TYPE pcr_jobs_active gauge")
+	fmt.Fprintf(w, "pcr_jobs_active %d\n", active)
+	writeCounters(w, "pcr_jobs_processed_total", "Jobs finished, by status.", "status", m.jobs)
+	writeCounters(w, "pcr_tables_total", "Tables of the finished jobs, by result.", "result", m.tables)
+	fmt.Fprintln(w, "# HELP pcr_rows_copied_total Rows copied into the new databases.")
+	fmt.Fprintln(w, "# TYPE pcr_rows_copied_total counter")
+	fmt.Fprintf(w, "pcr_rows_copied_total %d\n", m.rowsCopied)
+	fmt.Fprintln(w, "# HELP pcr_bytes_written_total Bytes of the new databases and table mappings written.")
+	fmt.Fprintln(w, "# TYPE pcr_bytes_written_total counter")
+	fmt.Fprintf(w, "pcr_bytes_written_total %d\n", m.bytesWritten)
+	writeHistogram(w, "pcr_table_copy_duration_seconds", "Time copying a table.", m.tableCopy)
+	writeHistogram(w, "pcr_job_duration_seconds", "Time from the submission of a job to its end.", m.jobDuration)
+}
+
+func writeCounters(w io.Writer, name, help, label string, values map[string]uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s=%q} %d\n", name, label, k, values[k])
+	}
+}
+
+func writeHistogram(w io.Writer, name, help string, h *histogram) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for i, bound := range h.bounds {
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+func (s *jobServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	active := 0
+	s.mu.Lock()
+	for _, j := range s.jobs {
+		if j.Status == jobQueued || j.Status == jobRunning {
+			active++
+		}
+	}
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.metrics.writeTo(w, active)
+}
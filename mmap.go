@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// mmapAuto is the default --mmapSize, mapping defaultMmapSize of the inputs opened read-only or immutable
+const mmapAuto = "auto"
+
+// defaultMmapSize is the mmap_size of --mmapSize auto
+const defaultMmapSize = 256 << 20
+
+// checkMmapSize returns an invalid input error if --mmapSize is neither auto nor a size
+func checkMmapSize() error {
+	if opts.MmapSizeFlag == mmapAuto {
+		return nil
+	}
+	_, err := parseByteSize(opts.MmapSizeFlag)
+	return err
+}
+
+// mmapPragmas returns the statements run on the connections of the input database at dsn for --mmapSize.
+// SQLite reads the pages of a memory-mapped database without copying them into its page cache, which
+// speeds up the full table scans of copying large tables, e.g. the story and text tables.
+func mmapPragmas(dsn string) []string {
+	var size int64
+	if opts.MmapSizeFlag == mmapAuto {
+		if isReadOnlyDSN(dsn) {
+			size = defaultMmapSize
+		}
+	} else {
+		size, _ = parseByteSize(opts.MmapSizeFlag)
+	}
+	if size <= 0 {
+		return nil
+	}
+	return []string{fmt.Sprintf("PRAGMA mmap_size = %d", size)}
+}
+
+// isReadOnlyDSN returns whether dsn opens its database read-only or immutable, e.g. file:jp.db?immutable=1
+func isReadOnlyDSN(dsn string) bool {
+	_, params := splitDSN(dsn)
+	values, err := url.ParseQuery(params)
+	return err == nil && (values.Get("mode") == "ro" || values.Get("immutable") == "1")
+}
@@ -0,0 +1,22 @@
+package main
+
+import "log/slog"
+
+// niceValue is the nice value of --nice, so the scheduler runs the processes of the default 0 first
+const niceValue = 10
+
+// applyNice lowers the priority of the process and limits the throughput of the run with --nice
+func applyNice() error {
+	if !opts.Nice {
+		return nil
+	}
+	rate, err := parseByteSize(opts.NiceRate)
+	if err != nil {
+		return err
+	}
+	opts.RateLimit = rate
+	if err = lowerPriority(); err != nil {
+		slog.Warn("could not lower the priority of the process", "error", err)
+	}
+	return nil
+}
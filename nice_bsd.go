@@ -0,0 +1,10 @@
+//go:build darwin || freebsd || netbsd || openbsd
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// lowerPriority sets niceValue on the process, these platforms have no I/O priority of their own
+func lowerPriority() error {
+	return unix.Setpriority(unix.PRIO_PROCESS, 0, niceValue)
+}
@@ -0,0 +1,38 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// ioprio_set arguments for the lowest priority of the best-effort I/O scheduling class
+const (
+	ioprioWhoProcess       = 1
+	ioprioBestEffortLowest = 2<<13 | 7
+)
+
+// lowerPriority sets niceValue and the lowest best-effort I/O priority on every thread of the
+// process, as Linux sets them per thread, the threads started later inherit them
+func lowerPriority() error {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		if err = unix.Setpriority(unix.PRIO_PROCESS, tid, niceValue); err != nil {
+			return err
+		}
+		if _, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), ioprioBestEffortLowest); errno != 0 {
+			return errno
+		}
+	}
+	return nil
+}
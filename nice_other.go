@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+
+package main
+
+import "errors"
+
+// lowerPriority is not supported on this platform, only the throughput is limited
+func lowerPriority() error {
+	return errors.New("lowering the priority is not supported on this platform")
+}
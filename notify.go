@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	eventFinished  = "finished"
+	eventUnmatched = "unmatched"
+	eventFailed    = "failed"
+)
+
+// discord rejects messages longer than this
+const discordMaxContent = 2000
+
+var webhookClient = &http.Client{Timeout: 30 * time.Second}
+
+type webhookPayload struct {
+	Event    string     `json:"event"`
+	ExitCode int        `json:"exitCode"`
+	Summary  runSummary `json:"summary"`
+}
+
+// runEvent returns the event of a run that exited with exitCode
+func runEvent(exitCode int) string {
+	switch {
+	case exitCode == exitUnmatchedTables || (exitCode == exitSuccess && len(summary.NewTables) > 0):
+		return eventUnmatched
+	case exitCode == exitSuccess:
+		return eventFinished
+	default:
+		return eventFailed
+	}
+}
+
+// notify posts the run summary to every webhook, failures are only logged
+// so a broken webhook never fails a run
+func notify(exitCode int) {
+	if len(opts.Webhooks) == 0 && len(opts.DiscordWebhooks) == 0 {
+		return
+	}
+
+	payload := webhookPayload{Event: runEvent(exitCode), ExitCode: exitCode, Summary: summary}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.Warn("error encoding webhook payload", "error", err)
+		return
+	}
+
+	for _, url := range opts.Webhooks {
+		postWebhook(url, "application/json", body)
+	}
+
+	if len(opts.DiscordWebhooks) > 0 {
+		contentType, discordBody, err := discordMessage(payload)
+		if err != nil {
+			slog.Warn("error building Discord message", "error", err)
+			return
+		}
+		for _, url := range opts.DiscordWebhooks {
+			postWebhook(url, contentType, discordBody)
+		}
+	}
+}
+
+// discordMessage builds a message with a short description of the run
+// and the summary attached as a JSON file
+func discordMessage(payload webhookPayload) (string, []byte, error) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "**pcr-hash-table-rename %s** (exit code %d)\n", payload.Event, payload.ExitCode)
+	fmt.Fprintf(&sb, tr("%d tables, %d rows copied to %s\n"), len(payload.Summary.Tables), payload.Summary.RowsCopied, payload.Summary.GeneratedDB)
+	if len(payload.Summary.Unmatched) > 0 {
+		fmt.Fprintf(&sb, tr("unmatched: %s\n"), strings.Join(payload.Summary.Unmatched, ", "))
+	}
+	if len(payload.Summary.NewTables) > 0 {
+		fmt.Fprintf(&sb, tr("new tables: %s\n"), strings.Join(payload.Summary.NewTables, ", "))
+	}
+	content := sb.String()
+	if len(content) > discordMaxContent {
+		content = content[:discordMaxContent-3] + "..."
+	}
+
+	summaryJSON, err := json.MarshalIndent(payload.Summary, "", "  ")
+	if err != nil {
+		return "", nil, err
+	}
+	messageJSON, err := json.Marshal(map[string]string{"content": content})
+	if err != nil {
+		return "", nil, err
+	}
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	if err = w.WriteField("payload_json", string(messageJSON)); err != nil {
+		return "", nil, err
+	}
+	file, err := w.CreateFormFile("files[0]", summaryFile)
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err = file.Write(summaryJSON); err != nil {
+		return "", nil, err
+	}
+	if err = w.Close(); err != nil {
+		return "", nil, err
+	}
+
+	return w.FormDataContentType(), body.Bytes(), nil
+}
+
+func postWebhook(url, contentType string, body []byte) {
+	resp, err := webhookClient.Post(url, contentType, bytes.NewReader(body))
+	if err != nil {
+		slog.Warn("error calling webhook", "error", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		slog.Warn("error calling webhook", "status", resp.Status)
+	}
+}
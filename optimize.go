@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// optimizeDB compacts db with --vacuum and regenerates its planner statistics with --analyze,
+// once every row is written, since the row by row build leaves it fragmented and without statistics
+func optimizeDB(ctx context.Context, db *sql.DB) error {
+	if opts.Analyze {
+		start := time.Now()
+		if _, err := db.ExecContext(ctx, "ANALYZE"); err != nil {
+			return fmt.Errorf("error analyzing new database: %w", err)
+		}
+		slog.Info("analyzed new database", "duration", time.Since(start))
+	}
+	// after ANALYZE, so its sqlite_stat1 table is compacted too
+	if opts.Vacuum {
+		start := time.Now()
+		if _, err := db.ExecContext(ctx, "VACUUM"); err != nil {
+			return fmt.Errorf("error vacuuming new database: %w", err)
+		}
+		slog.Info("vacuumed new database", "duration", time.Since(start))
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/peterli110/pcr-hash-table-rename/pkg/rename"
+)
+
+// otlpEndpoint is the collector of --otlpEndpoint, e.g. http://localhost:4318
+var otlpEndpoint string
+
+// tracer exports the spans of the runs with OTLP, nil without --otlpEndpoint
+var tracer *otlpTracer
+
+// spans buffered before they are exported, the rest are exported when the process exits
+const otlpBatchSize = 512
+
+// time an export to the collector may take
+const otlpExportTimeout = 10 * time.Second
+
+// otlpTracer is a rename.Tracer exporting its spans to an OpenTelemetry collector with OTLP/HTTP in JSON
+type otlpTracer struct {
+	mu       sync.Mutex
+	endpoint string
+	client   *http.Client
+	spans    []otlpSpanData
+}
+
+// otlpSpan is a span of otlpTracer
+type otlpSpan struct {
+	tracer                    *otlpTracer
+	traceID, spanID, parentID string
+	name                      string
+	start                     time.Time
+	attrs                     []slog.Attr
+}
+
+type otlpSpanKey struct{}
+
+// startTracing creates the tracer of --otlpEndpoint, which defaults to OTEL_EXPORTER_OTLP_ENDPOINT
+func startTracing() error {
+	if otlpEndpoint == "" {
+		return nil
+	}
+	if !strings.HasPrefix(otlpEndpoint, "http://") && !strings.HasPrefix(otlpEndpoint, "https://") {
+		return invalidInputf("invalid --otlpEndpoint %s, expected an http:// or https:// URL", otlpEndpoint)
+	}
+	endpoint := strings.TrimSuffix(otlpEndpoint, "/")
+	if !strings.HasSuffix(endpoint, "/v1/traces") {
+		endpoint += "/v1/traces"
+	}
+	tracer = &otlpTracer{endpoint: endpoint, client: &http.Client{Timeout: otlpExportTimeout}}
+	return nil
+}
+
+// stopTracing exports the spans not exported yet, before the process exits
+func stopTracing() {
+	if tracer == nil {
+		return
+	}
+	tracer.mu.Lock()
+	spans := tracer.spans
+	tracer.spans = nil
+	tracer.mu.Unlock()
+	tracer.export(spans)
+}
+
+// withTracing returns a copy of ctx in which the rename package starts its spans with the tracer, if any
+func withTracing(ctx context.Context) context.Context {
+	if tracer == nil {
+		return ctx
+	}
+	return rename.WithTracer(ctx, tracer)
+}
+
+// startSpan starts a span of the command itself, which does nothing without --otlpEndpoint
+func startSpan(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, rename.Span) {
+	if tracer == nil {
+		return ctx, noSpan{}
+	}
+	return tracer.Start(ctx, name, attrs...)
+}
+
+type noSpan struct{}
+
+func (noSpan) End(error, ...slog.Attr) {}
+
+// Start starts a span, child of the span of ctx if it has one
+func (t *otlpTracer) Start(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, rename.Span) {
+	span := &otlpSpan{tracer: t, spanID: randomHex(8), name: name, start: time.Now(), attrs: attrs}
+	if parent, ok := ctx.Value(otlpSpanKey{}).(*otlpSpan); ok {
+		span.traceID, span.parentID = parent.traceID, parent.spanID
+	} else {
+		span.traceID = randomHex(16)
+	}
+	return context.WithValue(ctx, otlpSpanKey{}, span), span
+}
+
+// End records the span, exporting the spans recorded so far once there are otlpBatchSize of them
+func (s *otlpSpan) End(err error, attrs ...slog.Attr) {
+	data := otlpSpanData{
+		TraceID:           s.traceID,
+		SpanID:            s.spanID,
+		ParentSpanID:      s.parentID,
+		Name:              s.name,
+		Kind:              otlpSpanKindInternal,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(time.Now().UnixNano(), 10),
+	}
+	for _, a := range append(s.attrs, attrs...) {
+		data.Attributes = append(data.Attributes, otlpAttr(a.Key, a.Value))
+	}
+	if err != nil {
+		data.Status = otlpStatus{Code: otlpStatusError, Message: err.Error()}
+	}
+
+	t := s.tracer
+	t.mu.Lock()
+	t.spans = append(t.spans, data)
+	var spans []otlpSpanData
+	if len(t.spans) >= otlpBatchSize {
+		spans, t.spans = t.spans, nil
+	}
+	t.mu.Unlock()
+	t.export(spans)
+}
+
+// export sends spans to the collector, failing to do so is only a warning
+func (t *otlpTracer) export(spans []otlpSpanData) {
+	if len(spans) == 0 {
+		return
+	}
+	request := otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpKeyValue{
+			otlpAttr("service.name", slog.StringValue("pcr-hash-table-rename")),
+			otlpAttr("service.version", slog.StringValue(version)),
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/peterli110/pcr-hash-table-rename", Version: version},
+			Spans: spans,
+		}},
+	}}}
+	body, err := json.Marshal(request)
+	if err == nil {
+		var resp *http.Response
+		if resp, err = t.client.Post(t.endpoint, "application/json", bytes.NewReader(body)); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode/100 != 2 {
+				err = fmt.Errorf("unexpected status %s", resp.Status)
+			}
+		}
+	}
+	if err != nil {
+		slog.Warn("error exporting spans", "endpoint", t.endpoint, "spans", len(spans), "error", err)
+		return
+	}
+	slog.Debug("exported spans", "endpoint", t.endpoint, "spans", len(spans))
+}
+
+// randomHex returns n random bytes in hex, the trace and span IDs of OTLP in JSON
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		fatalf(exitInternalError, "error generating span ID: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// SpanKind and StatusCode of OTLP
+const (
+	otlpSpanKindInternal = 1
+	otlpStatusError      = 2
+)
+
+// otlpTraces is the body of an OTLP/HTTP export of spans in JSON
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope      `json:"scope"`
+	Spans []otlpSpanData `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type otlpSpanData struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId,omitempty"`
+	Name         string `json:"name"`
+	Kind         int    `json:"kind"`
+	// times are 64-bit integers, which JSON encodes as strings in OTLP
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+// otlpValue is an AnyValue of OTLP, exactly one field is set
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+// otlpAttr converts an attribute of a span, the values of other kinds than numbers and booleans are strings
+func otlpAttr(key string, v slog.Value) otlpKeyValue {
+	var value otlpValue
+	switch v = v.Resolve(); v.Kind() {
+	case slog.KindInt64:
+		s := strconv.FormatInt(v.Int64(), 10)
+		value.IntValue = &s
+	case slog.KindUint64:
+		s := strconv.FormatUint(v.Uint64(), 10)
+		value.IntValue = &s
+	case slog.KindFloat64:
+		f := v.Float64()
+		value.DoubleValue = &f
+	case slog.KindBool:
+		b := v.Bool()
+		value.BoolValue = &b
+	case slog.KindDuration:
+		f := v.Duration().Seconds()
+		value.DoubleValue = &f
+	default:
+		s := v.String()
+		value.StringValue = &s
+	}
+	return otlpKeyValue{Key: key, Value: value}
+}
+
+// envOTLPEndpoint is the default of --otlpEndpoint, the endpoint of the OpenTelemetry SDKs
+func envOTLPEndpoint() string {
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); endpoint != "" {
+		return endpoint
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+}
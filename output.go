@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// journalSuffixes are the files SQLite keeps next to a database
+var journalSuffixes = []string{"-journal", "-wal", "-shm"}
+
+// createTempOutput creates an empty file next to path to write the new database to,
+// so it can be renamed to path on the same filesystem
+func createTempOutput(path string) (string, error) {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	return file.Name(), file.Close()
+}
+
+// copyDB copies the database at path into the empty file at tmp, for --append
+func copyDB(ctx context.Context, path, tmp string) error {
+	filePath, _ := splitDSN(path)
+	if _, err := os.Stat(filePath); err != nil {
+		return invalidInputf("--append needs an existing new database: %w", err)
+	}
+	db, err := openDB(path, opts.GeneratedKey)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err = db.ExecContext(ctx, "VACUUM INTO ?", tmp); err != nil {
+		return fmt.Errorf("error copying %s: %w", filePath, err)
+	}
+	return nil
+}
+
+// partialOutput returns the path a new database is written to with --resume, which is
+// kept when the run stops so the next run can continue it
+func partialOutput(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".partial")
+}
+
+// commitOutput renames the closed database at tmp to path, replacing any previous database
+func commitOutput(tmp, path string) error {
+	// journals of a previous database would be applied to the new one
+	for _, suffix := range journalSuffixes {
+		if err := os.Remove(path + suffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(tmp, path)
+}
+
+// removeDB removes the database at path and its journals, if they exist
+func removeDB(path string) {
+	os.Remove(path)
+	for _, suffix := range journalSuffixes {
+		os.Remove(path + suffix)
+	}
+}
+
+// checkOutput returns an invalid input error if the new database at path, an object
+// or a local file, already exists
+func checkOutput(ctx context.Context, path string) error {
+	exists := false
+	if isObjectURL(path) {
+		var err error
+		if exists, err = objectExists(ctx, path); err != nil {
+			return err
+		}
+	} else if _, err := os.Stat(path); err == nil {
+		exists = true
+	}
+	if exists {
+		return invalidInputf("%s already exists, use --force to overwrite it", path)
+	}
+	return nil
+}
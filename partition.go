@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/peterli110/pcr-hash-table-rename/pkg/rename"
+	"gopkg.in/yaml.v3"
+)
+
+// indexNameRegex matches a CREATE INDEX statement up to its index name
+var indexNameRegex = regexp.MustCompile(`(?i)^CREATE\s+(UNIQUE\s+)?INDEX\s+(IF\s+NOT\s+EXISTS\s+)?`)
+
+// partition is a group of tables of --partition moved into a database of its own
+type partition struct {
+	Name string
+	// Tables are the names of the tables of the group, or path.Match patterns
+	Tables []string `yaml:"tables"`
+	Path   string   `yaml:"path"`
+
+	// tmp is the path the database is written to until the run is complete
+	tmp string
+}
+
+// partitions are the groups of --partition of the current run
+var partitions []partition
+
+// batchPrefix is prepended to the file name of the partitions and extra outputs in batch mode,
+// <outputDir>/<name>_
+var batchPrefix string
+
+// readPartitions reads the groups of the --partition file at path, sorted by name
+func readPartitions(path string) ([]partition, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, invalidInputf("error opening partition file: %w", err)
+	}
+	var groups map[string]partition
+	if err = yaml.Unmarshal(content, &groups); err != nil {
+		return nil, invalidInputf("invalid partition file %s: %w", path, err)
+	}
+	if len(groups) == 0 {
+		return nil, invalidInputf("partition file %s has no groups", path)
+	}
+
+	paths := map[string]string{}
+	var parts []partition
+	for _, name := range sortedKeys(groups) {
+		p := groups[name]
+		p.Name = name
+		if p.Path == "" || len(p.Tables) == 0 {
+			return nil, invalidInputf("group %s of %s needs a path and tables", name, path)
+		}
+		if isURL(p.Path) || isObjectURL(p.Path) {
+			return nil, invalidInputf("group %s of %s needs a local path", name, path)
+		}
+		if batchPrefix != "" {
+			p.Path = batchPrefix + filepath.Base(p.Path)
+		}
+		if other, ok := paths[p.Path]; ok {
+			return nil, invalidInputf("groups %s and %s of %s have the same path %s", other, name, path, p.Path)
+		}
+		paths[p.Path] = name
+		for _, pattern := range p.Tables {
+			if _, err = filepath.Match(pattern, ""); err != nil {
+				return nil, invalidInputf("group %s of %s: invalid table %s: %w", name, path, pattern, err)
+			}
+		}
+		parts = append(parts, p)
+	}
+	return parts, nil
+}
+
+// partitionTables returns the tables of db matching the groups of parts, by group name. Views,
+// full-text search tables and the tables written by this tool are not partitioned.
+func partitionTables(ctx context.Context, db *sql.DB, parts []partition) (map[string][]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name FROM pragma_table_list WHERE schema = 'main' AND type = 'table' ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("error listing tables of new database: %w", err)
+	}
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if name != rename.MappingTable && name != metaTable && !strings.HasPrefix(name, "sqlite_") {
+			tables = append(tables, name)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	grouped := map[string][]string{}
+	groupOf := map[string]string{}
+	for _, p := range parts {
+		for _, table := range tables {
+			for _, pattern := range p.Tables {
+				if ok, _ := path.Match(pattern, table); !ok {
+					continue
+				}
+				if other, ok := groupOf[table]; ok && other != p.Name {
+					return nil, invalidInputf("table %s is in both groups %s and %s", table, other, p.Name)
+				} else if !ok {
+					groupOf[table] = p.Name
+					grouped[p.Name] = append(grouped[p.Name], table)
+				}
+			}
+		}
+	}
+	return grouped, nil
+}
+
+// partitionDB moves the tables of every group of parts from db into the database of the group,
+// with their indexes, the _meta table and their _table_mapping rows, and optimizes it like db
+func partitionDB(ctx context.Context, db *sql.DB, parts []partition) error {
+	grouped, err := partitionTables(ctx, db, parts)
+	if err != nil {
+		return err
+	}
+	var pageSize int
+	if err = db.QueryRowContext(ctx, "PRAGMA page_size").Scan(&pageSize); err != nil {
+		return err
+	}
+
+	// attached databases only exist on the connection that attached them
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for _, p := range parts {
+		tables := grouped[p.Name]
+		if len(tables) == 0 {
+			slog.Warn("no table of the new database in partition", "group", p.Name)
+			addWarning("no table in partition " + p.Name)
+		}
+		if _, err = conn.ExecContext(ctx, "ATTACH DATABASE ? AS partition", p.tmp); err != nil {
+			return fmt.Errorf("error attaching partition %s: %w", p.Name, err)
+		}
+		err = movePartitionTables(ctx, conn, tables, pageSize)
+		if _, detachErr := conn.ExecContext(ctx, "DETACH DATABASE partition"); err == nil {
+			err = detachErr
+		}
+		if err != nil {
+			return fmt.Errorf("partition %s: %w", p.Name, err)
+		}
+
+		partitionDB, err := openDB(p.tmp, opts.GeneratedKey)
+		if err != nil {
+			return err
+		}
+		err = optimizeDB(ctx, partitionDB)
+		partitionDB.Close()
+		if err != nil {
+			return err
+		}
+		slog.Info("partitioned tables", "group", p.Name, "path", p.Path, "tables", len(tables))
+	}
+	return nil
+}
+
+// movePartitionTables moves tables from the main database of conn to the attached partition
+func movePartitionTables(ctx context.Context, conn *sql.Conn, tables []string, pageSize int) error {
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("PRAGMA partition.page_size = %d", pageSize)); err != nil {
+		return err
+	}
+	// tables come before their indexes
+	schema, err := queryStatements(ctx, conn, "SELECT tbl_name, sql FROM main.sqlite_master WHERE type IN ('table', 'index') AND sql IS NOT NULL ORDER BY tbl_name, type = 'index', name")
+	if err != nil {
+		return err
+	}
+	statements := map[string][]string{}
+	for _, s := range schema {
+		statements[s[0]] = append(statements[s[0]], s[1])
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range tables {
+		for i, statement := range statements[table] {
+			if i == 0 {
+				statement = tableNameRegex.ReplaceAllLiteralString(statement, "CREATE TABLE partition."+quoteIdentifier(table))
+			} else {
+				statement = indexNameRegex.ReplaceAllString(statement, "${0}partition.")
+			}
+			if _, err = tx.ExecContext(ctx, statement); err != nil {
+				return fmt.Errorf("error creating table %s: %w", table, err)
+			}
+			// rows are inserted before the indexes are created, which is faster
+			if i == 0 {
+				if _, err = tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO partition.%[1]s SELECT * FROM main.%[1]s", quoteIdentifier(table))); err != nil {
+					return fmt.Errorf("error copying table %s: %w", table, err)
+				}
+			}
+		}
+		if _, err = tx.ExecContext(ctx, fmt.Sprintf("DROP TABLE main.%s", quoteIdentifier(table))); err != nil {
+			return fmt.Errorf("error dropping table %s: %w", table, err)
+		}
+	}
+
+	// the partitions record how they were produced too, and the hashed names of their tables
+	if len(statements[metaTable]) > 0 {
+		create := tableNameRegex.ReplaceAllLiteralString(statements[metaTable][0], "CREATE TABLE partition."+metaTable)
+		if _, err = tx.ExecContext(ctx, create); err != nil {
+			return fmt.Errorf("error creating table %s: %w", metaTable, err)
+		}
+		if _, err = tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO partition.%[1]s SELECT * FROM main.%[1]s", metaTable)); err != nil {
+			return fmt.Errorf("error copying table %s: %w", metaTable, err)
+		}
+	}
+	if len(statements[rename.MappingTable]) > 0 {
+		create := tableNameRegex.ReplaceAllLiteralString(statements[rename.MappingTable][0], "CREATE TABLE partition."+rename.MappingTable)
+		if _, err = tx.ExecContext(ctx, create); err != nil {
+			return fmt.Errorf("error creating table %s: %w", rename.MappingTable, err)
+		}
+		for _, table := range tables {
+			if _, err = tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO partition.%[1]s SELECT * FROM main.%[1]s WHERE original_name = ?", rename.MappingTable), table); err != nil {
+				return fmt.Errorf("error copying table %s: %w", rename.MappingTable, err)
+			}
+			if _, err = tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM main.%s WHERE original_name = ?", rename.MappingTable), table); err != nil {
+				return fmt.Errorf("error copying table %s: %w", rename.MappingTable, err)
+			}
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,45 @@
+package rename
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+)
+
+// TableChecksum returns the hex SHA-256 of the rows of table in db, in primary key order.
+// It does not depend on the table and column names nor on how the rows are stored, so a table
+// whose content did not change keeps its checksum when it is hashed with another name.
+func TableChecksum(ctx context.Context, db *sql.DB, table string) (string, error) {
+	h := sha256.New()
+	// the rows are hashed as they are read, so large tables are not held in memory
+	err := ForEachRow(WithRowLimit(WithPrimaryKeyOrder(ctx), 0), db, table, func(row []string) error {
+		for _, value := range row {
+			// unit and record separators, which the values do not hold
+			h.Write([]byte(value))
+			h.Write([]byte{0x1f})
+		}
+		h.Write([]byte{0x1e})
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("error reading table %s: %w", table, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// TableChecksums returns the TableChecksum of every table of db, by table name
+func TableChecksums(ctx context.Context, db *sql.DB) (map[string]string, error) {
+	tables, err := GetTableNames(ctx, db, false)
+	if err != nil {
+		return nil, err
+	}
+	checksums := make(map[string]string, len(tables))
+	for _, t := range tables {
+		if checksums[t], err = TableChecksum(ctx, db, t); err != nil {
+			return nil, err
+		}
+	}
+	return checksums, nil
+}
@@ -0,0 +1,177 @@
+package rename
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// LevelTrace is the level this package logs the statements it runs on the new database at,
+// below the slog.LevelDebug of its per-table details, since there is one per row
+const LevelTrace = slog.LevelDebug - 4
+
+type queryTimeoutKey struct{}
+
+type loggerKey struct{}
+
+type primaryKeyOrderKey struct{}
+
+type rowLimitKey struct{}
+
+type memoryBudgetKey struct{}
+
+type readWorkersKey struct{}
+
+type rateLimitKey struct{}
+
+type selectionKey struct{}
+
+type hashedPrefixKey struct{}
+
+// WithLogger returns a copy of ctx in which this package logs to logger,
+// slog.Default() is used otherwise
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok && logger != nil {
+		return logger
+	}
+	return slog.Default()
+}
+
+// WithQueryTimeout returns a copy of ctx in which every query of this package
+// is cancelled if it takes longer than timeout
+func WithQueryTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, queryTimeoutKey{}, timeout)
+}
+
+// queryContext returns the context of a single query, with the timeout set by WithQueryTimeout
+func queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if timeout, ok := ctx.Value(queryTimeoutKey{}).(time.Duration); ok && timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// WithPrimaryKeyOrder returns a copy of ctx in which this package reads every row of a table
+// in primary key order instead of rowid order
+func WithPrimaryKeyOrder(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKeyOrderKey{}, true)
+}
+
+func primaryKeyOrderFrom(ctx context.Context) bool {
+	byPK, _ := ctx.Value(primaryKeyOrderKey{}).(bool)
+	return byPK
+}
+
+// WithRowLimit returns a copy of ctx in which this package reads at most limit rows of every table
+// it copies, in the order of GetAllData, or every row if limit is 0
+func WithRowLimit(ctx context.Context, limit int) context.Context {
+	return context.WithValue(ctx, rowLimitKey{}, limit)
+}
+
+func rowLimitFrom(ctx context.Context) int {
+	limit, _ := ctx.Value(rowLimitKey{}).(int)
+	return limit
+}
+
+// WithMemoryBudget returns a copy of ctx in which this package holds at most about budget bytes
+// of the rows of a table it copies at a time, reading and inserting larger tables in chunks,
+// or every row of a table at once if budget is 0
+func WithMemoryBudget(ctx context.Context, budget int64) context.Context {
+	return context.WithValue(ctx, memoryBudgetKey{}, budget)
+}
+
+func memoryBudgetFrom(ctx context.Context) int64 {
+	budget, _ := ctx.Value(memoryBudgetKey{}).(int64)
+	return budget
+}
+
+// WithReadWorkers returns a copy of ctx in which ReadTables reads the first rows of this many tables
+// at the same time, each on a connection of its own, or one table at a time if workers is below 2
+func WithReadWorkers(ctx context.Context, workers int) context.Context {
+	return context.WithValue(ctx, readWorkersKey{}, workers)
+}
+
+func readWorkersFrom(ctx context.Context) int {
+	if workers, _ := ctx.Value(readWorkersKey{}).(int); workers > 1 {
+		return workers
+	}
+	return 1
+}
+
+// WithRateLimit returns a copy of ctx in which this package reads and inserts at most about
+// bytesPerSecond bytes of rows per second, shared by every goroutine using ctx
+func WithRateLimit(ctx context.Context, bytesPerSecond int64) context.Context {
+	return context.WithValue(ctx, rateLimitKey{}, &rateLimiter{rate: float64(bytesPerSecond)})
+}
+
+func rateLimiterFrom(ctx context.Context) *rateLimiter {
+	limiter, _ := ctx.Value(rateLimitKey{}).(*rateLimiter)
+	return limiter
+}
+
+// WithSelection returns a copy of ctx in which CopyData, CopyDataAs and AppendData only copy the rows and
+// columns of sel, and CreateTable, CreateTableAs, CopyIndexes and CopyIndexesAs leave out the other columns
+func WithSelection(ctx context.Context, sel Selection) context.Context {
+	return context.WithValue(ctx, selectionKey{}, sel)
+}
+
+func selectionFrom(ctx context.Context) Selection {
+	sel, _ := ctx.Value(selectionKey{}).(Selection)
+	return sel
+}
+
+// WithHashedPrefix returns a copy of ctx in which the hashed table names start with prefix
+// instead of DefaultHashedPrefix
+func WithHashedPrefix(ctx context.Context, prefix string) context.Context {
+	return context.WithValue(ctx, hashedPrefixKey{}, prefix)
+}
+
+func hashedPrefixFrom(ctx context.Context) string {
+	if prefix, _ := ctx.Value(hashedPrefixKey{}).(string); prefix != "" {
+		return prefix
+	}
+	return DefaultHashedPrefix
+}
+
+// shortest wait of a rateLimiter, shorter ones are added up so it does not sleep for every row
+const minRateLimitWait = 10 * time.Millisecond
+
+// rateLimiter spaces out reads and writes so they do not exceed rate bytes per second on average
+type rateLimiter struct {
+	mu   sync.Mutex
+	rate float64
+	// next is when the bytes counted so far are paid off
+	next time.Time
+}
+
+// waitRateLimit waits until n more bytes fit in the rate limit of ctx, if it has one
+func waitRateLimit(ctx context.Context, n int) error {
+	limiter := rateLimiterFrom(ctx)
+	if limiter == nil || limiter.rate <= 0 || n <= 0 {
+		return nil
+	}
+	limiter.mu.Lock()
+	now := time.Now()
+	if limiter.next.Before(now) {
+		limiter.next = now
+	}
+	limiter.next = limiter.next.Add(time.Duration(float64(n) / limiter.rate * float64(time.Second)))
+	wait := limiter.next.Sub(now)
+	limiter.mu.Unlock()
+	if wait < minRateLimitWait {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
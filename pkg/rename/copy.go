@@ -0,0 +1,399 @@
+package rename
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var numericRegex = regexp.MustCompile(`^\d+(\.\d+)?$`)
+
+// CopyData creates origTable in newDB with its schema in originalDB, copies the rows
+// of hashedTable into it and returns the number of rows copied. If the rows could not
+// be copied, the created table is dropped again.
+// Errors are *ErrCopyFailed, wrapping ErrSchemaMismatch if the tables have a different
+// number of columns.
+func CopyData(ctx context.Context, originalDB, hashedDB, newDB *sql.DB, origTable, hashedTable string) (int, error) {
+	rows, err := copyData(ctx, originalDB, hashedDB, newDB, origTable, hashedTable)
+	if err != nil {
+		return 0, &ErrCopyFailed{Table: origTable, Err: err}
+	}
+	return rows, nil
+}
+
+// CopyDataAs creates origTable in newDB with createStmt, copies the rows of hashedTable
+// into it and returns the number of rows copied, for when the original database is not
+// available. If the rows could not be copied, the created table is dropped again.
+// Errors are *ErrCopyFailed.
+func CopyDataAs(ctx context.Context, hashedDB, newDB *sql.DB, createStmt, origTable, hashedTable string) (int, error) {
+	rows, err := copyRows(ctx, hashedDB, newDB, createStmt, origTable, hashedTable)
+	if err != nil {
+		return 0, &ErrCopyFailed{Table: origTable, Err: err}
+	}
+	return rows, nil
+}
+
+// AppendData inserts the rows of hashedTable into the existing origTable of newDB by the names
+// of the columns of origTable in originalDB, so the columns in newDB may be in another order or
+// have defaults for more, and returns the number of rows inserted. The rows of origTable are
+// deleted first if truncate is set, in the same transaction. If newDB has no origTable, it is
+// created like CopyData does. Errors are *ErrCopyFailed, wrapping ErrSchemaMismatch if the tables
+// have a different number of columns or origTable in newDB lacks a column.
+func AppendData(ctx context.Context, originalDB, hashedDB, newDB *sql.DB, origTable, hashedTable string, truncate bool) (int, error) {
+	rows, err := appendData(ctx, originalDB, hashedDB, newDB, origTable, hashedTable, truncate)
+	if err != nil {
+		return 0, &ErrCopyFailed{Table: origTable, Err: err}
+	}
+	return rows, nil
+}
+
+func appendData(ctx context.Context, originalDB, hashedDB, newDB *sql.DB, origTable, hashedTable string, truncate bool) (int, error) {
+	if err := checkSchema(ctx, originalDB, hashedDB, origTable, hashedTable); err != nil {
+		return 0, err
+	}
+	columns, err := GetColumnNames(ctx, originalDB, origTable)
+	if err != nil {
+		return 0, err
+	}
+	existing, err := GetColumnNames(ctx, newDB, origTable)
+	if err != nil {
+		return 0, err
+	}
+	if len(existing) == 0 {
+		createStmt, err := getCreateTableStatement(ctx, originalDB, origTable)
+		if err != nil {
+			return 0, fmt.Errorf("error getting CREATE TABLE statement: %w", err)
+		}
+		return copyRows(ctx, hashedDB, newDB, createStmt, origTable, hashedTable)
+	}
+	existingColumns := make(map[string]struct{}, len(existing))
+	for _, column := range existing {
+		existingColumns[strings.ToLower(column)] = struct{}{}
+	}
+	for _, column := range columns {
+		if _, ok := existingColumns[strings.ToLower(column)]; !ok {
+			return 0, fmt.Errorf("%w: %s has no column %s in the new database", ErrSchemaMismatch, origTable, column)
+		}
+	}
+	sel := selectionFrom(ctx)
+	query, err := selectionQuery(ctx, hashedDB, hashedTable, columns, sel)
+	if err != nil {
+		return 0, err
+	}
+	if columns, err = sel.keptColumns(columns); err != nil {
+		return 0, err
+	}
+	return insertRows(ctx, hashedDB, newDB, origTable, hashedTable, query, columns, truncate)
+}
+
+// FilterRows deletes the rows of table in db for which the SQL condition where is not true,
+// and returns the number of rows deleted, for a table already copied. Runner.Copy applies
+// Options.RowFilters while copying instead, see WithSelection.
+// Errors are *ErrCopyFailed.
+func FilterRows(ctx context.Context, db *sql.DB, table, where string) (int, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	// a NULL condition does not keep the row either, as in a WHERE clause
+	result, err := db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE NOT coalesce((%s), 0)", quote(table), where))
+	if err != nil {
+		return 0, &ErrCopyFailed{Table: table, Err: fmt.Errorf("error filtering rows WHERE %s: %w", where, err)}
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, &ErrCopyFailed{Table: table, Err: err}
+	}
+	loggerFrom(ctx).DebugContext(ctx, "filtered rows", "table", table, "where", where, "deleted", deleted)
+	return int(deleted), nil
+}
+
+// KeepColumns rebuilds table in db with the columns in columns only, without the constraints and
+// indexes on the other columns, for a table already copied. Runner.Copy applies Options.Columns
+// while copying instead, see WithSelection. Errors are *ErrCopyFailed.
+func KeepColumns(ctx context.Context, db *sql.DB, table string, columns []string) error {
+	if err := keepColumns(ctx, db, table, columns); err != nil {
+		return &ErrCopyFailed{Table: table, Err: err}
+	}
+	return nil
+}
+
+func keepColumns(ctx context.Context, db *sql.DB, table string, columns []string) error {
+	createStmt, err := getCreateTableStatement(ctx, db, table)
+	if err != nil {
+		return fmt.Errorf("error getting CREATE TABLE statement: %w", err)
+	}
+	sel := Selection{Columns: columns}
+	create, err := parseCreateTable(createStmt)
+	if err != nil {
+		return err
+	}
+	kept, err := sel.keptColumns(create.columns)
+	if err != nil {
+		return err
+	}
+	indexes, err := getIndexes(ctx, db, table)
+	if err != nil {
+		return err
+	}
+
+	// the table is created again under another name, since SQLite cannot drop the columns of a key or an index
+	tmp := table + "_columns"
+	all := create.columns
+	create = create.keep(sel)
+	create.head = "CREATE TABLE " + quote(tmp) + " "
+	for i, column := range kept {
+		kept[i] = quote(column)
+	}
+	statements := []string{
+		create.String(),
+		fmt.Sprintf("INSERT INTO %s SELECT %s FROM %s", quote(tmp), strings.Join(kept, ", "), quote(table)),
+		fmt.Sprintf("DROP TABLE %s", quote(table)),
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s", quote(tmp), quote(table)),
+	}
+	for _, index := range indexes {
+		if index.kept(all, sel) {
+			statements = append(statements, index.sql)
+		} else {
+			loggerFrom(ctx).DebugContext(ctx, "dropping index of a dropped column", "table", table, "index", index.name)
+		}
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, statement := range statements {
+		loggerFrom(ctx).Log(ctx, LevelTrace, "keeping columns", "table", table, "sql", statement)
+		if _, err = tx.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("error keeping columns: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// CreateTable creates origTable in newDB with its schema in originalDB, without any row,
+// with the Columns of WithSelection only. Errors are *ErrCopyFailed.
+func CreateTable(ctx context.Context, originalDB, newDB *sql.DB, origTable string) error {
+	createStmt, err := getCreateTableStatement(ctx, originalDB, origTable)
+	if err != nil {
+		return &ErrCopyFailed{Table: origTable, Err: fmt.Errorf("error getting CREATE TABLE statement: %w", err)}
+	}
+	return CreateTableAs(ctx, newDB, createStmt, origTable)
+}
+
+// CreateTableAs creates origTable in newDB with createStmt, without any row, with the Columns
+// of WithSelection only, for when the original database is not available. Errors are *ErrCopyFailed.
+func CreateTableAs(ctx context.Context, newDB *sql.DB, createStmt, origTable string) error {
+	var err error
+	if sel := selectionFrom(ctx); len(sel.Columns) > 0 {
+		if createStmt, _, err = selectCreateStatement(createStmt, sel); err != nil {
+			return &ErrCopyFailed{Table: origTable, Err: err}
+		}
+	}
+	loggerFrom(ctx).Log(ctx, LevelTrace, "creating table", "table", origTable, "sql", createStmt)
+	if err = execContext(ctx, newDB, createStmt); err != nil {
+		return &ErrCopyFailed{Table: origTable, Err: fmt.Errorf("error creating table in new database: %w", err)}
+	}
+	return nil
+}
+
+func copyData(ctx context.Context, originalDB, hashedDB, newDB *sql.DB, origTable, hashedTable string) (int, error) {
+	if err := checkSchema(ctx, originalDB, hashedDB, origTable, hashedTable); err != nil {
+		return 0, err
+	}
+
+	// get the CREATE TABLE statement for the original table
+	createStmt, err := getCreateTableStatement(ctx, originalDB, origTable)
+	if err != nil {
+		return 0, fmt.Errorf("error getting CREATE TABLE statement: %w", err)
+	}
+	return copyRows(ctx, hashedDB, newDB, createStmt, origTable, hashedTable)
+}
+
+// copyRows creates origTable in newDB with createStmt and copies the rows of hashedTable into it
+func copyRows(ctx context.Context, hashedDB, newDB *sql.DB, createStmt, origTable, hashedTable string) (n int, err error) {
+	logger := loggerFrom(ctx)
+	// the table is created with the selected columns only, the names of all of them select the rows
+	sel := selectionFrom(ctx)
+	var names []string
+	if !sel.empty() {
+		if createStmt, names, err = selectCreateStatement(createStmt, sel); err != nil {
+			return 0, err
+		}
+	}
+	logger.Log(ctx, LevelTrace, "creating table", "table", origTable, "sql", createStmt)
+
+	// create the new table in the new database
+	if err = execContext(ctx, newDB, createStmt); err != nil {
+		return 0, fmt.Errorf("error creating table in new database: %w", err)
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		// ctx may be the reason of the failure, the table is dropped anyway
+		if dropErr := execContext(context.WithoutCancel(ctx), newDB, fmt.Sprintf("DROP TABLE IF EXISTS %s;", quote(origTable))); dropErr != nil {
+			logger.WarnContext(ctx, "error dropping table", "table", origTable, "error", dropErr)
+		}
+	}()
+
+	query, err := selectionQuery(ctx, hashedDB, hashedTable, names, sel)
+	if err != nil {
+		return 0, err
+	}
+	return insertRows(ctx, hashedDB, newDB, origTable, hashedTable, query, nil, false)
+}
+
+// insertRows inserts the rows of query, the rows of hashedTable to copy, into columns of origTable
+// in newDB, in order if columns is nil, deleting the rows of origTable first if truncate is set.
+// The rows are inserted in one transaction, rolled back if ctx is cancelled before it is committed.
+// With WithMemoryBudget, the rows are read and inserted in chunks of at most the budget.
+func insertRows(ctx context.Context, hashedDB, newDB *sql.DB, origTable, hashedTable, query string, columns []string, truncate bool) (int, error) {
+	logger := loggerFrom(ctx)
+	budget := memoryBudgetFrom(ctx)
+
+	// fetch data from the hashed table, or only count it if it is read in chunks
+	var hashedData [][]string
+	var total int
+	var err error
+	if budget > 0 && selectionFrom(ctx).empty() {
+		if total, err = CountRows(ctx, hashedDB, hashedTable); err != nil {
+			return 0, err
+		}
+		if limit := rowLimitFrom(ctx); limit > 0 {
+			total = min(total, limit)
+		}
+	} else if budget > 0 {
+		if total, err = countQueryRows(ctx, hashedDB, query); err != nil {
+			return 0, fmt.Errorf("error counting selected rows of hashed table %s: %w", hashedTable, err)
+		}
+	} else {
+		if hashedData, err = queryRows(ctx, hashedDB, query); err != nil {
+			return 0, fmt.Errorf("error fetching data from hashed table %s: %w", hashedTable, err)
+		}
+		total = len(hashedData)
+	}
+
+	tx, err := newDB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	if truncate {
+		if _, err = tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM '%s'", origTable)); err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("error deleting rows of table: %w", err)
+		}
+	}
+	// copy data row by row to the new table
+	progress(ctx, Event{Kind: EventRowsCopied, Table: origTable, Total: total})
+	n := 0
+	insert := func(rows [][]string) error {
+		for _, row := range rows {
+			insertStmt := createInsertStatement(origTable, columns, row)
+			logger.Log(ctx, LevelTrace, "inserting row", "table", origTable, "sql", insertStmt)
+			if err := waitRateLimit(ctx, len(insertStmt)); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, insertStmt); err != nil {
+				return fmt.Errorf("error inserting data into new table: %w", err)
+			}
+			n++
+			if n%progressRowsStep == 0 || n == total {
+				progress(ctx, Event{Kind: EventRowsCopied, Table: origTable, Rows: n, Total: total})
+			}
+		}
+		return nil
+	}
+
+	if budget > 0 {
+		var chunk [][]string
+		var chunkSize int64
+		err = forEachRow(ctx, hashedDB, query, func(row []string) error {
+			chunk = append(chunk, append([]string(nil), row...))
+			for _, value := range row {
+				chunkSize += int64(len(value))
+			}
+			if chunkSize < budget {
+				return nil
+			}
+			logger.DebugContext(ctx, "inserting chunk", "table", origTable, "rows", len(chunk), "bytes", chunkSize)
+			err := insert(chunk)
+			chunk, chunkSize = chunk[:0], 0
+			return err
+		})
+		if err == nil {
+			err = insert(chunk)
+		}
+	} else {
+		err = insert(hashedData)
+	}
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// checkSchema returns an error wrapping ErrSchemaMismatch if the rows of hashedTable
+// do not fit in origTable
+func checkSchema(ctx context.Context, originalDB, hashedDB *sql.DB, origTable, hashedTable string) error {
+	origColumns, err := GetColumnNames(ctx, originalDB, origTable)
+	if err != nil {
+		return err
+	}
+	hashedColumns, err := GetColumnNames(ctx, hashedDB, hashedTable)
+	if err != nil {
+		return err
+	}
+	if len(origColumns) != len(hashedColumns) {
+		return fmt.Errorf("%w: %s has %d columns but %s has %d", ErrSchemaMismatch, origTable, len(origColumns), hashedTable, len(hashedColumns))
+	}
+	return nil
+}
+
+func execContext(ctx context.Context, db *sql.DB, query string) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, query)
+	return err
+}
+
+func createInsertStatement(tableName string, columns []string, rowData []string) string {
+	var formattedValues []string
+
+	for _, value := range rowData {
+		formattedValues = append(formattedValues, formatValueByType(value))
+	}
+
+	values := strings.Join(formattedValues, ", ")
+	if columns == nil {
+		return fmt.Sprintf("INSERT INTO %s VALUES (%s)", tableName, values)
+	}
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = quote(column)
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", tableName, strings.Join(quoted, ", "), values)
+}
+
+func formatValueByType(value string) string {
+	if isNumeric(value) {
+		return value
+	}
+
+	escapedValue := strings.ReplaceAll(value, "'", "''")
+	return fmt.Sprintf("'%s'", escapedValue)
+}
+
+func isNumeric(s string) bool {
+	return numericRegex.MatchString(s)
+}
@@ -0,0 +1,71 @@
+package rename
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCopyData(t *testing.T) {
+	tests := []struct {
+		name   string
+		budget int64
+		limit  int
+		sel    Selection
+		// the columns of the new table, its rows and the sum of their ids, and whether they were inserted in chunks
+		columns []string
+		rows    int
+		sum     int
+		chunked bool
+	}{
+		{name: "every row", columns: []string{"id", "name"}, rows: 100, sum: 5050},
+		{name: "chunks", budget: 50, columns: []string{"id", "name"}, rows: 100, sum: 5050, chunked: true},
+		{name: "budget above the table", budget: 1 << 20, columns: []string{"id", "name"}, rows: 100, sum: 5050},
+		{name: "row limit in chunks", budget: 50, limit: 30, columns: []string{"id", "name"}, rows: 30, sum: 465, chunked: true},
+		{name: "selected rows", sel: Selection{Where: "id % 2 = 0"}, columns: []string{"id", "name"}, rows: 50, sum: 2550},
+		{name: "selected rows in chunks", budget: 50, sel: Selection{Where: "id % 2 = 0"}, columns: []string{"id", "name"}, rows: 50, sum: 2550, chunked: true},
+		{name: "selected columns", budget: 50, sel: Selection{Columns: []string{"ID"}}, columns: []string{"id"}, rows: 100, sum: 5050, chunked: true},
+		{name: "selected rows with a limit", limit: 10, sel: Selection{Where: "id > 50"}, columns: []string{"id", "name"}, rows: 10, sum: 555},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			originalDB := openFixture(t, "CREATE TABLE unit_data (id INTEGER PRIMARY KEY, name TEXT, CHECK (length(name) > 0))")
+			hashedDB := openFixture(t,
+				"CREATE TABLE v1_aaaa (c_1 INTEGER PRIMARY KEY, c_2 TEXT)",
+				rowsInsert("v1_aaaa", 100, "abcdefghij"))
+			newDB := openFixture(t)
+
+			var logs bytes.Buffer
+			ctx := WithLogger(context.Background(), slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+			ctx = WithMemoryBudget(WithRowLimit(WithSelection(ctx, tt.sel), tt.limit), tt.budget)
+			n, err := CopyData(ctx, originalDB, hashedDB, newDB, "unit_data", "v1_aaaa")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != tt.rows {
+				t.Errorf("CopyData = %d rows, want %d", n, tt.rows)
+			}
+
+			columns, err := GetColumnNames(context.Background(), newDB, "unit_data")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(columns, tt.columns) {
+				t.Errorf("columns = %v, want %v", columns, tt.columns)
+			}
+			var rows, sum int
+			if err = newDB.QueryRow("SELECT COUNT(*), COALESCE(SUM(id), 0) FROM unit_data").Scan(&rows, &sum); err != nil {
+				t.Fatal(err)
+			}
+			if rows != tt.rows || sum != tt.sum {
+				t.Errorf("new table has %d rows summing to %d, want %d summing to %d", rows, sum, tt.rows, tt.sum)
+			}
+			if chunked := strings.Contains(logs.String(), "inserting chunk"); chunked != tt.chunked {
+				t.Errorf("inserted in chunks = %v, want %v", chunked, tt.chunked)
+			}
+		})
+	}
+}
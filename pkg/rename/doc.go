@@ -0,0 +1,7 @@
+// Package rename matches the hashed tables of a Princess Connect Re:Dive database
+// to the human-readable tables of an older database, and copies them into a new
+// database under their readable names.
+//
+// The pcr-hash-table-rename command is built on this package, other programs can
+// use it to embed the pipeline instead of running the binary.
+package rename
@@ -0,0 +1,36 @@
+package rename
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNoMatch is returned when an original table has no matching hashed table
+var ErrNoMatch = errors.New("no matching table")
+
+// ErrSchemaMismatch is returned when a hashed table does not have the columns
+// of the original table it matched
+var ErrSchemaMismatch = errors.New("schema mismatch")
+
+// ErrCopyFailed is returned when a table could not be copied into the new database
+type ErrCopyFailed struct {
+	Table string
+	Err   error
+}
+
+func (e *ErrCopyFailed) Error() string {
+	return fmt.Sprintf("copying table %s: %v", e.Table, e.Err)
+}
+
+func (e *ErrCopyFailed) Unwrap() error {
+	return e.Err
+}
+
+// Err returns an error wrapping ErrNoMatch if some tables have no match, nil otherwise
+func (m Mapping) Err() error {
+	if len(m.Unmatched) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w for %d table(s): %s", ErrNoMatch, len(m.Unmatched), strings.Join(m.Unmatched, ", "))
+}
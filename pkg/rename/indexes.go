@@ -0,0 +1,254 @@
+package rename
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	uniqueIndexRegex  = regexp.MustCompile(`(?i)^\s*CREATE\s+UNIQUE\s`)
+	partialIndexRegex = regexp.MustCompile(`(?i)\)\s*WHERE\s`)
+)
+
+// IndexMatch is an index of an original table and the index of its hashed table with the same definition
+type IndexMatch struct {
+	Name       string `json:"name"`
+	HashedName string `json:"hashedName"`
+	// Table is the original table of the index
+	Table string `json:"table"`
+}
+
+// index is an index created by a CREATE INDEX statement
+type index struct {
+	name string
+	sql  string
+	// columns are the positions of the indexed columns in the table, -1 for the rowid
+	// and -2 for an expression
+	columns []int
+}
+
+// signature returns what two indexes of tables with the same columns must have in common to match,
+// their names aside
+func (i index) signature() string {
+	return fmt.Sprintf("unique=%t partial=%t columns=%v", uniqueIndexRegex.MatchString(i.sql), partialIndexRegex.MatchString(i.sql), i.columns)
+}
+
+// kept returns whether i only indexes columns of sel, columns being the names of the columns of its table
+func (i index) kept(columns []string, sel Selection) bool {
+	for _, cid := range i.columns {
+		switch {
+		case cid >= len(columns):
+			return false
+		case cid >= 0 && !sel.kept(columns[cid]):
+			return false
+		case cid == -2:
+			// an expression may use any column
+			for _, identifier := range sqlIdentifiers(i.sql) {
+				if containsFold(columns, identifier.name) && !sel.kept(identifier.name) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// getIndexes returns the indexes of table sorted by name, without the automatic indexes of
+// PRIMARY KEY and UNIQUE constraints, which CREATE TABLE creates
+func getIndexes(ctx context.Context, db *sql.DB, table string) ([]index, error) {
+	queryCtx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(queryCtx, "SELECT name, sql FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND sql IS NOT NULL ORDER BY name", table)
+	if err != nil {
+		return nil, fmt.Errorf("error listing indexes of table %s: %w", table, err)
+	}
+	var indexes []index
+	for rows.Next() {
+		var i index
+		if err = rows.Scan(&i.name, &i.sql); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning indexes of table %s: %w", table, err)
+		}
+		indexes = append(indexes, i)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for n := range indexes {
+		if indexes[n].columns, err = getIndexColumns(ctx, db, indexes[n].name); err != nil {
+			return nil, err
+		}
+	}
+	return indexes, nil
+}
+
+func getIndexColumns(ctx context.Context, db *sql.DB, name string) ([]int, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("PRAGMA index_info('%s')", strings.ReplaceAll(name, "'", "''")))
+	if err != nil {
+		return nil, fmt.Errorf("error reading index %s: %w", name, err)
+	}
+	defer rows.Close()
+
+	var columns []int
+	for rows.Next() {
+		var seqno, cid int
+		var column sql.NullString
+		if err = rows.Scan(&seqno, &cid, &column); err != nil {
+			return nil, fmt.Errorf("error scanning index %s: %w", name, err)
+		}
+		columns = append(columns, cid)
+	}
+	return columns, rows.Err()
+}
+
+// MatchIndexes matches the indexes of the original table of match with the indexes of its hashed
+// table, by the positions of their columns and whether they are unique or partial.
+// Indexes with the same definition are matched in the order of their names.
+func MatchIndexes(ctx context.Context, originalDB, hashedDB *sql.DB, match TableMatch) ([]IndexMatch, error) {
+	originalIndexes, err := getIndexes(ctx, originalDB, match.Name)
+	if err != nil {
+		return nil, err
+	}
+	if len(originalIndexes) == 0 {
+		return nil, nil
+	}
+	hashedIndexes, err := getIndexes(ctx, hashedDB, match.HashedName)
+	if err != nil {
+		return nil, err
+	}
+
+	bySignature := map[string][]string{}
+	for _, i := range hashedIndexes {
+		bySignature[i.signature()] = append(bySignature[i.signature()], i.name)
+	}
+	var matches []IndexMatch
+	for _, i := range originalIndexes {
+		hashedNames := bySignature[i.signature()]
+		if len(hashedNames) == 0 {
+			continue
+		}
+		matches = append(matches, IndexMatch{Name: i.name, HashedName: hashedNames[0], Table: match.Name})
+		bySignature[i.signature()] = hashedNames[1:]
+	}
+	return matches, nil
+}
+
+// CopyIndexes creates the indexes of the hashed table of match on its table in newDB,
+// which must already be created. Indexes matched in indexes are created with their
+// definition in originalDB, the others with their hashed name and the columns of the
+// table in newDB. Partial indexes and indexes on expressions that are not matched are left out.
+// With the Columns of WithSelection, the indexes on the other columns are left out.
+// Errors are *ErrCopyFailed.
+func CopyIndexes(ctx context.Context, originalDB, hashedDB, newDB *sql.DB, match TableMatch, indexes []IndexMatch) error {
+	// the table in newDB lacks the columns left out, the original table has all of them
+	db, table := newDB, match.Name
+	if len(selectionFrom(ctx).Columns) > 0 {
+		db = originalDB
+	}
+	columns, err := GetColumnNames(ctx, db, table)
+	if err == nil {
+		err = copyIndexes(ctx, originalDB, hashedDB, newDB, match, indexes, columns)
+	}
+	if err != nil {
+		return &ErrCopyFailed{Table: match.Name, Err: err}
+	}
+	return nil
+}
+
+// CopyIndexesAs creates the indexes of the hashed table of match on its table in newDB with their
+// hashed names, like CopyIndexes without matched indexes, for when the original database is not
+// available. columns are the names of the columns of the hashed table in newDB, in order, including
+// the ones left out by the Columns of WithSelection. Errors are *ErrCopyFailed.
+func CopyIndexesAs(ctx context.Context, hashedDB, newDB *sql.DB, match TableMatch, columns []string) error {
+	if err := copyIndexes(ctx, nil, hashedDB, newDB, match, nil, columns); err != nil {
+		return &ErrCopyFailed{Table: match.Name, Err: err}
+	}
+	return nil
+}
+
+func copyIndexes(ctx context.Context, originalDB, hashedDB, newDB *sql.DB, match TableMatch, indexes []IndexMatch, columns []string) error {
+	logger := loggerFrom(ctx)
+	sel := selectionFrom(ctx)
+	hashedIndexes, err := getIndexes(ctx, hashedDB, match.HashedName)
+	if err != nil || len(hashedIndexes) == 0 {
+		return err
+	}
+
+	matched := map[string]string{}
+	for _, i := range indexes {
+		if i.Table == match.Name {
+			matched[i.HashedName] = i.Name
+		}
+	}
+	var originalIndexes map[string]index
+	if len(matched) > 0 {
+		list, err := getIndexes(ctx, originalDB, match.Name)
+		if err != nil {
+			return err
+		}
+		originalIndexes = make(map[string]index, len(list))
+		for _, i := range list {
+			originalIndexes[i.name] = i
+		}
+	}
+
+	for _, i := range hashedIndexes {
+		if !i.kept(columns, sel) {
+			logger.DebugContext(ctx, "not copying index of a column left out", "table", match.Name, "hashedIndex", i.name)
+			continue
+		}
+		var createStmt string
+		if name, ok := matched[i.name]; ok {
+			if !originalIndexes[name].kept(columns, sel) {
+				logger.DebugContext(ctx, "not copying index of a column left out", "table", match.Name, "index", name)
+				continue
+			}
+			createStmt = originalIndexes[name].sql
+		} else if createStmt, ok = createIndexStatement(i, match.Name, columns); !ok {
+			logger.WarnContext(ctx, "not copying partial or expression index without a match", "table", match.Name, "hashedIndex", i.name)
+			continue
+		}
+		logger.Log(ctx, LevelTrace, "creating index", "table", match.Name, "hashedIndex", i.name, "sql", createStmt)
+		if err = execContext(ctx, newDB, createStmt); err != nil {
+			return fmt.Errorf("error creating index %s: %w", i.name, err)
+		}
+	}
+	return nil
+}
+
+// createIndexStatement returns the CREATE INDEX statement of i on table with the given columns,
+// false if i indexes an expression or is partial, since those name the hashed columns
+func createIndexStatement(i index, table string, columns []string) (string, bool) {
+	if partialIndexRegex.MatchString(i.sql) {
+		return "", false
+	}
+	names := make([]string, 0, len(i.columns))
+	for _, cid := range i.columns {
+		switch {
+		case cid == -1:
+			names = append(names, "rowid")
+		case cid < 0 || cid >= len(columns):
+			return "", false
+		default:
+			names = append(names, quote(columns[cid]))
+		}
+	}
+	create := "CREATE INDEX"
+	if uniqueIndexRegex.MatchString(i.sql) {
+		create = "CREATE UNIQUE INDEX"
+	}
+	return fmt.Sprintf("%s %s ON %s (%s)", create, quote(i.name), quote(table), strings.Join(names, ", ")), true
+}
+
+func quote(identifier string) string {
+	return `"` + strings.ReplaceAll(identifier, `"`, `""`) + `"`
+}
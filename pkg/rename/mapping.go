@@ -0,0 +1,275 @@
+package rename
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TableMatch is an original table and the hashed table holding its data
+type TableMatch struct {
+	Name       string `json:"name"`
+	HashedName string `json:"hashedName"`
+	// 1 if no other hashed table has the same first rows, 1/n if n hashed tables do
+	Confidence float64 `json:"confidence"`
+	// Original is the index of the original database the table was matched from, 0 for the
+	// one passed to NewRunner and i for the i-th one added with Runner.AddOriginal
+	Original int `json:"original,omitempty"`
+	// Manual is set for a match chosen by a user instead of matching by data
+	Manual bool `json:"manual,omitempty"`
+}
+
+// Mapping is the result of matching the tables of two databases
+type Mapping struct {
+	// matched tables, sorted by name
+	Tables []TableMatch `json:"tables"`
+	// original tables without a matching hashed table
+	Unmatched []string `json:"unmatched"`
+	// hashed tables no original table matched, only known when every table is matched
+	New []string `json:"new"`
+	// indexes of the matched tables with a matching hashed index, sorted by name,
+	// unless Options.SkipIndexes is set
+	Indexes []IndexMatch `json:"indexes,omitempty"`
+}
+
+// Map returns the mapping of original table name -> hashed table name
+func (m Mapping) Map() map[string]string {
+	tables := make(map[string]string, len(m.Tables))
+	for _, t := range m.Tables {
+		tables[t.Name] = t.HashedName
+	}
+	return tables
+}
+
+// IndexMap returns the mapping of original index name -> hashed index name
+func (m Mapping) IndexMap() map[string]string {
+	indexes := make(map[string]string, len(m.Indexes))
+	for _, i := range m.Indexes {
+		indexes[i.Name] = i.HashedName
+	}
+	return indexes
+}
+
+// MappingTable is the table WriteMappingTable writes to
+const MappingTable = "_table_mapping"
+
+// WriteMappingTable writes tables into a MappingTable table of db, replacing it if it exists,
+// so the provenance of every renamed table travels with the database
+func WriteMappingTable(ctx context.Context, db *sql.DB, tables []TableMatch) error {
+	if err := execContext(ctx, db, fmt.Sprintf("DROP TABLE IF EXISTS %s;", MappingTable)); err != nil {
+		return fmt.Errorf("error dropping %s table: %w", MappingTable, err)
+	}
+	if err := execContext(ctx, db, fmt.Sprintf("CREATE TABLE %s (original_name TEXT PRIMARY KEY, hashed_name TEXT NOT NULL, confidence REAL NOT NULL, manual INTEGER NOT NULL DEFAULT 0);", MappingTable)); err != nil {
+		return fmt.Errorf("error creating %s table: %w", MappingTable, err)
+	}
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, t := range tables {
+		if _, err = tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s VALUES (?, ?, ?, ?)", MappingTable), t.Name, t.HashedName, t.Confidence, t.Manual); err != nil {
+			return fmt.Errorf("error writing %s table: %w", MappingTable, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Report describes how a Mapping was found
+type Report struct {
+	// original tables left out by Options.Tables or Options.Exclude
+	Skipped []string
+	// original tables whose match was kept from Options.Reuse without matching them again
+	Reused []string
+	// closest hashed tables of every unmatched table
+	Candidates map[string][]Candidate
+	// time spent matching each original table
+	MatchDurations map[string]time.Duration
+	// sampled rows of the original tables, see Options.OriginalTables
+	OriginalTables Tables
+	// time spent reading the sampled rows of both databases
+	ReadDuration time.Duration
+	// time spent matching, including the candidate search
+	MatchDuration time.Duration
+}
+
+// MatchTables finds the hashed table in hashedDB holding the data of each table of originalDB
+func MatchTables(ctx context.Context, originalDB, hashedDB *sql.DB, opts Options) (Mapping, Report, error) {
+	mapping := Mapping{Tables: []TableMatch{}, Unmatched: []string{}, New: []string{}}
+	report := Report{
+		Skipped:        []string{},
+		Candidates:     map[string][]Candidate{},
+		MatchDurations: map[string]time.Duration{},
+	}
+
+	ctx = opts.context(ctx)
+	logger := loggerFrom(ctx)
+
+	sampleRows, workers := opts.SampleRows, opts.Workers
+	if sampleRows < 1 {
+		sampleRows = 1
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	progress(ctx, Event{Kind: EventPhaseChanged, Phase: PhaseRead})
+	start := time.Now()
+	originalTables := opts.OriginalTables
+	var originalErr error
+	var read sync.WaitGroup
+	if originalTables == nil {
+		read.Add(1)
+		readOriginal := func() {
+			defer read.Done()
+			originalTables, originalErr = ReadTables(ctx, originalDB, true, sampleRows)
+		}
+		// with several read workers, both databases are read at the same time
+		if opts.ReadWorkers > 1 {
+			go readOriginal()
+		} else {
+			readOriginal()
+		}
+	}
+	hashedTables, err := ReadTables(ctx, hashedDB, false, sampleRows)
+	read.Wait()
+	if originalErr != nil {
+		return mapping, report, originalErr
+	}
+	if err != nil {
+		return mapping, report, err
+	}
+	report.OriginalTables = originalTables
+	report.ReadDuration = time.Since(start)
+
+	// the first error of a worker stops the others
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var firstErr error
+
+	progress(ctx, Event{Kind: EventPhaseChanged, Phase: PhaseMatch})
+	start = time.Now()
+	queue := make(chan string)
+	go func() {
+		defer close(queue)
+		for _, t := range originalTables.Names() {
+			_, included := opts.Tables[t]
+			_, excluded := opts.Exclude[t]
+			if (len(opts.Tables) > 0 && !included) || excluded {
+				report.Skipped = append(report.Skipped, t)
+				continue
+			}
+			select {
+			case queue <- t:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	matchedHashed := map[string]struct{}{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range queue {
+				v := originalTables[t]
+				matchStart := time.Now()
+				var hashedTable string
+				var ok bool
+				var err error
+				if opts.HashedName != nil {
+					hashedTable = opts.HashedName(t)
+					_, ok = hashedTables[hashedTable]
+				} else {
+					hashedTable, ok, err = findMatchingTable(ctx, v, hashedDB, hashedTables, t, opts.RowRanges)
+				}
+				duration := time.Since(matchStart)
+
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+					}
+					cancel()
+				} else if ok {
+					confidence := 1.0
+					if opts.HashedName == nil {
+						confidence = 1 / float64(countSameFirstRows(v, hashedTables))
+					}
+					mapping.Tables = append(mapping.Tables, TableMatch{
+						Name:       t,
+						HashedName: hashedTable,
+						Confidence: confidence,
+					})
+					matchedHashed[hashedTable] = struct{}{}
+					logger.DebugContext(ctx, "matched table", "table", t, "hashedTable", hashedTable)
+				} else {
+					mapping.Unmatched = append(mapping.Unmatched, t)
+				}
+				if err == nil {
+					progress(ctx, Event{Kind: EventTableMatched, Table: t, HashedTable: hashedTable})
+				}
+				report.MatchDurations[t] = duration
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return mapping, report, firstErr
+	}
+	if err = ctx.Err(); err != nil {
+		return mapping, report, err
+	}
+
+	if len(opts.Tables) == 0 {
+		for _, t := range hashedTables.Names() {
+			if _, ok := matchedHashed[t]; !ok {
+				mapping.New = append(mapping.New, t)
+			}
+		}
+	}
+
+	if len(mapping.Unmatched) > 0 {
+		// the hashed tables are read once for all the unmatched tables
+		samples, err := readCandidateSamples(ctx, hashedDB, hashedTables.Names(), matchedHashed)
+		if err != nil {
+			return mapping, report, err
+		}
+		for _, t := range mapping.Unmatched {
+			if report.Candidates[t], err = findCandidates(ctx, originalDB, samples, t); err != nil {
+				return mapping, report, err
+			}
+		}
+	}
+	report.MatchDuration = time.Since(start)
+
+	sort.Slice(mapping.Tables, func(i, j int) bool {
+		return mapping.Tables[i].Name < mapping.Tables[j].Name
+	})
+	sort.Strings(mapping.Unmatched)
+	sort.Strings(mapping.New)
+	sort.Strings(report.Skipped)
+
+	return mapping, report, nil
+}
+
+// countSameFirstRows returns the number of hashed tables whose first rows are values
+func countSameFirstRows(values [][]string, hashedTables Tables) int {
+	count := 0
+	for _, t := range hashedTables.Names() {
+		if CompareData(values, hashedTables[t]) {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,159 @@
+package rename
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// TableMappingSchema is the JSON Schema of table mapping files, version TableMappingSchemaVersion
+//
+//go:embed schema/table_mapping.v1.json
+var TableMappingSchema []byte
+
+// TableMappingSchemaVersion is the version of TableMappingSchema
+const TableMappingSchemaVersion = 1
+
+var (
+	hashedTableRegex  = regexp.MustCompile(`^v1_[0-9a-f]+$`)
+	hashedColumnRegex = regexp.MustCompile(`^c_[0-9a-f]+$`)
+)
+
+// TableMappingEntry is a table of a table mapping file. Files written by the
+// pcr-hash-table-rename command only hold the hashed name of every table.
+type TableMappingEntry struct {
+	HashedName string            `json:"hashedName"`
+	Confidence float64           `json:"confidence,omitempty"`
+	Columns    map[string]string `json:"columns,omitempty"`
+}
+
+// MappingFileError is an error of a table mapping file at a location
+type MappingFileError struct {
+	// Pointer is the JSON pointer of the invalid value, e.g. /unit_data/confidence
+	Pointer string
+	// Line and Column locate the invalid value in the file, starting at 1
+	Line, Column int
+	Msg          string
+}
+
+func (e *MappingFileError) Error() string {
+	if e.Pointer == "" {
+		return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Msg)
+	}
+	return fmt.Sprintf("line %d, column %d: %s: %s", e.Line, e.Column, e.Pointer, e.Msg)
+}
+
+// ParseTableMapping validates data against TableMappingSchema and returns its tables.
+// Errors are *MappingFileError.
+func ParseTableMapping(data []byte) (map[string]TableMappingEntry, error) {
+	fail := func(offset int64, pointer, format string, v ...interface{}) error {
+		line, column := location(data, offset)
+		return &MappingFileError{Pointer: pointer, Line: line, Column: column, Msg: fmt.Sprintf(format, v...)}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, fail(0, "", "a table mapping must be a JSON object")
+	}
+
+	tables := map[string]TableMappingEntry{}
+	for dec.More() {
+		offset := skipSpace(data, dec.InputOffset())
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, syntaxError(data, err, fail)
+		}
+		name := tok.(string)
+		pointer := "/" + escapePointer(name)
+		if _, ok := tables[name]; ok {
+			return nil, fail(offset, pointer, "duplicate table")
+		}
+
+		var raw json.RawMessage
+		offset = skipSpace(data, dec.InputOffset())
+		if err = dec.Decode(&raw); err != nil {
+			return nil, syntaxError(data, err, fail)
+		}
+		if name == "$schema" {
+			var schema string
+			if json.Unmarshal(raw, &schema) != nil {
+				return nil, fail(offset, pointer, "must be a string")
+			}
+			continue
+		}
+
+		var entry TableMappingEntry
+		switch raw[0] {
+		case '"':
+			_ = json.Unmarshal(raw, &entry.HashedName)
+			if !hashedTableRegex.MatchString(entry.HashedName) {
+				return nil, fail(offset, pointer, "%q is not a hashed table name (%s)", entry.HashedName, hashedTableRegex)
+			}
+		case '{':
+			objDec := json.NewDecoder(bytes.NewReader(raw))
+			objDec.DisallowUnknownFields()
+			if err = objDec.Decode(&entry); err != nil {
+				return nil, fail(offset, pointer, "%s", strings.TrimPrefix(err.Error(), "json: "))
+			}
+			if !hashedTableRegex.MatchString(entry.HashedName) {
+				return nil, fail(offset, pointer+"/hashedName", "%q is not a hashed table name (%s)", entry.HashedName, hashedTableRegex)
+			}
+			if entry.Confidence < 0 || entry.Confidence > 1 {
+				return nil, fail(offset, pointer+"/confidence", "must be between 0 and 1, got %v", entry.Confidence)
+			}
+			for column, hashedColumn := range entry.Columns {
+				if !hashedColumnRegex.MatchString(hashedColumn) {
+					return nil, fail(offset, pointer+"/columns/"+escapePointer(column), "%q is not a hashed column name (%s)", hashedColumn, hashedColumnRegex)
+				}
+			}
+		default:
+			return nil, fail(offset, pointer, "must be a hashed table name or an object with a hashedName")
+		}
+		tables[name] = entry
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, syntaxError(data, err, fail)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fail(dec.InputOffset(), "", "unexpected data after the table mapping")
+	}
+	return tables, nil
+}
+
+// syntaxError locates a JSON syntax error
+func syntaxError(data []byte, err error, fail func(int64, string, string, ...interface{}) error) error {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return fail(syntaxErr.Offset, "", "%s", syntaxErr.Error())
+	}
+	return fail(int64(len(data)), "", "%s", err.Error())
+}
+
+// location returns the line and column of offset in data, starting at 1
+func location(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	return line, int(offset) - bytes.LastIndexByte(before, '\n')
+}
+
+// skipSpace returns the offset of the first byte of data from offset that is not
+// whitespace or a separator, where the next token starts
+func skipSpace(data []byte, offset int64) int64 {
+	for offset < int64(len(data)) && strings.IndexByte(" \t\r\n,:", data[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
+
+func escapePointer(s string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(s)
+}
@@ -0,0 +1,223 @@
+package rename
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"sort"
+)
+
+// number of rows sampled from each table when scoring candidates
+const candidateSampleRows = 10
+
+// number of candidates returned per unmatched table
+const maxCandidates = 5
+
+// RowRange bounds the number of rows of the hashed table matching an original table,
+// to tell apart tables with the same first rows. 0 means no bound.
+type RowRange struct {
+	Min, Max int
+}
+
+func (r RowRange) contains(rows int) bool {
+	return (r.Min == 0 || rows >= r.Min) && (r.Max == 0 || rows <= r.Max)
+}
+
+// DefaultRowRanges returns the row ranges of tables known to share their first rows
+func DefaultRowRanges() map[string]RowRange {
+	return map[string]RowRange{
+		// these 2 tables have the same data but different number of rows
+		// looks like unit_unique_equip is deprecated and there are only 183 rows
+		"unit_unique_equipment": {Min: 200},
+		"unit_unique_equip":     {Max: 200},
+	}
+}
+
+// FindMatchingTable returns the table of hashedTables whose first rows are the
+// same as values, the first rows of table in the original database, using DefaultRowRanges
+func FindMatchingTable(ctx context.Context, values [][]string, hashedDB *sql.DB, hashedTables Tables, table string) (string, bool, error) {
+	return findMatchingTable(ctx, values, hashedDB, hashedTables, table, DefaultRowRanges())
+}
+
+func findMatchingTable(ctx context.Context, values [][]string, hashedDB *sql.DB, hashedTables Tables, table string, rowRanges map[string]RowRange) (string, bool, error) {
+	if len(values) == 0 {
+		return "", false, nil
+	}
+	// in name order, so of several tables with the same first rows the same one is picked on every run
+	for _, t := range hashedTables.Names() {
+		v := hashedTables[t]
+		if len(v) == 0 {
+			continue
+		}
+		if CompareData(values, v) {
+			if r, ok := rowRanges[table]; ok {
+				rowsCount, err := CountRows(ctx, hashedDB, t)
+				if err != nil {
+					return "", false, err
+				}
+				if !r.contains(rowsCount) {
+					continue
+				}
+			}
+			return t, true, nil
+		}
+	}
+
+	return "", false, nil
+}
+
+// CompareData reports whether data1 and data2 hold the same rows in the same order
+func CompareData(data1, data2 [][]string) bool {
+	if len(data1) != len(data2) {
+		return false
+	}
+
+	for i := range data1 {
+		if !reflect.DeepEqual(data1[i], data2[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Candidate is a hashed table that may be the match of an unmatched table
+type Candidate struct {
+	Table       string
+	SchemaScore float64
+	RowScore    float64
+}
+
+// Score is the overall similarity of the candidate, between 0 and 1
+func (c Candidate) Score() float64 {
+	return (c.SchemaScore + c.RowScore) / 2
+}
+
+// FindCandidates returns the closest hashed tables to table, ranked by schema
+// similarity and partial row overlap, leaving out the tables in exclude
+func FindCandidates(ctx context.Context, originalDB, hashedDB *sql.DB, hashedTables Tables, table string, exclude map[string]struct{}) ([]Candidate, error) {
+	samples, err := readCandidateSamples(ctx, hashedDB, hashedTables.Names(), exclude)
+	if err != nil {
+		return nil, err
+	}
+	return findCandidates(ctx, originalDB, samples, table)
+}
+
+// candidateSample is the declared column types and first rows of a hashed table, compared to rank the candidates
+type candidateSample struct {
+	table string
+	types []string
+	rows  [][]string
+}
+
+// readCandidateSamples reads the candidateSample of the tables, in order, leaving out the tables in exclude.
+// They are read once and compared to every unmatched table.
+func readCandidateSamples(ctx context.Context, hashedDB *sql.DB, tables []string, exclude map[string]struct{}) ([]candidateSample, error) {
+	var samples []candidateSample
+	for _, t := range tables {
+		if _, ok := exclude[t]; ok {
+			continue
+		}
+		types, err := GetColumnTypes(ctx, hashedDB, t)
+		if err != nil {
+			return nil, err
+		}
+		rows, err := GetFirstNRows(ctx, hashedDB, t, candidateSampleRows)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, candidateSample{table: t, types: types, rows: rows})
+	}
+	return samples, nil
+}
+
+// findCandidates returns the closest hashed tables of samples to table, see FindCandidates
+func findCandidates(ctx context.Context, originalDB *sql.DB, samples []candidateSample, table string) ([]Candidate, error) {
+	origTypes, err := GetColumnTypes(ctx, originalDB, table)
+	if err != nil {
+		return nil, err
+	}
+	origRows, err := GetFirstNRows(ctx, originalDB, table, candidateSampleRows)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []Candidate
+	for _, sample := range samples {
+		c := Candidate{
+			Table:       sample.table,
+			SchemaScore: compareSchema(origTypes, sample.types),
+			RowScore:    compareRows(origRows, sample.rows),
+		}
+		if c.Score() > 0 {
+			candidates = append(candidates, c)
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Score() != candidates[j].Score() {
+			return candidates[i].Score() > candidates[j].Score()
+		}
+		return candidates[i].Table < candidates[j].Table
+	})
+	if len(candidates) > maxCandidates {
+		candidates = candidates[:maxCandidates]
+	}
+	return candidates, nil
+}
+
+// compareSchema returns the fraction of column positions with the same declared type
+func compareSchema(types1, types2 []string) float64 {
+	longest := len(types1)
+	if len(types2) > longest {
+		longest = len(types2)
+	}
+	if longest == 0 {
+		return 0
+	}
+
+	same := 0
+	for i := 0; i < len(types1) && i < len(types2); i++ {
+		if types1[i] == types2[i] {
+			same++
+		}
+	}
+	return float64(same) / float64(longest)
+}
+
+// compareRows returns how well the sampled rows of data1 are covered by data2,
+// averaging the best cell-by-cell overlap of every row in data1
+func compareRows(data1, data2 [][]string) float64 {
+	if len(data1) == 0 || len(data2) == 0 {
+		return 0
+	}
+
+	total := 0.0
+	for _, row1 := range data1 {
+		best := 0.0
+		for _, row2 := range data2 {
+			if overlap := compareCells(row1, row2); overlap > best {
+				best = overlap
+			}
+		}
+		total += best
+	}
+	return total / float64(len(data1))
+}
+
+func compareCells(row1, row2 []string) float64 {
+	longest := len(row1)
+	if len(row2) > longest {
+		longest = len(row2)
+	}
+	if longest == 0 {
+		return 0
+	}
+
+	same := 0
+	for i := 0; i < len(row1) && i < len(row2); i++ {
+		if row1[i] == row2[i] {
+			same++
+		}
+	}
+	return float64(same) / float64(longest)
+}
@@ -0,0 +1,242 @@
+package rename
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"sync/atomic"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+// fixtures counts the in-memory databases opened by the tests, to give each its own name
+var fixtures atomic.Int64
+
+// openFixture opens an in-memory database holding the tables created by stmts. Its connections
+// share the same cache, so the database lives as long as the pool.
+func openFixture(t *testing.T, stmts ...string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:fixture%d?mode=memory&cache=shared", fixtures.Add(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	for _, stmt := range stmts {
+		if _, err = db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	return db
+}
+
+// rowsInsert returns the statement inserting the rows (1, value) to (n, value) into table
+func rowsInsert(table string, n int, value string) string {
+	return fmt.Sprintf("WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < %d) INSERT INTO %s SELECT i, '%s' FROM n", n, table, value)
+}
+
+func TestCompareData(t *testing.T) {
+	tests := []struct {
+		name         string
+		data1, data2 [][]string
+		want         bool
+	}{
+		{"same rows", [][]string{{"1", "a"}, {"2", "b"}}, [][]string{{"1", "a"}, {"2", "b"}}, true},
+		{"no rows", nil, [][]string{}, true},
+		{"different cell", [][]string{{"1", "a"}}, [][]string{{"1", "b"}}, false},
+		{"different order", [][]string{{"1", "a"}, {"2", "b"}}, [][]string{{"2", "b"}, {"1", "a"}}, false},
+		{"more rows", [][]string{{"1", "a"}}, [][]string{{"1", "a"}, {"2", "b"}}, false},
+		{"more columns", [][]string{{"1", "a"}}, [][]string{{"1", "a", ""}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CompareData(tt.data1, tt.data2); got != tt.want {
+				t.Errorf("CompareData(%v, %v) = %v, want %v", tt.data1, tt.data2, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchTables(t *testing.T) {
+	tests := []struct {
+		name             string
+		original, hashed []string
+		opts             func(*Options)
+		// matched are the hashed tables by original table
+		matched                 map[string]string
+		unmatched, new, skipped []string
+	}{
+		{
+			name: "same first rows",
+			original: []string{
+				"CREATE TABLE unit_data (unit_id INTEGER PRIMARY KEY, unit_name TEXT)",
+				"INSERT INTO unit_data VALUES (1, 'a'), (2, 'b')",
+				"CREATE TABLE skill_data (skill_id INTEGER PRIMARY KEY, name TEXT)",
+				"INSERT INTO skill_data VALUES (10, 'x')",
+			},
+			hashed: []string{
+				"CREATE TABLE v1_aaaa (c_1 INTEGER PRIMARY KEY, c_2 TEXT)",
+				"INSERT INTO v1_aaaa VALUES (10, 'x')",
+				"CREATE TABLE v1_bbbb (c_1 INTEGER PRIMARY KEY, c_2 TEXT)",
+				"INSERT INTO v1_bbbb VALUES (1, 'a'), (2, 'b')",
+			},
+			matched: map[string]string{"unit_data": "v1_bbbb", "skill_data": "v1_aaaa"},
+		},
+		{
+			name: "unmatched and new tables",
+			original: []string{
+				"CREATE TABLE quest_data (quest_id INTEGER PRIMARY KEY, name TEXT)",
+				"INSERT INTO quest_data VALUES (1, 'q')",
+			},
+			hashed: []string{
+				"CREATE TABLE v1_cccc (c_1 INTEGER PRIMARY KEY, c_2 TEXT)",
+				"INSERT INTO v1_cccc VALUES (1, 'changed')",
+			},
+			matched:   map[string]string{},
+			unmatched: []string{"quest_data"},
+			new:       []string{"v1_cccc"},
+		},
+		{
+			name: "empty table",
+			original: []string{
+				"CREATE TABLE event_data (event_id INTEGER PRIMARY KEY)",
+			},
+			hashed: []string{
+				"CREATE TABLE v1_dddd (c_1 INTEGER PRIMARY KEY)",
+			},
+			matched:   map[string]string{},
+			unmatched: []string{"event_data"},
+			new:       []string{"v1_dddd"},
+		},
+		{
+			name: "row ranges",
+			original: []string{
+				"CREATE TABLE unit_unique_equip (unit_id INTEGER, name TEXT)",
+				"INSERT INTO unit_unique_equip VALUES (1, 'e')",
+				"CREATE TABLE unit_unique_equipment (unit_id INTEGER, name TEXT)",
+				"INSERT INTO unit_unique_equipment VALUES (1, 'e')",
+			},
+			hashed: []string{
+				"CREATE TABLE v1_aaaa (c_1 INTEGER, c_2 TEXT)",
+				rowsInsert("v1_aaaa", 250, "e"),
+				"CREATE TABLE v1_bbbb (c_1 INTEGER, c_2 TEXT)",
+				rowsInsert("v1_bbbb", 150, "e"),
+			},
+			matched: map[string]string{"unit_unique_equip": "v1_bbbb", "unit_unique_equipment": "v1_aaaa"},
+		},
+		{
+			name: "same first rows picks the first hashed table by name",
+			original: []string{
+				"CREATE TABLE unit_data (unit_id INTEGER, unit_name TEXT)",
+				"INSERT INTO unit_data VALUES (1, 'a')",
+			},
+			hashed: []string{
+				"CREATE TABLE v1_ffff (c_1 INTEGER, c_2 TEXT)",
+				"INSERT INTO v1_ffff VALUES (1, 'a')",
+				"CREATE TABLE v1_eeee (c_1 INTEGER, c_2 TEXT)",
+				"INSERT INTO v1_eeee VALUES (1, 'a')",
+			},
+			matched: map[string]string{"unit_data": "v1_eeee"},
+			new:     []string{"v1_ffff"},
+		},
+		{
+			name: "more sampled rows",
+			original: []string{
+				"CREATE TABLE unit_data (unit_id INTEGER, unit_name TEXT)",
+				"INSERT INTO unit_data VALUES (1, 'a'), (2, 'b')",
+			},
+			hashed: []string{
+				"CREATE TABLE v1_eeee (c_1 INTEGER, c_2 TEXT)",
+				"INSERT INTO v1_eeee VALUES (1, 'a'), (2, 'c')",
+				"CREATE TABLE v1_ffff (c_1 INTEGER, c_2 TEXT)",
+				"INSERT INTO v1_ffff VALUES (1, 'a'), (2, 'b')",
+			},
+			opts:    func(o *Options) { o.SampleRows = 2 },
+			matched: map[string]string{"unit_data": "v1_ffff"},
+			new:     []string{"v1_eeee"},
+		},
+		{
+			name: "tables and exclude",
+			original: []string{
+				"CREATE TABLE unit_data (unit_id INTEGER PRIMARY KEY)",
+				"INSERT INTO unit_data VALUES (1)",
+				"CREATE TABLE skill_data (skill_id INTEGER PRIMARY KEY)",
+				"INSERT INTO skill_data VALUES (2)",
+				"CREATE TABLE quest_data (quest_id INTEGER PRIMARY KEY)",
+				"INSERT INTO quest_data VALUES (3)",
+			},
+			hashed: []string{
+				"CREATE TABLE v1_aaaa (c_1 INTEGER PRIMARY KEY)",
+				"INSERT INTO v1_aaaa VALUES (1)",
+			},
+			opts: func(o *Options) {
+				o.Tables = map[string]struct{}{"unit_data": {}, "skill_data": {}}
+				o.Exclude = map[string]struct{}{"skill_data": {}}
+			},
+			matched: map[string]string{"unit_data": "v1_aaaa"},
+			skipped: []string{"quest_data", "skill_data"},
+		},
+		{
+			name: "hashed tables of the original database",
+			original: []string{
+				"CREATE TABLE unit_data (unit_id INTEGER PRIMARY KEY)",
+				"INSERT INTO unit_data VALUES (1)",
+				"CREATE TABLE v2_aaaa (c_1 INTEGER PRIMARY KEY)",
+				"INSERT INTO v2_aaaa VALUES (2)",
+			},
+			hashed: []string{
+				"CREATE TABLE v2_bbbb (c_1 INTEGER PRIMARY KEY)",
+				"INSERT INTO v2_bbbb VALUES (1)",
+			},
+			opts:    func(o *Options) { o.HashedPrefix = "v2_" },
+			matched: map[string]string{"unit_data": "v2_bbbb"},
+		},
+		{
+			name: "hashed name",
+			original: []string{
+				"CREATE TABLE unit_data (unit_id INTEGER PRIMARY KEY)",
+				"INSERT INTO unit_data VALUES (1)",
+				"CREATE TABLE skill_data (skill_id INTEGER PRIMARY KEY)",
+			},
+			hashed: []string{
+				"CREATE TABLE v1_unit_data (c_1 INTEGER PRIMARY KEY)",
+				"INSERT INTO v1_unit_data VALUES (2)",
+			},
+			opts:      func(o *Options) { o.HashedName = func(table string) string { return "v1_" + table } },
+			matched:   map[string]string{"unit_data": "v1_unit_data"},
+			unmatched: []string{"skill_data"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			originalDB := openFixture(t, tt.original...)
+			hashedDB := openFixture(t, tt.hashed...)
+			opts := DefaultOptions()
+			if tt.opts != nil {
+				tt.opts(&opts)
+			}
+
+			mapping, report, err := MatchTables(context.Background(), originalDB, hashedDB, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			matched := map[string]string{}
+			for _, m := range mapping.Tables {
+				matched[m.Name] = m.HashedName
+			}
+			if fmt.Sprint(matched) != fmt.Sprint(tt.matched) {
+				t.Errorf("matched %v, want %v", matched, tt.matched)
+			}
+			if !slices.Equal(mapping.Unmatched, tt.unmatched) {
+				t.Errorf("unmatched %v, want %v", mapping.Unmatched, tt.unmatched)
+			}
+			if !slices.Equal(mapping.New, tt.new) {
+				t.Errorf("new %v, want %v", mapping.New, tt.new)
+			}
+			if !slices.Equal(report.Skipped, tt.skipped) {
+				t.Errorf("skipped %v, want %v", report.Skipped, tt.skipped)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package rename
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+)
+
+// Options configures the pipeline, start from DefaultOptions
+type Options struct {
+	// Tables limits matching to these original tables, every table is matched if empty
+	Tables map[string]struct{}
+	// RowFilters are SQL conditions on the original column names of the rows to copy of
+	// some original tables, by table name, e.g. only the quests of the current events
+	RowFilters map[string]string
+	// Columns restricts the columns copied of some original tables to these, by table name,
+	// e.g. to leave out large descriptions where only the stats are needed
+	Columns map[string][]string
+	// Exclude leaves these original tables out of matching, e.g. tables deprecated in a region
+	Exclude map[string]struct{}
+	// RowRanges tells apart the hashed tables matching these original tables by their row count
+	RowRanges map[string]RowRange
+	// OriginalTables are the sampled rows of the original database returned in Report.OriginalTables
+	// by a previous match with the same SampleRows, so they are not read again when matching
+	// several hashed databases against the same original one
+	OriginalTables Tables
+	// HashedName computes the hashed name of an original table when the hashing scheme is
+	// known, it replaces matching by data: the table matches if hashedDB has a table of that name
+	HashedName func(table string) string
+	// HashedPrefix starts the hashed table names, which are left out of the original database, DefaultHashedPrefix if empty
+	HashedPrefix string
+	// SampleRows is the number of first rows that have to be the same for two tables to match
+	SampleRows int
+	// Workers is the number of tables matched at the same time
+	Workers int
+	// ReadWorkers is the number of tables of a database whose first rows are read at the same time,
+	// each on a connection of its own, before matching. Above 1, both databases are also read at the same time.
+	ReadWorkers int
+	// PageSize is the page size of the new database, the default of SQLite if 0
+	PageSize int
+	// Encoding is the text encoding of the new database, UTF-8, UTF-16le or UTF-16be,
+	// the default of SQLite if empty
+	Encoding string
+	// Pragmas are run on the new database before any table is copied, without the PRAGMA keyword
+	Pragmas []string
+	// FailFast stops Runner.Copy at the first table that could not be copied, otherwise
+	// the error is recorded in TableCopy.Err and the remaining tables are still copied
+	FailFast bool
+	// Resume makes Runner.Copy record the copied tables in the new database and skip
+	// the tables a previous, interrupted Copy into the same database completed
+	Resume bool
+	// CreateMissing makes Runner.Copy create the original tables without a match in the
+	// new database too, with their schema but no rows
+	CreateMissing bool
+	// Append makes Runner.Copy insert the rows into the tables newDB already has, e.g. from a
+	// previous run or a migration tool, instead of creating them. Their indexes are left as they are.
+	Append bool
+	// Truncate makes Runner.Copy delete the rows of the tables newDB already has before
+	// inserting the new ones, with Append
+	Truncate bool
+	// OrderByPK makes Runner.Copy insert the rows in primary key order instead of rowid order,
+	// for stable diffs between versions of the new database
+	OrderByPK bool
+	// LimitRows makes Runner.Copy insert at most this many rows per table, in the same order,
+	// for a small but schema-complete new database. 0 copies every row.
+	LimitRows int
+	// MaxMemory caps the bytes of the rows of a table Runner.Copy holds in memory at a time,
+	// larger tables are read and inserted in chunks. 0 reads every row of a table at once.
+	MaxMemory int64
+	// RateLimit caps the bytes of the rows a Runner reads and inserts per second, waiting as needed,
+	// so a background run does not starve the other processes of the disk. 0 does not limit them.
+	RateLimit int64
+	// Checksums makes Runner.Copy return the TableChecksum of every matched hashed table in TableCopy.Checksum
+	Checksums bool
+	// PreviousChecksums are the TableChecksum of the tables of a previous run, by table name: Runner.Copy
+	// leaves out the matched tables whose hashed table still has the same one, returning them with
+	// TableCopy.Unchanged set, so the new database only holds the tables that changed since
+	PreviousChecksums map[string]string
+	// Reuse are the tables the new database already holds from a previous Runner.Copy, by table name, e.g. a copy
+	// of the previous new database. Runner.Match keeps the match of those whose hashed table still has the same
+	// TableChecksum without matching them again, and Runner.Copy leaves them as they are, returning them with
+	// TableCopy.Reused set, so only the tables that changed since are matched and copied. The new database must not
+	// hold the other tables.
+	Reuse map[string]ReusableTable
+	// SchemaOnly makes Runner.Copy create the matched tables and their indexes without
+	// copying any row, for a readable schema to build queries and models against
+	SchemaOnly bool
+	// SkipIndexes leaves the indexes of the hashed tables out of Runner.Match and Runner.Copy
+	SkipIndexes bool
+	// Logger receives the logs of a Runner, slog.Default() is used if nil
+	Logger *slog.Logger
+	// Progress receives the progress of a Runner if set, see WithProgress
+	Progress func(Event)
+	// Tracer starts the spans of a Runner if set, see WithTracer
+	Tracer Tracer
+}
+
+// DefaultOptions returns the options used by the pcr-hash-table-rename command
+func DefaultOptions() Options {
+	return Options{
+		SampleRows:  1,
+		Workers:     1,
+		ReadWorkers: 1,
+		RowRanges:   DefaultRowRanges(),
+		// a fixed page size and encoding keep the new database byte-identical for identical inputs
+		PageSize: 4096,
+		Encoding: "UTF-8",
+		// using WAL mode to speed up insertions
+		Pragmas: []string{"journal_mode = WAL"},
+	}
+}
+
+// context returns ctx with the Logger, Progress, Tracer, HashedPrefix, ReadWorkers, OrderByPK, LimitRows, MaxMemory
+// and RateLimit, if set
+func (o Options) context(ctx context.Context) context.Context {
+	if o.Logger != nil {
+		ctx = WithLogger(ctx, o.Logger)
+	}
+	if o.Progress != nil {
+		ctx = WithProgress(ctx, o.Progress)
+	}
+	if o.Tracer != nil {
+		ctx = WithTracer(ctx, o.Tracer)
+	}
+	if o.HashedPrefix != "" {
+		ctx = WithHashedPrefix(ctx, o.HashedPrefix)
+	}
+	if o.ReadWorkers > 1 {
+		ctx = WithReadWorkers(ctx, o.ReadWorkers)
+	}
+	if o.OrderByPK {
+		ctx = WithPrimaryKeyOrder(ctx)
+	}
+	if o.LimitRows > 0 {
+		ctx = WithRowLimit(ctx, o.LimitRows)
+	}
+	if o.MaxMemory > 0 {
+		ctx = WithMemoryBudget(ctx, o.MaxMemory)
+	}
+	// the limit is shared by everything run with ctx, e.g. the workers reading both databases
+	if o.RateLimit > 0 && rateLimiterFrom(ctx) == nil {
+		ctx = WithRateLimit(ctx, o.RateLimit)
+	}
+	return ctx
+}
+
+// NewDBPragmas returns the pragmas run on the new database before any table is created:
+// the PageSize and Encoding, which cannot change once it has a table, then the Pragmas
+func (o Options) NewDBPragmas() []string {
+	var pragmas []string
+	if o.PageSize > 0 {
+		pragmas = append(pragmas, fmt.Sprintf("page_size = %d", o.PageSize))
+	}
+	if o.Encoding != "" {
+		pragmas = append(pragmas, fmt.Sprintf("encoding = '%s'", o.Encoding))
+	}
+	return append(pragmas, o.Pragmas...)
+}
+
+// ApplyPragmas runs every pragma on db
+func ApplyPragmas(ctx context.Context, db *sql.DB, pragmas []string) error {
+	for _, pragma := range pragmas {
+		if err := execContext(ctx, db, fmt.Sprintf("PRAGMA %s;", pragma)); err != nil {
+			return fmt.Errorf("PRAGMA %s: %w", pragma, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package rename
+
+import "context"
+
+// Phase is a step of the pipeline
+type Phase string
+
+const (
+	PhaseRead  Phase = "read"
+	PhaseMatch Phase = "match"
+	PhaseCopy  Phase = "copy"
+)
+
+// EventKind tells what an Event reports
+type EventKind string
+
+const (
+	// EventPhaseChanged is sent when a phase starts, with Phase set
+	EventPhaseChanged EventKind = "phaseChanged"
+	// EventTableMatched is sent when an original table is matched, with Table and HashedTable set,
+	// and when it has no match, with HashedTable empty
+	EventTableMatched EventKind = "tableMatched"
+	// EventRowsCopied is sent while a table is copied, with Table, Rows and Total set
+	EventRowsCopied EventKind = "rowsCopied"
+)
+
+// Event reports the progress of the pipeline
+type Event struct {
+	Kind        EventKind
+	Phase       Phase
+	Table       string
+	HashedTable string
+	// rows of Table copied so far, out of Total
+	Rows  int
+	Total int
+}
+
+// number of inserted rows between two EventRowsCopied of a table
+const progressRowsStep = 1000
+
+type progressKey struct{}
+
+// WithProgress returns a copy of ctx in which this package sends its progress to fn.
+// fn is called from the goroutine doing the work, but never concurrently.
+func WithProgress(ctx context.Context, fn func(Event)) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+func progress(ctx context.Context, event Event) {
+	if fn, ok := ctx.Value(progressKey{}).(func(Event)); ok && fn != nil {
+		fn(event)
+	}
+}
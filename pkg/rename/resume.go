@@ -0,0 +1,64 @@
+package rename
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// progressTable records the tables fully copied into the new database when Options.Resume
+// is set, it is dropped once every table is copied
+const progressTable = "_progress"
+
+// completedTable is a table recorded in progressTable
+type completedTable struct {
+	hashedName string
+	rows       int
+}
+
+// readProgress creates progressTable in db if needed and returns the tables it records
+func readProgress(ctx context.Context, db *sql.DB) (map[string]completedTable, error) {
+	if err := execContext(ctx, db, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (name TEXT PRIMARY KEY, hashed_name TEXT NOT NULL, rows INTEGER NOT NULL);", progressTable)); err != nil {
+		return nil, fmt.Errorf("error creating %s table: %w", progressTable, err)
+	}
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT name, hashed_name, rows FROM %s", progressTable))
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s table: %w", progressTable, err)
+	}
+	defer rows.Close()
+
+	completed := map[string]completedTable{}
+	for rows.Next() {
+		var name string
+		var t completedTable
+		if err = rows.Scan(&name, &t.hashedName, &t.rows); err != nil {
+			return nil, fmt.Errorf("error reading %s table: %w", progressTable, err)
+		}
+		completed[name] = t
+	}
+	return completed, rows.Err()
+}
+
+// isCompleted returns whether match was recorded by a previous run and still has all its rows
+func isCompleted(ctx context.Context, db *sql.DB, completed map[string]completedTable, match TableMatch) bool {
+	t, ok := completed[match.Name]
+	if !ok || t.hashedName != match.HashedName {
+		return false
+	}
+	rows, err := CountRows(ctx, db, match.Name)
+	return err == nil && rows == t.rows
+}
+
+// recordProgress records match as fully copied with rows rows
+func recordProgress(ctx context.Context, db *sql.DB, match TableMatch, rows int) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+	_, err := db.ExecContext(ctx, fmt.Sprintf("INSERT OR REPLACE INTO %s VALUES (?, ?, ?)", progressTable), match.Name, match.HashedName, rows)
+	if err != nil {
+		return fmt.Errorf("error recording progress of table %s: %w", match.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package rename
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+)
+
+// ReusableTable is a table a previous Runner.Copy wrote into the new database, see Options.Reuse
+type ReusableTable struct {
+	TableMatch
+	// Checksum is the TableChecksum of the hashed table it was copied from
+	Checksum string `json:"sha256"`
+}
+
+// reusableTables returns the tables of Options.Reuse whose hashed table is still in the hashed
+// database with the same TableChecksum, by table name
+func (r *Runner) reusableTables(ctx context.Context) (map[string]ReusableTable, error) {
+	if len(r.opts.Reuse) == 0 {
+		return nil, nil
+	}
+	names, err := GetTableNames(ctx, r.hashedDB, false)
+	if err != nil {
+		return nil, err
+	}
+	hashedTables := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		hashedTables[name] = struct{}{}
+	}
+
+	reused := map[string]ReusableTable{}
+	for name, t := range r.opts.Reuse {
+		_, included := r.opts.Tables[name]
+		_, excluded := r.opts.Exclude[name]
+		if (len(r.opts.Tables) > 0 && !included) || excluded || t.Original >= len(r.originalDBs) {
+			continue
+		}
+		if _, ok := hashedTables[t.HashedName]; !ok {
+			continue
+		}
+		checksum, err := TableChecksum(ctx, r.hashedDB, t.HashedName)
+		if err != nil {
+			return nil, err
+		}
+		if checksum == t.Checksum {
+			t.Name = name
+			reused[name] = t
+		}
+	}
+	return reused, nil
+}
+
+// reuseTable returns whether newDB holds table, and its number of rows if it does
+func reuseTable(ctx context.Context, newDB *sql.DB, table string) (bool, int, error) {
+	exists, err := tableExists(ctx, newDB, table)
+	if err != nil || !exists {
+		return false, 0, err
+	}
+	rows, err := CountRows(ctx, newDB, table)
+	return err == nil, rows, err
+}
+
+// mergeReused adds the reused tables, left out of matching, to mapping and report
+func mergeReused(mapping Mapping, report Report, reused map[string]ReusableTable) (Mapping, Report) {
+	if len(reused) == 0 {
+		return mapping, report
+	}
+	reusedHashed := map[string]struct{}{}
+	for name, t := range reused {
+		mapping.Tables = append(mapping.Tables, t.TableMatch)
+		reusedHashed[t.HashedName] = struct{}{}
+		report.Reused = append(report.Reused, name)
+	}
+	skipped := make([]string, 0, len(report.Skipped))
+	for _, t := range report.Skipped {
+		if _, ok := reused[t]; !ok {
+			skipped = append(skipped, t)
+		}
+	}
+	report.Skipped = skipped
+	newTables := make([]string, 0, len(mapping.New))
+	for _, t := range mapping.New {
+		if _, ok := reusedHashed[t]; !ok {
+			newTables = append(newTables, t)
+		}
+	}
+	mapping.New = newTables
+
+	sort.Slice(mapping.Tables, func(i, j int) bool {
+		return mapping.Tables[i].Name < mapping.Tables[j].Name
+	})
+	sort.Strings(report.Reused)
+	return mapping, report
+}
@@ -0,0 +1,411 @@
+package rename
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// TableCopy is the result of copying a matched table into the new database
+type TableCopy struct {
+	TableMatch
+	Rows     int
+	Duration time.Duration
+	// Err is the *ErrCopyFailed of a table that could not be copied
+	Err error
+	// Resumed is set if the table was copied by a previous run, see Options.Resume
+	Resumed bool
+	// Missing is set for an original table without a match created without rows,
+	// see Options.CreateMissing
+	Missing bool
+	// Checksum is the TableChecksum of the hashed table, see Options.Checksums
+	Checksum string
+	// Unchanged is set for a table left out because its content did not change,
+	// see Options.PreviousChecksums
+	Unchanged bool
+	// Reused is set for a table left as it was in the new database because its hashed table
+	// did not change, see Options.Reuse
+	Reused bool
+}
+
+// Runner holds the state of renaming the tables of one pair of databases.
+// A Runner is not safe for concurrent use, but any number of Runners can run
+// at the same time.
+type Runner struct {
+	// originalDBs are the original databases, newest first
+	originalDBs []*sql.DB
+	hashedDB    *sql.DB
+	opts        Options
+
+	mapping Mapping
+	report  Report
+	matched bool
+	// unmatchedOriginals are the indexes in originalDBs of the unmatched tables
+	unmatchedOriginals map[string]int
+	// reused are the tables of Options.Reuse whose hashed table did not change, by table name
+	reused map[string]ReusableTable
+}
+
+// NewRunner returns a Runner renaming the tables of hashedDB with the names in originalDB
+func NewRunner(originalDB, hashedDB *sql.DB, opts Options) *Runner {
+	return &Runner{originalDBs: []*sql.DB{originalDB}, hashedDB: hashedDB, opts: opts}
+}
+
+// AddOriginal adds an original database older than the ones already added, whose tables
+// are matched if no newer original database has them or if they have no match there.
+// It must be called before Match.
+func (r *Runner) AddOriginal(originalDB *sql.DB) {
+	r.originalDBs = append(r.originalDBs, originalDB)
+}
+
+// Match matches the tables of the original databases and the hashed database, see MatchTables,
+// then the indexes of the matched tables, see MatchIndexes.
+// Options.OriginalTables only applies to the original database passed to NewRunner.
+// The tables of Options.Reuse whose hashed table did not change keep their match without
+// being matched again, they are listed in Report.Reused.
+func (r *Runner) Match(ctx context.Context) (Mapping, Report, error) {
+	ctx, span := startSpan(r.context(ctx), SpanMatch, slog.Int("originalDBs", len(r.originalDBs)))
+	mapping, report, err := r.match(ctx)
+	span.End(err, slog.Int("matched", len(mapping.Tables)), slog.Int("unmatched", len(mapping.Unmatched)))
+	return mapping, report, err
+}
+
+func (r *Runner) match(ctx context.Context) (Mapping, Report, error) {
+	reused, err := r.reusableTables(ctx)
+	if err != nil {
+		return Mapping{}, Report{}, err
+	}
+	opts := r.opts
+	if len(reused) > 0 {
+		opts.Exclude = map[string]struct{}{}
+		for t := range r.opts.Exclude {
+			opts.Exclude[t] = struct{}{}
+		}
+		for t := range reused {
+			opts.Exclude[t] = struct{}{}
+		}
+	}
+	mapping, report, err := MatchTables(ctx, r.originalDBs[0], r.hashedDB, opts)
+	if err != nil {
+		return mapping, report, err
+	}
+	mapping, report = mergeReused(mapping, report, reused)
+	r.reused = reused
+	r.unmatchedOriginals = map[string]int{}
+	for i := 1; i < len(r.originalDBs); i++ {
+		if mapping, report, err = r.matchOlder(ctx, i, mapping, report); err != nil {
+			return mapping, report, err
+		}
+	}
+	if !r.opts.SkipIndexes {
+		if mapping.Indexes, err = r.matchIndexes(ctx, mapping.Tables); err != nil {
+			return mapping, report, err
+		}
+	}
+	r.mapping, r.report, r.matched = mapping, report, true
+	return mapping, report, nil
+}
+
+// matchIndexes matches the indexes of every matched table, see MatchIndexes
+func (r *Runner) matchIndexes(ctx context.Context, tables []TableMatch) ([]IndexMatch, error) {
+	indexes := []IndexMatch{}
+	for _, match := range tables {
+		matches, err := MatchIndexes(ctx, r.originalDBs[match.Original], r.hashedDB, match)
+		if err != nil {
+			return indexes, err
+		}
+		indexes = append(indexes, matches...)
+	}
+	sort.Slice(indexes, func(i, j int) bool {
+		return indexes[i].Name < indexes[j].Name
+	})
+	return indexes, nil
+}
+
+// matchOlder matches the tables of the i-th original database that were not matched from
+// a newer one, and merges them into mapping and report
+func (r *Runner) matchOlder(ctx context.Context, i int, mapping Mapping, report Report) (Mapping, Report, error) {
+	opts := r.opts
+	opts.OriginalTables = nil
+	opts.Exclude = map[string]struct{}{}
+	for t := range r.opts.Exclude {
+		opts.Exclude[t] = struct{}{}
+	}
+	matchedHashed := map[string]struct{}{}
+	for _, m := range mapping.Tables {
+		opts.Exclude[m.Name] = struct{}{}
+		matchedHashed[m.HashedName] = struct{}{}
+	}
+
+	older, olderReport, err := MatchTables(ctx, r.originalDBs[i], r.hashedDB, opts)
+	if err != nil {
+		return mapping, report, err
+	}
+
+	unmatched := map[string]struct{}{}
+	for _, t := range mapping.Unmatched {
+		unmatched[t] = struct{}{}
+	}
+	for _, t := range older.Unmatched {
+		if _, ok := unmatched[t]; !ok {
+			unmatched[t] = struct{}{}
+			r.unmatchedOriginals[t] = i
+			report.Candidates[t] = olderReport.Candidates[t]
+		}
+	}
+	for _, m := range older.Tables {
+		// a hashed table already matched by a newer original table belongs to it
+		if _, ok := matchedHashed[m.HashedName]; ok {
+			if _, ok = unmatched[m.Name]; !ok {
+				unmatched[m.Name] = struct{}{}
+				r.unmatchedOriginals[m.Name] = i
+			}
+			continue
+		}
+		m.Original = i
+		mapping.Tables = append(mapping.Tables, m)
+		matchedHashed[m.HashedName] = struct{}{}
+		delete(unmatched, m.Name)
+		delete(r.unmatchedOriginals, m.Name)
+		delete(report.Candidates, m.Name)
+		report.MatchDurations[m.Name] = olderReport.MatchDurations[m.Name]
+	}
+
+	mapping.Unmatched = make([]string, 0, len(unmatched))
+	for t := range unmatched {
+		mapping.Unmatched = append(mapping.Unmatched, t)
+	}
+	newTables := make([]string, 0, len(mapping.New))
+	for _, t := range mapping.New {
+		if _, ok := matchedHashed[t]; !ok {
+			newTables = append(newTables, t)
+		}
+	}
+	mapping.New = newTables
+	report.ReadDuration += olderReport.ReadDuration
+	report.MatchDuration += olderReport.MatchDuration
+
+	sort.Slice(mapping.Tables, func(i, j int) bool {
+		return mapping.Tables[i].Name < mapping.Tables[j].Name
+	})
+	sort.Strings(mapping.Unmatched)
+	return mapping, report, nil
+}
+
+// Mapping returns the mapping found by Match
+func (r *Runner) Mapping() Mapping {
+	return r.mapping
+}
+
+// Report returns the report of Match
+func (r *Runner) Report() Report {
+	return r.report
+}
+
+// SetMapping replaces the mapping found by Match with mapping before Copy, e.g. once its
+// matches were reviewed by a user. It must be called after Match, the indexes of the tables
+// of mapping are matched again unless Options.SkipIndexes is set.
+func (r *Runner) SetMapping(ctx context.Context, mapping Mapping) error {
+	if !r.matched {
+		return fmt.Errorf("SetMapping called before Match")
+	}
+	ctx = r.context(ctx)
+	// a rejected table is created from the original database it was matched from
+	for _, m := range r.mapping.Tables {
+		if m.Original > 0 {
+			r.unmatchedOriginals[m.Name] = m.Original
+		}
+	}
+	mapping.Indexes = nil
+	if !r.opts.SkipIndexes {
+		var err error
+		if mapping.Indexes, err = r.matchIndexes(ctx, mapping.Tables); err != nil {
+			return err
+		}
+	}
+	r.mapping = mapping
+	return nil
+}
+
+// Copy applies Options.NewDBPragmas to newDB and copies every matched table and its indexes into it,
+// matching the tables first if Match was not called. Tables that could not be copied
+// are returned with TableCopy.Err set, unless Options.FailFast is set or ctx is done,
+// in which case Copy stops and returns the error. With Options.Resume, the tables
+// a previous Copy into newDB completed are returned with TableCopy.Resumed set, with
+// Options.PreviousChecksums, the tables that did not change with TableCopy.Unchanged set, and with
+// Options.Reuse, the tables newDB already holds whose hashed table did not change with TableCopy.Reused set.
+func (r *Runner) Copy(ctx context.Context, newDB *sql.DB) ([]TableCopy, error) {
+	ctx = r.context(ctx)
+	if !r.matched {
+		if _, _, err := r.Match(ctx); err != nil {
+			return nil, err
+		}
+	}
+	ctx, span := startSpan(ctx, SpanCopy, slog.Int("tables", len(r.mapping.Tables)))
+	copies, err := r.copy(ctx, newDB)
+	span.End(err)
+	return copies, err
+}
+
+// copy copies the tables of Copy once they are matched
+func (r *Runner) copy(ctx context.Context, newDB *sql.DB) ([]TableCopy, error) {
+	if err := ApplyPragmas(ctx, newDB, r.opts.NewDBPragmas()); err != nil {
+		return nil, err
+	}
+
+	var completed map[string]completedTable
+	if r.opts.Resume {
+		var err error
+		if completed, err = readProgress(ctx, newDB); err != nil {
+			return nil, err
+		}
+	}
+
+	progress(ctx, Event{Kind: EventPhaseChanged, Phase: PhaseCopy})
+	copies := make([]TableCopy, 0, len(r.mapping.Tables))
+	for _, match := range r.mapping.Tables {
+		if t, ok := r.reused[match.Name]; ok && t.HashedName == match.HashedName {
+			reused, rows, err := reuseTable(ctx, newDB, match.Name)
+			if err != nil {
+				return copies, err
+			}
+			if reused {
+				copies = append(copies, TableCopy{TableMatch: match, Rows: rows, Checksum: t.Checksum, Reused: true})
+				continue
+			}
+			// the table is not in newDB after all, it is copied again
+		}
+		if r.opts.Resume {
+			if isCompleted(ctx, newDB, completed, match) {
+				copies = append(copies, TableCopy{TableMatch: match, Rows: completed[match.Name].rows, Resumed: true})
+				continue
+			}
+			// the table may have been left half-written by the previous run
+			if err := execContext(ctx, newDB, fmt.Sprintf("DROP TABLE IF EXISTS '%s';", match.Name)); err != nil {
+				return copies, err
+			}
+		}
+
+		c, err := r.copyTable(ctx, newDB, match)
+		if err != nil {
+			return copies, err
+		}
+		copies = append(copies, c)
+	}
+
+	if r.opts.CreateMissing {
+		for _, table := range r.mapping.Unmatched {
+			// a previous run may have created it already
+			if r.opts.Resume {
+				if err := execContext(ctx, newDB, fmt.Sprintf("DROP TABLE IF EXISTS '%s';", table)); err != nil {
+					return copies, err
+				}
+			}
+			err := CreateTable(r.selection(ctx, table), r.originalDBs[r.unmatchedOriginals[table]], newDB, table)
+			if err != nil && (r.opts.FailFast || ctx.Err() != nil) {
+				return copies, err
+			}
+			copies = append(copies, TableCopy{TableMatch: TableMatch{Name: table}, Missing: true, Err: err})
+		}
+	}
+
+	if r.opts.Resume {
+		if err := execContext(ctx, newDB, fmt.Sprintf("DROP TABLE %s;", progressTable)); err != nil {
+			return copies, err
+		}
+	}
+	return copies, nil
+}
+
+// copyTable copies the table of match into newDB, see Copy. The error is only returned if Copy stops,
+// TableCopy.Err is set otherwise.
+func (r *Runner) copyTable(ctx context.Context, newDB *sql.DB, match TableMatch) (TableCopy, error) {
+	ctx, span := startSpan(ctx, SpanCopyTable, slog.String("table", match.Name), slog.String("hashedTable", match.HashedName))
+	c, err := r.copyTableData(ctx, newDB, match)
+	spanErr := err
+	if spanErr == nil {
+		spanErr = c.Err
+	}
+	span.End(spanErr, slog.Int("rows", c.Rows), slog.Bool("unchanged", c.Unchanged))
+	return c, err
+}
+
+func (r *Runner) copyTableData(ctx context.Context, newDB *sql.DB, match TableMatch) (TableCopy, error) {
+	start := time.Now()
+	ctx = r.selection(ctx, match.Name)
+	var checksum string
+	if r.opts.Checksums || r.opts.PreviousChecksums != nil {
+		var err error
+		if checksum, err = TableChecksum(ctx, r.hashedDB, match.HashedName); err != nil {
+			return TableCopy{}, err
+		}
+		if previous, ok := r.opts.PreviousChecksums[match.Name]; ok && previous == checksum {
+			return TableCopy{TableMatch: match, Checksum: checksum, Unchanged: true}, nil
+		}
+	}
+
+	var rows int
+	var err error
+	existed := false
+	if r.opts.Append {
+		if existed, err = tableExists(ctx, newDB, match.Name); err != nil {
+			return TableCopy{}, err
+		}
+	}
+	switch {
+	case r.opts.SchemaOnly:
+		err = CreateTable(ctx, r.originalDBs[match.Original], newDB, match.Name)
+	case r.opts.Append:
+		rows, err = AppendData(ctx, r.originalDBs[match.Original], r.hashedDB, newDB, match.Name, match.HashedName, r.opts.Truncate)
+	default:
+		rows, err = CopyData(ctx, r.originalDBs[match.Original], r.hashedDB, newDB, match.Name, match.HashedName)
+	}
+	if err == nil {
+		if rows, err = r.completeTable(ctx, newDB, match, rows, existed); err != nil && !existed {
+			if dropErr := execContext(context.WithoutCancel(ctx), newDB, fmt.Sprintf("DROP TABLE IF EXISTS '%s';", match.Name)); dropErr != nil {
+				loggerFrom(ctx).WarnContext(ctx, "error dropping table", "table", match.Name, "error", dropErr)
+			}
+			rows = 0
+		}
+	}
+	if err != nil && (r.opts.FailFast || ctx.Err() != nil) {
+		return TableCopy{}, err
+	}
+	if err == nil && r.opts.Resume {
+		if err := recordProgress(ctx, newDB, match, rows); err != nil {
+			return TableCopy{}, err
+		}
+	}
+	return TableCopy{TableMatch: match, Rows: rows, Duration: time.Since(start), Err: err, Checksum: checksum}, nil
+}
+
+// selection returns a copy of ctx with the Options.RowFilters and Options.Columns of table, if any,
+// so the rows and columns are filtered as they are read from the hashed table
+func (r *Runner) selection(ctx context.Context, table string) context.Context {
+	where, filtered := r.opts.RowFilters[table]
+	columns, restricted := r.opts.Columns[table]
+	if !filtered && !restricted {
+		return ctx
+	}
+	return WithSelection(ctx, Selection{Where: where, Columns: columns})
+}
+
+// completeTable copies the indexes of the copied table of match unless it existed before,
+// and returns its number of rows
+func (r *Runner) completeTable(ctx context.Context, newDB *sql.DB, match TableMatch, rows int, existed bool) (int, error) {
+	// indexes are created after the rows are inserted, which is faster
+	if !r.opts.SkipIndexes && !existed {
+		if err := CopyIndexes(ctx, r.originalDBs[match.Original], r.hashedDB, newDB, match, r.mapping.Indexes); err != nil {
+			return rows, err
+		}
+	}
+	return rows, nil
+}
+
+// context returns ctx with Options.Logger and Options.Progress, if set
+func (r *Runner) context(ctx context.Context) context.Context {
+	return r.opts.context(ctx)
+}
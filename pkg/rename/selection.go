@@ -0,0 +1,291 @@
+package rename
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Selection restricts the rows and columns of a table that are copied, see WithSelection
+type Selection struct {
+	// Where is an SQL condition on the original column names of the rows to copy, every row is copied if empty
+	Where string
+	// Columns are the original columns to copy, every column is copied if empty. The table is created
+	// without the other columns nor the constraints and indexes on them.
+	Columns []string
+}
+
+func (s Selection) empty() bool {
+	return s.Where == "" && len(s.Columns) == 0
+}
+
+// kept returns whether column is copied
+func (s Selection) kept(column string) bool {
+	if len(s.Columns) == 0 {
+		return true
+	}
+	for _, c := range s.Columns {
+		if strings.EqualFold(c, column) {
+			return true
+		}
+	}
+	return false
+}
+
+// keptColumns returns the columns of columns that are copied, in order, and an error if sel has a column columns lacks
+func (s Selection) keptColumns(columns []string) ([]string, error) {
+	for _, c := range s.Columns {
+		found := false
+		for _, column := range columns {
+			found = found || strings.EqualFold(c, column)
+		}
+		if !found {
+			return nil, fmt.Errorf("no column %s to keep", c)
+		}
+	}
+	var kept []string
+	for _, column := range columns {
+		if s.kept(column) {
+			kept = append(kept, column)
+		}
+	}
+	return kept, nil
+}
+
+// selectionQuery returns the query of the rows of hashedTable in sel, in the order and with the limit of
+// GetAllData. columns are the original names of the columns of hashedTable, in order, used by the condition of sel.
+func selectionQuery(ctx context.Context, db *sql.DB, hashedTable string, columns []string, sel Selection) (string, error) {
+	if sel.empty() {
+		return allDataQuery(ctx, db, hashedTable)
+	}
+	hashedColumns, err := GetColumnNames(ctx, db, hashedTable)
+	if err != nil {
+		return "", err
+	}
+	if len(hashedColumns) != len(columns) {
+		return "", fmt.Errorf("%w: %s has %d columns but %d are expected", ErrSchemaMismatch, hashedTable, len(hashedColumns), len(columns))
+	}
+	kept, err := sel.keptColumns(columns)
+	if err != nil {
+		return "", err
+	}
+	orderBy, err := rowOrder(ctx, db, hashedTable)
+	if err != nil {
+		return "", err
+	}
+
+	// the hashed columns are renamed in a subquery, so the condition can use the original names
+	renamed := make([]string, len(columns))
+	for i, column := range columns {
+		renamed[i] = fmt.Sprintf("%s AS %s", quote(hashedColumns[i]), quote(column))
+	}
+	for i, column := range kept {
+		kept[i] = quote(column)
+	}
+	query := fmt.Sprintf("SELECT %s FROM (SELECT %s FROM %s%s)", strings.Join(kept, ", "), strings.Join(renamed, ", "), quote(hashedTable), orderBy)
+	if sel.Where != "" {
+		query += fmt.Sprintf(" WHERE (%s)", sel.Where)
+	}
+	// the limit applies to the selected rows
+	if limit := rowLimitFrom(ctx); limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+	return query, nil
+}
+
+// tableConstraints are the keywords starting the table constraints of a CREATE TABLE statement
+var tableConstraints = map[string]struct{}{"constraint": {}, "primary": {}, "unique": {}, "check": {}, "foreign": {}}
+
+// createTable is a CREATE TABLE statement split into its column definitions and table constraints
+type createTable struct {
+	// head is the statement up to the opening parenthesis, tail after the closing one, e.g. WITHOUT ROWID
+	head, tail string
+	// defs are the column definitions and table constraints, columns the names of the column definitions
+	defs    []string
+	columns []string
+	// isColumn tells whether each of defs is a column definition
+	isColumn []bool
+}
+
+var errInvalidCreateTable = errors.New("cannot parse CREATE TABLE statement")
+
+// parseCreateTable splits the CREATE TABLE statement stmt, which must have column definitions
+func parseCreateTable(stmt string) (createTable, error) {
+	var c createTable
+	depth, start := 0, -1
+	for i := 0; i < len(stmt); i++ {
+		if skip := sqlQuotedLength(stmt[i:]); skip > 0 {
+			i += skip - 1
+			continue
+		}
+		switch stmt[i] {
+		case '(':
+			depth++
+			if depth == 1 {
+				c.head, start = stmt[:i], i+1
+			}
+		case ')':
+			depth--
+			if depth == 0 && start >= 0 {
+				c.addDef(stmt[start:i])
+				c.tail = stmt[i+1:]
+				return c, nil
+			}
+		case ',':
+			if depth == 1 {
+				c.addDef(stmt[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return c, errInvalidCreateTable
+}
+
+func (c *createTable) addDef(def string) {
+	def = strings.TrimSpace(def)
+	tokens := sqlIdentifiers(def)
+	isColumn := len(tokens) > 0
+	if isColumn && !tokens[0].quoted {
+		_, isConstraint := tableConstraints[strings.ToLower(tokens[0].name)]
+		isColumn = !isConstraint
+	}
+	c.defs = append(c.defs, def)
+	c.isColumn = append(c.isColumn, isColumn)
+	if isColumn {
+		c.columns = append(c.columns, tokens[0].name)
+	}
+}
+
+// keep returns the statement with the columns of sel only, leaving out the table constraints on the other columns
+func (c createTable) keep(sel Selection) createTable {
+	kept := createTable{head: c.head, tail: c.tail}
+	for i, def := range c.defs {
+		if c.isColumn[i] {
+			name := sqlIdentifiers(def)[0].name
+			if !sel.kept(name) {
+				continue
+			}
+			kept.columns = append(kept.columns, name)
+		} else {
+			dropped := false
+			for _, token := range sqlIdentifiers(def) {
+				if !sel.kept(token.name) && containsFold(c.columns, token.name) {
+					dropped = true
+					break
+				}
+			}
+			if dropped {
+				continue
+			}
+		}
+		kept.defs = append(kept.defs, def)
+		kept.isColumn = append(kept.isColumn, c.isColumn[i])
+	}
+	return kept
+}
+
+func (c createTable) String() string {
+	return c.head + "(" + strings.Join(c.defs, ", ") + ")" + c.tail
+}
+
+// selectCreateStatement returns createStmt with the columns of sel only, with the names of all its columns
+func selectCreateStatement(createStmt string, sel Selection) (string, []string, error) {
+	c, err := parseCreateTable(createStmt)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(sel.Columns) == 0 {
+		return createStmt, c.columns, nil
+	}
+	if _, err = sel.keptColumns(c.columns); err != nil {
+		return "", nil, err
+	}
+	return c.keep(sel).String(), c.columns, nil
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// sqlIdentifier is a word or quoted identifier of an SQL statement
+type sqlIdentifier struct {
+	name   string
+	quoted bool
+}
+
+// sqlIdentifiers returns the words and quoted identifiers of s, unquoted, leaving out its string literals and comments
+func sqlIdentifiers(s string) []sqlIdentifier {
+	var identifiers []sqlIdentifier
+	for i := 0; i < len(s); {
+		if n := sqlQuotedLength(s[i:]); n > 0 {
+			switch s[i] {
+			case '"', '`':
+				quote := s[i : i+1]
+				identifiers = append(identifiers, sqlIdentifier{name: strings.ReplaceAll(s[i+1:i+n-1], quote+quote, quote), quoted: true})
+			case '[':
+				identifiers = append(identifiers, sqlIdentifier{name: s[i+1 : i+n-1], quoted: true})
+			}
+			i += n
+			continue
+		}
+		n := 0
+		for i+n < len(s) && isSQLWordByte(s[i+n]) {
+			n++
+		}
+		if n == 0 {
+			i++
+			continue
+		}
+		identifiers = append(identifiers, sqlIdentifier{name: s[i : i+n]})
+		i += n
+	}
+	return identifiers
+}
+
+func isSQLWordByte(b byte) bool {
+	return b == '_' || b == '$' || b >= 0x80 || (b >= '0' && b <= '9') || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+// sqlQuotedLength returns the length of the string literal, quoted identifier or comment s starts with, 0 if none
+func sqlQuotedLength(s string) int {
+	if len(s) == 0 {
+		return 0
+	}
+	switch {
+	case strings.HasPrefix(s, "--"):
+		if end := strings.IndexByte(s, '\n'); end >= 0 {
+			return end + 1
+		}
+		return len(s)
+	case strings.HasPrefix(s, "/*"):
+		if end := strings.Index(s[2:], "*/"); end >= 0 {
+			return end + 4
+		}
+		return len(s)
+	case s[0] == '[':
+		if end := strings.IndexByte(s, ']'); end >= 0 {
+			return end + 1
+		}
+		return len(s)
+	case s[0] == '\'' || s[0] == '"' || s[0] == '`':
+		// a doubled quote is an escaped quote
+		for i := 1; i < len(s); i++ {
+			if s[i] == s[0] {
+				if i+1 < len(s) && s[i+1] == s[0] {
+					i++
+					continue
+				}
+				return i + 1
+			}
+		}
+		return len(s)
+	}
+	return 0
+}
@@ -0,0 +1,78 @@
+package rename
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSelectCreateStatement(t *testing.T) {
+	tests := []struct {
+		name       string
+		createStmt string
+		columns    []string
+		want       string
+		// all are the names of all the columns of createStmt
+		all     []string
+		wantErr bool
+	}{
+		{
+			name:       "every column",
+			createStmt: "CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT)",
+			want:       "CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT)",
+			all:        []string{"id", "name"},
+		},
+		{
+			name:       "kept columns",
+			createStmt: "CREATE TABLE t (id INTEGER, name TEXT, detail TEXT)",
+			columns:    []string{"ID", "detail"},
+			want:       "CREATE TABLE t (id INTEGER, detail TEXT)",
+			all:        []string{"id", "name", "detail"},
+		},
+		{
+			name:       "constraints on dropped columns",
+			createStmt: "CREATE TABLE t (a INTEGER, b INTEGER, c TEXT, PRIMARY KEY (a, b), UNIQUE (c), CHECK (a > 0)) WITHOUT ROWID",
+			columns:    []string{"a", "c"},
+			want:       "CREATE TABLE t (a INTEGER, c TEXT, UNIQUE (c), CHECK (a > 0)) WITHOUT ROWID",
+			all:        []string{"a", "b", "c"},
+		},
+		{
+			name:       "quoted identifiers and literals",
+			createStmt: `CREATE TABLE "t" ("unit id" INTEGER, [name, full] TEXT DEFAULT 'a, (b)', "check" TEXT)`,
+			columns:    []string{"unit id", "check"},
+			want:       `CREATE TABLE "t" ("unit id" INTEGER, "check" TEXT)`,
+			all:        []string{"unit id", "name, full", "check"},
+		},
+		{
+			name:       "unknown column",
+			createStmt: "CREATE TABLE t (id INTEGER, name TEXT)",
+			columns:    []string{"missing"},
+			wantErr:    true,
+		},
+		{
+			name:       "no column definitions",
+			createStmt: "CREATE TABLE t AS SELECT 1",
+			columns:    []string{"id"},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, all, err := selectCreateStatement(tt.createStmt, Selection{Columns: tt.columns})
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("selectCreateStatement = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("selectCreateStatement = %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(all, tt.all) {
+				t.Errorf("columns = %v, want %v", all, tt.all)
+			}
+		})
+	}
+}
@@ -0,0 +1,354 @@
+package rename
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Tables holds the first rows of every table of a database, by table name
+type Tables map[string][][]string
+
+// Names returns the names of the tables in order, to go through them the same way on every run
+func (t Tables) Names() []string {
+	names := make([]string, 0, len(t))
+	for name := range t {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ReadTables reads the first n rows of every table in db, skipping the hashed
+// v1_ tables if filterV1Tables is set, with the number of workers of WithReadWorkers
+func ReadTables(ctx context.Context, db *sql.DB, filterV1Tables bool, n int) (Tables, error) {
+	names, err := GetTableNames(ctx, db, filterV1Tables)
+	if err != nil {
+		return nil, err
+	}
+
+	// the first error of a worker stops the others
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var firstErr error
+
+	queue := make(chan string)
+	go func() {
+		defer close(queue)
+		for _, table := range names {
+			select {
+			case queue <- table:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	tables := make(Tables, len(names))
+	// every worker holds at most one connection of the pool at a time
+	for i := 0; i < min(readWorkersFrom(ctx), len(names)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for table := range queue {
+				rows, err := GetFirstNRows(ctx, db, table, n)
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+					}
+					cancel()
+				} else {
+					tables[table] = rows
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
+
+var withoutRowidRegex = regexp.MustCompile(`(?i)\bWITHOUT\s+ROWID\b`)
+
+// DefaultHashedPrefix starts the hashed table names, unless WithHashedPrefix sets another prefix
+const DefaultHashedPrefix = "v1_"
+
+// GetTableNames returns the names of the tables in db, skipping the hashed
+// v1_ tables, or those of WithHashedPrefix, if filterV1Tables is set
+func GetTableNames(ctx context.Context, db *sql.DB, filterV1Tables bool) ([]string, error) {
+	prefix := hashedPrefixFrom(ctx)
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	tables := make([]string, 0)
+	query := "SELECT name FROM sqlite_master WHERE type='table';"
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("error querying database: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+
+		// ignore the sqlite_stat1 table because row data is also hashed
+		if name == "sqlite_stat1" {
+			continue
+		}
+		// ignore the new hashed v1_ tables
+		if strings.HasPrefix(name, prefix) {
+			if !filterV1Tables {
+				tables = append(tables, name)
+			}
+		} else {
+			tables = append(tables, name)
+		}
+	}
+
+	return tables, rows.Err()
+}
+
+// GetFirstNRows returns the first n rows of tableName, formatted as strings
+func GetFirstNRows(ctx context.Context, db *sql.DB, tableName string, n int) ([][]string, error) {
+	data, err := queryRows(ctx, db, fmt.Sprintf("SELECT * FROM %s LIMIT %d", tableName, n))
+	if err != nil {
+		return nil, fmt.Errorf("error querying database in table %s: %w", tableName, err)
+	}
+	return data, nil
+}
+
+// GetAllData returns every row of tableName in rowid order, or in primary key order for a table
+// WITHOUT ROWID or with WithPrimaryKeyOrder, formatted as strings. With WithRowLimit, only the
+// first rows are returned.
+func GetAllData(ctx context.Context, db *sql.DB, tableName string) ([][]string, error) {
+	query, err := allDataQuery(ctx, db, tableName)
+	if err != nil {
+		return nil, err
+	}
+	return queryRows(ctx, db, query)
+}
+
+// ForEachRow calls fn with every row of tableName, in the order and with the limit of GetAllData,
+// without holding more than one row in memory. fn must not keep row, which is reused.
+func ForEachRow(ctx context.Context, db *sql.DB, tableName string, fn func(row []string) error) error {
+	query, err := allDataQuery(ctx, db, tableName)
+	if err != nil {
+		return err
+	}
+	return forEachRow(ctx, db, query, fn)
+}
+
+// allDataQuery returns the query of GetAllData
+func allDataQuery(ctx context.Context, db *sql.DB, tableName string) (string, error) {
+	orderBy, err := rowOrder(ctx, db, tableName)
+	if err != nil {
+		return "", err
+	}
+	if limit := rowLimitFrom(ctx); limit > 0 {
+		orderBy += fmt.Sprintf(" LIMIT %d", limit)
+	}
+	return fmt.Sprintf("SELECT * FROM %s%s", tableName, orderBy), nil
+}
+
+// rowOrder returns the ORDER BY clause of GetAllData, so the rows are always read in the same order
+func rowOrder(ctx context.Context, db *sql.DB, tableName string) (string, error) {
+	createStmt, err := getCreateTableStatement(ctx, db, tableName)
+	if err == sql.ErrNoRows {
+		// views have no rowid
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("error getting CREATE TABLE statement of table %s: %w", tableName, err)
+	}
+	if !primaryKeyOrderFrom(ctx) && !withoutRowidRegex.MatchString(createStmt) {
+		return " ORDER BY rowid", nil
+	}
+
+	queryCtx, cancel := queryContext(ctx)
+	defer cancel()
+	rows, err := db.QueryContext(queryCtx, fmt.Sprintf("SELECT name FROM pragma_table_info('%s') WHERE pk > 0 ORDER BY pk", tableName))
+	if err != nil {
+		return "", fmt.Errorf("error getting primary key of table %s: %w", tableName, err)
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return "", fmt.Errorf("error scanning primary key of table %s: %w", tableName, err)
+		}
+		columns = append(columns, quote(name))
+	}
+	if err = rows.Err(); err != nil {
+		return "", err
+	}
+	if len(columns) == 0 {
+		return " ORDER BY rowid", nil
+	}
+	return " ORDER BY " + strings.Join(columns, ", "), nil
+}
+
+func queryRows(ctx context.Context, db *sql.DB, query string) ([][]string, error) {
+	var tableData [][]string
+	err := forEachRow(ctx, db, query, func(row []string) error {
+		tableData = append(tableData, append([]string(nil), row...))
+		return nil
+	})
+	return tableData, err
+}
+
+// forEachRow runs query and calls fn with each of its rows formatted as strings, reusing row
+func forEachRow(ctx context.Context, db *sql.DB, query string, fn func(row []string) error) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	columns := make([]interface{}, len(cols))
+	columnPointers := make([]interface{}, len(cols))
+	for i := range columns {
+		columnPointers[i] = &columns[i]
+	}
+	rowValues := make([]string, len(cols))
+	for rows.Next() {
+		if err := rows.Scan(columnPointers...); err != nil {
+			return err
+		}
+		size := 0
+		for i, col := range columns {
+			rowValues[i] = fmt.Sprintf("%v", col)
+			size += len(rowValues[i])
+		}
+		if err := waitRateLimit(ctx, size); err != nil {
+			return err
+		}
+		if err := fn(rowValues); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// CountRows returns the number of rows in tableName
+func CountRows(ctx context.Context, db *sql.DB, tableName string) (int, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	var count int
+	err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s", tableName)).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("error counting rows in table %s: %w", tableName, err)
+	}
+
+	return count, nil
+}
+
+// countQueryRows returns the number of rows of query
+func countQueryRows(ctx context.Context, db *sql.DB, query string) (int, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	var count int
+	err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM (%s)", query)).Scan(&count)
+	return count, err
+}
+
+// GetColumnNames returns the column names of tableName in order
+func GetColumnNames(ctx context.Context, db *sql.DB, tableName string) ([]string, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT name FROM pragma_table_info('%s')", tableName))
+	if err != nil {
+		return nil, fmt.Errorf("error getting columns in table %s: %w", tableName, err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("error scanning columns in table %s: %w", tableName, err)
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// GetColumnTypes returns the declared column types of tableName in order, upper-cased
+func GetColumnTypes(ctx context.Context, db *sql.DB, tableName string) ([]string, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("PRAGMA table_info('%s')", tableName))
+	if err != nil {
+		return nil, fmt.Errorf("error getting columns in table %s: %w", tableName, err)
+	}
+	defer rows.Close()
+
+	var types []string
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err = rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return nil, fmt.Errorf("error scanning columns in table %s: %w", tableName, err)
+		}
+		types = append(types, strings.ToUpper(colType))
+	}
+
+	return types, rows.Err()
+}
+
+// tableExists returns whether db has a table named tableName
+func tableExists(ctx context.Context, db *sql.DB, tableName string) (bool, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	var count int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name=?", tableName).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("error looking for table %s: %w", tableName, err)
+	}
+	return count > 0, nil
+}
+
+func getCreateTableStatement(ctx context.Context, db *sql.DB, tableName string) (string, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := "SELECT sql FROM sqlite_master WHERE type='table' AND name=?"
+	var createStmt string
+	row := db.QueryRowContext(ctx, query, tableName)
+	err := row.Scan(&createStmt)
+	if err != nil {
+		return "", err
+	}
+	return createStmt, nil
+}
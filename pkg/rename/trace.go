@@ -0,0 +1,46 @@
+package rename
+
+import (
+	"context"
+	"log/slog"
+)
+
+// names of the spans this package starts
+const (
+	SpanMatch     = "rename.match"
+	SpanCopy      = "rename.copy"
+	SpanCopyTable = "rename.copy_table"
+	SpanVerify    = "rename.verify"
+)
+
+// Tracer starts spans around the phases of the pipeline: matching, copying every table and verification,
+// to see where the time of a run goes, e.g. by exporting them with OpenTelemetry. The spans started
+// with the context Start returns are children of its span.
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, Span)
+}
+
+// Span is an operation started by a Tracer
+type Span interface {
+	// End ends the span, as failed if err is not nil, with the attributes known once it is done
+	End(err error, attrs ...slog.Attr)
+}
+
+type tracerKey struct{}
+
+// WithTracer returns a copy of ctx in which this package starts its spans with tracer
+func WithTracer(ctx context.Context, tracer Tracer) context.Context {
+	return context.WithValue(ctx, tracerKey{}, tracer)
+}
+
+// startSpan starts a span with the Tracer of ctx, or returns a span doing nothing if it has none
+func startSpan(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, Span) {
+	if tracer, ok := ctx.Value(tracerKey{}).(Tracer); ok && tracer != nil {
+		return tracer.Start(ctx, name, attrs...)
+	}
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) End(error, ...slog.Attr) {}
@@ -0,0 +1,151 @@
+package rename
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+)
+
+// reference describes a column that is expected to point at a row in another table
+type reference struct {
+	column       string
+	parentTable  string
+	parentColumn string
+}
+
+// cross-table references common in PCR data, a wrong table match
+// usually shows up as rows pointing at ids that do not exist
+var auditReferences = []reference{
+	{column: "unit_id", parentTable: "unit_data", parentColumn: "unit_id"},
+	{column: "equipment_id", parentTable: "equipment_data", parentColumn: "equipment_id"},
+	{column: "skill_id", parentTable: "skill_data", parentColumn: "skill_id"},
+	{column: "quest_id", parentTable: "quest_data", parentColumn: "quest_id"},
+	{column: "item_id", parentTable: "item_data", parentColumn: "item_id"},
+}
+
+// VerifyDB runs PRAGMA integrity_check and foreign_key_check on db
+// and returns a description of every problem found
+func VerifyDB(ctx context.Context, db *sql.DB) ([]string, error) {
+	ctx, span := startSpan(ctx, SpanVerify)
+	problems, err := verifyDB(ctx, db)
+	span.End(err, slog.Int("problems", len(problems)))
+	return problems, err
+}
+
+func verifyDB(ctx context.Context, db *sql.DB) ([]string, error) {
+	problems, err := integrityCheck(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, "PRAGMA foreign_key_check;")
+	if err != nil {
+		return nil, fmt.Errorf("error running foreign key check: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var table, parent string
+		var rowID sql.NullInt64
+		var fkID int
+		if err = rows.Scan(&table, &rowID, &parent, &fkID); err != nil {
+			return nil, fmt.Errorf("error scanning foreign key check result: %w", err)
+		}
+		problems = append(problems, fmt.Sprintf("foreign key check: row %d in table %s violates foreign key %d referencing %s", rowID.Int64, table, fkID, parent))
+	}
+
+	return problems, rows.Err()
+}
+
+func integrityCheck(ctx context.Context, db *sql.DB) ([]string, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, "PRAGMA integrity_check;")
+	if err != nil {
+		return nil, fmt.Errorf("error running integrity check: %w", err)
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var result string
+		if err = rows.Scan(&result); err != nil {
+			return nil, fmt.Errorf("error scanning integrity check result: %w", err)
+		}
+		if result != "ok" {
+			problems = append(problems, "integrity check: "+result)
+		}
+	}
+	return problems, rows.Err()
+}
+
+// AuditOrphans reports rows in db that reference a missing parent row,
+// 0 is treated as "no reference" and ignored
+func AuditOrphans(ctx context.Context, db *sql.DB) ([]string, error) {
+	tables, err := GetTableNames(ctx, db, false)
+	if err != nil {
+		return nil, err
+	}
+	existing := map[string]struct{}{}
+	for _, t := range tables {
+		existing[t] = struct{}{}
+	}
+
+	var problems []string
+	for _, ref := range auditReferences {
+		if _, ok := existing[ref.parentTable]; !ok {
+			continue
+		}
+
+		for _, t := range tables {
+			if t == ref.parentTable {
+				continue
+			}
+			ok, err := hasColumn(ctx, db, t, ref.column)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+
+			count, err := countOrphans(ctx, db, t, ref)
+			if err != nil {
+				return nil, fmt.Errorf("error auditing %s.%s: %w", t, ref.column, err)
+			}
+			if count > 0 {
+				problems = append(problems, fmt.Sprintf("audit: %d row(s) in %s reference a missing %s.%s", count, t, ref.parentTable, ref.parentColumn))
+			}
+		}
+	}
+
+	return problems, nil
+}
+
+func countOrphans(ctx context.Context, db *sql.DB, table string, ref reference) (int, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	var count int
+	query := fmt.Sprintf("SELECT COUNT(*) FROM '%s' WHERE %s != 0 AND %s NOT IN (SELECT %s FROM '%s')",
+		table, ref.column, ref.column, ref.parentColumn, ref.parentTable)
+	err := db.QueryRowContext(ctx, query).Scan(&count)
+	return count, err
+}
+
+func hasColumn(ctx context.Context, db *sql.DB, tableName, column string) (bool, error) {
+	names, err := GetColumnNames(ctx, db, tableName)
+	if err != nil {
+		return false, err
+	}
+	for _, name := range names {
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, nil
+}
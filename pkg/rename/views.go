@@ -0,0 +1,44 @@
+package rename
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// CreateView creates a view named after the original table of match in db, which holds its
+// hashed table, selecting the columns of the hashed table in order under the names in columns.
+// The hashed column names are kept if columns is nil.
+// Errors are *ErrCopyFailed, wrapping ErrSchemaMismatch if columns does not have as many
+// columns as the hashed table.
+func CreateView(ctx context.Context, db *sql.DB, match TableMatch, columns []string) error {
+	if err := createView(ctx, db, match, columns); err != nil {
+		return &ErrCopyFailed{Table: match.Name, Err: err}
+	}
+	return nil
+}
+
+func createView(ctx context.Context, db *sql.DB, match TableMatch, columns []string) error {
+	hashedColumns, err := GetColumnNames(ctx, db, match.HashedName)
+	if err != nil {
+		return err
+	}
+	if columns == nil {
+		columns = hashedColumns
+	}
+	if len(columns) != len(hashedColumns) {
+		return fmt.Errorf("%w: %s has %d columns but %s has %d", ErrSchemaMismatch, match.Name, len(columns), match.HashedName, len(hashedColumns))
+	}
+
+	selected := make([]string, len(columns))
+	for i, column := range columns {
+		selected[i] = fmt.Sprintf("%s AS %s", quote(hashedColumns[i]), quote(column))
+	}
+	createStmt := fmt.Sprintf("CREATE VIEW %s AS SELECT %s FROM %s", quote(match.Name), strings.Join(selected, ", "), quote(match.HashedName))
+	loggerFrom(ctx).Log(ctx, LevelTrace, "creating view", "table", match.Name, "sql", createStmt)
+	if err = execContext(ctx, db, createStmt); err != nil {
+		return fmt.Errorf("error creating view: %w", err)
+	}
+	return nil
+}
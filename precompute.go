@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+const hashLookupFile = "hash_lookup.json"
+
+// commonColumns are column names shared by many tables, precomputed with the table names
+var commonColumns = []string{
+	"id", "unit_id", "skill_id", "equipment_id", "quest_id", "item_id", "reward_id", "reward_type",
+	"reward_num", "rarity", "level", "name", "description", "type", "value", "count", "icon_type",
+	"start_time", "end_time",
+}
+
+// hashLookup is a precomputed name -> hashed name lookup table of a hash scheme
+type hashLookup struct {
+	Scheme  hashScheme        `json:"scheme"`
+	Tables  map[string]string `json:"tables"`
+	Columns map[string]string `json:"columns"`
+}
+
+func newPrecomputeCmd() *cobra.Command {
+	var output, columnsFile string
+
+	cmd := &cobra.Command{
+		Use:   "precompute <table names file>",
+		Short: "Precompute the hashed names of known tables and columns",
+		Long: `Compute the hashed name of every table listed in a file, one per line, and of common column
+names with a hash scheme, and write them as a JSON lookup table for the mapping engine and third-party
+tools. The lookup table can be passed to --useMapping.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			scheme, err := flagHashScheme()
+			if err != nil {
+				fatalf(exitInvalidInput, "%v", err)
+			}
+			tables, err := readFilterFile(args[0])
+			if err != nil {
+				fatalf(exitInvalidInput, "%v", err)
+			}
+			columns := map[string]struct{}{}
+			for _, c := range commonColumns {
+				columns[c] = struct{}{}
+			}
+			if columnsFile != "" {
+				extra, err := readFilterFile(columnsFile)
+				if err != nil {
+					fatalf(exitInvalidInput, "%v", err)
+				}
+				for c := range extra {
+					columns[c] = struct{}{}
+				}
+			}
+
+			lookup := hashLookup{Scheme: scheme, Tables: map[string]string{}, Columns: map[string]string{}}
+			for t := range tables {
+				lookup.Tables[t] = scheme.hashedName(hashedPrefix, t, hashedNameDigits)
+			}
+			for c := range columns {
+				lookup.Columns[c] = scheme.hashedName(hashedColumnPrefix, c, hashedColumnDigits)
+			}
+
+			jsonData, err := json.MarshalIndent(lookup, "", "  ")
+			if err == nil {
+				err = os.WriteFile(output, jsonData, 0644)
+			}
+			if err != nil {
+				fatalf(exitInternalError, "Error writing %s: %v", output, err)
+			}
+			slog.Info("precomputed hashed names", "path", output, "tables", len(lookup.Tables), "columns", len(lookup.Columns))
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", hashLookupFile, "OPTIONAL: Path of the lookup table")
+	cmd.Flags().StringVar(&columnsFile, "columns", "", "OPTIONAL: File listing column names to precompute, one per line, besides the common ones")
+	cmd.Flags().StringVar(&opts.HashScheme, "hashScheme", "", "REQUIRED: Digest of the hashed names, one of md5, sha1, sha256, sha512")
+	cmd.Flags().StringVar(&opts.Salt, "salt", "", "OPTIONAL: Salt, e.g. of a version, prepended to the names unless --hashTemplate is set")
+	cmd.Flags().StringVar(&opts.HashTemplate, "hashTemplate", "", "OPTIONAL: String hashed, where {name}, {NAME} and {salt} are the name, upper-case name and salt")
+	_ = cmd.MarkFlagRequired("hashScheme")
+
+	return cmd
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// preSQLInput returns the path of a temporary copy of the input database at path on which the
+// statements of --originalPreSQL or --hashedPreSQL were run, e.g. to delete rows that break the
+// matching, so the input itself is left untouched. The returned cleanup function removes the
+// copy, it is a no-op when an error is returned.
+func preSQLInput(ctx context.Context, path, key, flag string, statements []string) (string, func(), error) {
+	tmp, err := copyToTemp(path)
+	if err != nil {
+		return "", func() {}, err
+	}
+	cleanup := func() { removeDB(tmp) }
+
+	// the URI parameters of the input, e.g. mode=ro, are left out since the copy is written
+	db, err := openDB(tmp, key)
+	if err != nil {
+		cleanup()
+		return "", func() {}, err
+	}
+	defer db.Close()
+	for _, statement := range statements {
+		result, err := db.ExecContext(ctx, statement)
+		if err != nil {
+			cleanup()
+			return "", func() {}, invalidInputf("--%s %q: %w", flag, statement, err)
+		}
+		rows, _ := result.RowsAffected()
+		slog.Info("ran pre-run SQL", "flag", flag, "sql", statement, "rows", rows)
+	}
+	return tmp, cleanup, nil
+}
+
+// copyToTemp copies the file at path to a new temporary file and returns its path
+func copyToTemp(path string) (string, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return "", invalidInputf("error opening %s: %w", path, err)
+	}
+	defer src.Close()
+
+	dst, err := os.CreateTemp("", "pcr_presql_*.db")
+	if err != nil {
+		return "", err
+	}
+	if _, err = io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return "", err
+	}
+	if err = dst.Close(); err != nil {
+		os.Remove(dst.Name())
+		return "", err
+	}
+	return dst.Name(), nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"sync"
+)
+
+// profiling flags, to diagnose slow matching and copying on real databases
+var cpuProfilePath, memProfilePath, pprofAddr string
+
+// cpuProfileFile is the file of --cpuProfile while it is written
+var cpuProfileFile *os.File
+
+// stopProfilingOnce makes stopProfiling safe to call from both fatalf and the end of main
+var stopProfilingOnce sync.Once
+
+// startProfiling starts the CPU profile of --cpuProfile and the pprof server of --pprofAddr
+func startProfiling() error {
+	if cpuProfilePath != "" {
+		f, err := os.Create(cpuProfilePath)
+		if err != nil {
+			return invalidInputf("error creating CPU profile: %w", err)
+		}
+		if err = runtimepprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return fmt.Errorf("error starting CPU profile: %w", err)
+		}
+		cpuProfileFile = f
+	}
+	if pprofAddr != "" {
+		listener, err := net.Listen("tcp", pprofAddr)
+		if err != nil {
+			return invalidInputf("error listening on %s: %w", pprofAddr, err)
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		slog.Info("serving pprof", "url", "http://"+listener.Addr().String()+"/debug/pprof/")
+		go func() {
+			if err := http.Serve(listener, mux); err != nil {
+				slog.Warn("pprof server stopped", "error", err)
+			}
+		}()
+	}
+	return nil
+}
+
+// stopProfiling writes the CPU profile of --cpuProfile and the heap profile of --memProfile,
+// before the process exits
+func stopProfiling() {
+	stopProfilingOnce.Do(func() {
+		if cpuProfileFile != nil {
+			runtimepprof.StopCPUProfile()
+			if err := cpuProfileFile.Close(); err != nil {
+				slog.Warn("error writing CPU profile", "path", cpuProfilePath, "error", err)
+			}
+		}
+		if memProfilePath != "" {
+			f, err := os.Create(memProfilePath)
+			if err != nil {
+				slog.Warn("error creating memory profile", "path", memProfilePath, "error", err)
+				return
+			}
+			defer f.Close()
+			// the heap profile is as of the last garbage collection
+			runtime.GC()
+			if err = runtimepprof.WriteHeapProfile(f); err != nil {
+				slog.Warn("error writing memory profile", "path", memProfilePath, "error", err)
+			}
+		}
+	})
+}
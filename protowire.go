@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errInvalidProto = errors.New("invalid protobuf message")
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendTag(b []byte, field, wireType int) []byte {
+	return appendVarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+// appendProtoBytes appends a length-delimited field, left out if empty like the other proto3 defaults
+func appendProtoBytes(b []byte, field int, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = appendTag(b, field, wireBytes)
+	b = appendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendProtoString(b []byte, field int, v string) []byte {
+	return appendProtoBytes(b, field, []byte(v))
+}
+
+// appendProtoInt appends an int32 or int64 field, negative values take 10 bytes like in protobuf
+func appendProtoInt(b []byte, field int, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendTag(b, field, wireVarint)
+	return appendVarint(b, uint64(v))
+}
+
+func consumeVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < len(b) && i < 10; i++ {
+		v |= uint64(b[i]&0x7f) << (7 * i)
+		if b[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// consumeProtoFields calls fn with the number, wire type and value of every field of the message b:
+// data for the length-delimited fields, which aliases b, and v for the others
+func consumeProtoFields(b []byte, fn func(field, wireType int, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		tag, n := consumeVarint(b)
+		if n == 0 {
+			return errInvalidProto
+		}
+		b = b[n:]
+		field, wireType := int(tag>>3), int(tag&7)
+		var v uint64
+		var data []byte
+		switch wireType {
+		case wireVarint:
+			if v, n = consumeVarint(b); n == 0 {
+				return errInvalidProto
+			}
+			b = b[n:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return errInvalidProto
+			}
+			v, b = binary.LittleEndian.Uint64(b), b[8:]
+		case wireFixed32:
+			if len(b) < 4 {
+				return errInvalidProto
+			}
+			v, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		case wireBytes:
+			length, n := consumeVarint(b)
+			if n == 0 || length > uint64(len(b)-n) {
+				return errInvalidProto
+			}
+			data, b = b[n:n+int(length)], b[n+int(length):]
+		default:
+			return errInvalidProto
+		}
+		if err := fn(field, wireType, v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the messages of proto/rename/v1/rename.proto, unknown fields are skipped
+
+// submitJobRequest is a SubmitJobRequest
+type submitJobRequest struct {
+	original, hashed []byte
+	truthVersion     string
+}
+
+func (m *submitJobRequest) unmarshal(b []byte) error {
+	return consumeProtoFields(b, func(field, wireType int, _ uint64, data []byte) error {
+		if wireType != wireBytes {
+			return nil
+		}
+		switch field {
+		case 1:
+			m.original = data
+		case 2:
+			m.hashed = data
+		case 3:
+			m.truthVersion = string(data)
+		}
+		return nil
+	})
+}
+
+// jobRequest is a JobRequest
+type jobRequest struct {
+	id string
+}
+
+func (m *jobRequest) unmarshal(b []byte) error {
+	return consumeProtoFields(b, func(field, wireType int, _ uint64, data []byte) error {
+		if field == 1 && wireType == wireBytes {
+			m.id = string(data)
+		}
+		return nil
+	})
+}
+
+// marshalJob returns j as a Job
+func marshalJob(j job) []byte {
+	var b []byte
+	b = appendProtoString(b, 1, j.ID)
+	b = appendProtoString(b, 2, j.Status)
+	b = appendProtoInt(b, 3, int64(j.ExitCode))
+	b = appendProtoString(b, 4, j.Error)
+	b = appendProtoInt(b, 5, j.CreatedAt.UnixMilli())
+	if j.FinishedAt != nil {
+		b = appendProtoInt(b, 6, j.FinishedAt.UnixMilli())
+	}
+	return appendProtoBytes(b, 7, j.Summary)
+}
+
+// marshalProgress returns a Progress of j with the new lines of its output
+func marshalProgress(j job, lines []string) []byte {
+	jobMsg := marshalJob(j)
+	b := appendTag(nil, 1, wireBytes)
+	b = appendVarint(b, uint64(len(jobMsg)))
+	b = append(b, jobMsg...)
+	for _, line := range lines {
+		// empty strings of a repeated field are kept
+		b = appendTag(b, 2, wireBytes)
+		b = appendVarint(b, uint64(len(line)))
+		b = append(b, line...)
+	}
+	return b
+}
+
+// marshalMapping returns a Mapping of tables, in table order so equal mappings are equal messages
+func marshalMapping(tables map[string]string) []byte {
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b []byte
+	for _, name := range names {
+		// a map field is a repeated message of its key and value
+		entry := appendProtoString(appendProtoString(nil, 1, name), 2, tables[name])
+		b = appendTag(b, 1, wireBytes)
+		b = appendVarint(b, uint64(len(entry)))
+		b = append(b, entry...)
+	}
+	return b
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSubmitJobRequestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		req  submitJobRequest
+	}{
+		{"both databases", submitJobRequest{original: []byte("original"), hashed: []byte{0, 1, 2, 0xff}}},
+		{"truth version", submitJobRequest{original: []byte("original"), truthVersion: "10059000"}},
+		{"large database", submitJobRequest{original: bytes.Repeat([]byte{0x80}, 1<<16), hashed: []byte("h")}},
+		{"empty", submitJobRequest{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b []byte
+			b = appendProtoBytes(b, 1, tt.req.original)
+			b = appendProtoBytes(b, 2, tt.req.hashed)
+			b = appendProtoString(b, 3, tt.req.truthVersion)
+			// unknown fields of every wire type are skipped
+			b = appendProtoInt(b, 9, -1)
+			b = appendProtoString(b, 10, "unknown")
+			b = binary.LittleEndian.AppendUint32(appendTag(b, 11, wireFixed32), 7)
+			b = binary.LittleEndian.AppendUint64(appendTag(b, 12, wireFixed64), 7)
+
+			var got submitJobRequest
+			if err := got.unmarshal(b); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got.original, tt.req.original) || !bytes.Equal(got.hashed, tt.req.hashed) || got.truthVersion != tt.req.truthVersion {
+				t.Errorf("unmarshal = %+v, want %+v", got, tt.req)
+			}
+		})
+	}
+}
+
+func TestUnmarshalInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  []byte
+	}{
+		{"truncated tag", []byte{0x80}},
+		{"truncated varint", appendTag(nil, 1, wireVarint)},
+		{"truncated bytes", append(appendVarint(appendTag(nil, 1, wireBytes), 10), "short"...)},
+		{"truncated fixed32", append(appendTag(nil, 1, wireFixed32), 1, 2)},
+		{"truncated fixed64", append(appendTag(nil, 1, wireFixed64), 1, 2, 3, 4)},
+		{"invalid wire type", appendTag(nil, 1, 3)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req jobRequest
+			if err := req.unmarshal(tt.msg); !errors.Is(err, errInvalidProto) {
+				t.Errorf("unmarshal(%v) = %v, want %v", tt.msg, err, errInvalidProto)
+			}
+		})
+	}
+}
+
+// decodeFields returns the length-delimited values and the varints of msg by field number
+func decodeFields(t *testing.T, msg []byte) (map[int][]string, map[int]int64) {
+	t.Helper()
+	data, varints := map[int][]string{}, map[int]int64{}
+	err := consumeProtoFields(msg, func(field, wireType int, v uint64, b []byte) error {
+		if wireType == wireBytes {
+			data[field] = append(data[field], string(b))
+		} else {
+			varints[field] = int64(v)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, varints
+}
+
+func TestMarshalJob(t *testing.T) {
+	created := time.UnixMilli(1700000000123)
+	finished := created.Add(time.Minute)
+	tests := []struct {
+		name    string
+		job     job
+		data    map[int][]string
+		varints map[int]int64
+	}{
+		{
+			name:    "queued",
+			job:     job{ID: "abc", Status: jobQueued, CreatedAt: created},
+			data:    map[int][]string{1: {"abc"}, 2: {jobQueued}},
+			varints: map[int]int64{5: created.UnixMilli()},
+		},
+		{
+			name: "failed",
+			job:  job{ID: "abc", Status: jobFailed, ExitCode: -1, Error: "boom", CreatedAt: created, FinishedAt: &finished, Summary: []byte(`{"a":1}`)},
+			data: map[int][]string{1: {"abc"}, 2: {jobFailed}, 4: {"boom"}, 7: {`{"a":1}`}},
+			// negative int32 are sign-extended to 64 bits
+			varints: map[int]int64{3: -1, 5: created.UnixMilli(), 6: finished.UnixMilli()},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, varints := decodeFields(t, marshalJob(tt.job))
+			if !reflect.DeepEqual(data, tt.data) {
+				t.Errorf("bytes fields = %v, want %v", data, tt.data)
+			}
+			if !reflect.DeepEqual(varints, tt.varints) {
+				t.Errorf("varint fields = %v, want %v", varints, tt.varints)
+			}
+		})
+	}
+}
+
+func TestMarshalProgress(t *testing.T) {
+	j := job{ID: "abc", Status: jobRunning}
+	data, _ := decodeFields(t, marshalProgress(j, []string{"first", "", "third"}))
+	if want := []string{string(marshalJob(j))}; !reflect.DeepEqual(data[1], want) {
+		t.Errorf("job = %q, want %q", data[1], want)
+	}
+	if want := []string{"first", "", "third"}; !reflect.DeepEqual(data[2], want) {
+		t.Errorf("lines = %q, want %q", data[2], want)
+	}
+}
+
+func TestMarshalMapping(t *testing.T) {
+	tables := map[string]string{"unit_data": "v1_b", "skill_data": "v1_a", "empty": ""}
+	entries, _ := decodeFields(t, marshalMapping(tables))
+	got := map[string]string{}
+	var names []string
+	for _, entry := range entries[1] {
+		fields, _ := decodeFields(t, []byte(entry))
+		name := strings.Join(fields[1], "")
+		got[name] = strings.Join(fields[2], "")
+		names = append(names, name)
+	}
+	if !reflect.DeepEqual(got, tables) {
+		t.Errorf("mapping = %v, want %v", got, tables)
+	}
+	if want := []string{"empty", "skill_data", "unit_data"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("entries in order %v, want %v", names, want)
+	}
+}
+
+// grpcFrame returns msg framed as an uncompressed gRPC message announcing length bytes
+func grpcFrame(length int, msg []byte) []byte {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(length))
+	return append(frame, msg...)
+}
+
+func TestReadGRPCMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		body []byte
+		// limit of the message, and of the body with bodyLimit
+		limit, bodyLimit int64
+		want             []byte
+		code             int
+	}{
+		{name: "message", body: grpcFrame(5, []byte("hello")), limit: 10, want: []byte("hello")},
+		{name: "empty message", body: grpcFrame(0, nil), limit: 10, want: []byte{}},
+		{name: "larger than the limit", body: grpcFrame(11, nil), limit: 10, code: grpcResourceExhausted},
+		// the announced length is never allocated, only what arrives is read
+		{name: "announced length", body: grpcFrame(1<<30, []byte("short")), limit: 1 << 31, code: grpcInvalidArgument},
+		{name: "truncated prefix", body: []byte{0, 0}, limit: 10, code: grpcInvalidArgument},
+		{name: "compressed", body: append([]byte{1}, grpcFrame(0, nil)[1:]...), limit: 10, code: grpcUnimplemented},
+		{name: "larger body", body: grpcFrame(5, []byte("hello")), limit: 10, bodyLimit: 8, code: grpcResourceExhausted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bodyLimit := int64(len(tt.body))
+			if tt.bodyLimit > 0 {
+				bodyLimit = tt.bodyLimit
+			}
+			body := http.MaxBytesReader(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(tt.body)).Body, bodyLimit)
+			got, err := readGRPCMessage(body, tt.limit)
+			if tt.code == grpcOK {
+				if err != nil || !bytes.Equal(got, tt.want) {
+					t.Errorf("readGRPCMessage = %q, %v, want %q", got, err, tt.want)
+				}
+				return
+			}
+			var grpcErr *grpcError
+			if !errors.As(err, &grpcErr) || grpcErr.code != tt.code {
+				t.Errorf("readGRPCMessage error = %v, want code %d", err, tt.code)
+			}
+		})
+	}
+}
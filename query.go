@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// queryFormats are the formats of query results
+var queryFormats = []string{"table", "csv", "json"}
+
+func newQueryCmd() *cobra.Command {
+	var dbPath, sqlPath, format, mappingPath string
+
+	cmd := &cobra.Command{
+		Use:   "query [statement]",
+		Short: "Run an SQL query against a database and print its rows",
+		Long: `Run an ad-hoc query, or a saved one with --sql, against a database and print its rows as a table, CSV or JSON.
+A file of several statements prints the rows of the last one. With --mapping, a hashed database can be
+queried with readable names, like in repl.`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if !slices.Contains(queryFormats, format) {
+				fatalf(exitInvalidInput, "Unknown format %s, expected %s", format, strings.Join(queryFormats, ", "))
+			}
+			if (len(args) == 1) == (sqlPath != "") {
+				fatalf(exitInvalidInput, "Pass either a statement or --sql")
+			}
+			statement := ""
+			if len(args) == 1 {
+				statement = args[0]
+			} else {
+				data, err := os.ReadFile(sqlPath)
+				if err != nil {
+					fatalf(exitInvalidInput, "Error reading %s: %v", sqlPath, err)
+				}
+				statement = string(data)
+			}
+			var tables, columns map[string]string
+			if mappingPath != "" {
+				var err error
+				if tables, columns, err = readUseMapping(mappingPath); err != nil {
+					fatalf(exitInvalidInput, "%v", err)
+				}
+			}
+			db := openExistingDB(dbPath)
+			defer db.Close()
+
+			r, err := newRepl(cmd.Context(), db, tables, columns, os.Stdout)
+			if err != nil {
+				fatalf(exitInternalError, "%v", err)
+			}
+			if err = r.query(cmd.Context(), statement, format); err != nil {
+				fatalf(exitInternalError, "Error running query: %v", err)
+			}
+		},
+	}
+	cmd.Flags().StringVar(&dbPath, "db", "", "REQUIRED: Database to query")
+	cmd.Flags().StringVar(&sqlPath, "sql", "", "OPTIONAL: File of the query to run, instead of the statement argument")
+	cmd.Flags().StringVar(&format, "format", "table", "OPTIONAL: Output format, "+strings.Join(queryFormats, ", "))
+	cmd.Flags().StringVar(&mappingPath, "mapping", "", "OPTIONAL: Table mapping (of --generateTableMapping or precompute) resolving the readable names used against a hashed database")
+	cmd.Flags().StringVar(&opts.Driver, "driver", sqliteDriver, driverUsage)
+	cmd.MarkFlagRequired("db")
+
+	return cmd
+}
+
+// printRows prints the rows of a query in format, one of queryFormats
+func printRows(out io.Writer, format string, columns []string, rows [][]any) error {
+	switch format {
+	case "csv":
+		w := csv.NewWriter(out)
+		if err := w.Write(columns); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			values := make([]string, len(row))
+			for i, v := range row {
+				if v != nil {
+					values[i] = formatValue(v)
+				}
+			}
+			if err := w.Write(values); err != nil {
+				return err
+			}
+		}
+		w.Flush()
+		return w.Error()
+	case "json":
+		objects := make([]map[string]any, 0, len(rows))
+		for _, row := range rows {
+			object := make(map[string]any, len(columns))
+			for i, c := range columns {
+				object[c] = row[i]
+			}
+			objects = append(objects, object)
+		}
+		jsonData, err := json.MarshalIndent(objects, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, string(jsonData))
+		return err
+	default:
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, strings.Join(columns, "\t")+"\t")
+		for _, row := range rows {
+			values := make([]string, len(row))
+			for i, v := range row {
+				values[i] = formatValue(v)
+			}
+			fmt.Fprintln(w, strings.Join(values, "\t")+"\t")
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintf(out, "(%d rows)\n", len(rows))
+		return err
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/peterli110/pcr-hash-table-rename/pkg/rename"
+	"github.com/spf13/cobra"
+)
+
+// region holds the quirks of the databases of one region of the game, read from regions/<name>.json.
+// Add a file to regions to support a new region.
+type region struct {
+	// CDNHost serves the databases of --truthVersion and --fetchLatest, empty if unknown
+	CDNHost string `json:"cdnHost"`
+	// Locale is the directory of the asset manifests on the CDN
+	Locale string `json:"locale"`
+	// HashedPrefix starts the hashed table names, see rename.Options.HashedPrefix
+	HashedPrefix string `json:"hashedPrefix"`
+	// Encoding is the default --encoding, the text encoding of the databases of the region
+	Encoding string `json:"encoding"`
+	// Blacklist are known-dead original tables skipped when matching and generating,
+	// that the hashed databases of the region do not have anymore or only with stale rows
+	Blacklist []string `json:"blacklist"`
+	// RowRanges tell apart hashed tables with the same first rows, see rename.Options.RowRanges
+	RowRanges map[string]rename.RowRange `json:"rowRanges"`
+}
+
+//go:embed regions/*.json
+var regionFiles embed.FS
+
+// regions are the presets of --region, by name
+var regions = readRegions()
+
+// readRegions reads the embedded presets of the regions
+func readRegions() map[string]region {
+	entries, err := regionFiles.ReadDir("regions")
+	if err != nil {
+		panic(err)
+	}
+	regions := make(map[string]region, len(entries))
+	for _, entry := range entries {
+		data, err := regionFiles.ReadFile("regions/" + entry.Name())
+		r := region{HashedPrefix: rename.DefaultHashedPrefix}
+		if err == nil {
+			err = json.Unmarshal(data, &r)
+		}
+		if err != nil {
+			// the presets are embedded, only a broken build gets here
+			panic(fmt.Sprintf("invalid region preset %s: %v", entry.Name(), err))
+		}
+		regions[strings.TrimSuffix(entry.Name(), ".json")] = r
+	}
+	return regions
+}
+
+// regionUsage is the help of the --region flag
+var regionUsage = fmt.Sprintf("OPTIONAL: Region of the databases, one of %s (the regions with a preset so far), setting the quirks of its databases and the default --cdnHost, --encoding and --generatedDBPath", strings.Join(regionNames(), ", "))
+
+// regionNames returns the names of the regions, sorted
+func regionNames() []string {
+	names := make([]string, 0, len(regions))
+	for name := range regions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyRegion sets the options of --region that were not set by their own flags
+func applyRegion(cmd *cobra.Command) error {
+	r, ok := regions[opts.Region]
+	if !ok {
+		return invalidInputf("unknown region %s, available regions are %s", opts.Region, strings.Join(regionNames(), ", "))
+	}
+
+	flags := cmd.Flags()
+	if !flags.Changed("generatedDBPath") {
+		opts.GeneratedDBPath = opts.Region + "_fixed.db"
+	}
+	if !flags.Changed("cdnHost") {
+		opts.CDNHost = r.CDNHost
+	}
+	if opts.CDNHost == "" && (opts.TruthVersion != "" || opts.FetchLatest) {
+		return invalidInputf("the CDN of region %s is not known, set it with --cdnHost", opts.Region)
+	}
+	if r.Locale != "" {
+		opts.Locale = r.Locale
+	}
+	if !flags.Changed("encoding") && r.Encoding != "" {
+		opts.EncodingFlag = r.Encoding
+	}
+	hashedPrefix = r.HashedPrefix
+	opts.HashedPrefix = r.HashedPrefix
+
+	// --blacklist replaces the blacklist of the region, and an empty one disables it
+	if flags.Changed("blacklist") {
+		opts.Exclude = map[string]struct{}{}
+		if opts.Blacklist != "" {
+			blacklist, err := readFilterFile(opts.Blacklist)
+			if err != nil {
+				return err
+			}
+			opts.Exclude = blacklist
+		}
+	} else {
+		opts.Exclude = map[string]struct{}{}
+		for _, t := range r.Blacklist {
+			opts.Exclude[t] = struct{}{}
+		}
+	}
+	opts.RowRanges = r.RowRanges
+	return nil
+}
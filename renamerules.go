@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/peterli110/pcr-hash-table-rename/pkg/rename"
+)
+
+// renameRule is a line of a --renameRules file, <table|column> <action> [<args>...]
+type renameRule struct {
+	column bool
+	apply  func(name string) string
+}
+
+// renameRules rename the tables and columns of the new database after the hashed names are resolved
+type renameRules []renameRule
+
+// readRenameRules reads the --renameRules file at path. Each line is a rule applied to the table
+// or column names, in the order of the file: strip_prefix <prefix>, strip_suffix <suffix>,
+// replace <regexp> <replacement>, snake_case or lower. Empty lines and lines starting with # are skipped.
+func readRenameRules(path string) (renameRules, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, invalidInputf("error opening rename rules: %w", err)
+	}
+	defer file.Close()
+
+	var rules renameRules
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		rule, err := parseRenameRule(fields)
+		if err != nil {
+			return nil, invalidInputf("rename rules %s, line %d: %w", path, n, err)
+		}
+		rules = append(rules, rule)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading rename rules: %w", err)
+	}
+	return rules, nil
+}
+
+func parseRenameRule(fields []string) (renameRule, error) {
+	var rule renameRule
+	switch fields[0] {
+	case "table":
+	case "column":
+		rule.column = true
+	default:
+		return rule, fmt.Errorf("unknown target %s, expected table or column", fields[0])
+	}
+	if len(fields) < 2 {
+		return rule, fmt.Errorf("missing action")
+	}
+
+	action, args := fields[1], fields[2:]
+	arity := map[string]int{"strip_prefix": 1, "strip_suffix": 1, "replace": 2, "snake_case": 0, "lower": 0}
+	n, ok := arity[action]
+	if !ok {
+		return rule, fmt.Errorf("unknown action %s", action)
+	}
+	// the replacement of replace may be empty
+	if len(args) != n && !(action == "replace" && len(args) == 1) {
+		return rule, fmt.Errorf("%s takes %d arguments", action, n)
+	}
+	switch action {
+	case "strip_prefix":
+		rule.apply = func(name string) string { return strings.TrimPrefix(name, args[0]) }
+	case "strip_suffix":
+		rule.apply = func(name string) string { return strings.TrimSuffix(name, args[0]) }
+	case "replace":
+		re, err := regexp.Compile(args[0])
+		if err != nil {
+			return rule, err
+		}
+		replacement := ""
+		if len(args) > 1 {
+			replacement = args[1]
+		}
+		rule.apply = func(name string) string { return re.ReplaceAllString(name, replacement) }
+	case "snake_case":
+		rule.apply = snakeCase
+	case "lower":
+		rule.apply = strings.ToLower
+	}
+	return rule, nil
+}
+
+// snakeCase returns name in snake_case, e.g. UnitID and unit-id are unit_id
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		switch {
+		case r == '-' || r == ' ' || r == '.':
+			b.WriteRune('_')
+			continue
+		case unicode.IsUpper(r) && i > 0:
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteRune('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// TableName returns the name table is renamed to
+func (rules renameRules) TableName(table string) string {
+	return rules.rename(table, false)
+}
+
+func (rules renameRules) rename(name string, column bool) string {
+	for _, rule := range rules {
+		if rule.column == column {
+			name = rule.apply(name)
+		}
+	}
+	return name
+}
+
+// apply renames the tables of db and their columns, except the tables written by this tool.
+// Every table is renamed in one transaction, through temporary names so names can be swapped
+// or change case only. It returns the number of renamed tables and columns.
+func (rules renameRules) apply(ctx context.Context, db *sql.DB) (tables, columns int, err error) {
+	names, err := rename.GetTableNames(ctx, db, false)
+	if err != nil {
+		return 0, 0, err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	renamed := map[string]string{}
+	targets := map[string]string{}
+	for _, table := range names {
+		if table == rename.MappingTable || table == metaTable || strings.HasPrefix(table, "sqlite_") {
+			continue
+		}
+		newName := rules.TableName(table)
+		if newName == "" {
+			return 0, 0, invalidInputf("rename rules rename table %s to an empty name", table)
+		}
+		if other, ok := targets[strings.ToLower(newName)]; ok {
+			return 0, 0, invalidInputf("rename rules rename both tables %s and %s to %s", other, table, newName)
+		}
+		targets[strings.ToLower(newName)] = table
+		if newName != table {
+			renamed[table] = newName
+		}
+
+		n, err := rules.renameColumns(ctx, tx, table)
+		if err != nil {
+			return 0, 0, err
+		}
+		columns += n
+	}
+
+	tmpNames := map[string]string{}
+	for i, table := range sortedKeys(renamed) {
+		tmpNames[table] = fmt.Sprintf("_renaming_%d", i)
+		if err = renameTable(ctx, tx, table, tmpNames[table]); err != nil {
+			return 0, 0, err
+		}
+	}
+	for _, table := range sortedKeys(renamed) {
+		if err = renameTable(ctx, tx, tmpNames[table], renamed[table]); err != nil {
+			return 0, 0, err
+		}
+		slog.Debug("renamed table", "table", table, "name", renamed[table])
+	}
+	return len(renamed), columns, tx.Commit()
+}
+
+// renameColumns renames the columns of table, through temporary names like the tables
+func (rules renameRules) renameColumns(ctx context.Context, tx *sql.Tx, table string) (int, error) {
+	var columns []string
+	rows, err := tx.QueryContext(ctx, "SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return 0, fmt.Errorf("error reading columns of table %s: %w", table, err)
+	}
+	for rows.Next() {
+		var column string
+		if err = rows.Scan(&column); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		columns = append(columns, column)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	renamed := map[string]string{}
+	targets := map[string]string{}
+	for _, column := range columns {
+		newName := rules.rename(column, true)
+		if newName == "" {
+			return 0, invalidInputf("rename rules rename column %s of table %s to an empty name", column, table)
+		}
+		if other, ok := targets[strings.ToLower(newName)]; ok {
+			return 0, invalidInputf("rename rules rename both columns %s and %s of table %s to %s", other, column, table, newName)
+		}
+		targets[strings.ToLower(newName)] = column
+		if newName != column {
+			renamed[column] = newName
+		}
+	}
+
+	statement := "ALTER TABLE %s RENAME COLUMN %s TO %s"
+	for i, column := range columns {
+		if _, ok := renamed[column]; ok {
+			if _, err = tx.ExecContext(ctx, fmt.Sprintf(statement, quoteIdentifier(table), quoteIdentifier(column), quoteIdentifier(fmt.Sprintf("_renaming_%d", i)))); err != nil {
+				return 0, fmt.Errorf("error renaming column %s of table %s: %w", column, table, err)
+			}
+		}
+	}
+	for i, column := range columns {
+		if newName, ok := renamed[column]; ok {
+			if _, err = tx.ExecContext(ctx, fmt.Sprintf(statement, quoteIdentifier(table), quoteIdentifier(fmt.Sprintf("_renaming_%d", i)), quoteIdentifier(newName))); err != nil {
+				return 0, fmt.Errorf("error renaming column %s of table %s: %w", column, table, err)
+			}
+		}
+	}
+	return len(renamed), nil
+}
+
+func renameTable(ctx context.Context, tx *sql.Tx, table, newName string) error {
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s RENAME TO %s", quoteIdentifier(table), quoteIdentifier(newName))); err != nil {
+		return fmt.Errorf("error renaming table %s to %s: %w", table, newName, err)
+	}
+	return nil
+}
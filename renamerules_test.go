@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSnakeCase(t *testing.T) {
+	tests := map[string]string{
+		"UnitID":      "unit_id",
+		"unitData":    "unit_data",
+		"unit-id":     "unit_id",
+		"unit id":     "unit_id",
+		"unit.id":     "unit_id",
+		"HTTPServer":  "http_server",
+		"skill2Level": "skill2_level",
+		"unit_data":   "unit_data",
+		"UNIT":        "unit",
+	}
+	for name, want := range tests {
+		if got := snakeCase(name); got != want {
+			t.Errorf("snakeCase(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestReadRenameRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		// the names v1_UnitData and its column UnitID are renamed to
+		table, column string
+		code          int
+	}{
+		{
+			name:   "in order",
+			rules:  "# comment\n\ntable strip_prefix v1_\ntable snake_case\ncolumn lower\n",
+			table:  "unit_data",
+			column: "unitid",
+		},
+		{
+			name:   "replace",
+			rules:  "table replace Unit Chara\ncolumn replace ID$\n",
+			table:  "v1_CharaData",
+			column: "Unit",
+		},
+		{
+			name:   "strip suffix",
+			rules:  "table strip_suffix Data\ncolumn strip_suffix ID\n",
+			table:  "v1_Unit",
+			column: "Unit",
+		},
+		{name: "unknown target", rules: "index lower\n", code: exitInvalidInput},
+		{name: "unknown action", rules: "table upper\n", code: exitInvalidInput},
+		{name: "missing action", rules: "table\n", code: exitInvalidInput},
+		{name: "missing argument", rules: "table strip_prefix\n", code: exitInvalidInput},
+		{name: "extra argument", rules: "table lower x\n", code: exitInvalidInput},
+		{name: "invalid regexp", rules: "table replace ( x\n", code: exitInvalidInput},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "rules.txt")
+			if err := os.WriteFile(path, []byte(tt.rules), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			rules, err := readRenameRules(path)
+			if tt.code != 0 {
+				if code := exitCodeOf(err); code != tt.code {
+					t.Errorf("readRenameRules error = %v, exit code %d, want %d", err, code, tt.code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := rules.TableName("v1_UnitData"); got != tt.table {
+				t.Errorf("TableName(v1_UnitData) = %q, want %q", got, tt.table)
+			}
+			if got := rules.rename("UnitID", true); got != tt.column {
+				t.Errorf("column UnitID renamed to %q, want %q", got, tt.column)
+			}
+		})
+	}
+}
+
+func TestRenameRulesApply(t *testing.T) {
+	opts.Driver = sqliteDriver
+	tests := []struct {
+		name  string
+		rules string
+		stmts []string
+		// the tables and the columns of every table afterwards
+		tables  []string
+		columns map[string][]string
+		code    int
+	}{
+		{
+			name:  "tables and columns",
+			rules: "table strip_prefix v1_\ncolumn snake_case\n",
+			stmts: []string{
+				"CREATE TABLE v1_unit (UnitID INTEGER PRIMARY KEY, UnitName TEXT)",
+				"CREATE TABLE _table_mapping (original_name TEXT PRIMARY KEY, hashed_name TEXT NOT NULL)",
+			},
+			tables:  []string{"_table_mapping", "unit"},
+			columns: map[string][]string{"unit": {"unit_id", "unit_name"}},
+		},
+		{
+			name:  "swapped names",
+			rules: "table replace ^a$ tmp\ntable replace ^b$ a\ntable replace ^tmp$ b\n",
+			stmts: []string{
+				"CREATE TABLE a (x INTEGER)",
+				"CREATE TABLE b (y INTEGER)",
+			},
+			tables:  []string{"a", "b"},
+			columns: map[string][]string{"a": {"y"}, "b": {"x"}},
+		},
+		{
+			name:  "case only",
+			rules: "table lower\ncolumn lower\n",
+			stmts: []string{
+				"CREATE TABLE Unit (ID INTEGER, Name TEXT)",
+			},
+			tables:  []string{"unit"},
+			columns: map[string][]string{"unit": {"id", "name"}},
+		},
+		{
+			name:  "tables renamed to the same name",
+			rules: "table strip_suffix _x\n",
+			stmts: []string{
+				"CREATE TABLE unit (id INTEGER)",
+				"CREATE TABLE unit_x (id INTEGER)",
+			},
+			code: exitInvalidInput,
+		},
+		{
+			name:  "columns renamed to the same name",
+			rules: "column strip_suffix _id\n",
+			stmts: []string{
+				"CREATE TABLE unit (unit INTEGER, unit_id INTEGER)",
+			},
+			code: exitInvalidInput,
+		},
+		{
+			name:  "empty name",
+			rules: "table replace .*\n",
+			stmts: []string{
+				"CREATE TABLE unit (id INTEGER)",
+			},
+			code: exitInvalidInput,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			rulesPath := filepath.Join(dir, "rules.txt")
+			if err := os.WriteFile(rulesPath, []byte(tt.rules), 0644); err != nil {
+				t.Fatal(err)
+			}
+			rules, err := readRenameRules(rulesPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(dir, "new.db")
+			db := openTestDB(t, path, tt.stmts...)
+
+			_, _, err = rules.apply(context.Background(), db)
+			if tt.code != 0 {
+				if code := exitCodeOf(err); code != tt.code {
+					t.Errorf("apply error = %v, exit code %d, want %d", err, code, tt.code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := queryColumn(t, path, "SELECT name FROM sqlite_master WHERE type = 'table' ORDER BY name"); !reflect.DeepEqual(got, tt.tables) {
+				t.Errorf("tables = %v, want %v", got, tt.tables)
+			}
+			for table, want := range tt.columns {
+				if got := queryColumn(t, path, "SELECT name FROM pragma_table_info('"+table+"') ORDER BY cid"); !reflect.DeepEqual(got, want) {
+					t.Errorf("columns of %s = %v, want %v", table, got, want)
+				}
+			}
+		})
+	}
+}
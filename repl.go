@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/peterli110/pcr-hash-table-rename/pkg/rename"
+	"github.com/spf13/cobra"
+)
+
+const replHelp = `Statements end with a semicolon and may span several lines, Tab completes table names.
+Commands:
+  .tables          list the tables
+  .schema <table>  print the CREATE statement of a table
+  .help            print this help
+  .quit            exit, like Ctrl-D`
+
+// replCommands are the commands of the repl, completed like the table names
+var replCommands = []string{".tables", ".schema", ".help", ".quit", ".exit"}
+
+func newReplCmd() *cobra.Command {
+	var mappingPath string
+
+	cmd := &cobra.Command{
+		Use:   "repl <database>",
+		Short: "Run SQL statements against a database interactively",
+		Long: `Open an interactive SQL prompt against a database, e.g. a generated database, without a separate SQLite client.
+With --mapping, a hashed database can be queried with the readable table names of a table mapping, and the
+column names of a precompute lookup table: they are replaced with their hashed names before a statement
+is run, and the columns of the results are named back.
+
+` + replHelp,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			var tables, columns map[string]string
+			if mappingPath != "" {
+				var err error
+				if tables, columns, err = readUseMapping(mappingPath); err != nil {
+					fatalf(exitInvalidInput, "%v", err)
+				}
+			}
+			db := openExistingDB(args[0])
+			defer db.Close()
+
+			repl, err := newRepl(cmd.Context(), db, tables, columns, os.Stdout)
+			if err != nil {
+				fatalf(exitInternalError, "%v", err)
+			}
+			if err = repl.run(cmd.Context(), newLineReader(os.Stdin, os.Stdout, repl.complete)); err != nil {
+				fatalf(exitInternalError, "%v", err)
+			}
+		},
+	}
+	cmd.Flags().StringVar(&mappingPath, "mapping", "", "OPTIONAL: Table mapping (of --generateTableMapping or precompute) resolving the readable names used against a hashed database")
+	cmd.Flags().StringVar(&opts.Driver, "driver", sqliteDriver, driverUsage)
+
+	return cmd
+}
+
+// repl runs the SQL statements typed by the user against a database
+type repl struct {
+	db  *sql.DB
+	out io.Writer
+	// tables are the tables of db
+	tables []string
+	// names maps the readable table and column names of the mapping to hashed names,
+	// hashedColumns the hashed column names to readable ones
+	names, hashedColumns map[string]string
+	// words are completed on Tab
+	words []string
+}
+
+// newRepl returns a repl on db, resolving the readable names of tables and columns
+// to hashed names if set
+func newRepl(ctx context.Context, db *sql.DB, tables, columns map[string]string, out io.Writer) (*repl, error) {
+	names, err := rename.GetTableNames(ctx, db, false)
+	if err != nil {
+		return nil, err
+	}
+	r := &repl{db: db, out: out, tables: names, names: map[string]string{}, hashedColumns: map[string]string{}}
+	for table, hashed := range tables {
+		r.names[table] = hashed
+	}
+	for column, hashed := range columns {
+		r.names[column] = hashed
+		r.hashedColumns[hashed] = column
+	}
+	r.words = append(append(append(r.words, names...), sortedKeys(r.names)...), replCommands...)
+	return r, nil
+}
+
+// complete returns the table names, readable names and commands starting with prefix
+func (r *repl) complete(prefix string) []string {
+	return prefixCompletions(r.words, prefix)
+}
+
+// run reads statements from lines and prints their results until the end of the input
+func (r *repl) run(ctx context.Context, lines *lineReader) error {
+	if lines.terminal != nil {
+		fmt.Fprintln(r.out, `Enter ".help" for usage hints.`)
+	}
+	var statement strings.Builder
+	for {
+		prompt := "sql> "
+		if statement.Len() > 0 {
+			prompt = "...> "
+		}
+		line, err := lines.readLine(prompt)
+		if errors.Is(err, errLineInterrupted) {
+			statement.Reset()
+			continue
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if statement.Len() == 0 && strings.HasPrefix(trimmed, ".") {
+			if quit := r.command(ctx, strings.Fields(trimmed)); quit {
+				return nil
+			}
+			continue
+		}
+		if statement.Len() == 0 && trimmed == "" {
+			continue
+		}
+		statement.WriteString(line)
+		statement.WriteString("\n")
+		if !strings.HasSuffix(trimmed, ";") {
+			continue
+		}
+		if err = r.execute(ctx, statement.String()); err != nil {
+			fmt.Fprintln(r.out, "Error:", err)
+		}
+		statement.Reset()
+	}
+}
+
+// command runs a command of the repl and returns whether it quits
+func (r *repl) command(ctx context.Context, fields []string) bool {
+	switch fields[0] {
+	case ".quit", ".exit":
+		return true
+	case ".help":
+		fmt.Fprintln(r.out, replHelp)
+	case ".tables":
+		r.printTables()
+	case ".schema":
+		if len(fields) != 2 {
+			fmt.Fprintln(r.out, "Usage: .schema <table>")
+			return false
+		}
+		var createStmt string
+		err := r.db.QueryRowContext(ctx, "SELECT sql FROM sqlite_master WHERE name = ? AND sql IS NOT NULL", r.resolve(fields[1])).Scan(&createStmt)
+		if err == sql.ErrNoRows {
+			fmt.Fprintf(r.out, "Error: no table %s\n", fields[1])
+		} else if err != nil {
+			fmt.Fprintln(r.out, "Error:", err)
+		} else {
+			fmt.Fprintln(r.out, createStmt+";")
+		}
+	default:
+		fmt.Fprintf(r.out, "Error: unknown command %s, enter \".help\" for usage hints\n", fields[0])
+	}
+	return false
+}
+
+// printTables prints the tables of the database, with their readable name if the mapping has one
+func (r *repl) printTables() {
+	readable := map[string]string{}
+	for name, hashed := range r.names {
+		if _, ok := r.hashedColumns[hashed]; !ok {
+			readable[hashed] = name
+		}
+	}
+	tables := append([]string(nil), r.tables...)
+	sort.Strings(tables)
+	for _, t := range tables {
+		if name, ok := readable[t]; ok {
+			fmt.Fprintf(r.out, "%s (%s)\n", name, t)
+		} else {
+			fmt.Fprintln(r.out, t)
+		}
+	}
+}
+
+// resolve returns the hashed name of a readable name of the mapping, or name itself
+func (r *repl) resolve(name string) string {
+	if hashed, ok := r.names[name]; ok {
+		return hashed
+	}
+	return name
+}
+
+// execute runs statement and prints its rows as a table, see query
+func (r *repl) execute(ctx context.Context, statement string) error {
+	return r.query(ctx, statement, "table")
+}
+
+// query runs statement with the readable names of the mapping resolved and prints its rows
+// in format, with their readable column names, if it returns any
+func (r *repl) query(ctx context.Context, statement, format string) error {
+	if len(r.names) > 0 {
+		statement = resolveNames(statement, r.names)
+	}
+	columns, rows, err := queryAll(ctx, r.db, statement)
+	if err != nil {
+		return err
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+	for i, c := range columns {
+		if readable, ok := r.hashedColumns[c]; ok {
+			columns[i] = readable
+		}
+	}
+	return printRows(r.out, format, columns, rows)
+}
+
+// queryAll runs query on db and returns the names of its columns and its rows, with text
+// and blobs as strings
+func queryAll(ctx context.Context, db *sql.DB, query string) ([]string, [][]any, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+	var result [][]any
+	for rows.Next() {
+		values := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err = rows.Scan(pointers...); err != nil {
+			return nil, nil, err
+		}
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		result = append(result, values)
+	}
+	return columns, result, rows.Err()
+}
+
+// formatValue formats a value of queryAll, NULL for nil
+func formatValue(v any) string {
+	if v == nil {
+		return "NULL"
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// resolveNames replaces the identifiers of statement that are keys of names, bare or quoted,
+// with their value, leaving string literals as they are
+func resolveNames(statement string, names map[string]string) string {
+	var b strings.Builder
+	for i := 0; i < len(statement); {
+		c := statement[i]
+		switch {
+		case c == '\'':
+			end := i + 1
+			for end < len(statement) {
+				if statement[end] == '\'' {
+					// '' is an escaped quote inside the literal
+					if end+1 < len(statement) && statement[end+1] == '\'' {
+						end += 2
+						continue
+					}
+					break
+				}
+				end++
+			}
+			end = min(end+1, len(statement))
+			b.WriteString(statement[i:end])
+			i = end
+		case c == '"' || c == '`' || c == '[':
+			closing := map[byte]byte{'"': '"', '`': '`', '[': ']'}[c]
+			end := strings.IndexByte(statement[i+1:], closing)
+			if end < 0 {
+				b.WriteString(statement[i:])
+				return b.String()
+			}
+			name := statement[i+1 : i+1+end]
+			if hashed, ok := names[name]; ok {
+				name = hashed
+			}
+			b.WriteByte(c)
+			b.WriteString(name)
+			b.WriteByte(closing)
+			i += end + 2
+		case isIdentifierByte(c) && !(c >= '0' && c <= '9'):
+			end := i
+			for end < len(statement) && isIdentifierByte(statement[end]) {
+				end++
+			}
+			name := statement[i:end]
+			if hashed, ok := names[name]; ok {
+				name = hashed
+			}
+			b.WriteString(name)
+			i = end
+		case c >= '0' && c <= '9':
+			// numbers like 1e5 are not identifiers
+			end := i
+			for end < len(statement) && isIdentifierByte(statement[end]) {
+				end++
+			}
+			b.WriteString(statement[i:end])
+			i = end
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String()
+}
+
+// isIdentifierByte returns whether c can be part of an unquoted identifier
+func isIdentifierByte(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c >= 0x80
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/peterli110/pcr-hash-table-rename/pkg/rename"
+)
+
+// reviewSampleRows is the number of first rows of a match shown side by side by --review
+const reviewSampleRows = 3
+
+// errReviewAborted is returned when the review or the prompts of --interactive are quit
+// without generating the new database
+var errReviewAborted = errors.New("review aborted, nothing is written")
+
+const reviewHelp = `Commands:
+  l, list                  list the matches and the unmatched tables
+  s, show <n>              show the first rows of match n side by side with its hashed table
+  a, accept <n>            accept match n
+  r, reject <n>            reject match n, its table is left out of the new database
+  o, override <n> <table>  match table n with another hashed table
+  g, generate              generate the new database with the reviewed matches
+  q, quit                  quit without generating the new database
+  h, help                  print this help`
+
+// mappingSetter is a tableRunner whose mapping can be replaced before Copy, for --review
+type mappingSetter interface {
+	SetMapping(ctx context.Context, mapping rename.Mapping) error
+}
+
+// reviewStatus is what the user decided about a match
+type reviewStatus string
+
+const (
+	reviewPending    reviewStatus = ""
+	reviewAccepted   reviewStatus = "accepted"
+	reviewRejected   reviewStatus = "rejected"
+	reviewOverridden reviewStatus = "overridden"
+)
+
+// reviewEntry is a matched or unmatched original table under review, HashedName is
+// empty for an unmatched or rejected table
+type reviewEntry struct {
+	rename.TableMatch
+	status reviewStatus
+}
+
+// review lets the user review the matches of a run on the terminal before they are copied, for --review
+type review struct {
+	in  *bufio.Scanner
+	out io.Writer
+	// originalDBs are the original databases, newest first
+	originalDBs []*sql.DB
+	hashedDB    *sql.DB
+	candidates  map[string][]rename.Candidate
+	// hashedTables are the names of the tables of hashedDB
+	hashedTables map[string]struct{}
+
+	// entries are the matched tables, then the unmatched ones, numbered from 1
+	entries []reviewEntry
+	// mapping is the mapping under review
+	mapping rename.Mapping
+	// newKnown is set when every table was matched, so the hashed tables of rejected
+	// matches are new, see rename.Mapping.New
+	newKnown bool
+}
+
+// reviewMatches runs the review of mapping, reading commands from in and writing to out,
+// and returns the reviewed mapping, or errReviewAborted if the user quit. newKnown is
+// set when every table was matched.
+func reviewMatches(ctx context.Context, in io.Reader, out io.Writer, originalDBs []*sql.DB, hashedDB *sql.DB, mapping rename.Mapping, report rename.Report, newKnown bool) (rename.Mapping, error) {
+	r, err := newReview(ctx, in, out, originalDBs, hashedDB, mapping, report, newKnown)
+	if err != nil {
+		return mapping, err
+	}
+
+	r.list()
+	fmt.Fprintln(r.out, reviewHelp)
+	for {
+		fmt.Fprint(r.out, "> ")
+		if !r.in.Scan() {
+			if err = r.in.Err(); err != nil {
+				return mapping, err
+			}
+			return mapping, errReviewAborted
+		}
+		if err = ctx.Err(); err != nil {
+			return mapping, err
+		}
+		fields := strings.Fields(r.in.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "l", "list":
+			r.list()
+		case "s", "show":
+			if e, ok := r.entry(fields, 2); ok {
+				r.show(ctx, e)
+			}
+		case "a", "accept":
+			if e, ok := r.entry(fields, 2); ok {
+				if e.HashedName == "" {
+					fmt.Fprintf(r.out, "%s has no match to accept, override it with a hashed table\n", e.Name)
+				} else {
+					e.status = reviewAccepted
+				}
+			}
+		case "r", "reject":
+			if e, ok := r.entry(fields, 2); ok {
+				e.HashedName, e.Confidence, e.Manual, e.status = "", 0, false, reviewRejected
+			}
+		case "o", "override":
+			if e, ok := r.entry(fields, 3); ok {
+				r.override(e, fields[2])
+			}
+		case "g", "generate":
+			return r.result(), nil
+		case "q", "quit":
+			return mapping, errReviewAborted
+		case "h", "help", "?":
+			fmt.Fprintln(r.out, reviewHelp)
+		default:
+			fmt.Fprintf(r.out, "unknown command %s, h for help\n", fields[0])
+		}
+	}
+}
+
+// newReview returns the review of mapping, with its matched tables then its unmatched ones
+func newReview(ctx context.Context, in io.Reader, out io.Writer, originalDBs []*sql.DB, hashedDB *sql.DB, mapping rename.Mapping, report rename.Report, newKnown bool) (*review, error) {
+	names, err := rename.GetTableNames(ctx, hashedDB, false)
+	if err != nil {
+		return nil, err
+	}
+	r := &review{
+		in:           bufio.NewScanner(in),
+		out:          out,
+		originalDBs:  originalDBs,
+		hashedDB:     hashedDB,
+		candidates:   report.Candidates,
+		hashedTables: make(map[string]struct{}, len(names)),
+		mapping:      mapping,
+		newKnown:     newKnown,
+	}
+	for _, name := range names {
+		r.hashedTables[name] = struct{}{}
+	}
+	for _, m := range mapping.Tables {
+		r.entries = append(r.entries, reviewEntry{TableMatch: m})
+	}
+	for _, t := range mapping.Unmatched {
+		original, err := r.originalOf(ctx, t)
+		if err != nil {
+			return nil, err
+		}
+		r.entries = append(r.entries, reviewEntry{TableMatch: rename.TableMatch{Name: t, Original: original}})
+	}
+	return r, nil
+}
+
+// entry returns the entry numbered by the second field of a command of n fields
+func (r *review) entry(fields []string, n int) (*reviewEntry, bool) {
+	if len(fields) != n {
+		fmt.Fprintln(r.out, "wrong number of arguments, h for help")
+		return nil, false
+	}
+	i, err := strconv.Atoi(fields[1])
+	if err != nil || i < 1 || i > len(r.entries) {
+		fmt.Fprintf(r.out, "no table numbered %s, l to list them\n", fields[1])
+		return nil, false
+	}
+	return &r.entries[i-1], true
+}
+
+// list prints the entries with their confidence and status, and the best candidate of the unmatched ones
+func (r *review) list() {
+	w := tabwriter.NewWriter(r.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tTABLE\tHASHED TABLE\tCONFIDENCE\tSTATUS\t")
+	for i, e := range r.entries {
+		hashed, confidence := e.HashedName, fmt.Sprintf("%.2f", e.Confidence)
+		if hashed == "" {
+			hashed, confidence = "-", "-"
+			if c := r.candidates[e.Name]; len(c) > 0 && e.status == reviewPending {
+				hashed = fmt.Sprintf("(candidate %s, score %.2f)", c[0].Table, c[0].Score())
+			}
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t\n", i+1, e.Name, hashed, confidence, e.status)
+	}
+	w.Flush()
+}
+
+// show prints the first rows of the original table of e side by side with those of its
+// hashed table, or of its best candidate if it has no match
+func (r *review) show(ctx context.Context, e *reviewEntry) {
+	hashed := e.HashedName
+	if hashed == "" {
+		if c := r.candidates[e.Name]; len(c) > 0 {
+			hashed = c[0].Table
+			fmt.Fprintf(r.out, "%s has no match, showing its best candidate %s\n", e.Name, hashed)
+		}
+	}
+	r.preview(ctx, e, hashed)
+}
+
+// preview prints the first rows of the original table of e side by side with those of
+// the hashed table, or alone if hashed is empty
+func (r *review) preview(ctx context.Context, e *reviewEntry, hashed string) {
+	originalDB := r.originalDBs[e.Original]
+	columns, err := rename.GetColumnNames(ctx, originalDB, e.Name)
+	if err != nil {
+		fmt.Fprintln(r.out, err)
+		return
+	}
+	originalRows, err := rename.GetFirstNRows(ctx, originalDB, e.Name, reviewSampleRows)
+	if err != nil {
+		fmt.Fprintln(r.out, err)
+		return
+	}
+	var hashedRows [][]string
+	if hashed != "" {
+		if hashedRows, err = rename.GetFirstNRows(ctx, r.hashedDB, hashed, reviewSampleRows); err != nil {
+			fmt.Fprintln(r.out, err)
+			return
+		}
+	}
+	printSideBySide(r.out, columns, e.Name, originalRows, hashed, hashedRows)
+}
+
+// printSideBySide prints the rows of an original table and of a hashed table, row by row
+// and column by column, the columns of the hashed table being matched by position
+func printSideBySide(out io.Writer, columns []string, original string, originalRows [][]string, hashed string, hashedRows [][]string) {
+	if hashed == "" {
+		hashed = "-"
+	}
+	for i := 0; i < max(len(originalRows), len(hashedRows)); i++ {
+		fmt.Fprintf(out, "row %d\n", i+1)
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "COLUMN\t%s\t%s\t\n", original, hashed)
+		n := len(columns)
+		if i < len(hashedRows) {
+			n = max(n, len(hashedRows[i]))
+		}
+		for j := 0; j < n; j++ {
+			fmt.Fprintf(w, "%s\t%s\t%s\t\n", cell(columns, j), cell(rowAt(originalRows, i), j), cell(rowAt(hashedRows, i), j))
+		}
+		w.Flush()
+	}
+}
+
+// rowAt returns the i-th row of rows, nil if there is none
+func rowAt(rows [][]string, i int) []string {
+	if i < len(rows) {
+		return rows[i]
+	}
+	return nil
+}
+
+// cell returns the j-th value of row, - if there is none
+func cell(row []string, j int) string {
+	if j < len(row) {
+		return row[j]
+	}
+	return "-"
+}
+
+// override matches e with the hashed table, which must exist and not be the match of another
+// table, and returns whether it did
+func (r *review) override(e *reviewEntry, hashed string) bool {
+	if _, ok := r.hashedTables[hashed]; !ok {
+		fmt.Fprintf(r.out, "the hashed database has no table %s\n", hashed)
+		return false
+	}
+	for _, other := range r.entries {
+		if other.HashedName == hashed && other.Name != e.Name {
+			fmt.Fprintf(r.out, "%s is already the match of %s\n", hashed, other.Name)
+			return false
+		}
+	}
+	e.HashedName, e.Confidence, e.Manual, e.status = hashed, 1, true, reviewOverridden
+	return true
+}
+
+// originalOf returns the index of the newest original database with table
+func (r *review) originalOf(ctx context.Context, table string) (int, error) {
+	for i, db := range r.originalDBs {
+		var n int
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&n); err != nil {
+			return 0, err
+		}
+		if n > 0 {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("no original database has table %s", table)
+}
+
+// result returns the mapping with the reviewed matches
+func (r *review) result() rename.Mapping {
+	mapping := r.mapping
+	mapping.Tables, mapping.Unmatched = []rename.TableMatch{}, []string{}
+	matchedHashed := map[string]struct{}{}
+	for _, e := range r.entries {
+		if e.HashedName == "" {
+			mapping.Unmatched = append(mapping.Unmatched, e.Name)
+			continue
+		}
+		mapping.Tables = append(mapping.Tables, e.TableMatch)
+		matchedHashed[e.HashedName] = struct{}{}
+	}
+	// the hashed tables of rejected matches are new, the ones of overrides are not anymore
+	newTables := []string{}
+	for _, t := range r.mapping.New {
+		if _, ok := matchedHashed[t]; !ok {
+			newTables = append(newTables, t)
+		}
+	}
+	if r.newKnown {
+		for _, m := range r.mapping.Tables {
+			if _, ok := matchedHashed[m.HashedName]; !ok {
+				newTables = append(newTables, m.HashedName)
+			}
+		}
+	}
+	mapping.New = newTables
+
+	sort.Slice(mapping.Tables, func(i, j int) bool {
+		return mapping.Tables[i].Name < mapping.Tables[j].Name
+	})
+	sort.Strings(mapping.Unmatched)
+	sort.Strings(mapping.New)
+	return mapping
+}
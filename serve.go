@@ -0,0 +1,475 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// files inside a job directory
+const (
+	jobOriginalFile  = "original.db"
+	jobHashedFile    = "hashed.db"
+	jobGeneratedFile = "jp_fixed.db"
+	jobLogFile       = "output.log"
+)
+
+// time an interrupted run gets to clean up before it is killed
+const childStopTimeout = 30 * time.Second
+
+// uploads larger than this are stored in temporary files while parsing the form
+const maxFormMemory = 32 << 20
+
+// timeouts of the server, generous enough to upload or download a database over a slow link
+const (
+	serveReadHeaderTimeout = 10 * time.Second
+	serveReadTimeout       = 30 * time.Minute
+	serveWriteTimeout      = 30 * time.Minute
+	serveIdleTimeout       = 2 * time.Minute
+)
+
+type job struct {
+	ID         string          `json:"id"`
+	Status     string          `json:"status"`
+	ExitCode   int             `json:"exitCode"`
+	Error      string          `json:"error,omitempty"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	FinishedAt *time.Time      `json:"finishedAt,omitempty"`
+	Summary    json.RawMessage `json:"summary,omitempty"`
+	dir        string
+	// args are the arguments of the run of the job
+	args []string
+	// serving counts the requests reading the files of the job, which does not expire meanwhile
+	serving int
+}
+
+type jobServer struct {
+	mu      sync.Mutex
+	jobs    map[string]*job
+	dataDir string
+	// queue are the jobs waiting for a worker, in submission order, queued is signalled when one is added
+	queue  []*job
+	queued *sync.Cond
+	// ttl is how long the jobs are kept once finished, forever if 0
+	ttl time.Duration
+	// maxUpload is the largest request body of POST /jobs, in bytes
+	maxUpload int64
+	// ctx is cancelled when the server shuts down, interrupting the running jobs
+	ctx     context.Context
+	running sync.WaitGroup
+	metrics *serveMetrics
+}
+
+func newServeCmd() *cobra.Command {
+	var addr, dataDir, maxUpload string
+	var workers int
+	var ttl time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Run an HTTP server generating databases as jobs",
+		Long: `Run an HTTP server generating databases as jobs.
+
+  POST /jobs               multipart form with the "original" database file and either the
+                           "hashed" database file or a "truthVersion" field, returns the job
+  GET  /jobs/{id}          status of the job, with the run summary once it is finished
+  GET  /jobs/{id}/db       the generated database
+  GET  /jobs/{id}/mapping  the table mapping JSON
+  GET  /jobs/{id}/log      the output of the run
+  GET  /metrics            metrics of the jobs in the Prometheus text format
+
+The same address serves the gRPC API of proto/rename/v1/rename.proto (SubmitJob, GetStatus,
+StreamProgress and FetchMapping) over HTTP/2, with or without TLS.
+
+Jobs are queued and run --jobWorkers at a time. Their state is kept in --dataDir, so the jobs
+of a previous run of the server are served again, and the ones it left queued or running run
+again. Finished jobs and their outputs are deleted after --jobTTL.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if workers < 1 {
+				fatalf(exitInvalidInput, "--jobWorkers must be at least 1")
+			}
+			maxUploadSize, err := parseByteSize(maxUpload)
+			if err != nil {
+				fatalf(exitCodeOf(err), "Invalid --maxUploadSize: %v", err)
+			}
+			if dataDir == "" {
+				dir, err := os.MkdirTemp("", "pcr_jobs_*")
+				if err != nil {
+					fatalf(exitInternalError, "Error creating the job directory: %v", err)
+				}
+				dataDir = dir
+			}
+
+			s := &jobServer{jobs: map[string]*job{}, dataDir: dataDir, ttl: ttl, maxUpload: maxUploadSize, ctx: cmd.Context(), metrics: newServeMetrics()}
+			s.queued = sync.NewCond(&s.mu)
+			if err := s.load(); err != nil {
+				fatalf(exitInternalError, "Error loading the jobs of %s: %v", dataDir, err)
+			}
+			s.startWorkers(workers)
+			go s.expireJobs()
+			srv := &http.Server{
+				Addr:              addr,
+				Handler:           s.handler(),
+				ReadHeaderTimeout: serveReadHeaderTimeout,
+				ReadTimeout:       serveReadTimeout,
+				WriteTimeout:      serveWriteTimeout,
+				IdleTimeout:       serveIdleTimeout,
+			}
+			go func() {
+				<-cmd.Context().Done()
+				slog.Info("shutting down")
+				srv.Shutdown(context.Background())
+			}()
+
+			slog.Info("listening", "addr", addr, "dataDir", dataDir, "workers", workers)
+			if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				fatalf(exitInternalError, "Error listening on %s: %v", addr, err)
+			}
+			// interrupted jobs clean up their partial outputs before exiting, they run again on restart
+			s.running.Wait()
+			slog.Info("stopped")
+		},
+	}
+	cmd.Flags().StringVar(&addr, "addr", ":8080", "OPTIONAL: Address to listen on")
+	cmd.Flags().StringVar(&dataDir, "dataDir", "", "OPTIONAL: Directory to store jobs in, default to a temporary directory. The jobs in it are served again on restart")
+	cmd.Flags().IntVar(&workers, "jobWorkers", 2, "OPTIONAL: Number of jobs run at the same time, the others wait in the queue")
+	cmd.Flags().StringVar(&maxUpload, "maxUploadSize", "4GiB", "OPTIONAL: Largest upload of POST /jobs and SubmitJob, both databases included, e.g. 1GiB, larger ones are rejected with 413 or RESOURCE_EXHAUSTED")
+	cmd.Flags().DurationVar(&ttl, "jobTTL", 0, "OPTIONAL: Delete the finished jobs and their outputs this long after they finished, e.g. 24h, they are kept if 0")
+
+	return cmd
+}
+
+func (s *jobServer) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", s.handleSubmit)
+	mux.HandleFunc("GET /jobs/{id}", s.handleStatus)
+	mux.HandleFunc("GET /jobs/{id}/db", s.handleFile(jobGeneratedFile))
+	mux.HandleFunc("GET /jobs/{id}/mapping", s.handleFile(mappingFile))
+	mux.HandleFunc("GET /jobs/{id}/log", s.handleFile(jobLogFile))
+	mux.HandleFunc("GET /metrics", s.handleMetrics)
+	return mux
+}
+
+func (s *jobServer) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxUpload)
+	if err := r.ParseMultipartForm(maxFormMemory); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("upload larger than the maximum of %d bytes", tooLarge.Limit))
+			return
+		}
+		writeError(w, http.StatusBadRequest, "invalid form: "+err.Error())
+		return
+	}
+
+	truthVersion := r.FormValue("truthVersion")
+	_, _, hashedErr := r.FormFile("hashed")
+	if (hashedErr == nil) == (truthVersion != "") {
+		writeError(w, http.StatusBadRequest, `exactly one of the "hashed" file or "truthVersion" is required`)
+		return
+	}
+
+	j, err := s.submit(truthVersion, func(field, path string) error {
+		return saveFormFile(r, field, path)
+	})
+	var uploadErr *uploadError
+	switch {
+	case errors.As(err, &uploadErr):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	default:
+		writeJSON(w, http.StatusAccepted, j)
+	}
+}
+
+// uploadError is an error saving an uploaded database of a job
+type uploadError struct {
+	field string
+	err   error
+}
+
+func (e *uploadError) Error() string {
+	return fmt.Sprintf("invalid %q file: %v", e.field, e.err)
+}
+
+func (e *uploadError) Unwrap() error {
+	return e.err
+}
+
+// submit creates a job whose databases save writes to the paths of the job directory, by field:
+// original and, without truthVersion, hashed, and starts it
+func (s *jobServer) submit(truthVersion string, save func(field, path string) error) (job, error) {
+	j := &job{ID: newJobID(), Status: jobQueued, CreatedAt: time.Now()}
+	j.dir = filepath.Join(s.dataDir, j.ID)
+	if err := os.MkdirAll(j.dir, 0755); err != nil {
+		return job{}, err
+	}
+
+	if err := save("original", filepath.Join(j.dir, jobOriginalFile)); err != nil {
+		os.RemoveAll(j.dir)
+		return job{}, &uploadError{"original", err}
+	}
+	// a job run again once the server restarts replaces the outputs it may have written before
+	args := []string{"-r", jobOriginalFile, "-g", jobGeneratedFile, "-t", "--force"}
+	if truthVersion != "" {
+		args = append(args, "--truthVersion", truthVersion)
+	} else {
+		if err := save("hashed", filepath.Join(j.dir, jobHashedFile)); err != nil {
+			os.RemoveAll(j.dir)
+			return job{}, &uploadError{"hashed", err}
+		}
+		args = append(args, "-n", jobHashedFile)
+	}
+
+	j.args = args
+	s.mu.Lock()
+	s.jobs[j.ID] = j
+	s.enqueue(j)
+	s.mu.Unlock()
+
+	return s.snapshot(j), nil
+}
+
+// enqueue queues j for a worker, the caller holds s.mu
+func (s *jobServer) enqueue(j *job) {
+	s.saveJob(j)
+	s.queue = append(s.queue, j)
+	s.queued.Signal()
+}
+
+// startWorkers starts n workers running the queued jobs until the server shuts down
+func (s *jobServer) startWorkers(n int) {
+	go func() {
+		<-s.ctx.Done()
+		s.mu.Lock()
+		s.queued.Broadcast()
+		s.mu.Unlock()
+	}()
+	for i := 0; i < n; i++ {
+		s.running.Add(1)
+		go func() {
+			defer s.running.Done()
+			for {
+				j, ok := s.next()
+				if !ok {
+					return
+				}
+				s.runJob(j)
+			}
+		}()
+	}
+}
+
+// next waits for a queued job and takes it off the queue, it returns false once the server shuts down
+func (s *jobServer) next() (*job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.queue) == 0 && s.ctx.Err() == nil {
+		s.queued.Wait()
+	}
+	if s.ctx.Err() != nil {
+		return nil, false
+	}
+	j := s.queue[0]
+	s.queue = s.queue[1:]
+	return j, true
+}
+
+// runJob runs the tool itself in the job directory, so jobs never share state
+func (s *jobServer) runJob(j *job) {
+	s.setStatus(j, jobRunning, 0, "")
+
+	logFile, err := os.Create(filepath.Join(j.dir, jobLogFile))
+	if err != nil {
+		s.setStatus(j, jobFailed, exitInternalError, err.Error())
+		s.metrics.observeJob(s.snapshot(j))
+		return
+	}
+	defer logFile.Close()
+
+	code, err := runSelf(s.ctx, j.dir, logFile, j.args...)
+	switch {
+	case s.ctx.Err() != nil && code != exitSuccess && code != exitUnmatchedTables:
+		// interrupted by the shutdown of the server, it runs again once the server restarts
+		s.setStatus(j, jobQueued, 0, "")
+		slog.Info("job interrupted, it runs again on restart", "job", j.ID)
+		return
+	case code == exitSuccess || code == exitUnmatchedTables:
+		s.setStatus(j, jobDone, code, "")
+	case err != nil:
+		s.setStatus(j, jobFailed, code, err.Error())
+	default:
+		s.setStatus(j, jobFailed, code, "")
+	}
+	s.metrics.observeJob(s.snapshot(j))
+	slog.Info("job finished", "job", j.ID, "exitCode", code)
+}
+
+// runSelf runs this executable with args in dir and returns its exit code.
+// It is interrupted when ctx is done and killed if it does not exit within childStopTimeout.
+func runSelf(ctx context.Context, dir string, output io.Writer, args ...string) (int, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return exitInternalError, err
+	}
+
+	cmd := exec.CommandContext(ctx, self, args...)
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = childStopTimeout
+	cmd.Dir = dir
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	err = cmd.Run()
+	if cmd.ProcessState == nil {
+		return exitInternalError, err
+	}
+	return cmd.ProcessState.ExitCode(), err
+}
+
+func (s *jobServer) setStatus(j *job, status string, exitCode int, errMsg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j.Status = status
+	j.ExitCode = exitCode
+	j.Error = errMsg
+	if status == jobDone || status == jobFailed {
+		now := time.Now()
+		j.FinishedAt = &now
+		if data, err := os.ReadFile(filepath.Join(j.dir, summaryFile)); err == nil {
+			j.Summary = data
+		}
+	}
+	s.saveJob(j)
+}
+
+func (s *jobServer) snapshot(j *job) job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *j
+}
+
+// get returns a snapshot of the job with this id
+func (s *jobServer) get(id string) (job, bool) {
+	s.mu.Lock()
+	j, ok := s.jobs[id]
+	s.mu.Unlock()
+	if !ok {
+		return job{}, false
+	}
+	return s.snapshot(j), true
+}
+
+func (s *jobServer) lookup(w http.ResponseWriter, r *http.Request) (job, bool) {
+	j, ok := s.get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "no such job")
+	}
+	return j, ok
+}
+
+// acquire returns a snapshot of the job with this id and keeps its files from expiring until release is called
+func (s *jobServer) acquire(id string) (j job, release func(), ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.jobs[id]
+	if !ok {
+		return job{}, nil, false
+	}
+	current.serving++
+	return *current, func() {
+		s.mu.Lock()
+		current.serving--
+		s.mu.Unlock()
+	}, true
+}
+
+func (s *jobServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if j, ok := s.lookup(w, r); ok {
+		writeJSON(w, http.StatusOK, j)
+	}
+}
+
+func (s *jobServer) handleFile(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		j, release, ok := s.acquire(r.PathValue("id"))
+		if !ok {
+			writeError(w, http.StatusNotFound, "no such job")
+			return
+		}
+		defer release()
+		// the log can be followed while the job runs, results only exist once it is done
+		if name != jobLogFile && j.Status != jobDone {
+			writeError(w, http.StatusConflict, "job is "+j.Status)
+			return
+		}
+
+		path := filepath.Join(j.dir, name)
+		if _, err := os.Stat(path); err != nil {
+			writeError(w, http.StatusNotFound, name+" was not generated")
+			return
+		}
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+		http.ServeFile(w, r, path)
+	}
+}
+
+func saveFormFile(r *http.Request, field, path string) error {
+	file, _, err := r.FormFile(field)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, file)
+	return err
+}
+
+func newJobID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		fatalf(exitInternalError, "Error generating job ID: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("error writing response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
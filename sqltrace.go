@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// traceSQLPath is the file of --traceSql
+var traceSQLPath string
+
+// sqlTrace records the statements run on the databases of openSQL, nil without --traceSql
+var sqlTrace *sqlTracer
+
+// sqlTracer writes a JSON line per statement run, with its duration and the rows it affected or returned
+type sqlTracer struct {
+	mu     sync.Mutex
+	file   *os.File
+	w      *bufio.Writer
+	closed bool
+}
+
+// sqlTraceEntry is a line of --traceSql
+type sqlTraceEntry struct {
+	Time time.Time `json:"time"`
+	// DB is the path of the database the statement ran on
+	DB string `json:"db"`
+	// Duration in seconds, until the last row was read for queries
+	Duration     float64 `json:"duration"`
+	RowsAffected *int64  `json:"rowsAffected,omitempty"`
+	// Rows is the number of rows a query returned
+	Rows  *int64 `json:"rows,omitempty"`
+	SQL   string `json:"sql"`
+	Error string `json:"error,omitempty"`
+}
+
+// startSQLTrace creates the file of --traceSql
+func startSQLTrace() error {
+	if traceSQLPath == "" {
+		return nil
+	}
+	f, err := os.Create(traceSQLPath)
+	if err != nil {
+		return invalidInputf("error creating SQL trace: %w", err)
+	}
+	sqlTrace = &sqlTracer{file: f, w: bufio.NewWriter(f)}
+	return nil
+}
+
+// stopSQLTrace flushes and closes the file of --traceSql, before the process exits
+func stopSQLTrace() {
+	if sqlTrace == nil {
+		return
+	}
+	sqlTrace.mu.Lock()
+	defer sqlTrace.mu.Unlock()
+	// statements still running when the process exits are not recorded
+	sqlTrace.closed = true
+	err := sqlTrace.w.Flush()
+	if closeErr := sqlTrace.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		slog.Warn("error writing SQL trace", "path", traceSQLPath, "error", err)
+	}
+}
+
+// record writes the entry of a statement started at start
+func (t *sqlTracer) record(db, query string, start time.Time, rowsAffected, rows *int64, err error) {
+	entry := sqlTraceEntry{Time: start, DB: db, Duration: time.Since(start).Seconds(), RowsAffected: rowsAffected, Rows: rows, SQL: query}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	line, _ := json.Marshal(entry)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.closed {
+		t.w.Write(append(line, '\n'))
+	}
+}
+
+// openSQL opens the database at dsn with the driver driverName, running the init statements on
+// every connection it opens, e.g. per-connection pragmas, and tracing its statements with --traceSql
+func openSQL(driverName, dsn string, init ...string) (*sql.DB, error) {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil || (sqlTrace == nil && len(init) == 0) {
+		return db, err
+	}
+	// sql.Open does not connect, its driver is wrapped into connectors of our own
+	d := db.Driver()
+	db.Close()
+	var connector driver.Connector = &dsnConnector{driver: d, dsn: dsn}
+	if dc, ok := d.(driver.DriverContext); ok {
+		if connector, err = dc.OpenConnector(dsn); err != nil {
+			return nil, err
+		}
+	}
+	if sqlTrace != nil {
+		// the key of an encrypted database is left out of the trace
+		traced, _ := cutKeyParam(dsn)
+		connector = &traceConnector{inner: connector, dsn: traced}
+	}
+	if len(init) > 0 {
+		connector = &initConnector{Connector: connector, statements: init}
+	}
+	return sql.OpenDB(connector), nil
+}
+
+// traceConnector opens the connections of a traced database
+type traceConnector struct {
+	inner driver.Connector
+	dsn   string
+}
+
+func (c *traceConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.inner.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &traceConn{Conn: conn, db: c.dsn}, nil
+}
+
+func (c *traceConnector) Driver() driver.Driver {
+	return c.inner.Driver()
+}
+
+// traceConn records the statements run on a connection, falling back to the plain
+// interfaces of the driver when it lacks the context ones
+type traceConn struct {
+	driver.Conn
+	db string
+}
+
+func (c *traceConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *traceConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = p.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &traceStmt{Stmt: stmt, conn: c, query: query}, nil
+}
+
+func (c *traceConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	start := time.Now()
+	var tx driver.Tx
+	var err error
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		tx, err = b.BeginTx(ctx, opts)
+	} else {
+		tx, err = c.Conn.Begin()
+	}
+	sqlTrace.record(c.db, "BEGIN", start, nil, nil, err)
+	if err != nil {
+		return nil, err
+	}
+	return &traceTx{Tx: tx, db: c.db}, nil
+}
+
+func (c *traceConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	result, err := e.ExecContext(ctx, query, args)
+	if err != driver.ErrSkip {
+		sqlTrace.record(c.db, query, start, rowsAffected(result, err), nil, err)
+	}
+	return result, err
+}
+
+func (c *traceConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	rows, err := q.QueryContext(ctx, query, args)
+	if err != nil {
+		if err != driver.ErrSkip {
+			sqlTrace.record(c.db, query, start, nil, nil, err)
+		}
+		return nil, err
+	}
+	return &traceRows{Rows: rows, db: c.db, query: query, start: start}, nil
+}
+
+func (c *traceConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *traceConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *traceConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *traceConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// traceTx records the commit or rollback of a transaction
+type traceTx struct {
+	driver.Tx
+	db string
+}
+
+func (tx *traceTx) Commit() error {
+	start := time.Now()
+	err := tx.Tx.Commit()
+	sqlTrace.record(tx.db, "COMMIT", start, nil, nil, err)
+	return err
+}
+
+func (tx *traceTx) Rollback() error {
+	start := time.Now()
+	err := tx.Tx.Rollback()
+	sqlTrace.record(tx.db, "ROLLBACK", start, nil, nil, err)
+	return err
+}
+
+// traceStmt records every execution of a prepared statement
+type traceStmt struct {
+	driver.Stmt
+	conn  *traceConn
+	query string
+}
+
+func (s *traceStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *traceStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+	var result driver.Result
+	var err error
+	if e, ok := s.Stmt.(driver.StmtExecContext); ok {
+		result, err = e.ExecContext(ctx, args)
+	} else {
+		result, err = s.Stmt.Exec(plainValues(args))
+	}
+	sqlTrace.record(s.conn.db, s.query, start, rowsAffected(result, err), nil, err)
+	return result, err
+}
+
+func (s *traceStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+func (s *traceStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	var rows driver.Rows
+	var err error
+	if q, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		rows, err = q.QueryContext(ctx, args)
+	} else {
+		rows, err = s.Stmt.Query(plainValues(args))
+	}
+	if err != nil {
+		sqlTrace.record(s.conn.db, s.query, start, nil, nil, err)
+		return nil, err
+	}
+	return &traceRows{Rows: rows, db: s.conn.db, query: s.query, start: start}, nil
+}
+
+func (s *traceStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return s.conn.CheckNamedValue(nv)
+}
+
+// traceRows records a query once its rows are closed, with the number of rows read
+type traceRows struct {
+	driver.Rows
+	db, query string
+	start     time.Time
+	rows      int64
+	err       error
+}
+
+func (r *traceRows) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+	switch {
+	case err == nil:
+		r.rows++
+	case err != io.EOF:
+		r.err = err
+	}
+	return err
+}
+
+func (r *traceRows) Close() error {
+	err := r.Rows.Close()
+	sqlTrace.record(r.db, r.query, r.start, nil, &r.rows, r.err)
+	return err
+}
+
+func (r *traceRows) ColumnTypeDatabaseTypeName(index int) string {
+	if t, ok := r.Rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return t.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+// rowsAffected returns the rows affected by a statement, nil if unknown
+func rowsAffected(result driver.Result, err error) *int64 {
+	if err != nil || result == nil {
+		return nil
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return nil
+	}
+	return &n
+}
+
+// namedValues converts the arguments of the plain driver interfaces to named ones
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return named
+}
+
+// plainValues converts named arguments to the arguments of the plain driver interfaces
+func plainValues(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+	for i, nv := range args {
+		values[i] = nv.Value
+	}
+	return values
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// values of --staging
+const (
+	stagingDisk   = "disk"
+	stagingMemory = "memory"
+)
+
+// stagedDBs numbers the in-memory databases of --staging memory, so the runs of a batch never share one
+var stagedDBs atomic.Int64
+
+// checkStaging returns an invalid input error if --staging is unknown or cannot be used with the other flags
+func checkStaging() error {
+	switch opts.Staging {
+	case stagingDisk:
+		return nil
+	case stagingMemory:
+	default:
+		return invalidInputf("unknown --staging %s, expected %s or %s", opts.Staging, stagingDisk, stagingMemory)
+	}
+	// these need the new database on disk while it is written
+	if opts.Resume || opts.Append || opts.ViewsInPlace || opts.Incremental {
+		return invalidInputf("--staging %s cannot be used with --resume, --append, --viewsInPlace or --incremental", stagingMemory)
+	}
+	if opts.GeneratedKey != "" {
+		return invalidInputf("--staging %s cannot be used with --generatedKey", stagingMemory)
+	}
+	return nil
+}
+
+// openNewDB opens the new database at output, or with --staging memory an empty in-memory
+// database to build it in, written to output by writeStaged once it is complete
+func openNewDB(output string) (*sql.DB, error) {
+	if opts.Staging != stagingMemory {
+		return openDB(output, opts.GeneratedKey)
+	}
+	// the memdb VFS shares a database whose name starts with / between the connections of the pool,
+	// it is freed when the last one closes, which the idle connections of the pool prevent
+	dsn := fmt.Sprintf("file:/pcr_staging_%d?vfs=memdb", stagedDBs.Add(1))
+	db, err := openSQL(opts.Driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxIdleConns(4)
+	return db, nil
+}
+
+// writeStaged writes the in-memory database db of --staging memory to output at once, page by page
+// with the backup API, so the disk is only written to and synced once
+func writeStaged(ctx context.Context, db *sql.DB, output string) error {
+	if opts.Staging != stagingMemory {
+		return nil
+	}
+	start := time.Now()
+	path, _ := splitDSN(output)
+	if err := backupDB(ctx, db, path); err != nil {
+		return fmt.Errorf("error writing the staged database to %s: %w", path, err)
+	}
+	slog.Debug("wrote staged database", "path", path, "duration", time.Since(start))
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/peterli110/pcr-hash-table-rename/pkg/rename"
+	"github.com/spf13/cobra"
+)
+
+type tableStats struct {
+	name    string
+	rows    int
+	columns int
+	size    int64
+}
+
+func newStatsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "stats <database>...",
+		Short: "Print per-table row counts, column counts and sizes of databases",
+		Long: `Print per-table row counts, column counts and sizes of one or more databases.
+Sizes are the pages used by each table when SQLite is built with the dbstat virtual table,
+otherwise they are the size of the stored values.`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			for i, path := range args {
+				if i > 0 {
+					fmt.Println()
+				}
+				printStats(cmd.Context(), path)
+			}
+		},
+	}
+	cmd.Flags().StringVar(&opts.Driver, "driver", sqliteDriver, driverUsage)
+
+	return cmd
+}
+
+func printStats(ctx context.Context, path string) {
+	file, _ := splitDSN(path)
+	info, err := os.Stat(file)
+	if err != nil {
+		fatalf(exitInvalidInput, "Error opening %s: %v", path, err)
+	}
+
+	db := openExistingDB(path)
+	defer db.Close()
+
+	var stats []tableStats
+	var totalRows int
+	var totalSize int64
+	tables, err := rename.GetTableNames(ctx, db, false)
+	if err != nil {
+		fatalf(exitInvalidInput, "Error reading tables of %s: %v", path, err)
+	}
+	for _, table := range tables {
+		rows, err := rename.CountRows(ctx, db, table)
+		if err != nil {
+			fatalf(exitInvalidInput, "Error counting rows of table %s: %v", table, err)
+		}
+		types, err := rename.GetColumnTypes(ctx, db, table)
+		if err != nil {
+			fatalf(exitInvalidInput, "Error getting columns of table %s: %v", table, err)
+		}
+		s := tableStats{
+			name:    table,
+			rows:    rows,
+			columns: len(types),
+			size:    getTableSize(ctx, db, table),
+		}
+		totalRows += s.rows
+		totalSize += s.size
+		stats = append(stats, s)
+	}
+
+	fmt.Printf("%s (%d tables, %d bytes on disk)\n", path, len(stats), info.Size())
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "TABLE\tROWS\tCOLUMNS\tSIZE\t")
+	for _, s := range stats {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t\n", s.name, s.rows, s.columns, s.size)
+	}
+	fmt.Fprintf(w, "TOTAL\t%d\t\t%d\t\n", totalRows, totalSize)
+	w.Flush()
+}
+
+func getTableSize(ctx context.Context, db *sql.DB, tableName string) int64 {
+	var size sql.NullInt64
+	err := db.QueryRowContext(ctx, "SELECT SUM(pgsize) FROM dbstat WHERE name = ?", tableName).Scan(&size)
+	if err == nil {
+		return size.Int64
+	}
+
+	// dbstat is not compiled in, fall back to the size of the stored values
+	columns, err := rename.GetColumnNames(ctx, db, tableName)
+	if err != nil {
+		fatalf(exitInvalidInput, "Error getting columns of table %s: %v", tableName, err)
+	}
+	var lengths []string
+	for _, column := range columns {
+		quoted := `"` + strings.ReplaceAll(column, `"`, `""`) + `"`
+		lengths = append(lengths, fmt.Sprintf("IFNULL(LENGTH(CAST(%s AS BLOB)), 0)", quoted))
+	}
+	if len(lengths) == 0 {
+		return 0
+	}
+	query := fmt.Sprintf("SELECT SUM(%s) FROM '%s'", strings.Join(lengths, " + "), tableName)
+	if err = db.QueryRowContext(ctx, query).Scan(&size); err != nil {
+		fatalf(exitInvalidInput, "Error getting size of table %s: %v", tableName, err)
+	}
+	return size.Int64
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+const summaryFile = "run_summary.json"
+
+type runSummary struct {
+	Version string `json:"version"`
+	// SchemaGeneration is the prefix of the hashed table names supported by the tool, and
+	// ValidatedTruthVersion the last truth version its matching was validated against
+	SchemaGeneration      string `json:"schemaGeneration"`
+	ValidatedTruthVersion int    `json:"validatedTruthVersion"`
+	// TruthVersion of the hashed database, if known
+	TruthVersion string    `json:"truthVersion,omitempty"`
+	StartedAt    time.Time `json:"startedAt"`
+	OriginalDB   inputFile `json:"originalDB"`
+	// OlderOriginalDBs are the original databases after the first --originalDBPath
+	OlderOriginalDBs []inputFile    `json:"olderOriginalDBs,omitempty"`
+	HashedDB         inputFile      `json:"hashedDB"`
+	GeneratedDB      string         `json:"generatedDB"`
+	Tables           []tableSummary `json:"tables"`
+	Unmatched        []string       `json:"unmatched"`
+	NewTables        []string       `json:"newTables"`
+	Skipped          []string       `json:"skipped"`
+	Failed           []failedTable  `json:"failed"`
+	RowsCopied       int            `json:"rowsCopied"`
+	Translated       int            `json:"translated,omitempty"`
+	Durations        durations      `json:"durations"`
+	Warnings         []string       `json:"warnings"`
+	// ExitCode of the run, and Error the error it stopped with, if any
+	ExitCode int    `json:"exitCode"`
+	Error    string `json:"error,omitempty"`
+}
+
+type inputFile struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+}
+
+type tableSummary struct {
+	Name          string  `json:"name"`
+	HashedName    string  `json:"hashedName"`
+	Confidence    float64 `json:"confidence"`
+	Rows          int     `json:"rows"`
+	MatchSeconds  float64 `json:"matchSeconds"`
+	CopySeconds   float64 `json:"copySeconds"`
+	RowsPerSecond float64 `json:"rowsPerSecond"`
+	Resumed       bool    `json:"resumed,omitempty"`
+	Missing       bool    `json:"missing,omitempty"`
+	// OlderOriginal is the older original database the table was matched from, if not the newest
+	OlderOriginal string `json:"olderOriginal,omitempty"`
+	// SHA256 is the checksum of the content of the table, with --tableChecksums or --deltaFrom
+	SHA256 string `json:"sha256,omitempty"`
+	// Unchanged is set for a table left out by --deltaFrom
+	Unchanged bool `json:"unchanged,omitempty"`
+	// Reused is set for a table kept from the previous new database by --incremental
+	Reused bool `json:"reused,omitempty"`
+	// Manual is set for a table whose match was chosen with --interactive or --review
+	Manual bool `json:"manual,omitempty"`
+}
+
+type failedTable struct {
+	Name       string `json:"name"`
+	HashedName string `json:"hashedName"`
+	Error      string `json:"error"`
+}
+
+// durations of each phase in seconds
+type durations struct {
+	Read   float64 `json:"read"`
+	Match  float64 `json:"match"`
+	Copy   float64 `json:"copy"`
+	Verify float64 `json:"verify"`
+	Total  float64 `json:"total"`
+}
+
+var summary = newSummary()
+
+// path the run summary is written to, named after the hashed database in batch mode
+var summaryPath = summaryFile
+
+func newSummary() runSummary {
+	return runSummary{
+		Tables:    []tableSummary{},
+		Unmatched: []string{},
+		NewTables: []string{},
+		Skipped:   []string{},
+		Failed:    []failedTable{},
+		Warnings:  []string{},
+	}
+}
+
+// newInputFile hashes the input at path, or the archive containing it
+func newInputFile(path string) (inputFile, error) {
+	archivePath, _ := splitArchivePath(path)
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return inputFile{}, invalidInputf("error opening %s: %w", path, err)
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, file); err != nil {
+		return inputFile{}, fmt.Errorf("error hashing %s: %w", path, err)
+	}
+
+	return inputFile{Path: path, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+func addWarning(warning string) {
+	summary.Warnings = append(summary.Warnings, warning)
+}
+
+func writeSummary() error {
+	summary.Durations.Total = time.Since(summary.StartedAt).Seconds()
+	if summary.Unmatched == nil {
+		summary.Unmatched = []string{}
+	}
+	sort.Slice(summary.Tables, func(i, j int) bool {
+		return summary.Tables[i].Name < summary.Tables[j].Name
+	})
+	sort.Strings(summary.Unmatched)
+	sort.Strings(summary.NewTables)
+	sort.Strings(summary.Skipped)
+
+	jsonData, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(summaryPath, jsonData, 0644)
+}
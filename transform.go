@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/peterli110/pcr-hash-table-rename/pkg/rename"
+)
+
+// transformLineRegex matches a line of a filter file replacing the rows of a table with the
+// result of a query, <table> AS SELECT ...
+var transformLineRegex = regexp.MustCompile(`(?i)^(\S+)\s+AS\s+((?:SELECT|WITH)\s.+)$`)
+
+// tableTransform is a table of the new database replaced with the result of a query
+type tableTransform struct {
+	Table, Query string
+}
+
+// readTransforms returns the transforms of the filter file at path, in the order of the file
+func readTransforms(path string) ([]tableTransform, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, invalidInputf("error opening table list: %w", err)
+	}
+	var transforms []tableTransform
+	for _, line := range strings.Split(string(content), "\n") {
+		if m := transformLineRegex.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			transforms = append(transforms, tableTransform{Table: m[1], Query: m[2]})
+		}
+	}
+	return transforms, nil
+}
+
+// transformTables replaces every table of transforms in db with the result of its query, once
+// every table is copied so the queries can join any of them. A table takes the columns of its
+// query and loses its constraints and indexes. Later queries see the result of earlier ones.
+func transformTables(ctx context.Context, db *sql.DB, transforms []tableTransform) error {
+	for _, t := range transforms {
+		columns, err := rename.GetColumnNames(ctx, db, t.Table)
+		if err != nil {
+			return err
+		}
+		if len(columns) == 0 {
+			slog.Warn("not transforming a table missing from the new database", "table", t.Table)
+			addWarning("no transform of " + t.Table + ", which is missing from the new database")
+			continue
+		}
+		if err = transformTable(ctx, db, t); err != nil {
+			return invalidInputf("transforming table %s: %w", t.Table, err)
+		}
+		slog.Debug("transformed table", "table", t.Table)
+	}
+	return nil
+}
+
+func transformTable(ctx context.Context, db *sql.DB, t tableTransform) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	tmp := t.Table + "_transformed"
+	statements := []string{
+		fmt.Sprintf("CREATE TABLE %s AS %s", quoteIdentifier(tmp), t.Query),
+		fmt.Sprintf("DROP TABLE %s", quoteIdentifier(t.Table)),
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s", quoteIdentifier(tmp), quoteIdentifier(t.Table)),
+	}
+	for _, statement := range statements {
+		if _, err = tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
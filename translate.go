@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/peterli110/pcr-hash-table-rename/pkg/rename"
+)
+
+// translation overwrites column of the row of table whose key column is id
+type translation struct {
+	table  string
+	key    string // empty for the single-column primary key of the table
+	id     string
+	column string
+	text   string
+}
+
+// readTranslations reads the translations at path, either a CSV file with a
+// table,column,id,text header keyed by primary key, or a SQLite database with the
+// same tables as the generated one, keyed by their primary key or first column
+func readTranslations(ctx context.Context, path string) ([]translation, error) {
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return readTranslationCSV(path)
+	}
+	return readTranslationDB(ctx, path)
+}
+
+func readTranslationCSV(path string) ([]translation, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, invalidInputf("error opening translations: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 4
+	header, err := reader.Read()
+	if err != nil {
+		return nil, invalidInputf("error reading translations %s: %w", path, err)
+	}
+	if strings.Join(header, ",") != "table,column,id,text" {
+		return nil, invalidInputf("translations %s must have a table,column,id,text header", path)
+	}
+
+	var translations []translation
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return translations, nil
+		}
+		if err != nil {
+			return nil, invalidInputf("error reading translations %s: %w", path, err)
+		}
+		translations = append(translations, translation{table: record[0], column: record[1], id: record[2], text: record[3]})
+	}
+}
+
+func readTranslationDB(ctx context.Context, path string) ([]translation, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, invalidInputf("error opening translations: %w", err)
+	}
+	db, err := openDB(path, "")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	tables, err := rename.GetTableNames(ctx, db, false)
+	if err != nil {
+		return nil, invalidInputf("translations %s are neither a CSV file nor a SQLite database: %w", path, err)
+	}
+
+	var translations []translation
+	for _, table := range tables {
+		columns, err := rename.GetColumnNames(ctx, db, table)
+		if err != nil {
+			return nil, err
+		}
+		key := columns[0]
+		if pk := getPrimaryKey(ctx, db, table); len(pk) == 1 {
+			key = pk[0]
+		}
+
+		rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT * FROM %s", quoteIdentifier(table)))
+		if err != nil {
+			return nil, fmt.Errorf("error reading translations of table %s: %w", table, err)
+		}
+		keyIndex := indexOf(columns, key)
+		values := make([]sql.NullString, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		for rows.Next() {
+			if err = rows.Scan(pointers...); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("error reading translations of table %s: %w", table, err)
+			}
+			for i, column := range columns {
+				if i == keyIndex || !values[i].Valid {
+					continue
+				}
+				translations = append(translations, translation{table: table, key: key, id: values[keyIndex].String, column: column, text: values[i].String})
+			}
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return translations, nil
+}
+
+// applyTranslations overwrites the translated columns of db and returns the number of
+// values overwritten. Translations of tables or columns db does not have are skipped.
+func applyTranslations(ctx context.Context, db *sql.DB, translations []translation) (int, error) {
+	// the schema is read before the transaction, which holds the only write lock
+	keys := map[string]string{}
+	columns := map[string][]string{}
+	for _, t := range translations {
+		if _, ok := columns[t.table]; ok {
+			continue
+		}
+		var err error
+		if columns[t.table], err = rename.GetColumnNames(ctx, db, t.table); err != nil {
+			return 0, err
+		}
+		if pk := getPrimaryKey(ctx, db, t.table); len(pk) == 1 {
+			keys[t.table] = pk[0]
+		}
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	translated := 0
+	skipped := map[string]struct{}{}
+	for _, t := range translations {
+		key := t.key
+		if key == "" {
+			key = keys[t.table]
+		}
+		if key == "" || indexOf(columns[t.table], key) < 0 || indexOf(columns[t.table], t.column) < 0 {
+			name := t.table + "." + t.column
+			if _, ok := skipped[name]; !ok {
+				slog.Warn("skipped translations without a matching table, column or key", "table", t.table, "column", t.column)
+				skipped[name] = struct{}{}
+			}
+			continue
+		}
+
+		result, err := tx.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s = ?", quoteIdentifier(t.table), quoteIdentifier(t.column), quoteIdentifier(key)), t.text, t.id)
+		if err != nil {
+			return 0, fmt.Errorf("error translating %s.%s: %w", t.table, t.column, err)
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		translated += int(n)
+	}
+	return translated, tx.Commit()
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/peterli110/pcr-hash-table-rename/pkg/rename"
+)
+
+// viewRunner matches the tables like its tableRunner, but instead of copying them, copies
+// the hashed database as is and creates a readable view on every hashed table, for --viewsInPlace
+type viewRunner struct {
+	tableRunner
+	hashedDB *sql.DB
+	// originalDBs name the columns of the views, newest first, the hashed column names
+	// are kept without them
+	originalDBs []*sql.DB
+	// columns maps readable column names to hashed column names, of --useMapping
+	columns map[string]string
+	opts    rename.Options
+	// output is the path of the new database, which must not exist or be empty
+	output string
+
+	mapping rename.Mapping
+}
+
+func (r *viewRunner) Match(ctx context.Context) (rename.Mapping, rename.Report, error) {
+	mapping, report, err := r.tableRunner.Match(ctx)
+	r.mapping = mapping
+	return mapping, report, err
+}
+
+// SetMapping replaces the mapping of the views, and of its tableRunner if it can, see rename.Runner.SetMapping
+func (r *viewRunner) SetMapping(ctx context.Context, mapping rename.Mapping) error {
+	if setter, ok := r.tableRunner.(mappingSetter); ok {
+		if err := setter.SetMapping(ctx, mapping); err != nil {
+			return err
+		}
+	}
+	r.mapping = mapping
+	return nil
+}
+
+func (r *viewRunner) Copy(ctx context.Context, newDB *sql.DB) ([]rename.TableCopy, error) {
+	// newDB is not opened before its first query, so the copy is what it opens
+	if _, err := r.hashedDB.ExecContext(ctx, "VACUUM INTO ?", r.output); err != nil {
+		return nil, fmt.Errorf("error copying hashed database: %w", err)
+	}
+	if err := rename.ApplyPragmas(ctx, newDB, r.opts.Pragmas); err != nil {
+		return nil, err
+	}
+
+	hashedColumns := make(map[string]string, len(r.columns))
+	for column, hashedColumn := range r.columns {
+		hashedColumns[hashedColumn] = column
+	}
+	copies := make([]rename.TableCopy, 0, len(r.mapping.Tables))
+	for _, match := range r.mapping.Tables {
+		start := time.Now()
+		columns, err := r.viewColumns(ctx, newDB, match, hashedColumns)
+		if err == nil {
+			err = rename.CreateView(ctx, newDB, match, columns)
+		}
+		var rows int
+		if err == nil {
+			if rows, err = rename.CountRows(ctx, newDB, match.Name); err != nil {
+				err = &rename.ErrCopyFailed{Table: match.Name, Err: err}
+			}
+		}
+		if err != nil && (r.opts.FailFast || ctx.Err() != nil) {
+			return copies, err
+		}
+		copies = append(copies, rename.TableCopy{TableMatch: match, Rows: rows, Duration: time.Since(start), Err: err})
+	}
+	return copies, nil
+}
+
+// viewColumns returns the column names of the view of match: those of its original table,
+// or else the hashed column names with the ones of --useMapping made readable
+func (r *viewRunner) viewColumns(ctx context.Context, newDB *sql.DB, match rename.TableMatch, hashedColumns map[string]string) ([]string, error) {
+	if len(r.originalDBs) > 0 {
+		columns, err := rename.GetColumnNames(ctx, r.originalDBs[match.Original], match.Name)
+		if err != nil {
+			return nil, &rename.ErrCopyFailed{Table: match.Name, Err: err}
+		}
+		return columns, nil
+	}
+	columns, err := rename.GetColumnNames(ctx, newDB, match.HashedName)
+	if err != nil {
+		return nil, &rename.ErrCopyFailed{Table: match.Name, Err: err}
+	}
+	for i, column := range columns {
+		if readable, ok := hashedColumns[column]; ok {
+			columns[i] = readable
+		}
+	}
+	return columns, nil
+}
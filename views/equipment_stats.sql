@@ -0,0 +1,22 @@
+-- Equipment with their base stats and the stats they gain per enhancement level
+SELECT
+    e.equipment_id,
+    e.equipment_name,
+    e.promotion_level,
+    e.require_level,
+    e.hp,
+    e.atk,
+    e.magic_str,
+    e.def,
+    e.magic_def,
+    e.physical_critical,
+    e.magic_critical,
+    r.hp AS hp_per_level,
+    r.atk AS atk_per_level,
+    r.magic_str AS magic_str_per_level,
+    r.def AS def_per_level,
+    r.magic_def AS magic_def_per_level,
+    r.physical_critical AS physical_critical_per_level,
+    r.magic_critical AS magic_critical_per_level
+FROM equipment_data e
+LEFT JOIN equipment_enhance_rate r ON r.equipment_id = e.equipment_id
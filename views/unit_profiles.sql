@@ -0,0 +1,19 @@
+-- Units with their rarity and profile
+SELECT
+    u.unit_id,
+    u.unit_name,
+    u.rarity,
+    p.age,
+    p.guild,
+    p.race,
+    p.height,
+    p.weight,
+    p.birth_month,
+    p.birth_day,
+    p.blood_type,
+    p.favorite,
+    p.voice,
+    p.catch_copy,
+    p.self_text
+FROM unit_data u
+JOIN unit_profile p ON p.unit_id = u.unit_id
@@ -0,0 +1,22 @@
+-- Units with the names and descriptions of their union burst, main skills and EX skill
+SELECT
+    u.unit_id,
+    u.unit_name,
+    s.union_burst,
+    ub.name AS union_burst_name,
+    ub.description AS union_burst_description,
+    s.main_skill_1,
+    m1.name AS main_skill_1_name,
+    m1.description AS main_skill_1_description,
+    s.main_skill_2,
+    m2.name AS main_skill_2_name,
+    m2.description AS main_skill_2_description,
+    s.ex_skill_1,
+    ex.name AS ex_skill_1_name,
+    ex.description AS ex_skill_1_description
+FROM unit_data u
+JOIN unit_skill_data s ON s.unit_id = u.unit_id
+LEFT JOIN skill_data ub ON ub.skill_id = s.union_burst
+LEFT JOIN skill_data m1 ON m1.skill_id = s.main_skill_1
+LEFT JOIN skill_data m2 ON m2.skill_id = s.main_skill_2
+LEFT JOIN skill_data ex ON ex.skill_id = s.ex_skill_1
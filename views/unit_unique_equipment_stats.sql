@@ -0,0 +1,16 @@
+-- Units with their unique equipment and its base stats
+SELECT
+    u.unit_id,
+    u.unit_name,
+    ue.equip_slot,
+    ue.equip_id,
+    d.equipment_name,
+    d.description,
+    d.hp,
+    d.atk,
+    d.magic_str,
+    d.def,
+    d.magic_def
+FROM unit_data u
+JOIN unit_unique_equipment ue ON ue.unit_id = u.unit_id
+LEFT JOIN unique_equipment_data d ON d.equipment_id = ue.equip_id
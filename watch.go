@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+func newWatchCmd() *cobra.Command {
+	var original, hashedDir, outputDir, startVersion string
+	var interval time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "watch [-- generation flags]",
+		Short: "Generate a new database whenever a new hashed database appears",
+		Long: `Generate a new database whenever a new hashed database appears, either as a file in
+--hashedDir or as a new truth version on the game CDN.
+
+Every generation runs in its own directory of --outputDir, named after the hashed file or the
+truth version, holding the new database, the table mapping, the run summary and the output.
+Hashed databases that already have a directory are skipped, delete it to generate again.
+Flags after -- are passed to every generation, e.g. -- --strict --compress zstd`,
+		Run: func(cmd *cobra.Command, args []string) {
+			abs, err := filepath.Abs(original)
+			if err != nil {
+				fatalf(exitInvalidInput, "Invalid --originalDBPath %s: %v", original, err)
+			}
+			if err = os.MkdirAll(outputDir, 0755); err != nil {
+				fatalf(exitInvalidInput, "Error creating %s: %v", outputDir, err)
+			}
+
+			extraArgs := []string{}
+			if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+				extraArgs = args[dash:]
+			}
+
+			w := &watcher{
+				original:  abs,
+				outputDir: outputDir,
+				extraArgs: extraArgs,
+				seen:      map[string]fileState{},
+			}
+			if hashedDir != "" {
+				slog.Info("watching directory", "dir", hashedDir, "interval", interval)
+				for {
+					w.pollDir(cmd.Context(), hashedDir)
+					if !sleep(cmd.Context(), interval) {
+						return
+					}
+				}
+			}
+
+			version := knownTruthVersion
+			if startVersion != "" {
+				if version, err = strconv.Atoi(startVersion); err != nil {
+					fatalf(exitInvalidInput, "Invalid truth version %s", startVersion)
+				}
+			}
+			slog.Info("watching CDN", "cdnHost", opts.CDNHost, "truthVersion", version, "interval", interval)
+			for {
+				version = w.pollCDN(cmd.Context(), version)
+				if !sleep(cmd.Context(), interval) {
+					return
+				}
+			}
+		},
+	}
+	cmd.Flags().StringVarP(&original, "originalDBPath", "r", "", "REQUIRED: Path to the original (human-readable one) database")
+	cmd.Flags().StringVar(&hashedDir, "hashedDir", "", "OPTIONAL: Directory to watch for new hashed databases, the game CDN is watched if not set")
+	cmd.Flags().StringVar(&startVersion, "truthVersion", "", "OPTIONAL: Truth version to start watching the game CDN from")
+	cmd.Flags().StringVar(&opts.CDNHost, "cdnHost", "https://prd-priconne-redive.akamaized.net", "OPTIONAL: Game CDN to watch")
+	cmd.Flags().StringVarP(&outputDir, "outputDir", "o", "generated", "OPTIONAL: Directory to write the versioned outputs to")
+	cmd.Flags().DurationVar(&interval, "interval", 10*time.Minute, "OPTIONAL: Time between two checks")
+	_ = cmd.MarkFlagRequired("originalDBPath")
+
+	return cmd
+}
+
+type watcher struct {
+	original  string
+	outputDir string
+	extraArgs []string
+	// size and modification time of the files at the last poll
+	seen map[string]fileState
+}
+
+// pollDir generates files of dir that have no output yet, once they stopped
+// changing between two polls so files still being copied are not picked up
+func (w *watcher) pollDir(ctx context.Context, dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		slog.Warn("error reading directory", "dir", dir, "error", err)
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		state := fileState{size: info.Size(), modTime: info.ModTime()}
+		previous, ok := w.seen[entry.Name()]
+		w.seen[entry.Name()] = state
+		if !ok || previous != state {
+			continue
+		}
+
+		version := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		path, err := filepath.Abs(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			slog.Warn("error reading file", "file", entry.Name(), "error", err)
+			continue
+		}
+		w.generate(ctx, version, "-n", path)
+	}
+}
+
+// pollCDN generates the latest truth version from version on, and returns it
+func (w *watcher) pollCDN(ctx context.Context, version int) int {
+	latest, err := findLatestTruthVersion(ctx, version)
+	if err != nil {
+		slog.Warn("error checking the CDN", "error", err)
+		return version
+	}
+	w.generate(ctx, latest, "--truthVersion", latest, "--cdnHost", opts.CDNHost)
+
+	v, err := strconv.Atoi(latest)
+	if err != nil {
+		slog.Warn("error checking the CDN", "error", err)
+		return version
+	}
+	return v
+}
+
+// generate runs a generation in the directory of version, unless it already exists
+func (w *watcher) generate(ctx context.Context, version string, hashedArgs ...string) {
+	dir := filepath.Join(w.outputDir, version)
+	if _, err := os.Stat(dir); err == nil {
+		return
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		fatalf(exitInternalError, "Error creating %s: %v", dir, err)
+	}
+
+	logFile, err := os.Create(filepath.Join(dir, jobLogFile))
+	if err != nil {
+		fatalf(exitInternalError, "Error creating %s: %v", filepath.Join(dir, jobLogFile), err)
+	}
+	defer logFile.Close()
+
+	slog.Info("generating", "version", version, "dir", dir)
+	args := append([]string{"-r", w.original, "-g", jobGeneratedFile, "-t"}, hashedArgs...)
+	args = append(args, w.extraArgs...)
+	code, err := runSelf(ctx, dir, logFile, args...)
+	if code != exitSuccess && code != exitUnmatchedTables {
+		slog.Error("generation failed", "version", version, "exitCode", code, "error", err, "log", logFile.Name())
+		return
+	}
+	// strict generations exit with unmatched tables before writing anything
+	if outputs, _ := filepath.Glob(filepath.Join(dir, jobGeneratedFile+"*")); len(outputs) == 0 {
+		slog.Warn("generation wrote no database", "version", version, "exitCode", code, "log", logFile.Name())
+		return
+	}
+	slog.Info("generated", "version", version, "exitCode", code)
+}
+
+// sleep waits for d, it returns false if ctx is done first
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		slog.Info("stopped watching")
+		return false
+	case <-time.After(d):
+		return true
+	}
+}